@@ -3,12 +3,63 @@ package main
 import (
 	"context"
 	"fmt"
+	"go-web-api-starter/internal/apikeys"
 	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/credentials"
+	"go-web-api-starter/internal/impersonation"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/magiclink"
+	"go-web-api-starter/internal/mfa"
+	"go-web-api-starter/internal/oidc"
+	"go-web-api-starter/internal/orgs"
+	"go-web-api-starter/internal/policy"
+	"go-web-api-starter/internal/ratelimit"
+	"go-web-api-starter/internal/refreshtokens"
+	"go-web-api-starter/internal/revocation"
+	"go-web-api-starter/internal/sessions"
+	"go-web-api-starter/internal/tokens"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/webhooks"
 	"os"
+	"time"
 )
 
 type application struct {
-	config *apiutils.ApiConfig
+	config             *apiutils.ApiConfig
+	jwtReader          *jwtauth.Reader
+	signer             *jwtauth.Signer
+	accessIssuer       *auth.AccessTokenIssuer
+	tokenIssuer        auth.Issuer
+	userService        *users.UserService
+	roleService        *users.RoleService
+	roleSource         users.RoleSource
+	claimMapping       *users.ClaimMapping
+	credentialsService *credentials.Service
+	refreshTokens      *refreshtokens.Service
+	revocations        *revocation.Store
+	apiKeys            *apikeys.Service
+	apiKeyHeader       string
+	oidc               *oidc.Service
+	sessions           *sessions.Manager
+	tokens             *tokens.Service
+	tokenLimiter       *ratelimit.Limiter
+	magicLinks         *magiclink.Service
+	magicLinkLimiter   *ratelimit.Limiter
+	mfa                *mfa.Service
+	mfaLimiter         *ratelimit.Limiter
+	mfaRequired        bool
+	mfaMaxAge          time.Duration
+	metricsEnabled     bool
+	impersonations     *impersonation.Service
+	orgs               *orgs.Service
+	tenantSources      []orgs.TenantSource
+	policies           *policy.Engine
+	webhookVerifier    *webhooks.Verifier
+	webhooks           *webhooks.Service
+	auditRepo          audit.AuditPsqlRepo
+	auditWriter        *audit.Writer
 }
 
 func main() {
@@ -1,9 +1,218 @@
 package main
 
-import "net/http"
+import (
+	"go-web-api-starter/internal/apikeys"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/credentials"
+	"go-web-api-starter/internal/impersonation"
+	"go-web-api-starter/internal/magiclink"
+	"go-web-api-starter/internal/mfa"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/oidc"
+	"go-web-api-starter/internal/orgs"
+	"go-web-api-starter/internal/ratelimit"
+	"go-web-api-starter/internal/refreshtokens"
+	"go-web-api-starter/internal/revocation"
+	"go-web-api-starter/internal/sessions"
+	"go-web-api-starter/internal/tokens"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/webhooks"
+	"net/http"
+)
 
 func addRoutesV1(
 	mux *http.ServeMux,
+	app *application,
 ) {
+	logger := app.config.Logger
 
+	// Requests carrying an API key are authenticated with it, those with a session cookie
+	// with the session, and the others with a bearer token
+	newAuthenticate := func(opts ...users.AuthOption) func(http.Handler) http.Handler {
+		opts = append([]users.AuthOption{users.WithRevocationChecker(app.revocations)}, opts...)
+		if app.claimMapping != nil {
+			opts = append(opts, users.WithClaimRoles(app.claimMapping, app.roleSource))
+		}
+		bearer := users.Authenticate(logger, app.jwtReader, app.userService, opts...)
+		if app.sessions != nil {
+			bearer = sessions.Authenticate(logger, app.sessions, bearer)
+		}
+		// With ROLE_SOURCE=claims, users authenticated with an API key or a session have no permissions,
+		// since only tokens carry the claims their role is taken from
+		authenticate := apikeys.Authenticate(logger, app.apiKeys, app.apiKeyHeader, bearer)
+		tokenRoles := users.RequireTokenRoles(app.roleSource)
+		// Authenticated users with the users:impersonate permission may then act as another user
+		impersonate := impersonation.Middleware(logger, app.impersonations)
+		return func(next http.Handler) http.Handler { return authenticate(tokenRoles(impersonate(next))) }
+	}
+	authenticate := newAuthenticate()
+	// optionalAuthenticate lets requests without credentials through as the users.AnonymousUser
+	optionalAuthenticate := newAuthenticate(users.WithOptionalAuth())
+	// Entries are recorded under the user really making the request, along with the one they act as
+	auditM := audit.Middleware(logger, app.auditWriter, users.ContextGetActorId,
+		audit.WithImpersonatedUser(users.ContextGetImpersonatedUserId))
+
+	// authenticated wraps a handler with authentication and auditing, then any extra middlewares
+	authenticated := func(h http.Handler, mws ...func(http.Handler) http.Handler) http.Handler {
+		return middleware.Chain(h, append([]func(http.Handler) http.Handler{authenticate, auditM}, mws...)...)
+	}
+
+	// Sensitive routes require a second factor verified within MFA_MAX_AGE, unless MFA_REQUIRED is false
+	requireMFA := func(next http.Handler) http.Handler { return next }
+	if app.mfaRequired {
+		requireMFA = mfa.RequireMFA(logger, app.mfaMaxAge)
+	}
+	// Users with a second factor must verify it to add another, whatever MFA_REQUIRED is
+	enrolledMFA := mfa.RequireMFAIfEnrolled(logger, app.mfa, app.mfaMaxAge)
+	// Security settings and administrative actions may only be performed by users themselves
+	notImpersonated := impersonation.Refuse(logger)
+	mfaLimited := ratelimit.Middleware(logger, app.mfaLimiter, func(r *http.Request) string {
+		return users.ContextGetUserId(r).String()
+	})
+
+	if app.tokenIssuer != nil {
+		mux.Handle("POST /v1/auth/register", credentials.RegisterHandler(logger, app.credentialsService, app.tokenIssuer))
+		mux.Handle("POST /v1/auth/login", credentials.LoginHandler(logger, app.credentialsService, app.tokenIssuer))
+		mux.Handle("POST /v1/auth/refresh", refreshtokens.RefreshHandler(logger, app.refreshTokens, app.accessIssuer))
+
+		if app.magicLinks != nil {
+			// Link requests send emails, so each client IP may only make a few of them
+			limited := ratelimit.Middleware(logger, app.magicLinkLimiter, ratelimit.ByIP)
+
+			mux.Handle("POST /v1/auth/magic-link", limited(magiclink.RequestLinkHandler(logger, app.magicLinks)))
+			mux.Handle("POST /v1/auth/magic-link/verify", magiclink.SignInHandler(logger, app.magicLinks, app.tokenIssuer))
+			// Links opened on another device sign in once the device that requested them approves it
+			mux.Handle("POST /v1/auth/magic-link/pending", magiclink.PendingApprovalHandler(logger, app.magicLinks))
+			mux.Handle("POST /v1/auth/magic-link/approve", magiclink.ApproveHandler(logger, app.magicLinks))
+		}
+
+		if app.oidc != nil {
+			mux.Handle("GET /v1/auth/oidc/{provider}/login", oidc.LoginHandler(logger, app.oidc))
+			mux.Handle("GET /v1/auth/oidc/{provider}/callback", oidc.CallbackHandler(logger, app.oidc, app.tokenIssuer))
+		}
+	}
+
+	if app.sessions != nil {
+		sessionOnly := sessions.Authenticate(logger, app.sessions, nil)
+
+		mux.Handle("POST /v1/auth/session", sessions.LoginHandler(logger, app.credentialsService, app.sessions))
+		mux.Handle("GET /v1/auth/session", middleware.Chain(sessions.CurrentSessionHandler(logger), sessionOnly))
+		mux.Handle("DELETE /v1/auth/session", middleware.Chain(sessions.LogoutHandler(logger, app.sessions), sessionOnly, auditM))
+	}
+
+	if app.tokens != nil {
+		// Token requests send emails, so each client IP may only make a few of them
+		limited := ratelimit.Middleware(logger, app.tokenLimiter, ratelimit.ByIP)
+
+		mux.Handle("POST /v1/auth/verification", limited(tokens.RequestVerificationHandler(logger, app.tokens)))
+		mux.Handle("PUT /v1/auth/verification", tokens.ConfirmVerificationHandler(logger, app.tokens))
+		mux.Handle("POST /v1/auth/password-reset", limited(tokens.RequestPasswordResetHandler(logger, app.tokens)))
+		mux.Handle("PUT /v1/auth/password-reset", tokens.ResetPasswordHandler(logger, app.tokens))
+		mux.Handle("POST /v1/auth/email-change", authenticated(tokens.RequestEmailChangeHandler(logger, app.tokens), notImpersonated, limited))
+		mux.Handle("PUT /v1/auth/email-change", tokens.ConfirmEmailChangeHandler(logger, app.tokens))
+	}
+
+	if app.webhookVerifier != nil {
+		mux.Handle("POST /v1/webhooks/supabase/users", webhooks.SupabaseUsersHandler(logger, app.webhookVerifier, app.webhooks))
+	}
+
+	mux.Handle("GET /v1/users/me", optionalAuthenticate(users.GetCurrentUserHandler(logger)))
+
+	mux.Handle("POST /v1/auth/logout", authenticated(refreshtokens.LogoutHandler(logger, app.refreshTokens), notImpersonated))
+	mux.Handle("GET /v1/auth/sessions", authenticated(refreshtokens.ListSessionsHandler(logger, app.refreshTokens)))
+	mux.Handle("DELETE /v1/auth/sessions", authenticated(refreshtokens.RevokeAllSessionsHandler(logger, app.refreshTokens), notImpersonated))
+	mux.Handle("DELETE /v1/auth/sessions/{id}", authenticated(refreshtokens.RevokeSessionHandler(logger, app.refreshTokens), notImpersonated))
+
+	mux.Handle("GET /v1/mfa/authenticators", authenticated(mfa.ListAuthenticatorsHandler(logger, app.mfa), notImpersonated))
+	mux.Handle("POST /v1/mfa/authenticators", authenticated(mfa.EnrollHandler(logger, app.mfa), notImpersonated, enrolledMFA))
+	mux.Handle("POST /v1/mfa/authenticators/{id}/confirm", authenticated(mfa.ConfirmHandler(logger, app.mfa), notImpersonated, mfaLimited, enrolledMFA))
+	mux.Handle("DELETE /v1/mfa/authenticators/{id}", authenticated(mfa.RemoveAuthenticatorHandler(logger, app.mfa), notImpersonated, requireMFA))
+	mux.Handle("POST /v1/mfa/recovery-codes", authenticated(mfa.RegenerateRecoveryCodesHandler(logger, app.mfa), notImpersonated, requireMFA))
+	if app.accessIssuer != nil {
+		mux.Handle("POST /v1/mfa/verify", authenticated(mfa.VerifyHandler(logger, app.mfa, app.accessIssuer), notImpersonated, mfaLimited))
+	}
+
+	mux.Handle("POST /v1/api-keys", authenticated(apikeys.CreateKeyHandler(logger, app.apiKeys), notImpersonated))
+	mux.Handle("GET /v1/api-keys", authenticated(apikeys.ListKeysHandler(logger, app.apiKeys)))
+	mux.Handle("DELETE /v1/api-keys/{id}", authenticated(apikeys.RevokeKeyHandler(logger, app.apiKeys), notImpersonated))
+
+	// Organization routes act within the organization in their path, or else the one named by the request's
+	// header or claim, whose members are then authorized by its policies according to their org role
+	inOrg := orgs.Resolve(logger, app.orgs, app.tenantSources...)
+
+	mux.Handle("POST /v1/orgs", authenticated(orgs.CreateOrgHandler(logger, app.orgs), notImpersonated))
+	mux.Handle("GET /v1/orgs", authenticated(orgs.ListOrgsHandler(logger, app.orgs)))
+	mux.Handle("GET /v1/org", authenticated(orgs.GetOrgHandler(logger, app.policies), inOrg))
+	mux.Handle("GET /v1/orgs/{org}", authenticated(orgs.GetOrgHandler(logger, app.policies), inOrg))
+	mux.Handle("PATCH /v1/orgs/{org}", authenticated(orgs.UpdateOrgHandler(logger, app.policies, app.orgs), inOrg))
+	mux.Handle("DELETE /v1/orgs/{org}", authenticated(orgs.DeleteOrgHandler(logger, app.policies, app.orgs), inOrg, notImpersonated))
+	mux.Handle("GET /v1/orgs/{org}/members", authenticated(orgs.ListMembersHandler(logger, app.policies, app.orgs), inOrg))
+	mux.Handle("PATCH /v1/orgs/{org}/members/{userId}", authenticated(orgs.UpdateMemberHandler(logger, app.policies, app.orgs), inOrg, notImpersonated))
+	mux.Handle("DELETE /v1/orgs/{org}/members/{userId}", authenticated(orgs.RemoveMemberHandler(logger, app.policies, app.orgs), inOrg, notImpersonated))
+	mux.Handle("GET /v1/orgs/{org}/invitations", authenticated(orgs.ListInvitationsHandler(logger, app.policies, app.orgs), inOrg))
+	mux.Handle("POST /v1/orgs/{org}/invitations", authenticated(orgs.CreateInvitationHandler(logger, app.policies, app.orgs), inOrg, notImpersonated))
+	mux.Handle("DELETE /v1/orgs/{org}/invitations/{id}", authenticated(orgs.RevokeInvitationHandler(logger, app.policies, app.orgs), inOrg, notImpersonated))
+	mux.Handle("POST /v1/invitations/accept", authenticated(orgs.AcceptInvitationHandler(logger, app.orgs), notImpersonated))
+
+	mux.Handle("GET /v1/admin/audit", authenticated(
+		audit.ListEntriesHandler(logger, app.auditRepo),
+		users.RequirePermissions(logger, users.PermAuditRead),
+	))
+
+	mux.Handle("POST /v1/admin/revocations/tokens", authenticated(
+		revocation.RevokeTokenHandler(logger, app.revocations),
+		users.RequirePermissions(logger, users.PermTokensRevoke),
+		notImpersonated,
+		requireMFA,
+	))
+	mux.Handle("POST /v1/admin/users/{id}/revoke-tokens", authenticated(
+		revocation.RevokeUserTokensHandler(logger, app.revocations, sessionStoreRevokers(app.refreshTokens, app.sessions)...),
+		users.RequirePermissions(logger, users.PermTokensRevoke),
+		notImpersonated,
+		requireMFA,
+	))
+
+	// Suspending a user revokes their refresh tokens, cookie sessions and access tokens
+	suspensionRevokers := sessionRevokers(app.refreshTokens, app.revocations, app.sessions, "account suspended")
+
+	mux.Handle("POST /v1/admin/users/{id}/suspend", authenticated(
+		users.SuspendUserHandler(logger, app.userService, suspensionRevokers...),
+		users.RequirePermissions(logger, users.PermUsersManage),
+		notImpersonated,
+		requireMFA,
+	))
+	mux.Handle("POST /v1/admin/users/{id}/reinstate", authenticated(
+		users.ReinstateUserHandler(logger, app.userService),
+		users.RequirePermissions(logger, users.PermUsersManage),
+		notImpersonated,
+		requireMFA,
+	))
+
+	// Roles may be read with users:manage, but changing them also requires a recent second factor
+	manageRoles := users.RequirePermissions(logger, users.PermUsersManage)
+
+	mux.Handle("GET /v1/admin/roles", authenticated(users.ListRolesHandler(logger, app.roleService), manageRoles))
+	mux.Handle("POST /v1/admin/roles", authenticated(users.CreateRoleHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("GET /v1/admin/roles/{id}", authenticated(users.GetRoleHandler(logger, app.roleService), manageRoles))
+	mux.Handle("PATCH /v1/admin/roles/{id}", authenticated(users.UpdateRoleHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("DELETE /v1/admin/roles/{id}", authenticated(users.DeleteRoleHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("POST /v1/admin/roles/{id}/permissions", authenticated(users.AddRolePermissionsHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("DELETE /v1/admin/roles/{id}/permissions/{code}", authenticated(users.RemoveRolePermissionHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("PUT /v1/admin/roles/{id}/parents/{parentId}", authenticated(users.AddRoleParentHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("DELETE /v1/admin/roles/{id}/parents/{parentId}", authenticated(users.RemoveRoleParentHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("GET /v1/admin/roles/{id}/users", authenticated(users.ListRoleUsersHandler(logger, app.roleService), manageRoles))
+	mux.Handle("POST /v1/admin/roles/{id}/users", authenticated(users.AssignRoleHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+	mux.Handle("GET /v1/admin/permissions", authenticated(users.ListPermissionsHandler(logger, app.roleService), manageRoles))
+	mux.Handle("POST /v1/admin/permissions", authenticated(users.CreatePermissionHandler(logger, app.roleService), manageRoles, notImpersonated, requireMFA))
+
+	mux.Handle("POST /v1/admin/impersonations", authenticated(
+		impersonation.StartHandler(logger, app.impersonations),
+		users.RequirePermissions(logger, users.PermUsersImpersonate),
+		notImpersonated,
+		requireMFA,
+	))
+	mux.Handle("DELETE /v1/admin/impersonations/{id}", authenticated(
+		impersonation.EndHandler(logger, app.impersonations),
+		notImpersonated,
+	))
 }
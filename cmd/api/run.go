@@ -2,11 +2,37 @@ package main
 
 import (
 	"context"
+	"expvar"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apikeys"
 	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/common"
+	"go-web-api-starter/internal/credentials"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/impersonation"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/magiclink"
+	"go-web-api-starter/internal/mailer"
+	"go-web-api-starter/internal/mfa"
+	"go-web-api-starter/internal/oidc"
+	"go-web-api-starter/internal/orgs"
+	"go-web-api-starter/internal/policy"
+	"go-web-api-starter/internal/ratelimit"
+	"go-web-api-starter/internal/refreshtokens"
+	"go-web-api-starter/internal/revocation"
+	"go-web-api-starter/internal/sessions"
+	"go-web-api-starter/internal/tokens"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/webhooks"
 	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 )
 
 func run(
@@ -22,36 +48,218 @@ func run(
 	)
 	defer cancel()
 
-	//Uncomment for postgres config
-	//sslEnabled := common.BoolEnv(getEnv, "SSL_ENABLED", false)
-	//dbConfig := database.NewDatabase(sslEnabled)
-	//db, err := dbConfig.OpenDB("postgres")
-	//if db != nil {
-	//	defer func() {
-	//		dbErr := db.Close()
-	//		if dbErr != nil {
-	//			stderr.Write([]byte(dbErr.Error()))
-	//		}
-	//	}()
-	//}
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//err = db.RunMigrations(database.DialectPostgres)
-	//if err != nil {
-	//	return err
-	//}
+	sslEnabled := common.BoolEnv(getEnv, "SSL_ENABLED", false)
+	dbConfig := database.NewDatabase(sslEnabled)
+	db, err := dbConfig.OpenDB("postgres")
+	if db != nil {
+		defer func() {
+			dbErr := db.Close()
+			if dbErr != nil {
+				stderr.Write([]byte(dbErr.Error()))
+			}
+		}()
+	}
+	if err != nil {
+		return err
+	}
 
-	app := &application{
-		config: apiutils.NewApiConfig(getEnv, "API_PORT"),
+	err = db.RunMigrations(database.DialectPostgres)
+	if err != nil {
+		return err
 	}
 
-	httpServer := newServer(
-		app.config.Logger,
+	config := apiutils.NewApiConfig(getEnv, "API_PORT")
+
+	settings := jwtauth.Settings{
+		Issuer:   common.StringEnv(getEnv, "JWT_ISSUER", ""),
+		Audience: strings.Split(common.StringEnv(getEnv, "JWT_AUDIENCE", "authenticated"), ","),
+	}
+
+	signer, err := newSigner(ctx, getEnv, config, settings)
+	if err != nil {
+		return err
+	}
+
+	jwtReader, err := newReader(ctx, getEnv, config, settings, signer)
+	if err != nil {
+		return err
+	}
+
+	auditRepo := audit.AuditPsqlRepo{DB: db}
+	auditWriter := audit.NewWriter(
+		config.Logger,
+		auditRepo,
+		audit.WithBatchSize(common.IntEnv(getEnv, "AUDIT_BATCH_SIZE", 100)),
+		audit.WithFlushInterval(common.DurationEnv(getEnv, "AUDIT_FLUSH_INTERVAL", 2*time.Second)),
 	)
+	apiutils.BackgroundWg(&config.Wg, auditWriter.Run)
+
+	revocations, err := newRevocationStore(ctx, getEnv, config, db, dbConfig.Dsn)
+	if err != nil {
+		return err
+	}
+
+	userService, roleService := newUserServices(ctx, getEnv, config, db, dbConfig.Dsn)
+
+	// Roles come from the database unless ROLE_SOURCE is claims or hybrid, which map
+	// the claims of tokens to roles with the mapping in CLAIM_MAPPING_FILE
+	roleSource, err := users.ParseRoleSource(common.StringEnv(getEnv, "ROLE_SOURCE", string(users.RolesFromDatabase)))
+	if err != nil {
+		return err
+	}
+	var claimMapping *users.ClaimMapping
+	if roleSource != users.RolesFromDatabase {
+		path := getEnv("CLAIM_MAPPING_FILE")
+		if path == "" {
+			return fmt.Errorf("CLAIM_MAPPING_FILE must be set when ROLE_SOURCE is %s", roleSource)
+		}
+		claimMapping, err = users.LoadClaimMappingFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	refreshTokens := refreshtokens.NewService(
+		config.Logger,
+		refreshtokens.RefreshTokenPsqlRepo{DB: db},
+		userService,
+		common.DurationEnv(getEnv, "REFRESH_TOKEN_TTL", 30*24*time.Hour),
+	)
+	go refreshTokens.Run(ctx, common.DurationEnv(getEnv, "REFRESH_TOKEN_CLEANUP_INTERVAL", time.Hour))
+
+	// First-party sign in issues tokens with the signer, so it is only available when one is configured
+	var accessIssuer *auth.AccessTokenIssuer
+	var tokenIssuer auth.Issuer
+	if signer != nil {
+		accessIssuer = auth.NewAccessTokenIssuer(signer, common.DurationEnv(getEnv, "ACCESS_TOKEN_TTL", 15*time.Minute))
+		tokenIssuer = refreshtokens.NewIssuer(accessIssuer, refreshTokens)
+	}
+
+	var oidcService *oidc.Service
+	if path := getEnv("OIDC_PROVIDERS_FILE"); path != "" {
+		providers, err := oidc.LoadProviderConfigsFile(path, getEnv)
+		if err != nil {
+			return err
+		}
+		oidcService = oidc.NewService(
+			config.Logger,
+			providers,
+			nil,
+			oidc.StatePsqlRepo{DB: db},
+			oidc.IdentityPsqlRepo{DB: db},
+			userService,
+		)
+	}
+
+	sessionManager, err := newSessionManager(ctx, getEnv, config, db, userService)
+	if err != nil {
+		return err
+	}
+
+	credentialsService := credentials.NewService(config.Logger, credentials.CredentialPsqlRepo{DB: db}, userService)
+
+	// Resetting a password signs the user out everywhere, as suspending them does
+	resetRevokers := sessionRevokers(refreshTokens, revocations, sessionManager, "password reset")
 
-	err := apiutils.Serve(
+	// The flows that send emails are only available when SMTP_HOST is set
+	var tokenService *tokens.Service
+	var tokenRequestLimiter, magicLinkLimiter *ratelimit.Limiter
+	var magicLinks *magiclink.Service
+	orgOpts := []orgs.ServiceOption{
+		orgs.WithInvitationTTL(common.DurationEnv(getEnv, "ORG_INVITATION_TTL", 7*24*time.Hour)),
+		orgs.WithBackground(func(fn func()) { apiutils.BackgroundWg(&config.Wg, fn) }),
+	}
+	if mail, ok := newMailer(getEnv); ok {
+		orgOpts = append(orgOpts, orgs.WithMailer(mail))
+		tokenService, tokenRequestLimiter = newTokenService(ctx, getEnv, config, db, mail, userService, credentialsService, resetRevokers)
+
+		magicLinks, magicLinkLimiter, err = newMagicLinkService(ctx, getEnv, config, db, mail, userService)
+		if err != nil {
+			return err
+		}
+	}
+
+	mfaService := mfa.NewService(
+		config.Logger,
+		mfa.AuthenticatorPsqlRepo{DB: db},
+		mfa.RecoveryCodePsqlRepo{DB: db},
+		mfa.WithIssuerName(common.StringEnv(getEnv, "MFA_ISSUER_NAME", "Greenlight")),
+	)
+	// Limits guessing codes, per user, on the routes that check them
+	mfaLimiter := ratelimit.New(
+		common.IntEnv(getEnv, "MFA_VERIFY_LIMIT", 5),
+		common.DurationEnv(getEnv, "MFA_VERIFY_PERIOD", 5*time.Minute),
+	)
+	go mfaLimiter.Run(ctx, 10*time.Minute)
+
+	impersonations := impersonation.NewService(
+		config.Logger,
+		impersonation.ImpersonationPsqlRepo{DB: db},
+		userService,
+		impersonation.WithMaxDuration(common.DurationEnv(getEnv, "IMPERSONATION_MAX_DURATION", time.Hour)),
+	)
+
+	// The webhook receiver is only available when the signing secret is configured
+	var webhookVerifier *webhooks.Verifier
+	if secret := getEnv("SUPABASE_WEBHOOK_SECRET"); secret != "" {
+		webhookVerifier, err = webhooks.NewVerifier(secret, common.DurationEnv(getEnv, "SUPABASE_WEBHOOK_TOLERANCE", 5*time.Minute))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Requests act within the organization in their path, else the one in the ORG_HEADER header,
+	// else, when ORG_CLAIM is set, the one in that claim of their token
+	tenantSources := []orgs.TenantSource{
+		orgs.FromPath("org"),
+		orgs.FromHeader(common.StringEnv(getEnv, "ORG_HEADER", "X-Org-ID")),
+	}
+	if claim := getEnv("ORG_CLAIM"); claim != "" {
+		tenantSources = append(tenantSources, orgs.FromClaim(claim))
+	}
+
+	policies := policy.NewEngine()
+	orgs.RegisterPolicies(policies)
+
+	app := &application{
+		config:             config,
+		jwtReader:          jwtReader,
+		signer:             signer,
+		accessIssuer:       accessIssuer,
+		tokenIssuer:        tokenIssuer,
+		userService:        userService,
+		roleService:        roleService,
+		roleSource:         roleSource,
+		claimMapping:       claimMapping,
+		credentialsService: credentialsService,
+		refreshTokens:      refreshTokens,
+		revocations:        revocations,
+		apiKeys:            apikeys.NewService(config.Logger, apikeys.APIKeyPsqlRepo{DB: db}, userService),
+		apiKeyHeader:       common.StringEnv(getEnv, "API_KEY_HEADER", "X-API-Key"),
+		oidc:               oidcService,
+		sessions:           sessionManager,
+		tokens:             tokenService,
+		tokenLimiter:       tokenRequestLimiter,
+		magicLinks:         magicLinks,
+		magicLinkLimiter:   magicLinkLimiter,
+		mfa:                mfaService,
+		mfaLimiter:         mfaLimiter,
+		mfaRequired:        common.BoolEnv(getEnv, "MFA_REQUIRED", true),
+		mfaMaxAge:          common.DurationEnv(getEnv, "MFA_MAX_AGE", 15*time.Minute),
+		metricsEnabled:     common.BoolEnv(getEnv, "METRICS_ENABLED", false),
+		impersonations:     impersonations,
+		orgs:               orgs.NewService(config.Logger, orgs.OrgPsqlRepo{DB: db}, orgOpts...),
+		tenantSources:      tenantSources,
+		policies:           policies,
+		webhookVerifier:    webhookVerifier,
+		webhooks:           webhooks.NewService(config.Logger, webhooks.EventPsqlRepo{DB: db}, userService),
+		auditRepo:          auditRepo,
+		auditWriter:        auditWriter,
+	}
+
+	httpServer := newServer(app)
+
+	err = apiutils.Serve(
 		httpServer,
 		app.config.Logger,
 		app.config.Env,
@@ -59,8 +267,362 @@ func run(
 		app.config.Version,
 		ctx,
 	)
+
+	// Flush the audit entries recorded by in-flight requests before exiting
+	app.auditWriter.Close()
+	app.config.Wg.Wait()
+
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// newRevocationStore loads the token revocations and keeps them in sync, every
+// REVOCATION_SYNC_INTERVAL and, unless REVOCATION_LISTEN is false, as soon as
+// another instance announces one over Postgres notifications.
+func newRevocationStore(
+	ctx context.Context,
+	getEnv func(string) string,
+	config *apiutils.ApiConfig,
+	db *database.DB,
+	dsn string,
+) (*revocation.Store, error) {
+	store := revocation.NewStore(
+		config.Logger,
+		revocation.RevocationPsqlRepo{DB: db},
+		revocation.WithMaxTokenLifetime(common.DurationEnv(getEnv, "REVOCATION_MAX_TOKEN_LIFETIME", 24*time.Hour)),
+	)
+	if err := store.Sync(); err != nil {
+		return nil, err
+	}
+
+	go store.Run(ctx, common.DurationEnv(getEnv, "REVOCATION_SYNC_INTERVAL", 30*time.Second))
+
+	if common.BoolEnv(getEnv, "REVOCATION_LISTEN", true) {
+		go func() {
+			err := database.Listen(ctx, config.Logger, dsn, revocation.NotifyChannel, store.Apply, store.Resync)
+			if err != nil {
+				config.Logger.Error("failed to listen for token revocations", "error", err)
+			}
+		}()
+	}
+
+	return store, nil
+}
+
+// newUserServices builds the user and role services, reading users through a cache of USER_CACHE_CAPACITY
+// users unless it is 0. Cached users are dropped when they or their role change on this instance and, unless
+// USER_CACHE_LISTEN is false, as soon as another instance announces a change over Postgres notifications.
+// The cache counters are published with expvar as user_cache.
+func newUserServices(
+	ctx context.Context,
+	getEnv func(string) string,
+	config *apiutils.ApiConfig,
+	db *database.DB,
+	dsn string,
+) (*users.UserService, *users.RoleService) {
+	repo := users.UserPsqlRepo{DB: db}
+	roleRepo := users.RolePsqlRepo{DB: db}
+
+	capacity := common.IntEnv(getEnv, "USER_CACHE_CAPACITY", 10000)
+	if capacity <= 0 {
+		return users.NewUserService(repo), users.NewRoleService(roleRepo)
+	}
+
+	cache := users.NewCache(
+		config.Logger,
+		repo,
+		users.WithCacheCapacity(capacity),
+		users.WithCacheTTL(common.DurationEnv(getEnv, "USER_CACHE_TTL", time.Minute)),
+	)
+	expvar.Publish("user_cache", expvar.Func(func() any { return cache.Stats() }))
+
+	if common.BoolEnv(getEnv, "USER_CACHE_LISTEN", true) {
+		go func() {
+			err := database.Listen(ctx, config.Logger, dsn, users.NotifyChannel, cache.Apply, cache.Resync)
+			if err != nil {
+				config.Logger.Error("failed to listen for user changes", "error", err)
+			}
+		}()
+	}
+
+	return users.NewUserService(repo, users.WithCache(cache)), users.NewRoleService(roleRepo, users.WithRoleCache(cache))
+}
+
+// newSessionManager builds the cookie session manager when SESSION_KEYS is set, keeping sessions
+// in Postgres, or in memory when SESSION_STORE is memory. Returns a nil manager otherwise.
+func newSessionManager(
+	ctx context.Context,
+	getEnv func(string) string,
+	config *apiutils.ApiConfig,
+	db *database.DB,
+	userService *users.UserService,
+) (*sessions.Manager, error) {
+	encodedKeys := getEnv("SESSION_KEYS")
+	if encodedKeys == "" {
+		return nil, nil
+	}
+
+	keys, err := sessions.ParseKeys(encodedKeys)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := sessions.NewCodec(keys...)
+	if err != nil {
+		return nil, err
+	}
+
+	var store sessions.Store = sessions.SessionPsqlRepo{DB: db}
+	if getEnv("SESSION_STORE") == "memory" {
+		store = sessions.NewMemoryStore()
+	}
+
+	manager := sessions.NewManager(
+		config.Logger,
+		store,
+		userService,
+		codec,
+		sessions.WithCookieName(common.StringEnv(getEnv, "SESSION_COOKIE_NAME", sessions.DefaultCookieName)),
+		sessions.WithSecureCookie(common.BoolEnv(getEnv, "SESSION_COOKIE_SECURE", true)),
+		sessions.WithIdleTimeout(common.DurationEnv(getEnv, "SESSION_IDLE_TIMEOUT", 12*time.Hour)),
+		sessions.WithAbsoluteTimeout(common.DurationEnv(getEnv, "SESSION_ABSOLUTE_TIMEOUT", 7*24*time.Hour)),
+	)
+	go manager.Run(ctx, common.DurationEnv(getEnv, "SESSION_CLEANUP_INTERVAL", time.Hour))
+
+	return manager, nil
+}
+
+// sessionRevokers returns the revokers that sign a user out everywhere: their refresh tokens,
+// their access tokens, revoked with the reason, and their cookie sessions when those are enabled.
+func sessionRevokers(
+	refreshTokens *refreshtokens.Service,
+	revocations *revocation.Store,
+	sessionManager *sessions.Manager,
+	reason string,
+) []users.SessionRevoker {
+	return append(sessionStoreRevokers(refreshTokens, sessionManager),
+		users.SessionRevokerFunc(func(userID uuid.UUID) error {
+			return revocations.RevokeUser(&revocation.UserRevocation{UserID: userID, Reason: reason})
+		}),
+	)
+}
+
+// sessionStoreRevokers returns the revokers that end the sessions a user could get new access tokens
+// with: their refresh token families, and their cookie sessions when those are enabled.
+func sessionStoreRevokers(refreshTokens *refreshtokens.Service, sessionManager *sessions.Manager) []users.SessionRevoker {
+	revokers := []users.SessionRevoker{refreshTokens}
+	if sessionManager != nil {
+		revokers = append(revokers, sessionManager)
+	}
+
+	return revokers
+}
+
+// newMailer builds the mailer from the SMTP_* variables, and reports whether SMTP_HOST is set.
+func newMailer(getEnv func(string) string) (mailer.Mailer, bool) {
+	host := getEnv("SMTP_HOST")
+	if host == "" {
+		return mailer.Mailer{}, false
+	}
+
+	return mailer.New(
+		host,
+		common.IntEnv(getEnv, "SMTP_PORT", 25),
+		getEnv("SMTP_USERNAME"),
+		getEnv("SMTP_PASSWORD"),
+		common.StringEnv(getEnv, "SMTP_SENDER", "Greenlight <no-reply@greenlight.local>"),
+	), true
+}
+
+// newTokenService builds the service that mails verification, password reset and email change
+// tokens, along with the per-IP limiter of the requests for them. Each email address is sent at
+// most TOKEN_EMAIL_LIMIT tokens per TOKEN_EMAIL_PERIOD, and each IP may make TOKEN_REQUEST_LIMIT
+// requests per TOKEN_REQUEST_PERIOD.
+func newTokenService(
+	ctx context.Context,
+	getEnv func(string) string,
+	config *apiutils.ApiConfig,
+	db *database.DB,
+	mail mailer.Mailer,
+	userService *users.UserService,
+	credentialsService *credentials.Service,
+	revokers []users.SessionRevoker,
+) (*tokens.Service, *ratelimit.Limiter) {
+	emailLimiter := ratelimit.New(
+		common.IntEnv(getEnv, "TOKEN_EMAIL_LIMIT", 3),
+		common.DurationEnv(getEnv, "TOKEN_EMAIL_PERIOD", time.Hour),
+	)
+	requestLimiter := ratelimit.New(
+		common.IntEnv(getEnv, "TOKEN_REQUEST_LIMIT", 10),
+		common.DurationEnv(getEnv, "TOKEN_REQUEST_PERIOD", time.Hour),
+	)
+	go emailLimiter.Run(ctx, 10*time.Minute)
+	go requestLimiter.Run(ctx, 10*time.Minute)
+
+	service := tokens.NewService(
+		config.Logger,
+		tokens.TokenPsqlRepo{DB: db},
+		userService,
+		credentialsService,
+		mail,
+		tokens.WithTTL(tokens.ScopeVerification, common.DurationEnv(getEnv, "VERIFICATION_TOKEN_TTL", 72*time.Hour)),
+		tokens.WithTTL(tokens.ScopePasswordReset, common.DurationEnv(getEnv, "PASSWORD_RESET_TOKEN_TTL", time.Hour)),
+		tokens.WithTTL(tokens.ScopeEmailChange, common.DurationEnv(getEnv, "EMAIL_CHANGE_TOKEN_TTL", 24*time.Hour)),
+		tokens.WithEmailLimiter(emailLimiter),
+		tokens.WithSessionRevokers(revokers...),
+		tokens.WithBackground(func(fn func()) { apiutils.BackgroundWg(&config.Wg, fn) }),
+	)
+	go service.Run(ctx, common.DurationEnv(getEnv, "TOKEN_CLEANUP_INTERVAL", time.Hour))
+
+	return service, requestLimiter
+}
+
+// newMagicLinkService builds the service that mails sign in links to MAGIC_LINK_URL when it is set,
+// along with the per-IP limiter of the requests for them. Each email address is sent at most
+// MAGIC_LINK_EMAIL_LIMIT links per MAGIC_LINK_EMAIL_PERIOD, and each IP may make MAGIC_LINK_REQUEST_LIMIT
+// requests per MAGIC_LINK_REQUEST_PERIOD. Returns nils otherwise.
+func newMagicLinkService(
+	ctx context.Context,
+	getEnv func(string) string,
+	config *apiutils.ApiConfig,
+	db *database.DB,
+	mail mailer.Mailer,
+	userService *users.UserService,
+) (*magiclink.Service, *ratelimit.Limiter, error) {
+	linkURL := getEnv("MAGIC_LINK_URL")
+	if linkURL == "" {
+		return nil, nil, nil
+	}
+
+	emailLimiter := ratelimit.New(
+		common.IntEnv(getEnv, "MAGIC_LINK_EMAIL_LIMIT", 3),
+		common.DurationEnv(getEnv, "MAGIC_LINK_EMAIL_PERIOD", time.Hour),
+	)
+	requestLimiter := ratelimit.New(
+		common.IntEnv(getEnv, "MAGIC_LINK_REQUEST_LIMIT", 10),
+		common.DurationEnv(getEnv, "MAGIC_LINK_REQUEST_PERIOD", time.Hour),
+	)
+
+	service, err := magiclink.NewService(
+		config.Logger,
+		magiclink.LinkPsqlRepo{DB: db},
+		userService,
+		mail,
+		linkURL,
+		magiclink.WithTTL(common.DurationEnv(getEnv, "MAGIC_LINK_TTL", 15*time.Minute)),
+		magiclink.WithEmailLimiter(emailLimiter),
+		magiclink.WithBackground(func(fn func()) { apiutils.BackgroundWg(&config.Wg, fn) }),
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	go emailLimiter.Run(ctx, 10*time.Minute)
+	go requestLimiter.Run(ctx, 10*time.Minute)
+	go service.Run(ctx, common.DurationEnv(getEnv, "MAGIC_LINK_CLEANUP_INTERVAL", time.Hour))
+
+	return service, requestLimiter, nil
+}
+
+// newSigner builds the token signer from the key directory JWT_SIGNING_KEY_DIR, from JWT_SIGNING_KEY_FILE
+// and JWT_RETIRING_SIGNING_KEY_FILES, or from JWT_SIGNING_KEY and JWT_RETIRING_SIGNING_KEYS. Scheduled key
+// rotation starts when JWT_KEY_ROTATION_INTERVAL is set, which requires the key directory: rotated keys
+// must outlive the process and reach the other instances. Returns a nil signer when no signing key is configured.
+func newSigner(
+	ctx context.Context,
+	getEnv func(string) string,
+	config *apiutils.ApiConfig,
+	settings jwtauth.Settings,
+) (*jwtauth.Signer, error) {
+	retention := jwtauth.WithRetention(common.DurationEnv(getEnv, "JWT_KEY_RETENTION", 24*time.Hour))
+
+	var keys *jwtauth.Keyring
+	var err error
+
+	interval := common.DurationEnv(getEnv, "JWT_KEY_ROTATION_INTERVAL", 0)
+
+	switch {
+	case getEnv("JWT_SIGNING_KEY_DIR") != "":
+		keys, err = jwtauth.KeyringFromDir(getEnv("JWT_SIGNING_KEY_DIR"), retention)
+	case interval > 0:
+		return nil, fmt.Errorf("JWT_KEY_ROTATION_INTERVAL requires JWT_SIGNING_KEY_DIR, to keep the keys rotated to")
+	case getEnv("JWT_SIGNING_KEY_FILE") != "":
+		var retiringPaths []string
+		if paths := getEnv("JWT_RETIRING_SIGNING_KEY_FILES"); paths != "" {
+			retiringPaths = strings.Split(paths, ",")
+		}
+		keys, err = jwtauth.KeyringFromFiles(getEnv("JWT_SIGNING_KEY_FILE"), retiringPaths, retention)
+	case getEnv("JWT_SIGNING_KEY") != "":
+		keys, err = jwtauth.KeyringFromEnv(getEnv, "JWT_SIGNING_KEY", "JWT_RETIRING_SIGNING_KEYS", retention)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		go keys.Run(ctx, config.Logger, interval)
+	}
+
+	return jwtauth.NewKeyringSigner(keys, settings.Issuer, settings.Audience), nil
+}
+
+// newReader builds the token reader from every verification key that is configured:
+// JWT_SECRET for HMAC tokens, JWT_PUBLIC_KEYS for ES256 public keys, the signer's own keys
+// unless JWT_VERIFY_SIGNER is false, and the key set at JWKS_URL. Claims are validated
+// with the default policy adjusted by the JWT_LEEWAY, JWT_MAX_AGE, JWT_ACCEPTED_ISSUERS,
+// JWT_ACCEPTED_AUDIENCES and JWT_REQUIRE_EMAIL variables.
+func newReader(
+	ctx context.Context,
+	getEnv func(string) string,
+	config *apiutils.ApiConfig,
+	settings jwtauth.Settings,
+	signer *jwtauth.Signer,
+) (*jwtauth.Reader, error) {
+	policy := jwtauth.DefaultPolicy()
+	policy.Leeway = common.DurationEnv(getEnv, "JWT_LEEWAY", policy.Leeway)
+	policy.MaxAge = common.DurationEnv(getEnv, "JWT_MAX_AGE", 0)
+	if issuers := getEnv("JWT_ACCEPTED_ISSUERS"); issuers != "" {
+		policy.Issuers = strings.Split(issuers, ",")
+	}
+	if audiences := getEnv("JWT_ACCEPTED_AUDIENCES"); audiences != "" {
+		policy.Audiences = strings.Split(audiences, ",")
+	}
+	if common.BoolEnv(getEnv, "JWT_REQUIRE_EMAIL", false) {
+		policy.RequiredClaims = map[string]jwtauth.ClaimType{"email": jwtauth.ClaimString}
+	}
+
+	readerConfig := jwtauth.ReaderConfig{
+		Settings:   settings,
+		HmacSecret: getEnv("JWT_SECRET"),
+		Policy:     &policy,
+	}
+
+	if publicKeys := getEnv("JWT_PUBLIC_KEYS"); publicKeys != "" {
+		readerConfig.PublicKeys = strings.Split(publicKeys, ",")
+	}
+
+	if signer != nil && common.BoolEnv(getEnv, "JWT_VERIFY_SIGNER", true) {
+		readerConfig.Signer = signer
+	}
+
+	// Verify tokens against the identity provider's published keys when a JWKS URL is configured
+	if jwksURL := getEnv("JWKS_URL"); jwksURL != "" {
+		jwks := jwtauth.NewJWKS(
+			jwksURL,
+			jwtauth.WithJWKSLogger(config.Logger),
+			jwtauth.WithRefreshInterval(common.DurationEnv(getEnv, "JWKS_REFRESH_INTERVAL", 15*time.Minute)),
+			jwtauth.WithMaxStale(common.DurationEnv(getEnv, "JWKS_MAX_STALE", 24*time.Hour)),
+		)
+		if err := jwks.Refresh(ctx); err != nil {
+			config.Logger.Error("initial jwks fetch failed, keys will be fetched on demand", "error", err)
+		}
+		go jwks.Run(ctx)
+
+		readerConfig.JWKS = jwks
+	}
+
+	return jwtauth.NewReaderFromConfig(readerConfig)
+}
@@ -2,27 +2,39 @@ package main
 
 import (
 	"encoding/json"
+	"expvar"
+	"go-web-api-starter/internal/jwtauth"
 	"go-web-api-starter/internal/middleware"
 	"log/slog"
 	"net/http"
 )
 
 func newServer(
-	logger *slog.Logger,
+	app *application,
 ) http.Handler {
+	logger := app.config.Logger
 	v1Mux := http.NewServeMux()
 
-	addRoutesV1(v1Mux)
+	addRoutesV1(v1Mux, app)
 
 	mux := http.NewServeMux()
 	mux.Handle("/v1/", v1Mux)
 	mux.Handle("/ping", ping(logger))
+	// The expvar metrics, such as the user cache counters, are only served when METRICS_ENABLED is true,
+	// as they are meant for the internal network
+	if app.metricsEnabled {
+		mux.Handle("GET /debug/vars", expvar.Handler())
+	}
+	if app.signer != nil {
+		mux.Handle("GET /.well-known/jwks.json", jwtauth.JWKSHandler(logger, app.signer.Keys))
+	}
 
 	recoverM := middleware.RecoverPanic(logger)
-	loggerM := middleware.Logger(logger, []string{"/ping"})
+	loggerM := middleware.Logger(logger, []string{"/ping", "/debug/vars"})
 
 	var server http.Handler = mux
 	server = loggerM(server)
+	server = middleware.RequestID(server)
 	server = middleware.RealIP(server)
 	server = recoverM(server)
 
@@ -0,0 +1,7 @@
+package apikeys
+
+import "errors"
+
+var (
+	ErrInvalidKey = errors.New("api key is invalid, expired or revoked")
+)
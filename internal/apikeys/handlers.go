@@ -0,0 +1,124 @@
+package apikeys
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type keyCreator interface {
+	Create(owner *users.User, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error)
+}
+
+type keyManager interface {
+	List(userID uuid.UUID) ([]*APIKey, error)
+	Revoke(userID, id uuid.UUID) error
+}
+
+// CreateKeyHandler creates an API key for the authenticated user. The key is only
+// part of this response, it cannot be retrieved again.
+func CreateKeyHandler(
+	logger *slog.Logger,
+	creator keyCreator,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name      string     `json:"name"`
+			Scopes    []string   `json:"scopes"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		if input.Scopes == nil {
+			input.Scopes = []string{}
+		}
+
+		owner := users.ContextGetUser(r)
+
+		v := validator.New()
+		if ValidateKey(v, owner, input.Name, input.Scopes, input.ExpiresAt); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		key, secret, err := creator.Create(owner, input.Name, input.Scopes, input.ExpiresAt)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetResource(r, "api_key", key.ID.String())
+		audit.SetAfter(r, key)
+
+		err = apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"apiKey": key, "key": secret}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("CreateKeyHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListKeysHandler responds with the authenticated user's API keys that are not revoked.
+func ListKeysHandler(
+	logger *slog.Logger,
+	manager keyManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys, err := manager.List(users.ContextGetUserId(r))
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"apiKeys": keys}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListKeysHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RevokeKeyHandler revokes the authenticated user's API key with the id in the path.
+func RevokeKeyHandler(
+	logger *slog.Logger,
+	manager keyManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		id := apiutils.ReadUUIDPath(r, "id", v)
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "api_key", id.String())
+
+		err := manager.Revoke(users.ContextGetUserId(r), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "api key revoked"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RevokeKeyHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
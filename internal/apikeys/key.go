@@ -0,0 +1,84 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/users"
+	"hash/crc32"
+	"strings"
+	"time"
+)
+
+const (
+	// Prefix starts every key, so that leaked keys are easy to recognise by secret scanners.
+	Prefix = "gwa_"
+
+	secretBytes    = 20
+	secretLength   = 32 // base32 characters for secretBytes
+	checksumLength = 7  // base32 characters for a CRC-32
+	keyLength      = len(Prefix) + secretLength + checksumLength
+
+	// displayLength is how much of the key is stored in clear, to tell keys apart when listing them.
+	displayLength = len(Prefix) + 8
+)
+
+var encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// APIKey is a stored API key. Only the SHA-256 hash of the key is kept.
+// The key acts as its owner, limited to its scopes.
+type APIKey struct {
+	ID         uuid.UUID         `json:"id"`
+	UserID     uuid.UUID         `json:"userId"`
+	Name       string            `json:"name"`
+	Prefix     string            `json:"prefix"`
+	KeyHash    []byte            `json:"-"`
+	Scopes     users.Permissions `json:"scopes"`
+	ExpiresAt  *time.Time        `json:"expiresAt"`
+	LastUsedAt *time.Time        `json:"lastUsedAt"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	RevokedAt  *time.Time        `json:"revokedAt,omitempty"`
+}
+
+// Expired reports whether the key has an expiry that has passed.
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// generateKey returns a new key made of the prefix, a random secret and a CRC-32 checksum
+// of the secret, along with the hash it is stored under.
+func generateKey() (string, []byte, error) {
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, err
+	}
+
+	body := encoding.EncodeToString(secret)
+	key := Prefix + body + checksum(body)
+
+	return key, hashKey(key), nil
+}
+
+// wellFormed reports whether the key has the prefix, length and checksum of a generated key.
+// It rejects mistyped and made up keys without querying the database.
+func wellFormed(key string) bool {
+	if len(key) != keyLength || !strings.HasPrefix(key, Prefix) {
+		return false
+	}
+
+	body := key[len(Prefix) : len(Prefix)+secretLength]
+	return checksum(body) == key[len(Prefix)+secretLength:]
+}
+
+func checksum(body string) string {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.ChecksumIEEE([]byte(body)))
+	return encoding.EncodeToString(sum)
+}
+
+func hashKey(key string) []byte {
+	sum := sha256.Sum256([]byte(key))
+	return sum[:]
+}
@@ -0,0 +1,51 @@
+package apikeys
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeneratedKeysAreWellFormed(t *testing.T) {
+	key, keyHash, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(key, Prefix) || len(key) != keyLength {
+		t.Errorf("Expected a %d character key starting with %q, got %q", keyLength, Prefix, key)
+	}
+	if !wellFormed(key) {
+		t.Errorf("Expected generated key %q to be well formed", key)
+	}
+	if string(keyHash) == key || len(keyHash) != 32 {
+		t.Error("Expected the key hash to be a SHA-256 digest")
+	}
+}
+
+func TestWellFormedRejectsTypos(t *testing.T) {
+	key, _, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	typo := []byte(key)
+	i := len(Prefix) + 3
+	if typo[i] == 'a' {
+		typo[i] = 'b'
+	} else {
+		typo[i] = 'a'
+	}
+
+	for name, candidate := range map[string]string{
+		"typo":            string(typo),
+		"wrong prefix":    "xyz_" + key[len(Prefix):],
+		"truncated":       key[:len(key)-1],
+		"bearer token":    "eyJhbGciOiJIUzI1NiJ9.e30.signature",
+		"empty":           "",
+		"only the prefix": Prefix,
+	} {
+		if wellFormed(candidate) {
+			t.Errorf("Expected the %s key to be rejected", name)
+		}
+	}
+}
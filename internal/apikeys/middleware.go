@@ -0,0 +1,102 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// AuthorizationScheme is the scheme of an Authorization header carrying an API key.
+const AuthorizationScheme = "ApiKey"
+
+type contextKey string
+
+const apiKeyContextKey = contextKey("apiKey")
+
+// ContextGetAPIKey returns the API key the request was authenticated with, if any.
+func ContextGetAPIKey(r *http.Request) (*APIKey, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey).(*APIKey)
+	return key, ok
+}
+
+type keyAuthenticator interface {
+	Authenticate(secret string) (*users.User, *APIKey, error)
+}
+
+// readKey returns the API key from an "Authorization: ApiKey <key>" header, or from the
+// custom header when one is configured, and whether the request carries one at all.
+func readKey(r *http.Request, header string) (string, bool) {
+	if scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && scheme == AuthorizationScheme {
+		return key, true
+	}
+
+	if header != "" {
+		if key := r.Header.Get(header); key != "" {
+			return key, true
+		}
+	}
+
+	return "", false
+}
+
+// Authenticate authenticates requests that carry an API key, either in an
+// "Authorization: ApiKey <key>" header or in the custom header when it is not empty.
+// The key's owner is set as the context user, with their permissions limited to the key's
+// scopes, so users.ContextGetUser and users.RequirePermissions work as with a token.
+//
+// Requests without an API key are passed to the fallback middleware, typically
+// users.Authenticate, so routes accept either. With a nil fallback they are rejected.
+func Authenticate(
+	logger *slog.Logger,
+	authenticator keyAuthenticator,
+	header string,
+	fallback func(http.Handler) http.Handler,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var fallbackHandler http.Handler
+		if fallback != nil {
+			fallbackHandler = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			secret, ok := readKey(r, header)
+			if !ok {
+				if fallbackHandler == nil {
+					apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "missing api key")
+					return
+				}
+				fallbackHandler.ServeHTTP(w, r)
+				return
+			}
+
+			user, key, err := authenticator.Authenticate(secret)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrInvalidKey):
+					apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "invalid api key")
+				default:
+					apiutils.ServerErrorResponse(w, r, logger, err)
+				}
+				return
+			}
+
+			if message := users.InactiveUserMessage(user); message != "" {
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+				return
+			}
+
+			r = users.ContextSetUser(r, user)
+			r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key))
+
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Info("user authenticated", "request id", requestId, "user id", user.ID, "api key id", key.ID)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package apikeys
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/testutils"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockAPIKeyRepo struct {
+	keys []*APIKey
+}
+
+func (m *mockAPIKeyRepo) Insert(key *APIKey) error {
+	m.keys = append(m.keys, key)
+	return nil
+}
+
+func (m *mockAPIKeyRepo) GetByHash(keyHash []byte) (*APIKey, error) {
+	for _, key := range m.keys {
+		if bytes.Equal(key.KeyHash, keyHash) {
+			return key, nil
+		}
+	}
+	return nil, database.ErrRecordNotFound
+}
+
+func (m *mockAPIKeyRepo) ListForUser(userID uuid.UUID) ([]*APIKey, error) {
+	return m.keys, nil
+}
+
+func (m *mockAPIKeyRepo) Revoke(userID, id uuid.UUID) error {
+	return nil
+}
+
+func (m *mockAPIKeyRepo) TouchLastUsed(id uuid.UUID, resolution time.Duration) error {
+	return nil
+}
+
+type mockUserGetter struct {
+	user *users.User
+}
+
+func (m mockUserGetter) GetById(id uuid.UUID) (*users.User, error) {
+	return m.user, nil
+}
+
+func newTestService(t *testing.T) (*Service, *users.User) {
+	t.Helper()
+	owner := &users.User{
+		ID:    uuid.New(),
+		Email: "owner@example.com",
+		Role:  users.Role{Name: "admin", Permissions: users.Permissions{users.PermUsersManage, users.PermAuditRead}},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return NewService(logger, &mockAPIKeyRepo{}, mockUserGetter{user: owner}), owner
+}
+
+func createTestHandler(service *Service, fallback func(http.Handler) http.Handler, perms ...string) http.Handler {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return Authenticate(logger, service, "X-API-Key", fallback)(
+		users.RequirePermissions(logger, perms...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})),
+	)
+}
+
+func TestAuthenticateWithAPIKey(t *testing.T) {
+	service, owner := newTestService(t)
+	_, secret, err := service.Create(owner, "reporting job", []string{users.PermAuditRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		header   string
+		value    string
+		perms    []string
+		expected int
+	}{
+		{"authorization header", "Authorization", AuthorizationScheme + " " + secret, []string{users.PermAuditRead}, http.StatusOK},
+		{"custom header", "X-API-Key", secret, []string{users.PermAuditRead}, http.StatusOK},
+		{"permission outside the key's scopes", "X-API-Key", secret, []string{users.PermUsersManage}, http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(tc.header, tc.value)
+			rec := httptest.NewRecorder()
+
+			createTestHandler(service, nil, tc.perms...).ServeHTTP(rec, req)
+
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status code %d, got %d", tc.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRejectsInvalidKeys(t *testing.T) {
+	service, owner := newTestService(t)
+	expiresAt := time.Now().Add(time.Hour)
+	key, expired, err := service.Create(owner, "expired", nil, &expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	key.ExpiresAt = &past
+
+	unknown, _, _ := generateKey()
+
+	for name, secret := range map[string]string{
+		"malformed": "gwa_not-a-key",
+		"unknown":   unknown,
+		"expired":   expired,
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-API-Key", secret)
+			rec := httptest.NewRecorder()
+
+			createTestHandler(service, nil).ServeHTTP(rec, req)
+
+			testutils.CheckJSONResponseError(t, rec, http.StatusUnauthorized, "invalid api key")
+		})
+	}
+}
+
+func TestAuthenticateWithoutAPIKeyUsesFallback(t *testing.T) {
+	service, _ := newTestService(t)
+
+	fallbackCalled := false
+	fallback := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fallbackCalled = true
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+
+	createTestHandler(service, fallback).ServeHTTP(rec, req)
+
+	if !fallbackCalled || rec.Code != http.StatusTeapot {
+		t.Error("Expected a request without an api key to be passed to the fallback")
+	}
+}
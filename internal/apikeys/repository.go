@@ -0,0 +1,144 @@
+package apikeys
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type APIKeyPsqlRepo struct {
+	DB *database.DB
+}
+
+func (m APIKeyPsqlRepo) Insert(key *APIKey) error {
+	query := `INSERT INTO api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7)
+              RETURNING created_at`
+
+	args := []any{key.ID, key.UserID, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.CreatedAt)
+}
+
+const selectColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, created_at, revoked_at`
+
+func scanKey(row interface{ Scan(dest ...any) error }) (*APIKey, error) {
+	var key APIKey
+	var scopes []string
+
+	err := row.Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&scopes),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.CreatedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = scopes
+	return &key, nil
+}
+
+// GetByHash returns the key stored under the hash, whatever its state.
+// Returns database.ErrRecordNotFound if there is none.
+func (m APIKeyPsqlRepo) GetByHash(keyHash []byte) (*APIKey, error) {
+	query := `SELECT ` + selectColumns + ` FROM api_keys WHERE key_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key, err := scanKey(m.DB.QueryRowContext(ctx, query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// ListForUser returns the user's keys that are not revoked, newest first.
+func (m APIKeyPsqlRepo) ListForUser(userID uuid.UUID) ([]*APIKey, error) {
+	query := `SELECT ` + selectColumns + `
+              FROM api_keys
+              WHERE user_id = $1 AND revoked_at IS NULL
+              ORDER BY created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Revoke revokes the key if it belongs to the user and is not revoked yet.
+// Returns database.ErrRecordNotFound otherwise.
+func (m APIKeyPsqlRepo) Revoke(userID, id uuid.UUID) error {
+	query := `UPDATE api_keys
+              SET revoked_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TouchLastUsed records that the key was just used. To avoid a write on every request,
+// the time is only updated once it is older than the resolution.
+func (m APIKeyPsqlRepo) TouchLastUsed(id uuid.UUID, resolution time.Duration) error {
+	query := `UPDATE api_keys
+              SET last_used_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, time.Now().Add(-resolution))
+	return err
+}
@@ -0,0 +1,131 @@
+package apikeys
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"time"
+)
+
+const lastUsedResolution = time.Minute
+
+type apiKeyRepository interface {
+	Insert(key *APIKey) error
+	GetByHash(keyHash []byte) (*APIKey, error)
+	ListForUser(userID uuid.UUID) ([]*APIKey, error)
+	Revoke(userID, id uuid.UUID) error
+	TouchLastUsed(id uuid.UUID, resolution time.Duration) error
+}
+
+type userGetter interface {
+	GetById(id uuid.UUID) (*users.User, error)
+}
+
+// Service creates, authenticates and revokes API keys.
+type Service struct {
+	logger *slog.Logger
+	repo   apiKeyRepository
+	users  userGetter
+}
+
+func NewService(logger *slog.Logger, repo apiKeyRepository, users userGetter) *Service {
+	return &Service{logger: logger, repo: repo, users: users}
+}
+
+// ValidateKey checks the name, expiry and scopes of a new key. A key can only be granted
+// scopes its owner's role includes.
+func ValidateKey(v *validator.Validator, owner *users.User, name string, scopes []string, expiresAt *time.Time) {
+	v.Check(name != "", "name", "must be provided")
+	v.MaxLength(name, "name", 100)
+	v.Check(validator.Unique(scopes), "scopes", "must not contain duplicate values")
+	for _, scope := range scopes {
+		v.Check(owner.Role.Allows(scope), "scopes", fmt.Sprintf("%q is not a permission you have", scope))
+	}
+	if expiresAt != nil {
+		v.Check(expiresAt.After(time.Now()), "expiresAt", "must be in the future")
+	}
+}
+
+// Create generates a key for the owner. The key itself is only returned here,
+// it cannot be recovered from what is stored.
+func (s *Service) Create(owner *users.User, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	secret, keyHash, err := generateKey()
+	if err != nil {
+		return nil, "", fmt.Errorf("could not generate api key: %w", err)
+	}
+
+	key := &APIKey{
+		ID:        uuid.New(),
+		UserID:    owner.ID,
+		Name:      name,
+		Prefix:    secret[:displayLength],
+		KeyHash:   keyHash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+
+	if err = s.repo.Insert(key); err != nil {
+		return nil, "", fmt.Errorf("could not insert api key: %w", err)
+	}
+
+	return key, secret, nil
+}
+
+// Authenticate returns the owner of the key, with the permissions of their role limited to
+// the key's scopes, so that a key never grants more than its owner currently has.
+// Returns ErrInvalidKey for malformed, unknown, expired and revoked keys, and keys of deleted users.
+func (s *Service) Authenticate(secret string) (*users.User, *APIKey, error) {
+	if !wellFormed(secret) {
+		return nil, nil, ErrInvalidKey
+	}
+
+	key, err := s.repo.GetByHash(hashKey(secret))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidKey
+		}
+		return nil, nil, err
+	}
+
+	if key.RevokedAt != nil || key.Expired() {
+		return nil, nil, ErrInvalidKey
+	}
+
+	owner, err := s.users.GetById(key.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidKey
+		}
+		return nil, nil, err
+	}
+	if owner.IsDeleted {
+		return nil, nil, ErrInvalidKey
+	}
+
+	scoped := *owner
+	scoped.Role = users.NewRole(owner.Role.Name, owner.Role.Permissions.Intersect(key.Scopes))
+
+	apiutils.Background(func() {
+		if err := s.repo.TouchLastUsed(key.ID, lastUsedResolution); err != nil {
+			s.logger.Error("failed to record api key use", "api key id", key.ID, "error", err)
+		}
+	})
+
+	return &scoped, key, nil
+}
+
+// List returns the user's keys that are not revoked.
+func (s *Service) List(userID uuid.UUID) ([]*APIKey, error) {
+	return s.repo.ListForUser(userID)
+}
+
+// Revoke revokes one of the user's keys.
+// Returns database.ErrRecordNotFound if the user has no such key.
+func (s *Service) Revoke(userID, id uuid.UUID) error {
+	return s.repo.Revoke(userID, id)
+}
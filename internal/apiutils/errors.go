@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrVersionNotInt        = errors.New("X-Expected-Version header must be an int")
 	ErrVersionHeaderMissing = errors.New("X-Expected-Version header is missing")
+	ErrEmptyBody            = errors.New("body must not be empty")
 )
@@ -92,3 +92,18 @@ func ForbiddenResponse(w http.ResponseWriter, r *http.Request, logger *slog.Logg
 	message := "you are not authorized to access this resource"
 	ErrorResponse(w, r, logger, http.StatusForbidden, message)
 }
+
+func AuthenticationRequiredResponse(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	message := "you must be authenticated to access this resource"
+	ErrorResponse(w, r, logger, http.StatusUnauthorized, message)
+}
+
+func RateLimitExceededResponse(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	message := "rate limit exceeded, please try again later"
+	ErrorResponse(w, r, logger, http.StatusTooManyRequests, message)
+}
+
+func InvalidCredentialsResponse(w http.ResponseWriter, r *http.Request, logger *slog.Logger) {
+	message := "invalid authentication credentials"
+	ErrorResponse(w, r, logger, http.StatusUnauthorized, message)
+}
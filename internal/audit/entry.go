@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeError   = "error"
+)
+
+// Entry is a single record in the audit trail. It captures who made a request,
+// what they tried to change, when and from where, and how the request ended.
+type Entry struct {
+	ID         int64     `json:"id"`
+	OccurredAt time.Time `json:"occurredAt"`
+	ActorID    uuid.UUID `json:"actorId"`
+	// ImpersonatedUserID is the user the actor acted as, when the request was impersonated
+	ImpersonatedUserID uuid.NullUUID  `json:"impersonatedUserId"`
+	Method             string         `json:"method"`
+	Route              string         `json:"route"`
+	Path               string         `json:"path"`
+	ResourceType       string         `json:"resourceType,omitempty"`
+	ResourceID         string         `json:"resourceId,omitempty"`
+	IP                 string         `json:"ip"`
+	RequestID          string         `json:"requestId"`
+	StatusCode         int            `json:"statusCode"`
+	Outcome            string         `json:"outcome"`
+	Before             map[string]any `json:"before,omitempty"`
+	After              map[string]any `json:"after,omitempty"`
+}
+
+// outcomeFromStatus maps an HTTP status code onto one of the audit outcomes.
+// Client errors are failures, server errors are errors and everything else succeeded.
+func outcomeFromStatus(status int) string {
+	switch {
+	case status >= 500:
+		return OutcomeError
+	case status >= 400:
+		return OutcomeFailure
+	default:
+		return OutcomeSuccess
+	}
+}
@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/validator"
+	"net/url"
+	"time"
+)
+
+// Filters narrows down which audit entries are listed. Zero values are ignored.
+type Filters struct {
+	ActorID uuid.UUID
+	// ImpersonatedUserID narrows down the entries to requests made while impersonating the user
+	ImpersonatedUserID uuid.UUID
+	Method             string
+	Route              string
+	ResourceType       string
+	ResourceID         string
+	Outcome            string
+	From               time.Time
+	To                 time.Time
+	Pagination         database.Pagination
+}
+
+// ReadFilters parses the audit list query string into Filters, recording any problems on the validator.
+func ReadFilters(qs url.Values, v *validator.Validator) Filters {
+	var filters Filters
+
+	if actorID, exists := apiutils.ReadStringQuery(qs, "actorId", ""); exists {
+		id, err := uuid.Parse(actorID)
+		if err != nil {
+			v.AddError("actorId", "must be a valid UUID")
+		}
+		filters.ActorID = id
+	}
+
+	if impersonatedUserID, exists := apiutils.ReadStringQuery(qs, "impersonatedUserId", ""); exists {
+		id, err := uuid.Parse(impersonatedUserID)
+		if err != nil {
+			v.AddError("impersonatedUserId", "must be a valid UUID")
+		}
+		filters.ImpersonatedUserID = id
+	}
+
+	filters.Method, _ = apiutils.ReadStringQuery(qs, "method", "")
+	filters.Route, _ = apiutils.ReadStringQuery(qs, "route", "")
+	filters.ResourceType, _ = apiutils.ReadStringQuery(qs, "resourceType", "")
+	filters.ResourceID, _ = apiutils.ReadStringQuery(qs, "resourceId", "")
+	filters.Outcome, _ = apiutils.ReadStringQuery(qs, "outcome", "")
+	filters.From = readTimeQuery(qs, "from", v)
+	filters.To = readTimeQuery(qs, "to", v)
+
+	filters.Pagination.Page, _ = apiutils.ReadIntQuery(qs, "page", 1, v)
+	filters.Pagination.PageSize, _ = apiutils.ReadIntQuery(qs, "pageSize", database.DefaultPageSize, v)
+
+	return filters
+}
+
+// ValidateFilters checks the outcome, time range and pagination of the filters.
+func ValidateFilters(v *validator.Validator, filters Filters) {
+	if filters.Outcome != "" {
+		v.Check(
+			validator.PermittedValue(filters.Outcome, OutcomeSuccess, OutcomeFailure, OutcomeError),
+			"outcome",
+			"must be one of success, failure or error",
+		)
+	}
+
+	if !filters.From.IsZero() && !filters.To.IsZero() {
+		v.Check(filters.From.Before(filters.To), "from", "must be before to")
+	}
+
+	database.ValidatePagination(v, filters.Pagination)
+}
+
+func readTimeQuery(qs url.Values, key string, v *validator.Validator) time.Time {
+	s, exists := apiutils.ReadStringQuery(qs, key, "")
+	if !exists {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
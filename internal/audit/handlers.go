@@ -0,0 +1,49 @@
+package audit
+
+import (
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+)
+
+type entryLister interface {
+	List(filters Filters) ([]Entry, int, error)
+}
+
+// ListEntriesHandler responds with a page of audit entries matching the query string filters.
+// Supported filters are actorId, method, route, resourceType, resourceId, outcome, from and to,
+// paginated with page and pageSize.
+func ListEntriesHandler(
+	logger *slog.Logger,
+	lister entryLister,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		filters := ReadFilters(r.URL.Query(), v)
+
+		if ValidateFilters(v, filters); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		entries, totalRecords, err := lister.List(filters)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		responseData := apiutils.Envelope{
+			"entries":  entries,
+			"metadata": database.CalculateMetadata(totalRecords, filters.Pagination),
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, responseData, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListEntriesHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
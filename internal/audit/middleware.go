@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/middleware"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type contextKey string
+
+const annotationContextKey = contextKey("audit")
+
+// annotation is the mutable part of an audit entry that handlers fill in while the
+// request is being served. The middleware stores a pointer to it in the request
+// context, so anything set by a handler is visible once the handler returns.
+type annotation struct {
+	resourceType string
+	resourceID   string
+	before       any
+	after        any
+}
+
+func contextGetAnnotation(r *http.Request) (*annotation, bool) {
+	a, ok := r.Context().Value(annotationContextKey).(*annotation)
+	return a, ok
+}
+
+// SetResource records the type and ID of the resource a request acts on.
+// It is a no-op when the request is not being audited.
+func SetResource(r *http.Request, resourceType string, resourceID string) {
+	if a, ok := contextGetAnnotation(r); ok {
+		a.resourceType = resourceType
+		a.resourceID = resourceID
+	}
+}
+
+// SetBefore records the state of the resource before the request changed it.
+// The snapshot is redacted with Redact before it is stored.
+func SetBefore(r *http.Request, snapshot any) {
+	if a, ok := contextGetAnnotation(r); ok {
+		a.before = snapshot
+	}
+}
+
+// SetAfter records the state of the resource after the request changed it.
+// The snapshot is redacted with Redact before it is stored.
+func SetAfter(r *http.Request, snapshot any) {
+	if a, ok := contextGetAnnotation(r); ok {
+		a.after = snapshot
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (sr *statusRecorder) WriteHeader(statusCode int) {
+	sr.statusCode = statusCode
+	sr.ResponseWriter.WriteHeader(statusCode)
+}
+
+type entryRecorder interface {
+	Record(entry Entry)
+}
+
+type middlewareConfig struct {
+	impersonatedUser func(r *http.Request) uuid.NullUUID
+}
+
+// MiddlewareOption configures optional behaviour of the audit Middleware.
+type MiddlewareOption func(*middlewareConfig)
+
+// WithImpersonatedUser records the user the actor acted as, as resolved by the function,
+// on the entries of impersonated requests.
+func WithImpersonatedUser(impersonatedUser func(r *http.Request) uuid.NullUUID) MiddlewareOption {
+	return func(c *middlewareConfig) {
+		c.impersonatedUser = impersonatedUser
+	}
+}
+
+// Middleware records an audit Entry for every request that is not a GET, HEAD or OPTIONS request.
+// The actor is resolved with the provided function after the request is served, so the middleware
+// must be placed after the authentication middleware that sets the user on the request.
+//
+// Entries are handed to the recorder without blocking, so the audit trail adds no latency to the request.
+func Middleware(
+	logger *slog.Logger,
+	recorder entryRecorder,
+	actor func(r *http.Request) uuid.UUID,
+	opts ...MiddlewareOption,
+) func(http.Handler) http.Handler {
+	cfg := &middlewareConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			a := &annotation{}
+			r = r.WithContext(context.WithValue(r.Context(), annotationContextKey, a))
+			sr := &statusRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+
+			occurredAt := time.Now().UTC()
+			next.ServeHTTP(sr, r)
+
+			requestId, _ := middleware.GetRequestID(r)
+			entry := Entry{
+				OccurredAt:   occurredAt,
+				ActorID:      actor(r),
+				Method:       r.Method,
+				Route:        r.Pattern,
+				Path:         r.URL.Path,
+				ResourceType: a.resourceType,
+				ResourceID:   a.resourceID,
+				IP:           middleware.ClientIP(r),
+				RequestID:    requestId,
+				StatusCode:   sr.statusCode,
+				Outcome:      outcomeFromStatus(sr.statusCode),
+				Before:       Redact(a.before),
+				After:        Redact(a.after),
+			}
+			if cfg.impersonatedUser != nil {
+				entry.ImpersonatedUserID = cfg.impersonatedUser(r)
+			}
+			if entry.ResourceID == "" {
+				entry.ResourceID = r.PathValue("id")
+			}
+
+			recorder.Record(entry)
+			logger.Debug("audit entry recorded", middleware.RequestIdLog, requestId, "route", entry.Route)
+		})
+	}
+}
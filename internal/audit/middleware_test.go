@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"github.com/google/uuid"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+//region helpers
+
+type memoryRecorder struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func (m *memoryRecorder) Record(entry Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries = append(m.entries, entry)
+}
+
+var testActorID = uuid.MustParse("3f1c2f4e-8f7a-4d7e-9a51-2c4b1d0e6a77")
+
+func testActor(r *http.Request) uuid.UUID {
+	return testActorID
+}
+
+// newAuditedMux serves the handler on the pattern behind the audit middleware, as routes.go does.
+func newAuditedMux(recorder entryRecorder, pattern string, handler http.HandlerFunc, opts ...MiddlewareOption) http.Handler {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	mux := http.NewServeMux()
+	mux.Handle(pattern, Middleware(logger, recorder, testActor, opts...)(handler))
+	return mux
+}
+
+//endregion
+
+func TestMiddlewareSkipsReads(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodHead, http.MethodOptions} {
+		t.Run(method, func(t *testing.T) {
+			recorder := &memoryRecorder{}
+			handler := newAuditedMux(recorder, "/v1/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+				SetResource(r, "user", "ignored")
+				w.WriteHeader(http.StatusOK)
+			})
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(method, "/v1/users/42", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("Expected the request to be served, got status %d", rec.Code)
+			}
+			if len(recorder.entries) != 0 {
+				t.Errorf("Expected no audit entry, got %+v", recorder.entries)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRecordsMutations(t *testing.T) {
+	testCases := map[string]struct {
+		method   string
+		status   int
+		handler  func(w http.ResponseWriter, r *http.Request)
+		resource string
+		outcome  string
+	}{
+		"annotated resource": {
+			method: http.MethodPatch,
+			status: http.StatusOK,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				SetResource(r, "user", "annotated")
+				w.WriteHeader(http.StatusOK)
+			},
+			resource: "annotated",
+			outcome:  OutcomeSuccess,
+		},
+		"resource from path": {
+			method: http.MethodDelete,
+			status: http.StatusNoContent,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			resource: "42",
+			outcome:  OutcomeSuccess,
+		},
+		"implicit status": {
+			method: http.MethodPost,
+			status: http.StatusOK,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("{}"))
+			},
+			resource: "42",
+			outcome:  OutcomeSuccess,
+		},
+		"client error": {
+			method: http.MethodPut,
+			status: http.StatusForbidden,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			},
+			resource: "42",
+			outcome:  OutcomeFailure,
+		},
+		"server error": {
+			method: http.MethodPost,
+			status: http.StatusInternalServerError,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			resource: "42",
+			outcome:  OutcomeError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &memoryRecorder{}
+			handler := newAuditedMux(recorder, "/v1/users/{id}", tc.handler)
+
+			req := httptest.NewRequest(tc.method, "/v1/users/42", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.status {
+				t.Errorf("Expected the response status %d to be kept, got %d", tc.status, rec.Code)
+			}
+			if len(recorder.entries) != 1 {
+				t.Fatalf("Expected one audit entry, got %d", len(recorder.entries))
+			}
+
+			entry := recorder.entries[0]
+			if entry.ActorID != testActorID {
+				t.Errorf("Expected actor %s, got %s", testActorID, entry.ActorID)
+			}
+			if entry.Method != tc.method || entry.Route != "/v1/users/{id}" || entry.Path != "/v1/users/42" {
+				t.Errorf("Expected the request to be recorded, got %s %s %s", entry.Method, entry.Route, entry.Path)
+			}
+			if entry.ResourceID != tc.resource {
+				t.Errorf("Expected resource %q, got %q", tc.resource, entry.ResourceID)
+			}
+			if entry.StatusCode != tc.status || entry.Outcome != tc.outcome {
+				t.Errorf("Expected status %d and outcome %s, got %d and %s", tc.status, tc.outcome, entry.StatusCode, entry.Outcome)
+			}
+			if entry.IP != "192.0.2.1" {
+				t.Errorf("Expected the client IP, got %q", entry.IP)
+			}
+			if entry.OccurredAt.IsZero() {
+				t.Error("Expected the time of the request")
+			}
+			if entry.ImpersonatedUserID.Valid {
+				t.Error("Expected no impersonated user")
+			}
+		})
+	}
+}
+
+func TestMiddlewareRecordsAnnotations(t *testing.T) {
+	recorder := &memoryRecorder{}
+	impersonated := uuid.MustParse("6b0d7d0e-2a4f-4c8e-9d2a-1f3e5b7c9a11")
+	handler := newAuditedMux(recorder, "POST /v1/users/{id}/password", func(w http.ResponseWriter, r *http.Request) {
+		SetResource(r, "user", "7")
+		SetBefore(r, map[string]any{"email": "jane@example.com", "passwordHash": "old"})
+		SetAfter(r, map[string]any{"email": "jane@example.com", "passwordHash": "new"})
+		w.WriteHeader(http.StatusAccepted)
+	}, WithImpersonatedUser(func(r *http.Request) uuid.NullUUID {
+		return uuid.NullUUID{UUID: impersonated, Valid: true}
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/users/42/password", nil))
+
+	if len(recorder.entries) != 1 {
+		t.Fatalf("Expected one audit entry, got %d", len(recorder.entries))
+	}
+	entry := recorder.entries[0]
+
+	if entry.Route != "POST /v1/users/{id}/password" {
+		t.Errorf("Expected the route pattern, got %q", entry.Route)
+	}
+	if entry.ResourceType != "user" || entry.ResourceID != "7" {
+		t.Errorf("Expected the annotated resource over the path, got %s %s", entry.ResourceType, entry.ResourceID)
+	}
+	if entry.Before["passwordHash"] != redactedValue || entry.After["passwordHash"] != redactedValue {
+		t.Errorf("Expected snapshots to be redacted, got %v and %v", entry.Before, entry.After)
+	}
+	if entry.After["email"] != "jane@example.com" {
+		t.Errorf("Expected snapshots to be kept, got %v", entry.After)
+	}
+	if entry.ImpersonatedUserID.UUID != impersonated || !entry.ImpersonatedUserID.Valid {
+		t.Errorf("Expected impersonated user %s, got %v", impersonated, entry.ImpersonatedUserID)
+	}
+}
+
+func TestAnnotationsOutsideAuditedRequests(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/users", nil)
+
+	// Handlers annotate requests whether or not they are audited
+	SetResource(req, "user", "42")
+	SetBefore(req, map[string]any{})
+	SetAfter(req, map[string]any{})
+
+	if _, ok := contextGetAnnotation(req); ok {
+		t.Error("Expected no annotation on a request that is not audited")
+	}
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+const redactedValue = "[REDACTED]"
+
+// SensitiveFields are the snapshot keys whose values never reach the audit table.
+// Keys are compared case-insensitively and ignoring '_' and '-', so "password_hash"
+// and "passwordHash" both match "passwordhash".
+var SensitiveFields = []string{
+	"password",
+	"passwordhash",
+	"newpassword",
+	"currentpassword",
+	"token",
+	"accesstoken",
+	"refreshtoken",
+	"idtoken",
+	"secret",
+	"clientsecret",
+	"apikey",
+	"key",
+	"keyhash",
+	"tokenhash",
+	"recoverycodes",
+	"code",
+}
+
+// Redact converts a snapshot into a generic map by round-tripping it through JSON
+// and replaces the value of every sensitive field, at any depth, with a placeholder.
+// Returns nil if the snapshot is nil or cannot be represented as a JSON object.
+func Redact(snapshot any) map[string]any {
+	if snapshot == nil {
+		return nil
+	}
+
+	js, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil
+	}
+
+	var m map[string]any
+	if err = json.Unmarshal(js, &m); err != nil {
+		return nil
+	}
+
+	redactValue(m)
+	return m
+}
+
+func redactValue(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, inner := range v {
+			if isSensitive(key) {
+				v[key] = redactedValue
+				continue
+			}
+			redactValue(inner)
+		}
+	case []any:
+		for _, inner := range v {
+			redactValue(inner)
+		}
+	}
+}
+
+func isSensitive(key string) bool {
+	normalized := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	return slices.Contains(SensitiveFields, normalized)
+}
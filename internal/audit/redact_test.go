@@ -0,0 +1,78 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	type credentials struct {
+		Email        string `json:"email"`
+		PasswordHash string `json:"password_hash"`
+	}
+
+	testCases := map[string]struct {
+		snapshot any
+		expected map[string]any
+	}{
+		"nil": {
+			snapshot: nil,
+			expected: nil,
+		},
+		"not an object": {
+			snapshot: []string{"password"},
+			expected: nil,
+		},
+		"not representable as JSON": {
+			snapshot: map[string]any{"callback": func() {}},
+			expected: nil,
+		},
+		"no sensitive fields": {
+			snapshot: map[string]any{"email": "jane@example.com", "version": 2},
+			expected: map[string]any{"email": "jane@example.com", "version": float64(2)},
+		},
+		"struct tags": {
+			snapshot: credentials{Email: "jane@example.com", PasswordHash: "hash"},
+			expected: map[string]any{"email": "jane@example.com", "password_hash": redactedValue},
+		},
+		"case and separators are ignored": {
+			snapshot: map[string]any{"Password": "a", "ACCESS_TOKEN": "b", "refresh-token": "c", "clientSecret": "d", "tokenized": "e"},
+			expected: map[string]any{"Password": redactedValue, "ACCESS_TOKEN": redactedValue, "refresh-token": redactedValue, "clientSecret": redactedValue, "tokenized": "e"},
+		},
+		"nested objects and arrays": {
+			snapshot: map[string]any{
+				"user": map[string]any{
+					"email":       "jane@example.com",
+					"credentials": credentials{Email: "jane@example.com", PasswordHash: "hash"},
+				},
+				"keys": []any{
+					map[string]any{"name": "ci", "KeyHash": "hash"},
+					"plain",
+				},
+			},
+			expected: map[string]any{
+				"user": map[string]any{
+					"email":       "jane@example.com",
+					"credentials": map[string]any{"email": "jane@example.com", "password_hash": redactedValue},
+				},
+				"keys": []any{
+					map[string]any{"name": "ci", "KeyHash": redactedValue},
+					"plain",
+				},
+			},
+		},
+		"sensitive objects are redacted whole": {
+			snapshot: map[string]any{"secret": map[string]any{"value": "s3cr3t"}, "recovery_codes": []string{"a", "b"}},
+			expected: map[string]any{"secret": redactedValue, "recovery_codes": redactedValue},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			got := Redact(tc.snapshot)
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"strings"
+	"time"
+)
+
+type AuditPsqlRepo struct {
+	DB *database.DB
+}
+
+const entryColumns = 14
+
+// InsertBatch writes all entries with a single multi-row insert.
+func (m AuditPsqlRepo) InsertBatch(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO audit_entries (occurred_at, actor_id, impersonated_user_id, method, route, path,
+                    resource_type, resource_id, ip, request_id, status_code, outcome, before, after) VALUES `)
+
+	args := make([]any, 0, len(entries)*entryColumns)
+	for i, e := range entries {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+
+		sb.WriteString("(")
+		for j := 1; j <= entryColumns; j++ {
+			if j > 1 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(&sb, "$%d", i*entryColumns+j)
+		}
+		sb.WriteString(")")
+
+		before, err := marshalSnapshot(e.Before)
+		if err != nil {
+			return err
+		}
+		after, err := marshalSnapshot(e.After)
+		if err != nil {
+			return err
+		}
+
+		args = append(args,
+			e.OccurredAt,
+			nullUUID(e.ActorID),
+			e.ImpersonatedUserID,
+			e.Method,
+			e.Route,
+			e.Path,
+			e.ResourceType,
+			e.ResourceID,
+			e.IP,
+			e.RequestID,
+			e.StatusCode,
+			e.Outcome,
+			before,
+			after,
+		)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// List returns a page of entries matching the filters, newest first, along with
+// the total number of matching entries.
+func (m AuditPsqlRepo) List(filters Filters) ([]Entry, int, error) {
+	query := `SELECT count(*) OVER(), id, occurred_at, actor_id, impersonated_user_id, method, route, path,
+                  resource_type, resource_id, ip, request_id, status_code, outcome, before, after
+              FROM audit_entries
+              WHERE ($1::uuid IS NULL OR actor_id = $1)
+              AND ($2::uuid IS NULL OR impersonated_user_id = $2)
+              AND ($3 = '' OR method = $3)
+              AND ($4 = '' OR route = $4)
+              AND ($5 = '' OR resource_type = $5)
+              AND ($6 = '' OR resource_id = $6)
+              AND ($7 = '' OR outcome = $7)
+              AND ($8::timestamptz IS NULL OR occurred_at >= $8)
+              AND ($9::timestamptz IS NULL OR occurred_at < $9)
+              ORDER BY occurred_at DESC, id DESC
+              LIMIT $10 OFFSET $11`
+
+	args := []any{
+		nullUUID(filters.ActorID),
+		nullUUID(filters.ImpersonatedUserID),
+		filters.Method,
+		filters.Route,
+		filters.ResourceType,
+		filters.ResourceID,
+		filters.Outcome,
+		nullTime(filters.From),
+		nullTime(filters.To),
+		filters.Pagination.Limit(),
+		filters.Pagination.Offset(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []Entry{}
+
+	for rows.Next() {
+		var entry Entry
+		var actorID uuid.NullUUID
+		var before, after []byte
+
+		err = rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.OccurredAt,
+			&actorID,
+			&entry.ImpersonatedUserID,
+			&entry.Method,
+			&entry.Route,
+			&entry.Path,
+			&entry.ResourceType,
+			&entry.ResourceID,
+			&entry.IP,
+			&entry.RequestID,
+			&entry.StatusCode,
+			&entry.Outcome,
+			&before,
+			&after,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		entry.ActorID = actorID.UUID
+		if err = unmarshalSnapshot(before, &entry.Before); err != nil {
+			return nil, 0, err
+		}
+		if err = unmarshalSnapshot(after, &entry.After); err != nil {
+			return nil, 0, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return entries, totalRecords, nil
+}
+
+func marshalSnapshot(snapshot map[string]any) ([]byte, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+	return json.Marshal(snapshot)
+}
+
+func unmarshalSnapshot(data []byte, dst *map[string]any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, dst)
+}
+
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: id != uuid.Nil}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
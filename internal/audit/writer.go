@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultBufferSize    = 1024
+	defaultBatchSize     = 100
+	defaultFlushInterval = 2 * time.Second
+)
+
+type batchInserter interface {
+	InsertBatch(entries []Entry) error
+}
+
+// Writer buffers audit entries in memory and writes them to the repository in batches
+// from a single background goroutine. Entries are written when a batch fills up, when
+// the flush interval elapses, and when the Writer is closed.
+type Writer struct {
+	logger        *slog.Logger
+	repo          batchInserter
+	entries       chan Entry
+	batchSize     int
+	flushInterval time.Duration
+
+	mu      sync.RWMutex
+	closed  bool
+	dropped atomic.Int64
+}
+
+type WriterOption func(*Writer)
+
+// WithBufferSize sets how many entries may wait to be written before new entries are dropped.
+func WithBufferSize(size int) WriterOption {
+	return func(w *Writer) {
+		w.entries = make(chan Entry, size)
+	}
+}
+
+// WithBatchSize sets the maximum number of entries written in a single insert.
+func WithBatchSize(size int) WriterOption {
+	return func(w *Writer) {
+		w.batchSize = size
+	}
+}
+
+// WithFlushInterval sets the longest time an entry waits in the buffer before it is written.
+func WithFlushInterval(interval time.Duration) WriterOption {
+	return func(w *Writer) {
+		w.flushInterval = interval
+	}
+}
+
+func NewWriter(logger *slog.Logger, repo batchInserter, opts ...WriterOption) *Writer {
+	w := &Writer{
+		logger:        logger,
+		repo:          repo,
+		entries:       make(chan Entry, defaultBufferSize),
+		batchSize:     defaultBatchSize,
+		flushInterval: defaultFlushInterval,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Record queues an entry to be written. It never blocks: if the buffer is full
+// or the Writer is closed, the entry is dropped and the drop is logged.
+func (w *Writer) Record(entry Entry) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		w.logger.Error("audit entry recorded after writer closed", "route", entry.Route, "actor id", entry.ActorID)
+		return
+	}
+
+	select {
+	case w.entries <- entry:
+	default:
+		dropped := w.dropped.Add(1)
+		w.logger.Error("audit buffer full, entry dropped", "route", entry.Route, "actor id", entry.ActorID, "dropped", dropped)
+	}
+}
+
+// Dropped returns the number of entries that were dropped because the buffer was full.
+func (w *Writer) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+// Run writes queued entries until Close is called, then writes whatever is left in the buffer.
+// It is meant to be started once in its own goroutine, e.g. with apiutils.BackgroundWg.
+func (w *Writer) Run() {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Entry, 0, w.batchSize)
+
+	for {
+		select {
+		case entry, ok := <-w.entries:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+
+			batch = append(batch, entry)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Close stops the Writer from accepting entries. Run returns once the remaining entries are written.
+func (w *Writer) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	w.closed = true
+	close(w.entries)
+}
+
+func (w *Writer) flush(batch []Entry) {
+	if len(batch) == 0 {
+		return
+	}
+
+	err := w.repo.InsertBatch(batch)
+	if err != nil {
+		w.logger.Error("failed to write audit entries", "error", err, "count", len(batch))
+	}
+}
@@ -0,0 +1,182 @@
+package audit
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+//region helpers
+
+type memoryBatchInserter struct {
+	mu      sync.Mutex
+	batches [][]Entry
+	err     error
+}
+
+func (m *memoryBatchInserter) InsertBatch(entries []Entry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The writer reuses its batch slice, so keep a copy
+	m.batches = append(m.batches, slices.Clone(entries))
+	return m.err
+}
+
+func (m *memoryBatchInserter) batchSizes() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sizes []int
+	for _, batch := range m.batches {
+		sizes = append(sizes, len(batch))
+	}
+	return sizes
+}
+
+func (m *memoryBatchInserter) routes() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var routes []string
+	for _, batch := range m.batches {
+		for _, entry := range batch {
+			routes = append(routes, entry.Route)
+		}
+	}
+	return routes
+}
+
+// startWriter runs the writer in the background. The returned function closes it and
+// waits for Run to return.
+func startWriter(w *Writer) func() {
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+
+	return func() {
+		w.Close()
+		<-done
+	}
+}
+
+func newTestWriter(repo batchInserter, opts ...WriterOption) *Writer {
+	return NewWriter(slog.New(slog.NewTextHandler(io.Discard, nil)), repo, opts...)
+}
+
+func recordRoutes(w *Writer, routes ...string) {
+	for _, route := range routes {
+		w.Record(Entry{Route: route})
+	}
+}
+
+//endregion
+
+func TestWriterWritesFullBatches(t *testing.T) {
+	repo := &memoryBatchInserter{}
+	w := newTestWriter(repo, WithBatchSize(3), WithFlushInterval(time.Hour))
+	stop := startWriter(w)
+
+	recordRoutes(w, "a", "b", "c", "d", "e", "f", "g")
+
+	deadline := time.Now().Add(time.Second)
+	for len(repo.batchSizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sizes := repo.batchSizes(); !slices.Equal(sizes, []int{3, 3}) {
+		t.Fatalf("Expected two full batches before the writer is closed, got %v", sizes)
+	}
+
+	stop()
+
+	if sizes := repo.batchSizes(); !slices.Equal(sizes, []int{3, 3, 1}) {
+		t.Errorf("Expected the remaining entry to be written on close, got batches %v", sizes)
+	}
+	if routes := repo.routes(); !slices.Equal(routes, []string{"a", "b", "c", "d", "e", "f", "g"}) {
+		t.Errorf("Expected entries to be written in order, got %v", routes)
+	}
+}
+
+func TestWriterFlushesOnInterval(t *testing.T) {
+	repo := &memoryBatchInserter{}
+	w := newTestWriter(repo, WithBatchSize(100), WithFlushInterval(10*time.Millisecond))
+	stop := startWriter(w)
+	defer stop()
+
+	recordRoutes(w, "a", "b")
+
+	deadline := time.Now().Add(time.Second)
+	for len(repo.batchSizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if sizes := repo.batchSizes(); !slices.Equal(sizes, []int{2}) {
+		t.Errorf("Expected the partial batch to be written once the interval elapsed, got %v", sizes)
+	}
+}
+
+func TestWriterFlushesOnClose(t *testing.T) {
+	repo := &memoryBatchInserter{}
+	w := newTestWriter(repo, WithBatchSize(100), WithFlushInterval(time.Hour))
+	stop := startWriter(w)
+
+	recordRoutes(w, "a", "b", "c")
+	stop()
+
+	if sizes := repo.batchSizes(); !slices.Equal(sizes, []int{3}) {
+		t.Errorf("Expected buffered entries to be written on close, got batches %v", sizes)
+	}
+
+	// Entries recorded after the writer is closed are dropped without panicking
+	w.Record(Entry{Route: "late"})
+	w.Close()
+
+	if routes := repo.routes(); slices.Contains(routes, "late") {
+		t.Error("Expected the entry recorded after close not to be written")
+	}
+}
+
+func TestWriterDropsEntriesWhenBufferIsFull(t *testing.T) {
+	repo := &memoryBatchInserter{}
+	w := newTestWriter(repo, WithBufferSize(2), WithBatchSize(100), WithFlushInterval(time.Hour))
+
+	// Nothing drains the buffer until Run starts, so Record must not block once it is full
+	done := make(chan struct{})
+	go func() {
+		recordRoutes(w, "a", "b", "c", "d")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Record not to block when the buffer is full")
+	}
+
+	if dropped := w.Dropped(); dropped != 2 {
+		t.Errorf("Expected 2 dropped entries, got %d", dropped)
+	}
+
+	startWriter(w)()
+
+	if routes := repo.routes(); !slices.Equal(routes, []string{"a", "b"}) {
+		t.Errorf("Expected the buffered entries to be written, got %v", routes)
+	}
+}
+
+func TestWriterKeepsRunningWhenInsertFails(t *testing.T) {
+	repo := &memoryBatchInserter{err: errors.New("database unavailable")}
+	w := newTestWriter(repo, WithBatchSize(1), WithFlushInterval(time.Hour))
+	stop := startWriter(w)
+
+	recordRoutes(w, "a", "b")
+	stop()
+
+	if sizes := repo.batchSizes(); !slices.Equal(sizes, []int{1, 1}) {
+		t.Errorf("Expected every batch to be attempted, got %v", sizes)
+	}
+}
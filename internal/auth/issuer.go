@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/users"
+	"net/http"
+	"time"
+)
+
+const (
+	TokenTypeBearer = "Bearer"
+	// AccessTokenRole is the role claim on access tokens, matching the one Supabase issues to signed in users.
+	AccessTokenRole = "authenticated"
+)
+
+// Authentication methods recorded in the amr claim, named like the ones Supabase records.
+const (
+	MethodPassword     = "password"
+	MethodOIDC         = "oidc"
+	MethodMagicLink    = "magiclink"
+	MethodTOTP         = "totp"
+	MethodRecoveryCode = "recovery_code"
+)
+
+// Authenticator assurance levels recorded in the aal claim: one factor, or two.
+const (
+	AAL1 = "aal1"
+	AAL2 = "aal2"
+)
+
+// IsSecondFactor reports whether the method is a second factor, which raises the assurance level to AAL2.
+func IsSecondFactor(method string) bool {
+	return method == MethodTOTP || method == MethodRecoveryCode
+}
+
+type contextKey string
+
+const methodContextKey = contextKey("authMethod")
+
+// ContextSetMethod records the method the user of the request authenticated with, so that the
+// Issuer can put it in the amr claim of the tokens it issues for the request.
+func ContextSetMethod(r *http.Request, method string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), methodContextKey, method))
+}
+
+func contextGetMethod(r *http.Request) (string, bool) {
+	method, ok := r.Context().Value(methodContextKey).(string)
+	return method, ok
+}
+
+// Tokens is the response body returned to a client once it has authenticated.
+type Tokens struct {
+	AccessToken  string    `json:"accessToken"`
+	TokenType    string    `json:"tokenType"`
+	ExpiresIn    int       `json:"expiresIn"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	RefreshToken string    `json:"refreshToken,omitempty"`
+}
+
+// Issuer issues tokens to a user who has just proven their identity.
+// The request is the one the user authenticated with.
+type Issuer interface {
+	Issue(r *http.Request, user *users.User) (*Tokens, error)
+}
+
+// AccessTokenIssuer issues ES256 access tokens signed by a jwtauth.Signer, that the
+// API's own jwtauth.Reader accepts when it is configured with the same signer.
+type AccessTokenIssuer struct {
+	Signer *jwtauth.Signer
+	TTL    time.Duration
+}
+
+func NewAccessTokenIssuer(signer *jwtauth.Signer, ttl time.Duration) *AccessTokenIssuer {
+	return &AccessTokenIssuer{Signer: signer, TTL: ttl}
+}
+
+// Issue signs an access token for the user that expires after the issuer's TTL. The method set on
+// the request with ContextSetMethod is recorded in its amr claim.
+func (i *AccessTokenIssuer) Issue(r *http.Request, user *users.User) (*Tokens, error) {
+	var methods []jwtauth.AuthenticationMethod
+	if method, ok := contextGetMethod(r); ok {
+		methods = append(methods, jwtauth.AuthenticationMethod{Method: method, Timestamp: time.Now().Unix()})
+	}
+
+	return i.IssueWithMethods(user, nil, methods)
+}
+
+// IssueWithMethods signs an access token for the user that records the methods they authenticated with.
+// The token is at AAL2 when one of them is a second factor, and at AAL1 otherwise.
+//
+// When source is set, the token replaces that one, such as on a step-up, and keeps its claims other
+// than the registered ones, the assurance level and the methods: roles mapped from its claims with a
+// users.ClaimMapping are the same for both tokens.
+func (i *AccessTokenIssuer) IssueWithMethods(user *users.User, source *jwtauth.Claims, methods []jwtauth.AuthenticationMethod) (*Tokens, error) {
+	claims := jwtauth.Claims{
+		RegisteredClaims: i.Signer.RegisteredClaims(user.ID.String(), i.TTL),
+		Email:            user.Email,
+		Role:             AccessTokenRole,
+		AAL:              AAL1,
+		AMR:              methods,
+	}
+	if source != nil {
+		if source.Role != "" {
+			claims.Role = source.Role
+		}
+		claims.SessionID = source.SessionID
+		claims.AppMetadata = source.AppMetadata
+		claims.UserMetadata = source.UserMetadata
+		claims.Extra = source.Unmodelled()
+	}
+	for _, method := range methods {
+		if IsSecondFactor(method.Method) {
+			claims.AAL = AAL2
+		}
+	}
+
+	accessToken, err := i.Signer.GenerateJWT(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken: accessToken,
+		TokenType:   TokenTypeBearer,
+		ExpiresIn:   int(i.TTL.Seconds()),
+		ExpiresAt:   claims.ExpiresAt.Time,
+	}, nil
+}
@@ -0,0 +1,8 @@
+package credentials
+
+import "errors"
+
+var (
+	ErrInvalidHash        = errors.New("password hash is not a valid argon2id hash")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
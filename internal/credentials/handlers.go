@@ -0,0 +1,127 @@
+package credentials
+
+import (
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type registrar interface {
+	ValidateRegistration(v *validator.Validator, email, password string)
+	Register(email, password string) (*users.User, error)
+}
+
+type authenticator interface {
+	Authenticate(email, password string) (*users.User, error)
+}
+
+type credentialsInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// RegisterHandler creates a user from an email and password, and responds with the user
+// and the tokens the issuer grants them, so they are signed in straight away.
+func RegisterHandler(
+	logger *slog.Logger,
+	registrar registrar,
+	issuer auth.Issuer,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input credentialsInput
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		input.Email = strings.TrimSpace(input.Email)
+
+		v := validator.New()
+		if registrar.ValidateRegistration(v, input.Email, input.Password); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		user, err := registrar.Register(input.Email, input.Password)
+		if err != nil {
+			switch {
+			case errors.Is(err, users.ErrDuplicateEmail):
+				v.AddError("email", "a user with this email address already exists")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		tokens, err := issuer.Issue(auth.ContextSetMethod(r, auth.MethodPassword), user)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"user": user, "tokens": tokens}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RegisterHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// LoginHandler authenticates a user by email and password, and responds with the user
+// and the tokens the issuer grants them.
+func LoginHandler(
+	logger *slog.Logger,
+	authenticator authenticator,
+	issuer auth.Issuer,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input credentialsInput
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		input.Email = strings.TrimSpace(input.Email)
+
+		v := validator.New()
+		users.ValidateEmail(v, input.Email)
+		v.Check(input.Password != "", "password", "must be provided")
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		user, err := authenticator.Authenticate(input.Email, input.Password)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidCredentials):
+				apiutils.InvalidCredentialsResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		if message := users.InactiveUserMessage(user); message != "" {
+			apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+			return
+		}
+
+		tokens, err := issuer.Issue(auth.ContextSetMethod(r, auth.MethodPassword), user)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"user": user, "tokens": tokens}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("LoginHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package credentials
+
+import (
+	"errors"
+	"testing"
+)
+
+// testParams keep the tests fast, they are far too weak for production
+var testParams = Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashAndVerify(t *testing.T) {
+	encoded, err := Hash("correct horse battery staple", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	match, needsRehash, err := Verify("correct horse battery staple", encoded, testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Error("Expected the password to match its hash")
+	}
+	if needsRehash {
+		t.Error("Expected a hash made with the current params not to need a rehash")
+	}
+
+	match, _, err = Verify("wrong horse battery staple", encoded, testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match {
+		t.Error("Expected a wrong password not to match")
+	}
+}
+
+func TestHashUsesRandomSalt(t *testing.T) {
+	first, _ := Hash("correct horse battery staple", testParams)
+	second, _ := Hash("correct horse battery staple", testParams)
+
+	if first == second {
+		t.Error("Expected two hashes of the same password to differ")
+	}
+}
+
+func TestVerifyDetectsOutdatedParams(t *testing.T) {
+	encoded, err := Hash("correct horse battery staple", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := testParams
+	stronger.Iterations = 2
+
+	match, needsRehash, err := Verify("correct horse battery staple", encoded, stronger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !match {
+		t.Error("Expected a hash made with older params to keep verifying")
+	}
+	if !needsRehash {
+		t.Error("Expected a hash made with older params to need a rehash")
+	}
+}
+
+func TestVerifyRejectsInvalidHash(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"$2a$10$abcdefghijklmnopqrstuv",
+		"$argon2i$v=19$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$not base64$a2V5",
+	} {
+		if _, _, err := Verify("password", encoded, testParams); !errors.Is(err, ErrInvalidHash) {
+			t.Errorf("Expected ErrInvalidHash for %q, got %v", encoded, err)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package credentials
+
+import (
+	"go-web-api-starter/internal/validator"
+	"slices"
+	"strings"
+)
+
+// PasswordPolicy holds the rules a new password must follow. Following NIST SP 800-63B it
+// favours length over composition rules, and rejects passwords known to be common.
+type PasswordPolicy struct {
+	MinLength int
+	// MaxLength bounds the work done hashing a password, and is counted in bytes.
+	MaxLength int
+	// Forbidden are passwords that are rejected regardless of their length, compared case-insensitively.
+	Forbidden []string
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength: 12,
+	MaxLength: 256,
+	Forbidden: []string{
+		"123456789012", "password1234", "passwordpassword", "qwertyuiopas",
+		"111111111111", "iloveyou1234", "changeme1234", "letmein12345",
+	},
+}
+
+// ValidatePassword checks the password against the policy, and that it is not the user's email.
+// Failures are added to the Validator under the 'password' key.
+func (p PasswordPolicy) ValidatePassword(v *validator.Validator, password, email string) {
+	v.Check(password != "", "password", "must be provided")
+	v.MinLength(password, "password", p.MinLength)
+	v.MaxLength(password, "password", p.MaxLength)
+	v.Check(!strings.EqualFold(password, email), "password", "must not be the same as the email")
+	v.Check(!slices.ContainsFunc(p.Forbidden, func(f string) bool {
+		return strings.EqualFold(f, password)
+	}), "password", "is too common")
+}
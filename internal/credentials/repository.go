@@ -0,0 +1,106 @@
+package credentials
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"time"
+)
+
+// Credential is the password a user signs in with. Users authenticated by an external
+// identity provider have no credential.
+type Credential struct {
+	UserID       uuid.UUID
+	PasswordHash string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type CredentialPsqlRepo struct {
+	DB *database.DB
+}
+
+func (m CredentialPsqlRepo) Insert(credential *Credential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return insertCredential(ctx, m.DB, credential)
+}
+
+// InsertWithUser inserts the user and their credential in a single transaction, so that a user is never
+// left without the credential they registered with. Returns users.ErrDuplicateEmail if the email is taken.
+func (m CredentialPsqlRepo) InsertWithUser(user *users.User, credential *Credential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	insert := func(tx *sql.Tx) error {
+		return insertCredential(ctx, tx, credential)
+	}
+
+	return m.DB.WithTransaction(ctx, users.InsertUser(ctx, user), insert)
+}
+
+func insertCredential(ctx context.Context, q database.Querier, credential *Credential) error {
+	query := `INSERT INTO credentials (user_id, password_hash)
+              VALUES ($1, $2)
+              RETURNING created_at, updated_at`
+
+	return q.QueryRowContext(ctx, query, credential.UserID, credential.PasswordHash).
+		Scan(&credential.CreatedAt, &credential.UpdatedAt)
+}
+
+// GetByEmail returns the credential of the user with the email, compared case-insensitively.
+// Deleted users have no credential. Returns database.ErrRecordNotFound if there is none.
+func (m CredentialPsqlRepo) GetByEmail(email string) (*Credential, error) {
+	query := `SELECT credentials.user_id, credentials.password_hash, credentials.created_at, credentials.updated_at
+              FROM credentials
+              INNER JOIN users ON users.id = credentials.user_id
+              WHERE lower(users.email) = lower($1) AND NOT users.is_deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var credential Credential
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&credential.UserID,
+		&credential.PasswordHash,
+		&credential.CreatedAt,
+		&credential.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// UpdateHash replaces the password hash of the user.
+// Returns database.ErrRecordNotFound if the user has no credential.
+func (m CredentialPsqlRepo) UpdateHash(userID uuid.UUID, passwordHash string) error {
+	query := `UPDATE credentials
+              SET password_hash = $1, updated_at = CURRENT_TIMESTAMP
+              WHERE user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"sync"
+)
+
+type credentialRepository interface {
+	Insert(credential *Credential) error
+	InsertWithUser(user *users.User, credential *Credential) error
+	GetByEmail(email string) (*Credential, error)
+	UpdateHash(userID uuid.UUID, passwordHash string) error
+}
+
+type userService interface {
+	GetById(id uuid.UUID) (*users.User, error)
+}
+
+// Service registers users with an email and password and authenticates them.
+// Users are created with users.NewDefaultUser, so they get the same default role
+// as users coming from an external identity provider.
+type Service struct {
+	logger      *slog.Logger
+	credentials credentialRepository
+	users       userService
+	params      Params
+	policy      PasswordPolicy
+	dummyHash   func() (string, error)
+}
+
+type ServiceOption func(*Service)
+
+// WithParams sets the argon2id parameters new hashes are made with. Existing hashes made with
+// other parameters are upgraded the next time their user signs in.
+func WithParams(params Params) ServiceOption {
+	return func(s *Service) {
+		s.params = params
+	}
+}
+
+// WithPasswordPolicy sets the rules new passwords must follow.
+func WithPasswordPolicy(policy PasswordPolicy) ServiceOption {
+	return func(s *Service) {
+		s.policy = policy
+	}
+}
+
+func NewService(logger *slog.Logger, credentials credentialRepository, users userService, opts ...ServiceOption) *Service {
+	s := &Service{
+		logger:      logger,
+		credentials: credentials,
+		users:       users,
+		params:      DefaultParams,
+		policy:      DefaultPasswordPolicy,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// Hashed once, on the first sign in with an unknown email, so that it takes as long as one with a known email
+	s.dummyHash = sync.OnceValues(func() (string, error) {
+		return Hash("dummy password", s.params)
+	})
+
+	return s
+}
+
+// ValidateRegistration checks the email and the password against the password policy.
+func (s *Service) ValidateRegistration(v *validator.Validator, email, password string) {
+	users.ValidateEmail(v, email)
+	s.policy.ValidatePassword(v, password, email)
+}
+
+// ValidatePassword checks the password of the user with the email against the password policy.
+func (s *Service) ValidatePassword(v *validator.Validator, password, email string) {
+	s.policy.ValidatePassword(v, password, email)
+}
+
+// Register creates a user with the default role and a credential with the password, in a single
+// transaction so that a failed registration may be retried with the same email. Returns users.ErrDuplicateEmail if the email is already taken.
+func (s *Service) Register(email, password string) (*users.User, error) {
+	passwordHash, err := Hash(password, s.params)
+	if err != nil {
+		return nil, fmt.Errorf("could not hash password: %w", err)
+	}
+
+	user := users.NewDefaultUser(email, uuid.New())
+	err = s.credentials.InsertWithUser(user, &Credential{UserID: user.ID, PasswordHash: passwordHash})
+	if err != nil {
+		return nil, fmt.Errorf("could not insert user: %w", err)
+	}
+
+	return s.users.GetById(user.ID)
+}
+
+// Authenticate returns the user with the email if the password matches their credential.
+// A hash made with outdated parameters is replaced with one made with the current parameters.
+// Returns ErrInvalidCredentials for an unknown email or a wrong password alike.
+func (s *Service) Authenticate(email, password string) (*users.User, error) {
+	credential, err := s.credentials.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			if dummy, hashErr := s.dummyHash(); hashErr == nil {
+				_, _, _ = Verify(password, dummy, s.params)
+			}
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	match, needsRehash, err := Verify(password, credential.PasswordHash, s.params)
+	if err != nil {
+		return nil, err
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	if needsRehash {
+		s.rehash(credential.UserID, password)
+	}
+
+	return s.users.GetById(credential.UserID)
+}
+
+// SetPassword replaces the password of the user, or gives them one if they have none yet,
+// such as users who signed up with an external identity provider.
+func (s *Service) SetPassword(userID uuid.UUID, password string) error {
+	passwordHash, err := Hash(password, s.params)
+	if err != nil {
+		return fmt.Errorf("could not hash password: %w", err)
+	}
+
+	err = s.credentials.UpdateHash(userID, passwordHash)
+	if errors.Is(err, database.ErrRecordNotFound) {
+		err = s.credentials.Insert(&Credential{UserID: userID, PasswordHash: passwordHash})
+	}
+	if err != nil {
+		return fmt.Errorf("could not set password: %w", err)
+	}
+
+	return nil
+}
+
+// rehash upgrades the stored hash to the current parameters. Failing to do so does not
+// fail the sign in, the upgrade is retried on the next one.
+func (s *Service) rehash(userID uuid.UUID, password string) {
+	passwordHash, err := Hash(password, s.params)
+	if err == nil {
+		err = s.credentials.UpdateHash(userID, passwordHash)
+	}
+	if err != nil {
+		s.logger.Error("failed to upgrade password hash", "user id", userID, "error", err)
+		return
+	}
+
+	s.logger.Info("upgraded password hash", "user id", userID)
+}
@@ -0,0 +1,158 @@
+package credentials
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type mockCredentialRepo struct {
+	credentials map[string]*Credential
+	inserted    map[uuid.UUID]*Credential
+	updated     map[uuid.UUID]string
+	users       *mockUserService
+	failInsert  error
+}
+
+func (m *mockCredentialRepo) Insert(credential *Credential) error {
+	m.inserted[credential.UserID] = credential
+	return nil
+}
+
+func (m *mockCredentialRepo) InsertWithUser(user *users.User, credential *Credential) error {
+	if m.failInsert != nil {
+		return m.failInsert
+	}
+	m.users.user = user
+	m.inserted[credential.UserID] = credential
+	return nil
+}
+
+func (m *mockCredentialRepo) GetByEmail(email string) (*Credential, error) {
+	credential, ok := m.credentials[strings.ToLower(email)]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return credential, nil
+}
+
+func (m *mockCredentialRepo) UpdateHash(userID uuid.UUID, passwordHash string) error {
+	m.updated[userID] = passwordHash
+	return nil
+}
+
+type mockUserService struct {
+	user *users.User
+}
+
+func (m *mockUserService) GetById(id uuid.UUID) (*users.User, error) {
+	return m.user, nil
+}
+
+func newTestService(t *testing.T, stored Params) (*Service, *mockCredentialRepo, *users.User) {
+	t.Helper()
+	user := &users.User{ID: uuid.New(), Email: "test@example.com", Role: users.RegularRole}
+
+	passwordHash, err := Hash("correct horse battery staple", stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &mockCredentialRepo{
+		credentials: map[string]*Credential{user.Email: {UserID: user.ID, PasswordHash: passwordHash}},
+		inserted:    map[uuid.UUID]*Credential{},
+		updated:     map[uuid.UUID]string{},
+		users:       &mockUserService{user: user},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	service := NewService(logger, repo, repo.users, WithParams(testParams))
+
+	return service, repo, user
+}
+
+func TestAuthenticate(t *testing.T) {
+	service, repo, user := newTestService(t, testParams)
+
+	got, err := service.Authenticate("Test@Example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatalf("Expected the user to be authenticated, got %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("Expected user %v, got %v", user.ID, got.ID)
+	}
+	if len(repo.updated) != 0 {
+		t.Error("Expected a hash with current params not to be upgraded")
+	}
+}
+
+func TestAuthenticateRejectsInvalidCredentials(t *testing.T) {
+	service, _, _ := newTestService(t, testParams)
+
+	testCases := map[string][2]string{
+		"wrong password": {"test@example.com", "wrong horse battery staple"},
+		"unknown email":  {"unknown@example.com", "correct horse battery staple"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := service.Authenticate(tc[0], tc[1]); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Expected ErrInvalidCredentials, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateUpgradesOutdatedHash(t *testing.T) {
+	weaker := testParams
+	weaker.Memory = 512
+	service, repo, user := newTestService(t, weaker)
+
+	if _, err := service.Authenticate("test@example.com", "correct horse battery staple"); err != nil {
+		t.Fatalf("Expected the user to be authenticated, got %v", err)
+	}
+
+	upgraded, ok := repo.updated[user.ID]
+	if !ok {
+		t.Fatal("Expected the outdated hash to be upgraded")
+	}
+	if match, needsRehash, _ := Verify("correct horse battery staple", upgraded, testParams); !match || needsRehash {
+		t.Error("Expected the upgraded hash to use the current params")
+	}
+}
+
+func TestRegisterHashesPassword(t *testing.T) {
+	service, repo, _ := newTestService(t, testParams)
+
+	user, err := service.Register("new@example.com", "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, ok := repo.inserted[user.ID]
+	if !ok {
+		t.Fatal("Expected a credential to be stored for the new user")
+	}
+	if strings.Contains(credential.PasswordHash, "correct horse") {
+		t.Error("Expected the password not to be stored in plain text")
+	}
+	if match, _, _ := Verify("correct horse battery staple", credential.PasswordHash, testParams); !match {
+		t.Error("Expected the stored hash to match the password")
+	}
+}
+
+func TestRegisterDuplicateEmail(t *testing.T) {
+	service, repo, _ := newTestService(t, testParams)
+	repo.failInsert = users.ErrDuplicateEmail
+
+	if _, err := service.Register("test@example.com", "correct horse battery staple"); !errors.Is(err, users.ErrDuplicateEmail) {
+		t.Errorf("Expected ErrDuplicateEmail, got %v", err)
+	}
+	if len(repo.inserted) != 0 {
+		t.Error("Expected no credential to be stored")
+	}
+}
@@ -14,6 +14,8 @@ const (
 //go:embed migrations/*.sql
 var embedMigrations embed.FS
 
+// RunMigrations applies the pending migrations, in order. Migrations are only ever added after the
+// latest one, since databases that applied a later migration refuse to apply an earlier one.
 func (db *DB) RunMigrations(dialect string) error {
 	goose.SetBaseFS(embedMigrations)
 
@@ -0,0 +1,58 @@
+-- +goose Up
+-- Users and roles predate versioned migrations: databases created before this one already have them,
+-- so every statement must leave an existing schema as it is.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TABLE IF NOT EXISTS roles (
+    id          BIGSERIAL PRIMARY KEY,
+    name        TEXT        NOT NULL UNIQUE CHECK (name <> ''),
+    description TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id          BIGSERIAL PRIMARY KEY,
+    code        TEXT        NOT NULL UNIQUE CHECK (code <> ''),
+    description TEXT        NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS roles_permissions (
+    role_id       BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    permission_id BIGINT NOT NULL REFERENCES permissions (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, permission_id)
+);
+
+CREATE INDEX IF NOT EXISTS roles_permissions_permission_id_idx ON roles_permissions (permission_id);
+
+CREATE TABLE IF NOT EXISTS users (
+    id         UUID PRIMARY KEY,
+    email      TEXT        NOT NULL CHECK (email <> ''),
+    role_id    BIGINT REFERENCES roles (id) ON DELETE RESTRICT,
+    is_deleted BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Deleted users keep a mangled copy of their email, and must not stop it from being used again
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email)) WHERE NOT is_deleted;
+CREATE INDEX IF NOT EXISTS users_role_id_idx ON users (role_id);
+
+DROP TRIGGER IF EXISTS roles_set_updated_at ON roles;
+CREATE TRIGGER roles_set_updated_at BEFORE UPDATE ON roles
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+DROP TRIGGER IF EXISTS users_set_updated_at ON users;
+CREATE TRIGGER users_set_updated_at BEFORE UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+-- +goose StatementEnd
+
+-- +goose Down
+-- The tables may predate this migration, so they are kept.
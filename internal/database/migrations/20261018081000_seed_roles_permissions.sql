@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+INSERT INTO roles (name, description)
+VALUES ('regular', 'Every user starts with this role'),
+       ('admin', 'Manages users and the API')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO permissions (code, description)
+VALUES ('users:manage', 'Suspend and reinstate users, and manage their roles'),
+       ('audit:read', 'Read the audit trail'),
+       ('tokens:revoke', 'Revoke the tokens of any user'),
+       ('users:impersonate', 'Act as another user with the Act-As header')
+ON CONFLICT (code) DO NOTHING;
+
+INSERT INTO roles_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id
+FROM roles
+CROSS JOIN permissions
+WHERE roles.name = 'admin'
+  AND permissions.code IN ('users:manage', 'audit:read', 'tokens:revoke', 'users:impersonate')
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- The roles and permissions may predate this migration, so they are kept.
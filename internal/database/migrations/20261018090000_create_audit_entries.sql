@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS audit_entries (
+    id            BIGSERIAL PRIMARY KEY,
+    occurred_at   TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    actor_id      UUID,
+    method        TEXT        NOT NULL,
+    route         TEXT        NOT NULL,
+    path          TEXT        NOT NULL,
+    resource_type TEXT        NOT NULL DEFAULT '',
+    resource_id   TEXT        NOT NULL DEFAULT '',
+    ip            TEXT        NOT NULL DEFAULT '',
+    request_id    TEXT        NOT NULL DEFAULT '',
+    status_code   INTEGER     NOT NULL,
+    outcome       TEXT        NOT NULL CHECK (outcome IN ('success', 'failure', 'error')),
+    before        JSONB,
+    after         JSONB
+);
+
+CREATE INDEX IF NOT EXISTS audit_entries_occurred_at_idx ON audit_entries (occurred_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_entries_actor_id_idx ON audit_entries (actor_id, occurred_at DESC);
+CREATE INDEX IF NOT EXISTS audit_entries_resource_idx ON audit_entries (resource_type, resource_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS audit_entries;
+-- +goose StatementEnd
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+    state         TEXT PRIMARY KEY,
+    provider      TEXT        NOT NULL,
+    nonce         TEXT        NOT NULL,
+    code_verifier TEXT        NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS oidc_login_states_expires_at_idx ON oidc_login_states (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    provider      TEXT        NOT NULL,
+    subject       TEXT        NOT NULL,
+    user_id       UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email         TEXT        NOT NULL DEFAULT '',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_identities;
+DROP TABLE IF EXISTS oidc_login_states;
+-- +goose StatementEnd
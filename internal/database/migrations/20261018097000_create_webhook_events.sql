@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id          TEXT PRIMARY KEY,
+    type        TEXT        NOT NULL,
+    user_id     UUID        NOT NULL,
+    email       TEXT        NOT NULL DEFAULT '',
+    occurred_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    outcome     TEXT        NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_events_user_id_idx ON webhook_events (user_id, occurred_at) WHERE outcome = 'applied';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS webhook_events;
+-- +goose StatementEnd
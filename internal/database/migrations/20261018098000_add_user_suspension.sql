@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS suspended_at      TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS suspended_until   TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS suspension_reason TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS suspended_by      UUID REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS users_suspended_at_idx ON users (suspended_at) WHERE suspended_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_suspended_at_idx;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS suspended_by,
+    DROP COLUMN IF EXISTS suspension_reason,
+    DROP COLUMN IF EXISTS suspended_until,
+    DROP COLUMN IF EXISTS suspended_at;
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS mfa_authenticators (
+    id             UUID PRIMARY KEY,
+    user_id        UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name           TEXT        NOT NULL,
+    secret         BYTEA       NOT NULL,
+    confirmed_at   TIMESTAMPTZ,
+    last_used_step BIGINT      NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS mfa_authenticators_user_id_idx ON mfa_authenticators (user_id);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash  BYTEA       NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, code_hash)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS mfa_authenticators;
+-- +goose StatementEnd
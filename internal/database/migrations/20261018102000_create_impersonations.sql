@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS impersonations (
+    id         UUID PRIMARY KEY,
+    actor_id   UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    target_id  UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reason     TEXT        NOT NULL,
+    started_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMPTZ NOT NULL,
+    ended_at   TIMESTAMPTZ,
+    CHECK (actor_id <> target_id),
+    CHECK (expires_at > started_at)
+);
+
+CREATE INDEX IF NOT EXISTS impersonations_actor_target_idx ON impersonations (actor_id, target_id, expires_at DESC)
+    WHERE ended_at IS NULL;
+CREATE INDEX IF NOT EXISTS impersonations_target_id_idx ON impersonations (target_id, started_at DESC);
+
+ALTER TABLE audit_entries ADD COLUMN IF NOT EXISTS impersonated_user_id UUID;
+
+CREATE INDEX IF NOT EXISTS audit_entries_impersonated_user_id_idx ON audit_entries (impersonated_user_id, occurred_at DESC)
+    WHERE impersonated_user_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS audit_entries_impersonated_user_id_idx;
+
+ALTER TABLE audit_entries DROP COLUMN IF EXISTS impersonated_user_id;
+
+DROP TABLE IF EXISTS impersonations;
+-- +goose StatementEnd
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS roles_parents (
+    role_id   BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    parent_id BIGINT NOT NULL REFERENCES roles (id) ON DELETE CASCADE,
+    PRIMARY KEY (role_id, parent_id),
+    CHECK (role_id <> parent_id)
+);
+
+CREATE INDEX IF NOT EXISTS roles_parents_parent_id_idx ON roles_parents (parent_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS roles_parents;
+-- +goose StatementEnd
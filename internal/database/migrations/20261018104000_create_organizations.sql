@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS organizations (
+    id         UUID PRIMARY KEY,
+    slug       TEXT        NOT NULL UNIQUE CHECK (slug <> ''),
+    name       TEXT        NOT NULL CHECK (name <> ''),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS org_memberships (
+    org_id     UUID        NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role       TEXT        NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS org_memberships_user_id_idx ON org_memberships (user_id);
+
+CREATE TABLE IF NOT EXISTS org_invitations (
+    id          UUID PRIMARY KEY,
+    org_id      UUID        NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    email       TEXT        NOT NULL CHECK (email <> ''),
+    role        TEXT        NOT NULL CHECK (role IN ('owner', 'admin', 'member')),
+    token_hash  BYTEA       NOT NULL UNIQUE,
+    invited_by  UUID        REFERENCES users (id) ON DELETE SET NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    accepted_at TIMESTAMPTZ,
+    accepted_by UUID        REFERENCES users (id) ON DELETE SET NULL,
+    revoked_at  TIMESTAMPTZ
+);
+
+-- An email has at most one open invitation to an organization: inviting it again replaces it
+CREATE UNIQUE INDEX IF NOT EXISTS org_invitations_open_idx ON org_invitations (org_id, lower(email))
+    WHERE accepted_at IS NULL AND revoked_at IS NULL;
+
+DROP TRIGGER IF EXISTS organizations_set_updated_at ON organizations;
+CREATE TRIGGER organizations_set_updated_at BEFORE UPDATE ON organizations
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+DROP TRIGGER IF EXISTS org_memberships_set_updated_at ON org_memberships;
+CREATE TRIGGER org_memberships_set_updated_at BEFORE UPDATE ON org_memberships
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS org_invitations;
+DROP TABLE IF EXISTS org_memberships;
+DROP TABLE IF EXISTS organizations;
+-- +goose StatementEnd
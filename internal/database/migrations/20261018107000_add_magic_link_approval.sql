@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE magic_links
+    ADD COLUMN IF NOT EXISTS opener_hash       BYTEA,
+    ADD COLUMN IF NOT EXISTS opened_ip         TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS opened_user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS opened_at         TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS approved_at       TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS magic_links_device_hash_idx ON magic_links (device_hash);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS magic_links_device_hash_idx;
+
+ALTER TABLE magic_links
+    DROP COLUMN IF EXISTS opener_hash,
+    DROP COLUMN IF EXISTS opened_ip,
+    DROP COLUMN IF EXISTS opened_user_agent,
+    DROP COLUMN IF EXISTS opened_at,
+    DROP COLUMN IF EXISTS approved_at;
+-- +goose StatementEnd
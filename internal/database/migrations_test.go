@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/pressly/goose/v3"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	createdTableRX    = regexp.MustCompile(`(?i)CREATE TABLE (?:IF NOT EXISTS )?(\w+)`)
+	usedTableRX       = regexp.MustCompile(`(?i)(?:REFERENCES|ALTER TABLE|INSERT INTO) (?:IF EXISTS )?(\w+)`)
+	createdFunctionRX = regexp.MustCompile(`(?i)CREATE (?:OR REPLACE )?FUNCTION (\w+)`)
+	usedFunctionRX    = regexp.MustCompile(`(?i)EXECUTE (?:FUNCTION|PROCEDURE) (\w+)`)
+)
+
+// TestMigrations checks that the embedded migrations are ordered by version, have an Up section with
+// balanced statements, and only use the tables and functions created by themselves or earlier migrations,
+// which is the order RunMigrations applies them in.
+func TestMigrations(t *testing.T) {
+	entries, err := fs.ReadDir(embedMigrations, "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+
+	var previous int64
+	tables := map[string]bool{}
+	functions := map[string]bool{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		version, err := goose.NumericComponent(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if version <= previous {
+			t.Errorf("%s: expected version to be after %d", name, previous)
+		}
+		previous = version
+
+		b, err := fs.ReadFile(embedMigrations, "migrations/"+name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source := string(b)
+
+		up, _, ok := strings.Cut(source, "-- +goose Down")
+		if !strings.Contains(up, "-- +goose Up") || !ok {
+			t.Errorf("%s: expected an Up and a Down section", name)
+		}
+		if begins, ends := strings.Count(source, "-- +goose StatementBegin"), strings.Count(source, "-- +goose StatementEnd"); begins != ends {
+			t.Errorf("%s: expected as many StatementBegin as StatementEnd, got %d and %d", name, begins, ends)
+		}
+
+		for _, match := range createdTableRX.FindAllStringSubmatch(up, -1) {
+			tables[match[1]] = true
+		}
+		for _, match := range createdFunctionRX.FindAllStringSubmatch(up, -1) {
+			functions[match[1]] = true
+		}
+
+		for _, match := range usedTableRX.FindAllStringSubmatch(up, -1) {
+			if !tables[match[1]] {
+				t.Errorf("%s: uses table %s before a migration creates it", name, match[1])
+			}
+		}
+		for _, match := range usedFunctionRX.FindAllStringSubmatch(up, -1) {
+			if !functions[match[1]] {
+				t.Errorf("%s: uses function %s before a migration creates it", name, match[1])
+			}
+		}
+	}
+}
+
+// TestRunMigrationsOnEmptyDatabase applies every migration to a new, empty database on the Postgres
+// server of TEST_DATABASE_DSN, a lib/pq connection string, and is skipped when it is not set.
+// The role connecting must be allowed to create databases.
+func TestRunMigrationsOnEmptyDatabase(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	server, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	name := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err = server.ExecContext(ctx, "CREATE DATABASE "+name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := server.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+name+" WITH (FORCE)"); err != nil {
+			t.Errorf("failed to drop database %s: %v", name, err)
+		}
+	})
+
+	db, err := SqlDbConfig{Dsn: dsn + " dbname=" + name, MaxOpenConns: 2, MaxIdleConns: 2, MaxIdleTime: time.Minute}.OpenDB("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = db.RunMigrations(DialectPostgres); err != nil {
+		t.Fatalf("Expected migrations to apply to an empty database, got %v", err)
+	}
+	// Applying them again has nothing left to do
+	if err = db.RunMigrations(DialectPostgres); err != nil {
+		t.Fatalf("Expected migrations to be up to date, got %v", err)
+	}
+
+	for _, table := range []string{"users", "roles", "permissions", "roles_permissions", "roles_parents", "credentials", "organizations", "magic_links"} {
+		var exists bool
+		err = db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Errorf("Expected table %s to be created", table)
+		}
+	}
+
+	var granted int
+	query := `SELECT count(*)
+              FROM roles_permissions
+              INNER JOIN roles ON roles.id = roles_permissions.role_id
+              WHERE roles.name = 'admin'`
+	if err = db.QueryRowContext(ctx, query).Scan(&granted); err != nil {
+		t.Fatal(err)
+	}
+	if granted == 0 {
+		t.Error("Expected the admin role to be seeded with permissions")
+	}
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/lib/pq"
+	"log/slog"
+	"time"
+)
+
+// Notify sends a notification on the Postgres channel. Inside a transaction the notification
+// is only delivered once the transaction commits, and not at all if it rolls back.
+func Notify(ctx context.Context, tx *sql.Tx, channel string, payload string) error {
+	_, err := tx.ExecContext(ctx, "SELECT pg_notify($1, $2)", channel, payload)
+	return err
+}
+
+// Listen calls onNotify with the payload of every notification sent on the Postgres channel
+// until the context is cancelled. It opens a dedicated connection to the database with the dsn.
+//
+// Notifications sent while the connection is down are lost, so onReconnect is called every time
+// the connection is re-established to let the caller catch up from the tables it watches.
+func Listen(
+	ctx context.Context,
+	logger *slog.Logger,
+	dsn string,
+	channel string,
+	onNotify func(payload string),
+	onReconnect func(),
+) error {
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Error("postgres listener error", "channel", channel, "error", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(channel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			// A nil notification is sent after the connection was lost and re-established
+			if n == nil {
+				onReconnect()
+				continue
+			}
+			onNotify(n.Extra)
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
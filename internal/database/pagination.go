@@ -0,0 +1,60 @@
+package database
+
+import (
+	"go-web-api-starter/internal/validator"
+	"math"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// Pagination holds the page and page size requested by a client for a list query.
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+// Limit returns the number of records a query should return for the page.
+func (p Pagination) Limit() int {
+	return p.PageSize
+}
+
+// Offset returns the number of records a query should skip to reach the page.
+func (p Pagination) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// ValidatePagination checks that the page is positive and the page size is between 1 and MaxPageSize.
+func ValidatePagination(v *validator.Validator, p Pagination) {
+	v.Check(p.Page > 0, "page", "must be greater than zero")
+	v.Check(p.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(p.PageSize > 0, "pageSize", "must be greater than zero")
+	v.Check(p.PageSize <= MaxPageSize, "pageSize", "must be a maximum of 100")
+}
+
+// Metadata describes where a page of results sits within the full result set.
+type Metadata struct {
+	CurrentPage  int `json:"currentPage,omitempty"`
+	PageSize     int `json:"pageSize,omitempty"`
+	FirstPage    int `json:"firstPage,omitempty"`
+	LastPage     int `json:"lastPage,omitempty"`
+	TotalRecords int `json:"totalRecords"`
+}
+
+// CalculateMetadata builds the Metadata for a page given the total number of matching records.
+// An empty Metadata is returned when there are no records.
+func CalculateMetadata(totalRecords int, p Pagination) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  p.Page,
+		PageSize:     p.PageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(p.PageSize))),
+		TotalRecords: totalRecords,
+	}
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"regexp"
+)
+
+var (
+	// ErrNoTenant is returned by the queries of a Tenant without an organization.
+	ErrNoTenant = errors.New("query has no tenant")
+	// ErrUnscopedQuery is returned by the queries of a Tenant that do not filter on its organization.
+	ErrUnscopedQuery = errors.New("query is not scoped to the tenant")
+)
+
+var (
+	// tenantCondition matches the condition tenant queries filter on, org_id = $1, optionally qualified by a table.
+	tenantCondition = regexp.MustCompile(`\borg_id\s*=\s*\$1\b`)
+	// tenantInsert matches inserts of rows of the tenant, whose first column is org_id and first value $1.
+	tenantInsert = regexp.MustCompile(`(?is)^\s*INSERT\s+INTO\s+\w+\s*\(\s*org_id\s*,.*\)\s*VALUES\s*\(\s*\$1\s*,`)
+)
+
+// Querier runs queries, on a DB or within a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Tenant runs queries on the tables shared by organizations for one of them. Every query must filter
+// on the organization with an org_id = $1 condition, or insert rows with org_id as their first column
+// and $1 as its value, and is given the organization's ID as the first argument, before the arguments
+// passed. Other queries are refused with ErrUnscopedQuery, so that rows of another organization are
+// never read or changed by mistake.
+//
+//	tenant := database.NewTenant(db, orgID)
+//	rows, err := tenant.QueryContext(ctx, `SELECT user_id FROM org_memberships WHERE org_id = $1 AND role = $2`, role)
+type Tenant struct {
+	q     Querier
+	orgID uuid.UUID
+}
+
+// NewTenant returns a Tenant running queries for the organization with the querier, a *DB or an *sql.Tx.
+func NewTenant(q Querier, orgID uuid.UUID) Tenant {
+	return Tenant{q: q, orgID: orgID}
+}
+
+// OrgID is the ID of the organization the queries are scoped to.
+func (t Tenant) OrgID() uuid.UUID {
+	return t.orgID
+}
+
+func (t Tenant) check(query string) error {
+	if t.orgID == uuid.Nil {
+		return ErrNoTenant
+	}
+	if !tenantCondition.MatchString(query) && !tenantInsert.MatchString(query) {
+		return ErrUnscopedQuery
+	}
+	return nil
+}
+
+func (t Tenant) args(args []any) []any {
+	return append([]any{t.orgID}, args...)
+}
+
+func (t Tenant) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if err := t.check(query); err != nil {
+		return nil, err
+	}
+	return t.q.ExecContext(ctx, query, t.args(args)...)
+}
+
+func (t Tenant) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if err := t.check(query); err != nil {
+		return nil, err
+	}
+	return t.q.QueryContext(ctx, query, t.args(args)...)
+}
+
+// QueryRowContext runs a query returning at most one row. The error of an unscoped query is returned by Scan.
+func (t Tenant) QueryRowContext(ctx context.Context, query string, args ...any) *TenantRow {
+	if err := t.check(query); err != nil {
+		return &TenantRow{err: err}
+	}
+	return &TenantRow{row: t.q.QueryRowContext(ctx, query, t.args(args)...)}
+}
+
+// TenantRow is the result of Tenant.QueryRowContext.
+type TenantRow struct {
+	row *sql.Row
+	err error
+}
+
+// Scan copies the columns of the row into dest, like sql.Row.Scan.
+func (r *TenantRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.row.Scan(dest...)
+}
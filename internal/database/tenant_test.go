@@ -0,0 +1,65 @@
+package database
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestTenant_check(t *testing.T) {
+	tests := []struct {
+		name  string
+		orgID uuid.UUID
+		query string
+		want  error
+	}{
+		{
+			name:  "filter",
+			orgID: uuid.New(),
+			query: `SELECT role FROM org_memberships WHERE org_id = $1 AND user_id = $2`,
+		},
+		{
+			name:  "qualified filter",
+			orgID: uuid.New(),
+			query: `SELECT m.role FROM org_memberships m JOIN users u ON u.id = m.user_id WHERE m.org_id=$1`,
+		},
+		{
+			name:  "insert",
+			orgID: uuid.New(),
+			query: `INSERT INTO org_memberships (org_id, user_id, role)
+                    VALUES ($1, $2, $3)`,
+		},
+		{
+			name:  "no tenant",
+			query: `SELECT role FROM org_memberships WHERE org_id = $1`,
+			want:  ErrNoTenant,
+		},
+		{
+			name:  "no filter",
+			orgID: uuid.New(),
+			query: `SELECT role FROM org_memberships WHERE user_id = $1`,
+			want:  ErrUnscopedQuery,
+		},
+		{
+			name:  "filter on another parameter",
+			orgID: uuid.New(),
+			query: `SELECT role FROM org_memberships WHERE user_id = $1 AND org_id = $12`,
+			want:  ErrUnscopedQuery,
+		},
+		{
+			name:  "insert with another value",
+			orgID: uuid.New(),
+			query: `INSERT INTO org_memberships (org_id, user_id) VALUES ($2, $1)`,
+			want:  ErrUnscopedQuery,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewTenant(nil, tt.orgID).check(tt.query)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
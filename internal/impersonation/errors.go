@@ -0,0 +1,8 @@
+package impersonation
+
+import "errors"
+
+var (
+	ErrNotActive        = errors.New("no active impersonation of the user")
+	ErrPrivilegedTarget = errors.New("the user has permissions the actor does not have")
+)
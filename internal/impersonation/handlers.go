@@ -0,0 +1,113 @@
+package impersonation
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type impersonationStarter interface {
+	Start(actor *users.User, imp *Impersonation) (*users.User, error)
+	MaxDuration() time.Duration
+}
+
+type impersonationEnder interface {
+	End(actorID, id uuid.UUID) error
+}
+
+// StartHandler starts an impersonation of the user with the userId by the context user, for a reason.
+// It lasts until expiresAt, or the maximum duration when it is omitted.
+func StartHandler(logger *slog.Logger, starter impersonationStarter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			UserID    uuid.UUID  `json:"userId"`
+			Reason    string     `json:"reason"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		actor := users.ContextGetUser(r)
+		imp := &Impersonation{
+			TargetID:  input.UserID,
+			Reason:    strings.TrimSpace(input.Reason),
+			ExpiresAt: time.Now().Add(starter.MaxDuration()),
+		}
+		if input.ExpiresAt != nil {
+			imp.ExpiresAt = *input.ExpiresAt
+		}
+
+		v := validator.New()
+		if ValidateImpersonation(v, actor, imp, starter.MaxDuration()); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		target, err := starter.Start(actor, imp)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				v.AddError("userId", "no user with this id")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			case errors.Is(err, ErrPrivilegedTarget):
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "this user has permissions you do not have")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		audit.SetResource(r, "impersonation", imp.ID.String())
+		audit.SetAfter(r, imp)
+
+		env := apiutils.Envelope{"impersonation": imp, "user": target}
+		err = apiutils.WriteJson(w, http.StatusCreated, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("StartHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// EndHandler ends the context user's impersonation with the id in the path before it expires.
+func EndHandler(logger *slog.Logger, ender impersonationEnder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		id := apiutils.ReadUUIDPath(r, "id", v)
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "impersonation", id.String())
+
+		if err := ender.End(users.ContextGetUserId(r), id); err != nil {
+			switch {
+			case errors.Is(err, ErrNotActive):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		env := apiutils.Envelope{"message": "impersonation ended"}
+		err := apiutils.WriteJson(w, http.StatusOK, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("EndHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
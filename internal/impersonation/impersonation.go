@@ -0,0 +1,32 @@
+package impersonation
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Header is the request header naming the user to act as, by ID.
+const Header = "Act-As"
+
+// Response headers of impersonated requests, naming the user acted as and when the impersonation ends.
+const (
+	ActingAsHeader        = "Acting-As"
+	ActingAsExpiresHeader = "Acting-As-Expires"
+)
+
+// Impersonation lets the actor act as the target until it expires or is ended,
+// by sending the target's ID in the Act-As header.
+type Impersonation struct {
+	ID        uuid.UUID  `json:"id"`
+	ActorID   uuid.UUID  `json:"actorId"`
+	TargetID  uuid.UUID  `json:"targetId"`
+	Reason    string     `json:"reason"`
+	StartedAt time.Time  `json:"startedAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	EndedAt   *time.Time `json:"endedAt"`
+}
+
+// IsActive reports whether the impersonation can be used at the time.
+func (i *Impersonation) IsActive(at time.Time) bool {
+	return i.EndedAt == nil && at.Before(i.ExpiresAt)
+}
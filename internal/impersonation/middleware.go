@@ -0,0 +1,96 @@
+package impersonation
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type impersonationResolver interface {
+	Resolve(actor *users.User, targetID uuid.UUID) (*Impersonation, *users.User, error)
+}
+
+// Middleware lets users with the users:impersonate permission act as another user, whose ID they send
+// in the Act-As header, while they have an active impersonation of them. The target is set as the
+// context user, so handlers and permission checks see the API as the target does, and the actor is
+// set with users.ContextSetActor. Responses name the target and when the impersonation ends in the
+// Acting-As and Acting-As-Expires headers.
+//
+// Requests without the header are passed through. The middleware must be placed after the
+// authentication middleware, and before the audit middleware so that entries record both users.
+func Middleware(logger *slog.Logger, resolver impersonationResolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get(Header)
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			actor, ok := users.ContextLookupUser(r)
+			if !ok || actor.IsAnonymous() {
+				apiutils.AuthenticationRequiredResponse(w, r, logger)
+				return
+			}
+			if !actor.Role.Allows(users.PermUsersImpersonate) {
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "you do not have permission to act as another user")
+				return
+			}
+
+			targetID, err := uuid.Parse(header)
+			if err != nil {
+				apiutils.ErrorResponse(w, r, logger, http.StatusBadRequest, "the Act-As header must be a user id")
+				return
+			}
+
+			imp, target, err := resolver.Resolve(actor, targetID)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrNotActive):
+					apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "you have no active impersonation of this user")
+				case errors.Is(err, ErrPrivilegedTarget):
+					apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "this user has permissions you do not have")
+				default:
+					apiutils.ServerErrorResponse(w, r, logger, err)
+				}
+				return
+			}
+
+			if message := users.InactiveUserMessage(target); message != "" {
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+				return
+			}
+
+			w.Header().Set(ActingAsHeader, target.ID.String())
+			w.Header().Set(ActingAsExpiresHeader, imp.ExpiresAt.UTC().Format(time.RFC3339))
+
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Info("impersonated request", "request id", requestId, "actor id", actor.ID,
+				"user id", target.ID, "impersonation id", imp.ID, "route", r.Pattern)
+
+			next.ServeHTTP(w, users.ContextSetUser(users.ContextSetActor(r, actor), target))
+		})
+	}
+}
+
+// Refuse creates a middleware that rejects impersonated requests with a "Forbidden" status,
+// for routes only the user themselves may use, such as their security settings.
+func Refuse(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if actor, ok := users.ContextGetActor(r); ok {
+				requestId, _ := middleware.GetRequestID(r)
+				logger.Warn("impersonated request refused", "request id", requestId, "actor id", actor.ID, "route", r.Pattern)
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "this action cannot be performed while impersonating a user")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
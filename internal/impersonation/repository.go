@@ -0,0 +1,82 @@
+package impersonation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type ImpersonationPsqlRepo struct {
+	DB *database.DB
+}
+
+func (m ImpersonationPsqlRepo) Insert(imp *Impersonation) error {
+	query := `INSERT INTO impersonations (id, actor_id, target_id, reason, expires_at)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING started_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, imp.ID, imp.ActorID, imp.TargetID, imp.Reason, imp.ExpiresAt).
+		Scan(&imp.StartedAt)
+}
+
+// GetActive returns the latest impersonation of the target by the actor that has not ended or expired.
+// Returns database.ErrRecordNotFound if there is none.
+func (m ImpersonationPsqlRepo) GetActive(actorID, targetID uuid.UUID) (*Impersonation, error) {
+	query := `SELECT id, actor_id, target_id, reason, started_at, expires_at, ended_at
+              FROM impersonations
+              WHERE actor_id = $1 AND target_id = $2 AND ended_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+              ORDER BY expires_at DESC
+              LIMIT 1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var imp Impersonation
+	err := m.DB.QueryRowContext(ctx, query, actorID, targetID).Scan(
+		&imp.ID,
+		&imp.ActorID,
+		&imp.TargetID,
+		&imp.Reason,
+		&imp.StartedAt,
+		&imp.ExpiresAt,
+		&imp.EndedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &imp, nil
+}
+
+// End ends the actor's impersonation with the id, if it is still active.
+// Returns database.ErrRecordNotFound if there is no such active impersonation.
+func (m ImpersonationPsqlRepo) End(actorID, id uuid.UUID) error {
+	query := `UPDATE impersonations SET ended_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND actor_id = $2 AND ended_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id, actorID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package impersonation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"time"
+)
+
+const defaultMaxDuration = time.Hour
+
+type impersonationRepository interface {
+	Insert(imp *Impersonation) error
+	GetActive(actorID, targetID uuid.UUID) (*Impersonation, error)
+	End(actorID, id uuid.UUID) error
+}
+
+type userGetter interface {
+	GetById(id uuid.UUID) (*users.User, error)
+}
+
+// Service starts and ends impersonations, and resolves the user a request acts as.
+type Service struct {
+	logger      *slog.Logger
+	repo        impersonationRepository
+	users       userGetter
+	maxDuration time.Duration
+}
+
+type ServiceOption func(*Service)
+
+// WithMaxDuration sets how long an impersonation may last at most.
+func WithMaxDuration(d time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.maxDuration = d
+	}
+}
+
+func NewService(logger *slog.Logger, repo impersonationRepository, users userGetter, opts ...ServiceOption) *Service {
+	s := &Service{
+		logger:      logger,
+		repo:        repo,
+		users:       users,
+		maxDuration: defaultMaxDuration,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// MaxDuration is how long an impersonation may last at most.
+func (s *Service) MaxDuration() time.Duration {
+	return s.maxDuration
+}
+
+// ValidateImpersonation checks the target, reason and expiry of a new impersonation by the actor.
+func ValidateImpersonation(v *validator.Validator, actor *users.User, imp *Impersonation, maxDuration time.Duration) {
+	v.Check(imp.TargetID != uuid.Nil, "userId", "must be provided")
+	v.Check(imp.TargetID != actor.ID, "userId", "you cannot impersonate yourself")
+	v.Check(imp.Reason != "", "reason", "must be provided")
+	v.MaxLength(imp.Reason, "reason", 500)
+	v.Check(imp.ExpiresAt.After(time.Now()), "expiresAt", "must be in the future")
+	v.Check(!imp.ExpiresAt.After(time.Now().Add(maxDuration)),
+		"expiresAt", fmt.Sprintf("must be at most %s from now", maxDuration))
+}
+
+// Start lets the actor act as the target of the impersonation until it expires, and returns the target.
+// Returns database.ErrRecordNotFound, wrapped, when there is no such target, and ErrPrivilegedTarget
+// when the target has permissions the actor does not have.
+func (s *Service) Start(actor *users.User, imp *Impersonation) (*users.User, error) {
+	target, err := s.target(actor, imp.TargetID)
+	if err != nil {
+		return nil, err
+	}
+
+	imp.ID = uuid.New()
+	imp.ActorID = actor.ID
+	if err = s.repo.Insert(imp); err != nil {
+		return nil, fmt.Errorf("could not start impersonation: %w", err)
+	}
+
+	s.logger.Info("impersonation started",
+		"impersonation id", imp.ID, "actor id", actor.ID, "target id", imp.TargetID, "expires at", imp.ExpiresAt)
+	return target, nil
+}
+
+// Resolve returns the actor's active impersonation of the target, and the target.
+// Returns ErrNotActive when the actor has no active impersonation of the target, and
+// ErrPrivilegedTarget when the target has gained permissions the actor does not have since.
+func (s *Service) Resolve(actor *users.User, targetID uuid.UUID) (*Impersonation, *users.User, error) {
+	imp, err := s.repo.GetActive(actor.ID, targetID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil, ErrNotActive
+		}
+		return nil, nil, fmt.Errorf("could not get impersonation: %w", err)
+	}
+
+	target, err := s.target(actor, targetID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil, ErrNotActive
+		}
+		return nil, nil, err
+	}
+
+	return imp, target, nil
+}
+
+// End ends the actor's impersonation with the id before it expires.
+// Returns ErrNotActive when the actor has no such active impersonation.
+func (s *Service) End(actorID, id uuid.UUID) error {
+	if err := s.repo.End(actorID, id); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return ErrNotActive
+		}
+		return fmt.Errorf("could not end impersonation: %w", err)
+	}
+
+	s.logger.Info("impersonation ended", "impersonation id", id, "actor id", actorID)
+	return nil
+}
+
+// target returns the user the actor wants to act as, if the actor has every permission the user has,
+// so that impersonation never grants the actor more than they already have.
+func (s *Service) target(actor *users.User, targetID uuid.UUID) (*users.User, error) {
+	target, err := s.users.GetById(targetID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !actor.Role.Allows(target.Role.Permissions...) {
+		return nil, ErrPrivilegedTarget
+	}
+
+	return target, nil
+}
@@ -0,0 +1,254 @@
+package impersonation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/testutils"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type memoryImpersonationRepo struct {
+	impersonations map[uuid.UUID]*Impersonation
+}
+
+func (m *memoryImpersonationRepo) Insert(imp *Impersonation) error {
+	imp.StartedAt = time.Now()
+	m.impersonations[imp.ID] = imp
+	return nil
+}
+
+func (m *memoryImpersonationRepo) GetActive(actorID, targetID uuid.UUID) (*Impersonation, error) {
+	for _, imp := range m.impersonations {
+		if imp.ActorID == actorID && imp.TargetID == targetID && imp.IsActive(time.Now()) {
+			return imp, nil
+		}
+	}
+	return nil, database.ErrRecordNotFound
+}
+
+func (m *memoryImpersonationRepo) End(actorID, id uuid.UUID) error {
+	imp, ok := m.impersonations[id]
+	if !ok || imp.ActorID != actorID || !imp.IsActive(time.Now()) {
+		return database.ErrRecordNotFound
+	}
+	now := time.Now()
+	imp.EndedAt = &now
+	return nil
+}
+
+type mockUserGetter struct {
+	users map[uuid.UUID]*users.User
+}
+
+func (m *mockUserGetter) GetById(id uuid.UUID) (*users.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting user: %w", database.ErrRecordNotFound)
+	}
+	return user, nil
+}
+
+type testEnv struct {
+	service *Service
+	repo    *memoryImpersonationRepo
+	users   *mockUserGetter
+	support *users.User
+	target  *users.User
+	admin   *users.User
+}
+
+func newTestEnv() *testEnv {
+	env := &testEnv{
+		repo:  &memoryImpersonationRepo{impersonations: map[uuid.UUID]*Impersonation{}},
+		users: &mockUserGetter{users: map[uuid.UUID]*users.User{}},
+		support: &users.User{ID: uuid.New(), Email: "support@example.com", Role: users.Role{
+			Name:        "support",
+			Permissions: users.Permissions{users.PermUsersImpersonate, users.PermAuditRead},
+		}},
+		target: &users.User{ID: uuid.New(), Email: "jane@example.com", Role: users.RegularRole},
+		admin: &users.User{ID: uuid.New(), Email: "admin@example.com", Role: users.Role{
+			Name:        "admin",
+			Permissions: users.Permissions{users.PermUsersManage},
+		}},
+	}
+	for _, user := range []*users.User{env.support, env.target, env.admin} {
+		env.users.users[user.ID] = user
+	}
+
+	env.service = NewService(slog.New(slog.NewTextHandler(io.Discard, nil)), env.repo, env.users)
+	return env
+}
+
+func (env *testEnv) start(t *testing.T, targetID uuid.UUID) *Impersonation {
+	t.Helper()
+	imp := &Impersonation{TargetID: targetID, Reason: "ticket 42", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := env.service.Start(env.support, imp); err != nil {
+		t.Fatal(err)
+	}
+	return imp
+}
+
+// serve sends a request authenticated as the user, acting as the user with the id in actAs
+// when it is not empty, through the impersonation middleware.
+func (env *testEnv) serve(user *users.User, actAs string, handler http.Handler) *httptest.ResponseRecorder {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	req := httptest.NewRequest("GET", "/v1/users/me", nil)
+	if actAs != "" {
+		req.Header.Set(Header, actAs)
+	}
+	req = users.ContextSetUser(req, user)
+
+	rec := httptest.NewRecorder()
+	Middleware(logger, env.service)(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestImpersonatedRequest(t *testing.T) {
+	env := newTestEnv()
+	imp := env.start(t, env.target.ID)
+
+	var user, actor *users.User
+	rec := env.serve(env.support, env.target.ID.String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user = users.ContextGetUser(r)
+		actor, _ = users.ContextGetActor(r)
+	}))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	if user.ID != env.target.ID || actor == nil || actor.ID != env.support.ID {
+		t.Errorf("Expected the target as the user and the support staff as the actor, got %v and %v", user, actor)
+	}
+	if rec.Header().Get(ActingAsHeader) != env.target.ID.String() {
+		t.Errorf("Expected the %s header to name the target, got %q", ActingAsHeader, rec.Header().Get(ActingAsHeader))
+	}
+	if expires := rec.Header().Get(ActingAsExpiresHeader); expires != imp.ExpiresAt.UTC().Format(time.RFC3339) {
+		t.Errorf("Expected the %s header to be the expiry, got %q", ActingAsExpiresHeader, expires)
+	}
+}
+
+func TestRequestsWithoutHeaderAreNotImpersonated(t *testing.T) {
+	env := newTestEnv()
+
+	rec := env.serve(env.target, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := users.ContextGetActor(r); ok || users.ContextGetActorId(r) != env.target.ID {
+			t.Error("Expected the request not to be impersonated")
+		}
+	}))
+
+	if rec.Code != http.StatusOK || rec.Header().Get(ActingAsHeader) != "" {
+		t.Errorf("Expected the request to pass through, got %d", rec.Code)
+	}
+}
+
+func TestImpersonationRejected(t *testing.T) {
+	env := newTestEnv()
+	ended := env.start(t, env.target.ID)
+	if err := env.service.End(env.support.ID, ended.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := &users.User{ID: uuid.New(), Email: "expired@example.com", Role: users.RegularRole}
+	env.users.users[expired.ID] = expired
+	env.repo.impersonations[uuid.New()] = &Impersonation{
+		ActorID: env.support.ID, TargetID: expired.ID, ExpiresAt: time.Now().Add(-time.Minute),
+	}
+
+	// The target became an admin after the impersonation started
+	promoted := &users.User{ID: uuid.New(), Email: "promoted@example.com", Role: users.RegularRole}
+	env.users.users[promoted.ID] = promoted
+	env.start(t, promoted.ID)
+	promoted.Role = env.admin.Role
+
+	now := time.Now()
+	suspended := &users.User{ID: uuid.New(), Email: "suspended@example.com", Role: users.RegularRole}
+	env.users.users[suspended.ID] = suspended
+	env.start(t, suspended.ID)
+	suspended.Suspension = &users.Suspension{SuspendedAt: now, Reason: "spam"}
+
+	testCases := []struct {
+		name    string
+		user    *users.User
+		actAs   string
+		status  int
+		message string
+	}{
+		{"anonymous", users.AnonymousUser, env.target.ID.String(), http.StatusUnauthorized, "you must be authenticated to access this resource"},
+		{"without permission", env.admin, env.target.ID.String(), http.StatusForbidden, "you do not have permission to act as another user"},
+		{"not a user id", env.support, "jane@example.com", http.StatusBadRequest, "the Act-As header must be a user id"},
+		{"never started", env.support, env.admin.ID.String(), http.StatusForbidden, "you have no active impersonation of this user"},
+		{"ended", env.support, env.target.ID.String(), http.StatusForbidden, "you have no active impersonation of this user"},
+		{"expired", env.support, expired.ID.String(), http.StatusForbidden, "you have no active impersonation of this user"},
+		{"privileged target", env.support, promoted.ID.String(), http.StatusForbidden, "this user has permissions you do not have"},
+		{"suspended target", env.support, suspended.ID.String(), http.StatusForbidden, "this account is suspended: spam"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := env.serve(tc.user, tc.actAs, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Error("Expected the request to be rejected")
+			}))
+			testutils.CheckJSONResponseError(t, rec, tc.status, tc.message)
+		})
+	}
+}
+
+func TestRefuse(t *testing.T) {
+	env := newTestEnv()
+	env.start(t, env.target.ID)
+
+	refused := Refuse(slog.New(slog.NewTextHandler(io.Discard, nil)))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := env.serve(env.support, env.target.ID.String(), refused)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "this action cannot be performed while impersonating a user")
+
+	if rec = env.serve(env.target, "", refused); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected requests that are not impersonated to pass, got %d", rec.Code)
+	}
+}
+
+func TestStartImpersonation(t *testing.T) {
+	env := newTestEnv()
+
+	if _, err := env.service.Start(env.support, &Impersonation{TargetID: env.admin.ID}); !errors.Is(err, ErrPrivilegedTarget) {
+		t.Errorf("Expected ErrPrivilegedTarget, got %v", err)
+	}
+	if _, err := env.service.Start(env.support, &Impersonation{TargetID: uuid.New()}); !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("Expected ErrRecordNotFound, got %v", err)
+	}
+	if err := env.service.End(env.admin.ID, env.start(t, env.target.ID).ID); !errors.Is(err, ErrNotActive) {
+		t.Errorf("Expected another actor not to end the impersonation, got %v", err)
+	}
+
+	testCases := map[string]struct {
+		imp   Impersonation
+		field string
+	}{
+		"self":      {Impersonation{TargetID: env.support.ID, Reason: "test", ExpiresAt: time.Now().Add(time.Minute)}, "userId"},
+		"no reason": {Impersonation{TargetID: env.target.ID, ExpiresAt: time.Now().Add(time.Minute)}, "reason"},
+		"past":      {Impersonation{TargetID: env.target.ID, Reason: "test", ExpiresAt: time.Now().Add(-time.Minute)}, "expiresAt"},
+		"too long":  {Impersonation{TargetID: env.target.ID, Reason: "test", ExpiresAt: time.Now().Add(2 * time.Hour)}, "expiresAt"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			v := validator.New()
+			ValidateImpersonation(v, env.support, &tc.imp, time.Hour)
+			if _, ok := v.Errors[tc.field]; !ok {
+				t.Errorf("Expected an error on %s, got %v", tc.field, v.Errors)
+			}
+		})
+	}
+}
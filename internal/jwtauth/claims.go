@@ -0,0 +1,211 @@
+package jwtauth
+
+import (
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"slices"
+	"strings"
+)
+
+// Claims are the claims the API reads from, and writes to, access tokens. Besides the
+// registered claims it models the ones Supabase adds. Every claim in the token, modelled
+// or not, stays available through Get for policies and claim mappings.
+type Claims struct {
+	jwt.RegisteredClaims
+	Email        string         `json:"email,omitempty"`
+	Role         string         `json:"role,omitempty"`
+	SessionID    string         `json:"session_id,omitempty"`
+	AppMetadata  map[string]any `json:"app_metadata,omitempty"`
+	UserMetadata map[string]any `json:"user_metadata,omitempty"`
+	// AAL is the authenticator assurance level, aal1 for one factor and aal2 for two
+	AAL string                 `json:"aal,omitempty"`
+	AMR []AuthenticationMethod `json:"amr,omitempty"`
+	// Extra are claims written to the token besides the modelled ones, which take precedence
+	Extra map[string]any `json:"-"`
+
+	raw map[string]any
+}
+
+// registeredClaimNames are the JSON names of the claims of jwt.RegisteredClaims.
+var registeredClaimNames = []string{"iss", "sub", "aud", "exp", "nbf", "iat", "jti"}
+
+// modelledClaimNames are the JSON names of the other claims Claims has a field for.
+var modelledClaimNames = []string{"email", "role", "session_id", "app_metadata", "user_metadata", "aal", "amr"}
+
+// MarshalJSON encodes the modelled claims along with the Extra ones.
+func (c Claims) MarshalJSON() ([]byte, error) {
+	// claims has the fields of Claims, but not its methods, so encoding it does not recurse
+	type claims Claims
+	data, err := json.Marshal(claims(c))
+	if err != nil || len(c.Extra) == 0 {
+		return data, err
+	}
+
+	var merged map[string]any
+	if err = json.Unmarshal(data, &merged); err != nil {
+		return nil, err
+	}
+	for name, value := range c.Extra {
+		if _, ok := merged[name]; !ok && !slices.Contains(registeredClaimNames, name) && !slices.Contains(modelledClaimNames, name) {
+			merged[name] = value
+		}
+	}
+
+	return json.Marshal(merged)
+}
+
+// Unmodelled returns the claims decoded from the token that Claims has no field for, such as the
+// custom claims of an identity provider. The map is a copy.
+func (c *Claims) Unmodelled() map[string]any {
+	unmodelled := map[string]any{}
+	for name, value := range c.raw {
+		if !slices.Contains(registeredClaimNames, name) && !slices.Contains(modelledClaimNames, name) {
+			unmodelled[name] = value
+		}
+	}
+	return unmodelled
+}
+
+// AuthenticationMethod is an entry of the amr claim. Supabase issues them as objects with the time
+// the method was used, other providers as bare method names (RFC 8176), which have no Timestamp.
+type AuthenticationMethod struct {
+	Method    string `json:"method"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+}
+
+// parseAMR reads an amr claim made of objects, method names or a mix of both.
+func parseAMR(value any) []AuthenticationMethod {
+	entries, _ := value.([]any)
+
+	var methods []AuthenticationMethod
+	for _, entry := range entries {
+		switch e := entry.(type) {
+		case string:
+			methods = append(methods, AuthenticationMethod{Method: e})
+		case map[string]any:
+			method, _ := e["method"].(string)
+			timestamp, _ := e["timestamp"].(float64)
+			methods = append(methods, AuthenticationMethod{Method: method, Timestamp: int64(timestamp)})
+		}
+	}
+
+	return methods
+}
+
+// UnmarshalJSON decodes the typed claims and keeps a copy of every claim by name.
+// Modelled claims with an unexpected type are left empty rather than failing the decode,
+// so that the ValidationPolicy can report which claim is invalid.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	var registered jwt.RegisteredClaims
+	if err := json.Unmarshal(data, &registered); err != nil {
+		return err
+	}
+
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*c = Claims{RegisteredClaims: registered, raw: raw}
+	c.Email, _ = raw["email"].(string)
+	c.Role, _ = raw["role"].(string)
+	c.SessionID, _ = raw["session_id"].(string)
+	c.AppMetadata, _ = raw["app_metadata"].(map[string]any)
+	c.UserMetadata, _ = raw["user_metadata"].(map[string]any)
+	c.AAL, _ = raw["aal"].(string)
+	c.AMR = parseAMR(raw["amr"])
+
+	return nil
+}
+
+// Get returns the claim with the given name as decoded from JSON, and whether it is present.
+// Claims set on a Claims value that was never decoded from a token are not available.
+func (c *Claims) Get(name string) (any, bool) {
+	value, ok := c.raw[name]
+	return value, ok
+}
+
+// Lookup returns the claim at the dotted path, such as app_metadata.roles, and whether it is present.
+// Each segment but the last must name an object claim, or a key of the previous object.
+func (c *Claims) Lookup(path string) (any, bool) {
+	segments := strings.Split(path, ".")
+
+	value, ok := c.Get(segments[0])
+	for _, segment := range segments[1:] {
+		if !ok {
+			return nil, false
+		}
+		object, isObject := value.(map[string]any)
+		if !isObject {
+			return nil, false
+		}
+		value, ok = object[segment]
+	}
+
+	return value, ok
+}
+
+// ClaimType is the JSON type a claim must have to pass validation.
+type ClaimType int
+
+const (
+	ClaimAny ClaimType = iota
+	ClaimString
+	ClaimNumber
+	ClaimBool
+	ClaimObject
+	// ClaimStringOrList accepts a string or a list of strings, like the aud claim.
+	ClaimStringOrList
+)
+
+func (t ClaimType) String() string {
+	switch t {
+	case ClaimString:
+		return "a string"
+	case ClaimNumber:
+		return "a number"
+	case ClaimBool:
+		return "a boolean"
+	case ClaimObject:
+		return "an object"
+	case ClaimStringOrList:
+		return "a string or a list of strings"
+	default:
+		return "any value"
+	}
+}
+
+func (t ClaimType) matches(value any) bool {
+	switch t {
+	case ClaimString:
+		s, ok := value.(string)
+		return ok && s != ""
+	case ClaimNumber:
+		switch value.(type) {
+		case float64, json.Number:
+			return true
+		}
+		return false
+	case ClaimBool:
+		_, ok := value.(bool)
+		return ok
+	case ClaimObject:
+		_, ok := value.(map[string]any)
+		return ok
+	case ClaimStringOrList:
+		switch v := value.(type) {
+		case string:
+			return true
+		case []any:
+			for _, item := range v {
+				if _, ok := item.(string); !ok {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	default:
+		return value != nil
+	}
+}
@@ -11,9 +11,17 @@ var (
 	ErrWrongECDSAFormat     = errors.New("invalid key: expected ES256 key")
 	ErrImproperClaimsFormat = errors.New("claims are not formatted properly")
 	ErrExpiredToken         = errors.New("provided tokens has expired")
+	ErrTokenNotYetValid     = errors.New("provided tokens is not valid yet")
+	ErrIssuedInFuture       = errors.New("provided tokens was issued in the future")
+	ErrTokenTooOld          = errors.New("provided tokens was issued too long ago")
 	ErrInvalidIssuer        = errors.New("unaccepted issuer on tokens")
 	ErrInvalidAudience      = errors.New("invalid tokens audience")
 	ErrEmptySubject         = errors.New("subject of tokens is empty")
+	ErrMissingKeyID         = errors.New("token has no kid header")
+	ErrUnknownKeyID         = errors.New("no key found for the token's kid")
+	ErrEmptyKeySet          = errors.New("key set contains no usable keys")
+	ErrJWKSUnavailable      = errors.New("key set could not be fetched and no fresh keys are cached")
+	ErrNoVerificationKeys   = errors.New("no hmac secret or public keys configured to verify tokens")
 )
 
 type ErrUnexpectedSigningMethod struct {
@@ -23,3 +31,32 @@ type ErrUnexpectedSigningMethod struct {
 func (e ErrUnexpectedSigningMethod) Error() string {
 	return fmt.Sprintf("unexpected signing method %v", e.unexpectedValue)
 }
+
+type ErrUnsupportedKey struct {
+	kty string
+	crv string
+}
+
+func (e ErrUnsupportedKey) Error() string {
+	if e.crv != "" {
+		return fmt.Sprintf("unsupported key type %s with curve %s", e.kty, e.crv)
+	}
+	return fmt.Sprintf("unsupported key type %s", e.kty)
+}
+
+type ErrMissingClaim struct {
+	Claim string
+}
+
+func (e ErrMissingClaim) Error() string {
+	return fmt.Sprintf("required claim %s is missing", e.Claim)
+}
+
+type ErrInvalidClaim struct {
+	Claim    string
+	Expected ClaimType
+}
+
+func (e ErrInvalidClaim) Error() string {
+	return fmt.Sprintf("claim %s must be %s", e.Claim, e.Expected)
+}
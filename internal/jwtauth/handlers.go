@@ -0,0 +1,26 @@
+package jwtauth
+
+import (
+	"go-web-api-starter/internal/apiutils"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// JWKSHandler publishes the public keys of the keyring as a JWK set, to be served at
+// /.well-known/jwks.json. Responses may be cached for a few minutes, so a rotated key
+// must stay published for longer than that before tokens signed with it are seen.
+func JWKSHandler(
+	logger *slog.Logger,
+	keys *Keyring,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := keys.PublicKeys()
+		responseData := apiutils.Envelope{"keys": set.Keys}
+
+		err := apiutils.WriteJson(w, http.StatusOK, responseData, apiutils.GetCachingHeaders(5*time.Minute, time.Minute))
+		if err != nil {
+			logger.Error("JWKSHandler write failed", "error", err)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultJWKSRefreshInterval    = 15 * time.Minute
+	defaultJWKSMinRefreshInterval = 30 * time.Second
+	defaultJWKSMaxStale           = 24 * time.Hour
+)
+
+// KeySource resolves the key that verifies the signature of a parsed, but not yet verified, token.
+// Its Key method has the signature of a jwt.Keyfunc so it can be handed straight to the jwt parser.
+type KeySource interface {
+	Key(token *jwt.Token) (interface{}, error)
+}
+
+type jwksKey struct {
+	alg string
+	key interface{}
+}
+
+// JWKS is a KeySource backed by a remote JSON Web Key Set, such as the one published
+// by Supabase or any other OpenID Connect provider.
+//
+// Keys are selected by the token's "kid" header and cached in memory. The cache is
+// refreshed on a schedule by Run, and on demand when a token references a kid that is
+// not cached, at most once per minimum refresh interval. When a refresh fails the
+// previously fetched keys keep being served until they are older than the refresh
+// interval plus the max stale duration. Past that, verifying a token refreshes the
+// key set first, on the same schedule, and fails if it still cannot be fetched.
+type JWKS struct {
+	url                string
+	client             *http.Client
+	logger             *slog.Logger
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	maxStale           time.Duration
+
+	refreshMu   sync.Mutex
+	mu          sync.RWMutex
+	keys        map[string]jwksKey
+	fetchedAt   time.Time
+	lastAttempt time.Time
+}
+
+type JWKSOption func(*JWKS)
+
+// WithHTTPClient sets the client used to fetch the key set.
+func WithHTTPClient(client *http.Client) JWKSOption {
+	return func(j *JWKS) {
+		j.client = client
+	}
+}
+
+// WithJWKSLogger sets the logger used to report failed scheduled refreshes.
+func WithJWKSLogger(logger *slog.Logger) JWKSOption {
+	return func(j *JWKS) {
+		j.logger = logger
+	}
+}
+
+// WithRefreshInterval sets how often Run refetches the key set.
+func WithRefreshInterval(interval time.Duration) JWKSOption {
+	return func(j *JWKS) {
+		j.refreshInterval = interval
+	}
+}
+
+// WithMinRefreshInterval sets how long to wait between refreshes triggered by unknown kids.
+// This stops tokens with made up kids from turning into a flood of requests to the provider.
+func WithMinRefreshInterval(interval time.Duration) JWKSOption {
+	return func(j *JWKS) {
+		j.minRefreshInterval = interval
+	}
+}
+
+// WithMaxStale sets how long cached keys may outlive a failed refresh.
+func WithMaxStale(maxStale time.Duration) JWKSOption {
+	return func(j *JWKS) {
+		j.maxStale = maxStale
+	}
+}
+
+// NewJWKS creates a JWKS for the key set at the given URL. No keys are fetched until
+// Refresh or Run is called, or a token is verified.
+func NewJWKS(url string, opts ...JWKSOption) *JWKS {
+	j := &JWKS{
+		url:                url,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		logger:             slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		refreshInterval:    defaultJWKSRefreshInterval,
+		minRefreshInterval: defaultJWKSMinRefreshInterval,
+		maxStale:           defaultJWKSMaxStale,
+		keys:               map[string]jwksKey{},
+	}
+
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	return j
+}
+
+// Run refreshes the key set every refresh interval until the context is cancelled.
+// Failed refreshes are logged and the cached keys are kept.
+func (j *JWKS) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.Refresh(ctx); err != nil {
+				j.logger.Error("failed to refresh jwks", "url", j.url, "error", err)
+			}
+		}
+	}
+}
+
+// Refresh fetches the key set and replaces the cached keys. Keys with an unsupported
+// type or curve, or without a kid when the set holds more than one key, are skipped.
+// On error the cached keys are left untouched.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	return j.refresh(ctx)
+}
+
+func (j *JWKS) refresh(ctx context.Context) error {
+	j.mu.Lock()
+	j.lastAttempt = time.Now()
+	j.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := j.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch jwks: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch jwks: unexpected status %d", res.StatusCode)
+	}
+
+	var set JWKSet
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&set)
+	if err != nil {
+		return fmt.Errorf("failed to decode jwks: %w", err)
+	}
+
+	keys := make(map[string]jwksKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if k.Kid == "" && len(set.Keys) > 1 {
+			continue
+		}
+
+		pub, err := k.PublicKey()
+		if err != nil {
+			j.logger.Warn("skipping jwk", "url", j.url, "kid", k.Kid, "error", err)
+			continue
+		}
+
+		keys[k.Kid] = jwksKey{alg: k.Alg, key: pub}
+	}
+
+	if len(keys) == 0 {
+		return ErrEmptyKeySet
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.fetchedAt = time.Now()
+	j.mu.Unlock()
+
+	return nil
+}
+
+// Key implements KeySource. It returns the cached key matching the token's kid, refreshing
+// the key set once if the kid is unknown or the cache is too stale to be used, and checks that
+// the key can verify the token's algorithm.
+func (j *JWKS) Key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	// A cache past the max stale duration has no usable keys, so every kid is looked up again
+	key, ok, err := j.lookup(kid)
+	if !ok {
+		key, ok, err = j.refreshAndLookup(kid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if kid == "" {
+			return nil, ErrMissingKeyID
+		}
+		return nil, ErrUnknownKeyID
+	}
+
+	if key.alg != "" && key.alg != token.Method.Alg() {
+		return nil, ErrUnexpectedSigningMethod{unexpectedValue: token.Method.Alg()}
+	}
+	if !keyMatchesMethod(key.key, token.Method) {
+		return nil, ErrUnexpectedSigningMethod{unexpectedValue: token.Method.Alg()}
+	}
+
+	return key.key, nil
+}
+
+// lookup returns the cached key for the kid. It errors with ErrJWKSUnavailable, and finds no key,
+// once the cache is older than the refresh interval plus the max stale duration.
+func (j *JWKS) lookup(kid string) (jwksKey, bool, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	if j.fetchedAt.IsZero() {
+		return jwksKey{}, false, nil
+	}
+	if time.Since(j.fetchedAt) > j.refreshInterval+j.maxStale {
+		return jwksKey{}, false, ErrJWKSUnavailable
+	}
+
+	key, ok := j.keys[kid]
+	return key, ok, nil
+}
+
+func (j *JWKS) refreshAndLookup(kid string) (jwksKey, bool, error) {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	// Another request may have refreshed the keys while this one waited for the lock
+	key, ok, err := j.lookup(kid)
+	if ok {
+		return key, ok, err
+	}
+
+	j.mu.RLock()
+	recentlyAttempted := !j.lastAttempt.IsZero() && time.Since(j.lastAttempt) < j.minRefreshInterval
+	j.mu.RUnlock()
+
+	if !recentlyAttempted {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if refreshErr := j.refresh(ctx); refreshErr != nil {
+			j.logger.Error("failed to refresh jwks for unknown kid", "url", j.url, "kid", kid, "error", refreshErr)
+		}
+	}
+
+	key, ok, err = j.lookup(kid)
+	if err == nil && !ok && j.neverFetched() {
+		return jwksKey{}, false, ErrJWKSUnavailable
+	}
+
+	return key, ok, err
+}
+
+func (j *JWKS) neverFetched() bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return j.fetchedAt.IsZero()
+}
+
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return method == jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		return method == jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		return method == jwt.SigningMethodEdDSA
+	default:
+		return false
+	}
+}
@@ -0,0 +1,353 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//region helpers
+
+type testKey struct {
+	kid    string
+	method jwt.SigningMethod
+	signer interface{}
+	jwk    JWK
+}
+
+func newRSATestKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{
+		kid:    kid,
+		method: jwt.SigningMethodRS256,
+		signer: key,
+		jwk: JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Alg: "RS256",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		},
+	}
+}
+
+func newECTestKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{
+		kid:    kid,
+		method: jwt.SigningMethodES256,
+		signer: key,
+		jwk: JWK{
+			Kty: "EC",
+			Kid: kid,
+			Alg: "ES256",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		},
+	}
+}
+
+func newEdDSATestKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{
+		kid:    kid,
+		method: jwt.SigningMethodEdDSA,
+		signer: priv,
+		jwk: JWK{
+			Kty: "OKP",
+			Kid: kid,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		},
+	}
+}
+
+func (k testKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method, claims)
+	token.Header["kid"] = k.kid
+
+	s, err := token.SignedString(k.signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// jwksServer serves whatever keys are currently set, or a 500 when failing is set.
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []JWK
+	failing  bool
+	requests atomic.Int32
+}
+
+func newJWKSServer(t *testing.T, keys ...testKey) *jwksServer {
+	t.Helper()
+	s := &jwksServer{}
+	s.setKeys(keys...)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.failing {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(JWKSet{Keys: s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...testKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	for _, k := range keys {
+		s.keys = append(s.keys, k.jwk)
+	}
+}
+
+func (s *jwksServer) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "3f1c2f4e-8f7a-4d7e-9a51-2c4b1d0e6a77",
+		"iss":   "https://idp.example.com",
+		"email": "test@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+//endregion
+
+func TestJWKSReaderAcceptsSupportedAlgorithms(t *testing.T) {
+	keys := []testKey{
+		newRSATestKey(t, "rsa-1"),
+		newECTestKey(t, "ec-1"),
+		newEdDSATestKey(t, "ed-1"),
+	}
+	server := newJWKSServer(t, keys...)
+	reader := NewJWKSReader(NewJWKS(server.URL), "https://idp.example.com")
+
+	for _, k := range keys {
+		t.Run(k.method.Alg(), func(t *testing.T) {
+			claims, err := reader.Read(k.sign(t, validClaims()))
+			if err != nil {
+				t.Fatalf("Expected token to be read, got error %v", err)
+			}
+			if err = reader.ValidateClaims(claims); err != nil {
+				t.Errorf("Expected claims to be valid, got error %v", err)
+			}
+		})
+	}
+}
+
+func TestJWKSReaderRejectsHMAC(t *testing.T) {
+	server := newJWKSServer(t, newECTestKey(t, "ec-1"))
+	reader := NewJWKSReader(NewJWKS(server.URL), "https://idp.example.com")
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	token.Header["kid"] = "ec-1"
+	tokenString, _ := token.SignedString([]byte("secret"))
+
+	if _, err := reader.Read(tokenString); err == nil {
+		t.Fatal("Expected HS256 token to be rejected")
+	}
+}
+
+func TestJWKSRejectsKeyUsedWithWrongAlgorithm(t *testing.T) {
+	ec := newECTestKey(t, "ec-1")
+	rsaKey := newRSATestKey(t, "rsa-1")
+	// Serve the RSA key under the EC key's kid
+	rsaKey.jwk.Kid = "ec-1"
+	server := newJWKSServer(t, rsaKey)
+	reader := NewJWKSReader(NewJWKS(server.URL), "https://idp.example.com")
+
+	_, err := reader.Read(ec.sign(t, validClaims()))
+	var methodErr ErrUnexpectedSigningMethod
+	if !errors.As(err, &methodErr) {
+		t.Fatalf("Expected ErrUnexpectedSigningMethod, got %v", err)
+	}
+}
+
+func TestJWKSRefreshesOnUnknownKid(t *testing.T) {
+	oldKey := newECTestKey(t, "old")
+	newKey := newECTestKey(t, "new")
+	server := newJWKSServer(t, oldKey)
+	jwks := NewJWKS(server.URL, WithMinRefreshInterval(0))
+	reader := NewJWKSReader(jwks, "https://idp.example.com")
+
+	if _, err := reader.Read(oldKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("Expected old key to verify, got %v", err)
+	}
+
+	server.setKeys(oldKey, newKey)
+	if _, err := reader.Read(newKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("Expected rotated key to be fetched and verify, got %v", err)
+	}
+	if got := server.requests.Load(); got != 2 {
+		t.Errorf("Expected 2 jwks requests, got %d", got)
+	}
+}
+
+func TestJWKSUnknownKidRefreshIsRateLimited(t *testing.T) {
+	known := newECTestKey(t, "known")
+	unknown := newECTestKey(t, "unknown")
+	server := newJWKSServer(t, known)
+	jwks := NewJWKS(server.URL, WithMinRefreshInterval(time.Hour))
+	reader := NewJWKSReader(jwks, "https://idp.example.com")
+
+	if _, err := reader.Read(known.sign(t, validClaims())); err != nil {
+		t.Fatalf("Expected known key to verify, got %v", err)
+	}
+
+	for range 5 {
+		_, err := reader.Read(unknown.sign(t, validClaims()))
+		if !errors.Is(err, ErrUnknownKeyID) {
+			t.Fatalf("Expected ErrUnknownKeyID, got %v", err)
+		}
+	}
+	if got := server.requests.Load(); got != 1 {
+		t.Errorf("Expected unknown kids not to trigger refreshes within the min interval, got %d requests", got)
+	}
+}
+
+func TestJWKSServesStaleKeysOnError(t *testing.T) {
+	key := newECTestKey(t, "ec-1")
+	server := newJWKSServer(t, key)
+	jwks := NewJWKS(server.URL, WithRefreshInterval(time.Millisecond), WithMaxStale(time.Hour))
+	reader := NewJWKSReader(jwks, "https://idp.example.com")
+
+	if err := jwks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server.setFailing(true)
+	if err := jwks.Refresh(context.Background()); err == nil {
+		t.Fatal("Expected refresh against a failing server to error")
+	}
+
+	if _, err := reader.Read(key.sign(t, validClaims())); err != nil {
+		t.Fatalf("Expected cached key to verify while the server fails, got %v", err)
+	}
+}
+
+func TestJWKSExpiresStaleKeys(t *testing.T) {
+	key := newECTestKey(t, "ec-1")
+	server := newJWKSServer(t, key)
+	jwks := NewJWKS(server.URL, WithRefreshInterval(time.Millisecond), WithMaxStale(time.Millisecond))
+	reader := NewJWKSReader(jwks, "https://idp.example.com")
+
+	if err := jwks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	server.setFailing(true)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := reader.Read(key.sign(t, validClaims())); !errors.Is(err, ErrJWKSUnavailable) {
+		t.Fatalf("Expected ErrJWKSUnavailable once keys are too stale, got %v", err)
+	}
+}
+
+func TestJWKSRefreshesStaleKeys(t *testing.T) {
+	oldKey := newECTestKey(t, "old")
+	newKey := newECTestKey(t, "new")
+	server := newJWKSServer(t, oldKey)
+	jwks := NewJWKS(server.URL, WithRefreshInterval(time.Millisecond), WithMaxStale(time.Millisecond), WithMinRefreshInterval(0))
+	reader := NewJWKSReader(jwks, "https://idp.example.com")
+
+	if err := jwks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without Run refreshing the cache, the known kid must not keep failing once it is too stale
+	server.setKeys(newKey)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := reader.Read(newKey.sign(t, validClaims())); err != nil {
+		t.Fatalf("Expected the stale cache to be refreshed, got %v", err)
+	}
+	if got := server.requests.Load(); got != 2 {
+		t.Errorf("Expected 2 jwks requests, got %d", got)
+	}
+
+	// The refreshed key set replaces the stale one, so the removed key no longer verifies
+	time.Sleep(5 * time.Millisecond)
+	if _, err := reader.Read(oldKey.sign(t, validClaims())); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Expected the removed key to be unknown after a refresh, got %v", err)
+	}
+}
+
+func TestJWKSStaleRefreshIsRateLimited(t *testing.T) {
+	key := newECTestKey(t, "ec-1")
+	server := newJWKSServer(t, key)
+	jwks := NewJWKS(server.URL, WithRefreshInterval(time.Millisecond), WithMaxStale(time.Millisecond), WithMinRefreshInterval(time.Hour))
+	reader := NewJWKSReader(jwks, "https://idp.example.com")
+
+	if err := jwks.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	for range 5 {
+		if _, err := reader.Read(key.sign(t, validClaims())); !errors.Is(err, ErrJWKSUnavailable) {
+			t.Fatalf("Expected ErrJWKSUnavailable within the min refresh interval, got %v", err)
+		}
+	}
+	if got := server.requests.Load(); got != 1 {
+		t.Errorf("Expected stale lookups not to trigger refreshes within the min interval, got %d requests", got)
+	}
+}
+
+func TestJWKSUnavailableBeforeFirstFetch(t *testing.T) {
+	key := newECTestKey(t, "ec-1")
+	server := newJWKSServer(t, key)
+	server.setFailing(true)
+	reader := NewJWKSReader(NewJWKS(server.URL), "https://idp.example.com")
+
+	if _, err := reader.Read(key.sign(t, validClaims())); !errors.Is(err, ErrJWKSUnavailable) {
+		t.Fatalf("Expected ErrJWKSUnavailable, got %v", err)
+	}
+}
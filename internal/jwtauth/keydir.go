@@ -0,0 +1,180 @@
+package jwtauth
+
+import (
+	"cmp"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// keyDirPublishDelay is how long a key written to a key directory is published before it signs
+// tokens, so that every instance sharing the directory reloads it before it meets its tokens.
+// Keyring.Run reloads the directory at least this often.
+const keyDirPublishDelay = time.Minute
+
+const keyFileExtension = ".key"
+
+// KeyringFromDir builds a Keyring from a directory of files each holding a base64 encoded PEM key,
+// the same format NewSigner accepts. The keys rotated to with Rotate are written to the directory,
+// so that they survive restarts and are shared by the instances that use the same directory.
+//
+// The key written last signs tokens once it has been in the directory for a minute, and is only
+// published until then. The keys it replaced are retiring, and are removed from the directory by
+// Prune once they have been retired for longer than the retention period.
+// An empty directory is given a new key.
+func KeyringFromDir(dir string, opts ...KeyringOption) (*Keyring, error) {
+	k := &Keyring{retention: defaultRetention, dir: dir}
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	if k.active != nil {
+		return k, nil
+	}
+
+	// The first key signs right away: no instance has published another one
+	if _, err := writeKeyFile(dir); err != nil {
+		return nil, err
+	}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Reload replaces the keys of a Keyring built with KeyringFromDir with the ones in its directory,
+// which other instances may have rotated to. It does nothing for other keyrings.
+func (k *Keyring) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+
+	keys, err := readKeyDir(k.dir)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	// The newest key that has been published for long enough is active, unless none has been,
+	// such as when the directory was just given its first keys
+	now := time.Now()
+	active := len(keys) - 1
+	for active > 0 && now.Sub(keys[active].AddedAt) < keyDirPublishDelay {
+		active--
+	}
+
+	for i := range active {
+		keys[i].RetiredAt = keys[i+1].AddedAt.Add(keyDirPublishDelay)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.retiring = keys[:active]
+	k.active = keys[active]
+	k.next = keys[active+1:]
+
+	return nil
+}
+
+// readKeyDir returns the keys in the directory from the oldest to the newest.
+// They were added when their file was written.
+func readKeyDir(dir string) ([]*SigningKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key directory: %w", err)
+	}
+
+	var keys []*SigningKey
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != keyFileExtension {
+			continue
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			// Pruned by another instance
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signing key directory: %w", err)
+		}
+
+		key, err := readSigningKeyFile(filepath.Join(dir, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		signingKey, err := newSigningKey(key, info.ModTime())
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, signingKey)
+	}
+
+	slices.SortFunc(keys, func(a, b *SigningKey) int {
+		return cmp.Or(a.AddedAt.Compare(b.AddedAt), strings.Compare(a.ID, b.ID))
+	})
+
+	return keys, nil
+}
+
+// writeKeyFile generates a new P-256 key and writes it to the directory, in a file named after its ID.
+// The file is renamed into place once written, so that other instances never read part of a key.
+func writeKeyFile(dir string) (*SigningKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+
+	file, err := os.CreateTemp(dir, ".signing-key-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.WriteString(encoded + "\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+
+	signingKey, err := newSigningKey(key, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Rename(file.Name(), keyFilePath(dir, signingKey.ID)); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+
+	return signingKey, nil
+}
+
+func keyFilePath(dir string, id string) string {
+	return filepath.Join(dir, id+keyFileExtension)
+}
@@ -0,0 +1,129 @@
+package jwtauth
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+//region helpers
+
+func newTestDirKeyring(t *testing.T, dir string) *Keyring {
+	t.Helper()
+	keys, err := KeyringFromDir(dir, WithRetention(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// backdate sets when the key was written to the directory, as if it had been written the duration ago.
+func backdate(t *testing.T, dir string, kid string, ago time.Duration) {
+	t.Helper()
+	at := time.Now().Add(-ago)
+	if err := os.Chtimes(keyFilePath(dir, kid), at, at); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signWith(t *testing.T, keys *Keyring) string {
+	t.Helper()
+	token, err := NewKeyringSigner(keys, "https://api.example.com", nil).GenerateJWT(testClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+//endregion
+
+func TestKeyringFromDirGeneratesFirstKey(t *testing.T) {
+	dir := t.TempDir()
+
+	keys := newTestDirKeyring(t, dir)
+	if _, err := os.Stat(keyFilePath(dir, keys.Active().ID)); err != nil {
+		t.Fatalf("Expected the active key to be written to the directory: %v", err)
+	}
+
+	restarted := newTestDirKeyring(t, dir)
+	if restarted.Active().ID != keys.Active().ID {
+		t.Error("Expected the key to be loaded again from the directory")
+	}
+}
+
+func TestKeyringFromDirSharesRotatedKeys(t *testing.T) {
+	dir := t.TempDir()
+	first := newTestDirKeyring(t, dir)
+	second := newTestDirKeyring(t, dir)
+	previous := first.Active()
+	backdate(t, dir, previous.ID, 2*keyDirPublishDelay)
+
+	next, err := first.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Active().ID != previous.ID {
+		t.Error("Expected the rotated key not to sign before it has been published")
+	}
+	if err = second.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if kids := publishedKids(second); !slices.Contains(kids, next.ID) {
+		t.Errorf("Expected the other instance to publish the rotated key, got %v", kids)
+	}
+
+	backdate(t, dir, next.ID, keyDirPublishDelay)
+	for _, keys := range []*Keyring{first, second} {
+		if err = keys.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if keys.Active().ID != next.ID {
+			t.Errorf("Expected the rotated key to sign once published, got %s", keys.Active().ID)
+		}
+	}
+
+	if _, err = verify(second, signWith(t, first)); err != nil {
+		t.Errorf("Expected the other instance to verify tokens of the rotated key: %v", err)
+	}
+	previousKeys, err := NewKeyring(previous.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = verify(first, signWith(t, previousKeys)); err != nil {
+		t.Errorf("Expected the previous key to keep verifying: %v", err)
+	}
+
+	restarted := newTestDirKeyring(t, dir)
+	if restarted.Active().ID != next.ID {
+		t.Error("Expected the rotated key to survive a restart")
+	}
+}
+
+func TestKeyringFromDirPrune(t *testing.T) {
+	dir := t.TempDir()
+	keys := newTestDirKeyring(t, dir)
+	previous := keys.Active()
+
+	next, err := keys.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The previous key was retired once the next one had been published, over the retention period ago
+	backdate(t, dir, previous.ID, 3*time.Hour)
+	backdate(t, dir, next.ID, 2*time.Hour)
+	if err = keys.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if err = keys.Prune(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(keyFilePath(dir, previous.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired key file to be deleted, got %v", err)
+	}
+	if kids := publishedKids(keys); !slices.Equal(kids, []string{next.ID}) {
+		t.Errorf("Expected only the active key to be published, got %v", kids)
+	}
+}
@@ -0,0 +1,327 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRetention = 24 * time.Hour
+
+// SigningKey is an ES256 private key in a Keyring. Its ID is the RFC 7638 thumbprint
+// of the public key and is written to the "kid" header of every token it signs.
+type SigningKey struct {
+	ID         string
+	PrivateKey *ecdsa.PrivateKey
+	AddedAt    time.Time
+	RetiredAt  time.Time
+}
+
+// Keyring holds the active ES256 signing key along with the keys it replaced.
+// Only the active key signs new tokens. Retiring keys keep being published, and keep
+// verifying tokens, until they have been retired for longer than the retention period,
+// which should exceed the lifetime of the tokens they signed.
+type Keyring struct {
+	mu       sync.RWMutex
+	active   *SigningKey
+	retiring []*SigningKey
+	// next are keys of a key directory that are published, but do not sign tokens yet
+	next      []*SigningKey
+	retention time.Duration
+	// dir is the key directory of a Keyring built with KeyringFromDir
+	dir string
+}
+
+type KeyringOption func(*Keyring)
+
+// WithRetention sets how long retired keys keep being published after a rotation.
+func WithRetention(retention time.Duration) KeyringOption {
+	return func(k *Keyring) {
+		k.retention = retention
+	}
+}
+
+// NewKeyring creates a Keyring that signs with the active key and still publishes the retiring keys.
+// Returns ErrWrongECDSAFormat if any key is not on the P-256 curve.
+func NewKeyring(active *ecdsa.PrivateKey, retiring []*ecdsa.PrivateKey, opts ...KeyringOption) (*Keyring, error) {
+	k := &Keyring{retention: defaultRetention}
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	now := time.Now()
+
+	activeKey, err := newSigningKey(active, now)
+	if err != nil {
+		return nil, err
+	}
+	k.active = activeKey
+
+	for _, key := range retiring {
+		retiringKey, err := newSigningKey(key, now)
+		if err != nil {
+			return nil, err
+		}
+		retiringKey.RetiredAt = now
+		k.retiring = append(k.retiring, retiringKey)
+	}
+
+	return k, nil
+}
+
+// KeyringFromEnv builds a Keyring from environment variables holding base64 encoded PEM
+// keys, the same format NewSigner accepts. activeKey names the variable with the active key
+// and retiringKey names an optional variable with a comma separated list of retiring keys.
+func KeyringFromEnv(getEnv func(string) string, activeKey string, retiringKey string, opts ...KeyringOption) (*Keyring, error) {
+	active, err := ParseSigningKey(getEnv(activeKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", activeKey, err)
+	}
+
+	var retiring []*ecdsa.PrivateKey
+	for _, encoded := range strings.Split(getEnv(retiringKey), ",") {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+
+		key, err := ParseSigningKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", retiringKey, err)
+		}
+		retiring = append(retiring, key)
+	}
+
+	return NewKeyring(active, retiring, opts...)
+}
+
+// KeyringFromFiles builds a Keyring from files each holding a base64 encoded PEM key,
+// the same format NewSigner accepts.
+func KeyringFromFiles(activePath string, retiringPaths []string, opts ...KeyringOption) (*Keyring, error) {
+	active, err := readSigningKeyFile(activePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var retiring []*ecdsa.PrivateKey
+	for _, path := range retiringPaths {
+		key, err := readSigningKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		retiring = append(retiring, key)
+	}
+
+	return NewKeyring(active, retiring, opts...)
+}
+
+func readSigningKeyFile(path string) (*ecdsa.PrivateKey, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key file: %w", err)
+	}
+
+	key, err := ParseSigningKey(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key file %s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+func newSigningKey(key *ecdsa.PrivateKey, addedAt time.Time) (*SigningKey, error) {
+	if key == nil || key.Curve != elliptic.P256() {
+		return nil, ErrWrongECDSAFormat
+	}
+
+	return &SigningKey{ID: Thumbprint(&key.PublicKey), PrivateKey: key, AddedAt: addedAt}, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (k *Keyring) Active() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return k.active
+}
+
+// Rotate generates a new P-256 key, makes it the active key and retires the previous one.
+// A Keyring built with KeyringFromDir writes the key to its directory instead, and it becomes
+// the active key once it has been published for long enough, see KeyringFromDir.
+func (k *Keyring) Rotate() (*SigningKey, error) {
+	if k.dir != "" {
+		key, err := writeKeyFile(k.dir)
+		if err != nil {
+			return nil, err
+		}
+		return key, k.Reload()
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.RotateTo(key)
+}
+
+// RotateTo makes the provided key the active key and retires the previous one.
+// Rotating to a key that is already retiring reinstates it.
+func (k *Keyring) RotateTo(key *ecdsa.PrivateKey) (*SigningKey, error) {
+	now := time.Now()
+	next, err := newSigningKey(key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if next.ID == k.active.ID {
+		return k.active, nil
+	}
+
+	k.retiring = slices.DeleteFunc(k.retiring, func(s *SigningKey) bool { return s.ID == next.ID })
+
+	previous := *k.active
+	previous.RetiredAt = now
+	k.retiring = append(k.retiring, &previous)
+	k.active = next
+
+	return next, nil
+}
+
+// Prune removes retiring keys that have been retired for longer than the retention period,
+// and deletes their files from the directory of a Keyring built with KeyringFromDir.
+func (k *Keyring) Prune() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var err error
+	k.retiring = slices.DeleteFunc(k.retiring, func(s *SigningKey) bool {
+		if time.Since(s.RetiredAt) <= k.retention {
+			return false
+		}
+		if k.dir != "" {
+			// Another instance sharing the directory may have deleted it first
+			if removeErr := os.Remove(keyFilePath(k.dir, s.ID)); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+				err = errors.Join(err, fmt.Errorf("failed to delete signing key: %w", removeErr))
+			}
+		}
+		return true
+	})
+
+	return err
+}
+
+// Run rotates the active key every rotation interval and prunes expired retiring keys
+// until the context is cancelled.
+//
+// Keys generated by Run only exist in this process, unless the Keyring was built with
+// KeyringFromDir. Run then reloads the directory, to use the keys other instances rotated to,
+// and only rotates once the newest key in it is older than the rotation interval.
+func (k *Keyring) Run(ctx context.Context, logger *slog.Logger, rotationInterval time.Duration) {
+	tick := rotationInterval
+	if k.dir != "" {
+		tick = min(rotationInterval, keyDirPublishDelay)
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Reload(); err != nil {
+				logger.Error("failed to reload signing keys", "error", err)
+			}
+			if err := k.Prune(); err != nil {
+				logger.Error("failed to prune signing keys", "error", err)
+			}
+
+			if k.dir != "" && time.Since(k.newest().AddedAt) < rotationInterval {
+				continue
+			}
+
+			key, err := k.Rotate()
+			if err != nil {
+				logger.Error("failed to rotate signing key", "error", err)
+				continue
+			}
+			logger.Info("rotated signing key", "kid", key.ID)
+		}
+	}
+}
+
+// newest returns the key added last, which may not sign tokens yet.
+func (k *Keyring) newest() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if len(k.next) > 0 {
+		return k.next[len(k.next)-1]
+	}
+	return k.active
+}
+
+// PublicKeys returns the public half of the active, next and retiring keys as a JWK set.
+// Retiring keys past the retention period are left out even if they have not been pruned yet.
+func (k *Keyring) PublicKeys() JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{NewECJWK(k.active.ID, &k.active.PrivateKey.PublicKey)}}
+	for _, key := range k.next {
+		set.Keys = append(set.Keys, NewECJWK(key.ID, &key.PrivateKey.PublicKey))
+	}
+	for _, key := range k.retiring {
+		if time.Since(key.RetiredAt) > k.retention {
+			continue
+		}
+		set.Keys = append(set.Keys, NewECJWK(key.ID, &key.PrivateKey.PublicKey))
+	}
+
+	return set
+}
+
+// Key implements KeySource so tokens signed by the keyring can be verified with it.
+// Tokens without a kid are checked against the active key.
+func (k *Keyring) Key(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodES256 {
+		return nil, ErrUnexpectedSigningMethod{unexpectedValue: token.Header["alg"]}
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if kid == "" || kid == k.active.ID {
+		return &k.active.PrivateKey.PublicKey, nil
+	}
+
+	for _, key := range k.next {
+		if key.ID == kid {
+			return &key.PrivateKey.PublicKey, nil
+		}
+	}
+
+	for _, key := range k.retiring {
+		if key.ID == kid && time.Since(key.RetiredAt) <= k.retention {
+			return &key.PrivateKey.PublicKey, nil
+		}
+	}
+
+	return nil, ErrUnknownKeyID
+}
@@ -0,0 +1,115 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// StaticKeys is a KeySource of fixed ES256 public keys, for verifying tokens signed by another
+// service whose public keys are distributed as PEM. Keys are selected by their RFC 7638 thumbprint.
+type StaticKeys struct {
+	keys map[string]*ecdsa.PublicKey
+}
+
+// NewStaticKeys creates a StaticKeys from P-256 public keys.
+// Returns ErrWrongECDSAFormat if a key is on another curve.
+func NewStaticKeys(keys ...*ecdsa.PublicKey) (*StaticKeys, error) {
+	s := &StaticKeys{keys: make(map[string]*ecdsa.PublicKey, len(keys))}
+	for _, key := range keys {
+		if key == nil || key.Curve != elliptic.P256() {
+			return nil, ErrWrongECDSAFormat
+		}
+		s.keys[Thumbprint(key)] = key
+	}
+
+	return s, nil
+}
+
+// Key implements KeySource. Tokens without a kid are accepted when exactly one key is configured.
+func (s *StaticKeys) Key(token *jwt.Token) (interface{}, error) {
+	if token.Method != jwt.SigningMethodES256 {
+		return nil, ErrUnexpectedSigningMethod{unexpectedValue: token.Header["alg"]}
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if len(s.keys) == 1 {
+			for _, key := range s.keys {
+				return key, nil
+			}
+		}
+		return nil, ErrMissingKeyID
+	}
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	return key, nil
+}
+
+// ParseVerificationKey decodes a base64 encoded PEM string holding an ES256 public key
+// ("PUBLIC KEY" block) or private key ("EC PRIVATE KEY" block), and returns the public key.
+// Returns ErrNotECDSA if the key is not ECDSA, or ErrWrongECDSAFormat if it is not on elliptic.P256.
+func ParseVerificationKey(base64String string) (*ecdsa.PublicKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(base64String)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(decoded)
+	if block == nil {
+		return nil, ErrPemParse
+	}
+
+	var pub *ecdsa.PublicKey
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		priv, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pub = &priv.PublicKey
+	default:
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		pub, ok = parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, ErrNotECDSA
+		}
+	}
+
+	if pub.Curve != elliptic.P256() {
+		return nil, ErrWrongECDSAFormat
+	}
+
+	return pub, nil
+}
+
+// KeySources tries each KeySource in order and returns the first key found,
+// so a Reader can accept tokens from several issuers' keys at once.
+type KeySources []KeySource
+
+// Key implements KeySource. When no source has a key, the error from the last source is returned.
+func (sources KeySources) Key(token *jwt.Token) (interface{}, error) {
+	err := error(ErrUnknownKeyID)
+	for _, source := range sources {
+		var key interface{}
+		key, err = source.Key(token)
+		if err == nil {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no key source could verify the token: %w", err)
+}
@@ -0,0 +1,109 @@
+package jwtauth
+
+import (
+	"maps"
+	"slices"
+	"time"
+)
+
+// ValidationPolicy controls which tokens Reader.ValidateClaims accepts.
+// The exp claim and a non-empty sub claim are always required.
+type ValidationPolicy struct {
+	// Issuers are the accepted iss values. When empty the reader's Settings.Issuer is the only one accepted.
+	Issuers []string
+	// Audiences are the accepted aud values, of which the token must have at least one.
+	// When empty the reader's Settings.Audience is used, and if that is empty too aud is not checked.
+	Audiences []string
+	// Leeway is the clock skew tolerated when checking exp, nbf, iat and MaxAge.
+	Leeway time.Duration
+	// MaxAge rejects tokens issued longer ago than this, even if they have not expired.
+	// Setting it makes the iat claim required. Zero disables the check.
+	MaxAge time.Duration
+	// RequiredClaims must be present on the token with the given type.
+	RequiredClaims map[string]ClaimType
+	// OptionalClaims may be missing, but must have the given type when present.
+	OptionalClaims map[string]ClaimType
+}
+
+// DefaultPolicy accepts tokens with a string email claim, when they carry one, and allows
+// thirty seconds of clock skew between the token issuer and this server.
+func DefaultPolicy() ValidationPolicy {
+	return ValidationPolicy{
+		Leeway: 30 * time.Second,
+		OptionalClaims: map[string]ClaimType{
+			"email": ClaimString,
+		},
+	}
+}
+
+// Validate checks the claims against the policy at the given time. The settings supply the
+// accepted issuer and audience when the policy does not list its own.
+//
+// Returns ErrMissingClaim or ErrInvalidClaim for claims that are missing or mistyped,
+// ErrExpiredToken, ErrTokenNotYetValid, ErrIssuedInFuture or ErrTokenTooOld for tokens
+// outside their validity window, ErrInvalidIssuer, ErrInvalidAudience, or ErrEmptySubject.
+func (p ValidationPolicy) Validate(claims *Claims, settings Settings, now time.Time) error {
+	required := map[string]ClaimType{"exp": ClaimNumber}
+	maps.Copy(required, p.RequiredClaims)
+	if p.MaxAge > 0 {
+		required["iat"] = ClaimNumber
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(required)) {
+		value, ok := claims.Get(name)
+		if !ok {
+			return ErrMissingClaim{Claim: name}
+		}
+		if !required[name].matches(value) {
+			return ErrInvalidClaim{Claim: name, Expected: required[name]}
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(p.OptionalClaims)) {
+		value, ok := claims.Get(name)
+		if ok && !p.OptionalClaims[name].matches(value) {
+			return ErrInvalidClaim{Claim: name, Expected: p.OptionalClaims[name]}
+		}
+	}
+
+	if now.After(claims.ExpiresAt.Add(p.Leeway)) {
+		return ErrExpiredToken
+	}
+
+	if claims.NotBefore != nil && now.Add(p.Leeway).Before(claims.NotBefore.Time) {
+		return ErrTokenNotYetValid
+	}
+
+	if claims.IssuedAt != nil {
+		if now.Add(p.Leeway).Before(claims.IssuedAt.Time) {
+			return ErrIssuedInFuture
+		}
+		if p.MaxAge > 0 && now.Sub(claims.IssuedAt.Time) > p.MaxAge+p.Leeway {
+			return ErrTokenTooOld
+		}
+	}
+
+	issuers := p.Issuers
+	if len(issuers) == 0 {
+		issuers = []string{settings.Issuer}
+	}
+	if !slices.Contains(issuers, claims.Issuer) {
+		return ErrInvalidIssuer
+	}
+
+	audiences := p.Audiences
+	if len(audiences) == 0 {
+		audiences = settings.Audience
+	}
+	if len(audiences) > 0 && !slices.ContainsFunc(claims.Audience, func(aud string) bool {
+		return slices.Contains(audiences, aud)
+	}) {
+		return ErrInvalidAudience
+	}
+
+	if claims.Subject == "" {
+		return ErrEmptySubject
+	}
+
+	return nil
+}
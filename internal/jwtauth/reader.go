@@ -1,16 +1,20 @@
 package jwtauth
 
 import (
-	"errors"
+	"crypto/ecdsa"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"time"
 )
 
 // Reader holds the necessary components to validate JWT tokens.
+// HMAC signed tokens are verified with HmacSecret and RS256, ES256 and EdDSA
+// signed tokens with Keys. Either may be left empty to reject that kind of token.
 type Reader struct {
+	Settings
 	HmacSecret []byte
-	Issuer     string
+	Keys       KeySource
+	Policy     ValidationPolicy
 }
 
 // NewReader constructs a new Reader with the provided HMAC secret and issuer.
@@ -18,70 +22,139 @@ type Reader struct {
 // Requires the HMAC secret and issuer as string parameters.
 // Returns a pointer to the new Reader and an error. If the construction is successful, the error is nil.
 func NewReader(hmacSecret string, issuer string) (*Reader, error) {
-	return &Reader{HmacSecret: []byte(hmacSecret), Issuer: issuer}, nil
+	return &Reader{HmacSecret: []byte(hmacSecret), Settings: Settings{Issuer: issuer}, Policy: DefaultPolicy()}, nil
 }
 
-// Read accepts a JWT token string, parses it, validates the signing method,
-// and extracts its Claims as jwt.MapClaims. If the token's signing method is
-// different from jwt.SigningMethodHMAC, ErrUnexpectedSigningMethod error is
-// returned. If the claims inside the token cannot be formatted properly as
-// jwt.MapClaims, ErrImproperClaimsFormat error is returned.
-// It is recommended to validate the returned claims via reader's ValidateClaims method.
-func (reader *Reader) Read(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrUnexpectedSigningMethod{unexpectedValue: token.Header["alg"]}
+// NewJWKSReader constructs a new Reader that verifies RS256, ES256 and EdDSA tokens
+// with keys from the provided JWKS, and validates them against the issuer.
+func NewJWKSReader(jwks *JWKS, issuer string) *Reader {
+	return &Reader{Keys: jwks, Settings: Settings{Issuer: issuer}, Policy: DefaultPolicy()}
+}
+
+// ReaderConfig selects the keys a Reader verifies tokens with. Any combination may be set;
+// at least one is required.
+type ReaderConfig struct {
+	Settings
+	// HmacSecret verifies HMAC signed tokens, such as those issued by Supabase's legacy JWT secret.
+	HmacSecret string
+	// PublicKeys are base64 encoded PEM ES256 public keys, see ParseVerificationKey.
+	PublicKeys []string
+	// Signer verifies tokens signed by any key of the signer's keyring.
+	Signer *Signer
+	// JWKS verifies tokens signed by keys published at a JWKS URL.
+	JWKS *JWKS
+	// Policy controls claim validation. DefaultPolicy is used when nil.
+	Policy *ValidationPolicy
+}
+
+// NewReaderFromConfig constructs a new Reader that verifies tokens with every key source set on the config.
+// Returns ErrNoVerificationKeys if none is set, or an error if a public key cannot be parsed.
+func NewReaderFromConfig(cfg ReaderConfig) (*Reader, error) {
+	reader := &Reader{Settings: cfg.Settings, HmacSecret: []byte(cfg.HmacSecret), Policy: DefaultPolicy()}
+	if cfg.Policy != nil {
+		reader.Policy = *cfg.Policy
+	}
+
+	var sources KeySources
+	if cfg.Signer != nil {
+		sources = append(sources, cfg.Signer.Keys)
+	}
+
+	if len(cfg.PublicKeys) > 0 {
+		var keys []*ecdsa.PublicKey
+		for _, encoded := range cfg.PublicKeys {
+			key, err := ParseVerificationKey(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid public key: %w", err)
+			}
+			keys = append(keys, key)
 		}
 
-		return reader.HmacSecret, nil
-	})
-	if err != nil {
-		return nil, err
+		static, err := NewStaticKeys(keys...)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, static)
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, ErrImproperClaimsFormat
+	if cfg.JWKS != nil {
+		sources = append(sources, cfg.JWKS)
 	}
 
-	return claims, nil
+	switch len(sources) {
+	case 0:
+	case 1:
+		reader.Keys = sources[0]
+	default:
+		reader.Keys = sources
+	}
+
+	if len(reader.HmacSecret) == 0 && reader.Keys == nil {
+		return nil, ErrNoVerificationKeys
+	}
+
+	return reader, nil
 }
 
-// ValidateClaims validates the provided JWT claims.
-// It checks the expiration time, issuer, subject, and email fields.
-// Returns appropriate errors if validation fails: ErrExpiredToken for expired tokens,
-// ErrInvalidIssuer for an incorrect issuer, ErrEmptySubject for a missing subject,
-// or a general error if the email claim is not a string.
-func (reader *Reader) ValidateClaims(claims jwt.MapClaims) error {
-	expiration, err := claims.GetExpirationTime()
-	if err != nil {
-		return fmt.Errorf("failed to get expiration time from claims: %w", err)
+// key selects the key that verifies the token based on its signing method.
+func (reader *Reader) key(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(reader.HmacSecret) == 0 {
+			return nil, ErrUnexpectedSigningMethod{unexpectedValue: token.Header["alg"]}
+		}
+		return reader.HmacSecret, nil
 	}
 
-	if time.Now().After(expiration.Time) {
-		return ErrExpiredToken
+	if reader.Keys == nil {
+		return nil, ErrUnexpectedSigningMethod{unexpectedValue: token.Header["alg"]}
 	}
+	return reader.Keys.Key(token)
+}
 
-	issuer, err := claims.GetIssuer()
-	if err != nil {
-		return fmt.Errorf("failed to get issuer from claims: %w", err)
+func (reader *Reader) validMethods() []string {
+	var methods []string
+	if len(reader.HmacSecret) > 0 {
+		methods = append(methods,
+			jwt.SigningMethodHS256.Alg(),
+			jwt.SigningMethodHS384.Alg(),
+			jwt.SigningMethodHS512.Alg(),
+		)
 	}
-	if issuer != reader.Issuer {
-		return ErrInvalidIssuer
+	if reader.Keys != nil {
+		methods = append(methods,
+			jwt.SigningMethodRS256.Alg(),
+			jwt.SigningMethodES256.Alg(),
+			jwt.SigningMethodEdDSA.Alg(),
+		)
 	}
+	return methods
+}
+
+// Read accepts a JWT token string, parses it, validates the signing method,
+// and extracts its Claims. HMAC signed tokens are verified with the reader's HmacSecret
+// and RS256, ES256 or EdDSA signed tokens with its KeySource. Any other signing method,
+// or one the reader has no key for, results in an ErrUnexpectedSigningMethod error.
+// Read only checks the signature; the claims must be validated via reader's ValidateClaims method.
+func (reader *Reader) Read(tokenString string) (*Claims, error) {
+	claims := &Claims{}
 
-	subject, err := claims.GetSubject()
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		claims,
+		reader.key,
+		jwt.WithValidMethods(reader.validMethods()),
+		jwt.WithoutClaimsValidation(),
+	)
 	if err != nil {
-		return fmt.Errorf("failed to get subject from claims: %w", err)
-	}
-	if subject == "" {
-		return ErrEmptySubject
+		return nil, err
 	}
 
-	_, ok := claims["email"].(string)
-	if !ok {
-		return errors.New("email claim is not a string")
-	}
+	return claims, nil
+}
 
-	return err
+// ValidateClaims validates the provided JWT claims against the reader's ValidationPolicy,
+// using the reader's Settings for the accepted issuer and audience when the policy has none.
+// See ValidationPolicy.Validate for the errors returned.
+func (reader *Reader) ValidateClaims(claims *Claims) error {
+	return reader.Policy.Validate(claims, reader.Settings, time.Now())
 }
@@ -0,0 +1,299 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"testing"
+	"time"
+)
+
+//region helpers
+
+type testClaims struct {
+	jwt.RegisteredClaims
+	Email string `json:"email"`
+}
+
+func newTestSigner(t *testing.T) *Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+
+	signer, err := NewSigner(encoded, "https://api.example.com", []string{"authenticated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func signTestToken(t *testing.T, signer *Signer) string {
+	t.Helper()
+	claims := testClaims{
+		RegisteredClaims: signer.RegisteredClaims("3f1c2f4e-8f7a-4d7e-9a51-2c4b1d0e6a77", time.Hour),
+		Email:            "test@example.com",
+	}
+
+	token, err := signer.GenerateJWT(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func encodePublicKey(t *testing.T, pub *ecdsa.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+//endregion
+
+func TestReaderVerifiesSignerTokens(t *testing.T) {
+	signer := newTestSigner(t)
+	reader, err := NewReaderFromConfig(ReaderConfig{Settings: signer.Settings, Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString := signTestToken(t, signer)
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != signer.Keys.Active().ID {
+		t.Errorf("Expected kid %q, got %v", signer.Keys.Active().ID, token.Header["kid"])
+	}
+
+	claims, err := reader.Read(tokenString)
+	if err != nil {
+		t.Fatalf("Expected signer token to be read, got %v", err)
+	}
+	if err = reader.ValidateClaims(claims); err != nil {
+		t.Errorf("Expected signer token claims to be valid, got %v", err)
+	}
+}
+
+func TestReaderVerifiesRetiringKeyAfterRotation(t *testing.T) {
+	signer := newTestSigner(t)
+	reader, err := NewReaderFromConfig(ReaderConfig{Settings: signer.Settings, Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := signTestToken(t, signer)
+	if _, err = signer.Keys.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	after := signTestToken(t, signer)
+
+	for name, tokenString := range map[string]string{"before rotation": before, "after rotation": after} {
+		if _, err = reader.Read(tokenString); err != nil {
+			t.Errorf("Expected token signed %s to be read, got %v", name, err)
+		}
+	}
+
+	if got := len(signer.Keys.PublicKeys().Keys); got != 2 {
+		t.Errorf("Expected active and retiring keys to be published, got %d keys", got)
+	}
+}
+
+func TestReaderVerifiesPEMPublicKey(t *testing.T) {
+	signer := newTestSigner(t)
+	reader, err := NewReaderFromConfig(ReaderConfig{
+		Settings:   signer.Settings,
+		PublicKeys: []string{encodePublicKey(t, &signer.Keys.Active().PrivateKey.PublicKey)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = reader.Read(signTestToken(t, signer)); err != nil {
+		t.Fatalf("Expected token to be verified with the PEM public key, got %v", err)
+	}
+
+	other := newTestSigner(t)
+	if _, err = reader.Read(signTestToken(t, other)); !errors.Is(err, ErrUnknownKeyID) {
+		t.Errorf("Expected ErrUnknownKeyID for another signer's token, got %v", err)
+	}
+}
+
+func TestReaderAcceptsHMACAlongsideES256(t *testing.T) {
+	signer := newTestSigner(t)
+	reader, err := NewReaderFromConfig(ReaderConfig{Settings: signer.Settings, HmacSecret: "secret", Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims{
+		RegisteredClaims: signer.RegisteredClaims("3f1c2f4e-8f7a-4d7e-9a51-2c4b1d0e6a77", time.Hour),
+		Email:            "test@example.com",
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tokenString := range map[string]string{"HS256": hmacToken, "ES256": signTestToken(t, signer)} {
+		if _, err = reader.Read(tokenString); err != nil {
+			t.Errorf("Expected %s token to be read, got %v", name, err)
+		}
+	}
+}
+
+func TestReaderWithoutHMACSecretRejectsHMAC(t *testing.T) {
+	signer := newTestSigner(t)
+	reader, err := NewReaderFromConfig(ReaderConfig{Settings: signer.Settings, Signer: signer})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "x"}).SignedString([]byte(""))
+	if _, err = reader.Read(hmacToken); err == nil {
+		t.Fatal("Expected HMAC token to be rejected by a reader without an HMAC secret")
+	}
+}
+
+func TestReaderFromConfigRequiresKeys(t *testing.T) {
+	if _, err := NewReaderFromConfig(ReaderConfig{}); !errors.Is(err, ErrNoVerificationKeys) {
+		t.Fatalf("Expected ErrNoVerificationKeys, got %v", err)
+	}
+}
+
+func TestValidateClaimsChecksSharedAudience(t *testing.T) {
+	signer := newTestSigner(t)
+	reader, err := NewReaderFromConfig(ReaderConfig{
+		Settings: Settings{Issuer: signer.Issuer, Audience: []string{"another-api"}},
+		Signer:   signer,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := reader.Read(signTestToken(t, signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.ValidateClaims(claims); !errors.Is(err, ErrInvalidAudience) {
+		t.Errorf("Expected ErrInvalidAudience, got %v", err)
+	}
+}
+
+func TestValidationPolicy(t *testing.T) {
+	now := time.Now()
+	settings := Settings{Issuer: "https://api.example.com", Audience: []string{"authenticated"}}
+
+	read := func(t *testing.T, claims jwt.MapClaims) *Claims {
+		t.Helper()
+		js, err := json.Marshal(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Claims{}
+		if err = json.Unmarshal(js, c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	base := func(overrides jwt.MapClaims) jwt.MapClaims {
+		claims := jwt.MapClaims{
+			"iss":   "https://api.example.com",
+			"aud":   "authenticated",
+			"sub":   "3f1c2f4e-8f7a-4d7e-9a51-2c4b1d0e6a77",
+			"exp":   now.Add(time.Hour).Unix(),
+			"iat":   now.Add(-time.Minute).Unix(),
+			"email": "test@example.com",
+		}
+		for k, v := range overrides {
+			if v == nil {
+				delete(claims, k)
+				continue
+			}
+			claims[k] = v
+		}
+		return claims
+	}
+
+	testCases := []struct {
+		name     string
+		policy   ValidationPolicy
+		claims   jwt.MapClaims
+		expected error
+	}{
+		{"valid", DefaultPolicy(), base(nil), nil},
+		{"email is optional", DefaultPolicy(), base(jwt.MapClaims{"email": nil}), nil},
+		{"email must be a string", DefaultPolicy(), base(jwt.MapClaims{"email": 42}), ErrInvalidClaim{Claim: "email", Expected: ClaimString}},
+		{"required claim missing", ValidationPolicy{RequiredClaims: map[string]ClaimType{"email": ClaimString}}, base(jwt.MapClaims{"email": nil}), ErrMissingClaim{Claim: "email"}},
+		{"missing exp", DefaultPolicy(), base(jwt.MapClaims{"exp": nil}), ErrMissingClaim{Claim: "exp"}},
+		{"expired", DefaultPolicy(), base(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}), ErrExpiredToken},
+		{"expired within leeway", ValidationPolicy{Leeway: 2 * time.Minute}, base(jwt.MapClaims{"exp": now.Add(-time.Minute).Unix()}), nil},
+		{"not yet valid", DefaultPolicy(), base(jwt.MapClaims{"nbf": now.Add(time.Hour).Unix()}), ErrTokenNotYetValid},
+		{"issued in future", DefaultPolicy(), base(jwt.MapClaims{"iat": now.Add(time.Hour).Unix()}), ErrIssuedInFuture},
+		{"too old", ValidationPolicy{MaxAge: 30 * time.Second}, base(nil), ErrTokenTooOld},
+		{"max age requires iat", ValidationPolicy{MaxAge: time.Hour}, base(jwt.MapClaims{"iat": nil}), ErrMissingClaim{Claim: "iat"}},
+		{"wrong issuer", DefaultPolicy(), base(jwt.MapClaims{"iss": "https://evil.example.com"}), ErrInvalidIssuer},
+		{"one of several issuers", ValidationPolicy{Issuers: []string{"https://a.example.com", "https://b.example.com"}}, base(jwt.MapClaims{"iss": "https://b.example.com"}), nil},
+		{"wrong audience", DefaultPolicy(), base(jwt.MapClaims{"aud": "anon"}), ErrInvalidAudience},
+		{"one of several audiences", ValidationPolicy{Audiences: []string{"admin", "authenticated"}}, base(jwt.MapClaims{"aud": []string{"authenticated", "other"}}), nil},
+		{"empty subject", DefaultPolicy(), base(jwt.MapClaims{"sub": ""}), ErrEmptySubject},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.Validate(read(t, tc.claims), settings, now)
+			if tc.expected == nil {
+				if err != nil {
+					t.Fatalf("Expected claims to be valid, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("Expected error %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestClaimsReadAuthenticationMethods(t *testing.T) {
+	var claims Claims
+	data := `{"sub":"user","aal":"aal2","amr":[{"method":"password","timestamp":1700000000},"otp",{"method":"totp","timestamp":1700000100}]}`
+	if err := json.Unmarshal([]byte(data), &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.AAL != "aal2" {
+		t.Errorf("Expected aal2, got %q", claims.AAL)
+	}
+
+	expected := []AuthenticationMethod{
+		{Method: "password", Timestamp: 1700000000},
+		{Method: "otp"},
+		{Method: "totp", Timestamp: 1700000100},
+	}
+	if len(claims.AMR) != len(expected) {
+		t.Fatalf("Expected %d methods, got %v", len(expected), claims.AMR)
+	}
+	for i, method := range expected {
+		if claims.AMR[i] != method {
+			t.Errorf("Expected method %d to be %+v, got %+v", i, method, claims.AMR[i])
+		}
+	}
+}
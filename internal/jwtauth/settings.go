@@ -0,0 +1,31 @@
+package jwtauth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"time"
+)
+
+// Settings are the token settings a Signer and Reader share: the issuer the signer
+// stamps on tokens and the reader expects, and the audience tokens are intended for.
+type Settings struct {
+	Issuer   string
+	Audience []string
+}
+
+// RegisteredClaims builds the registered claims for a new token issued to the subject.
+// The issuer and audience come from the settings, the token is valid from now until ttl
+// from now, and it gets a random jti so it can be told apart from other tokens.
+func (s Settings) RegisteredClaims(subject string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+
+	return jwt.RegisteredClaims{
+		Issuer:    s.Issuer,
+		Subject:   subject,
+		Audience:  s.Audience,
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        uuid.NewString(),
+	}
+}
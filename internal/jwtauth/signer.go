@@ -10,11 +10,11 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// Signer holds the necessary variables to sign JWTs
+// Signer holds the necessary variables to sign JWTs.
+// Its Settings are meant to be shared with the Reader that verifies its tokens.
 type Signer struct {
-	PrivateKey     *ecdsa.PrivateKey
-	Issuer         string
-	AcceptAudience []string
+	Settings
+	Keys *Keyring
 }
 
 func parseECDSAPrivateKey(pemEncodedBytes []byte) (*ecdsa.PrivateKey, error) {
@@ -31,11 +31,10 @@ func parseECDSAPrivateKey(pemEncodedBytes []byte) (*ecdsa.PrivateKey, error) {
 	return privKey, nil
 }
 
-// NewSigner creates a new instance of Signer using the provided base64 encoded string
-// which is expected to be an ECDSA256 private key, issuer and an accepted audience slice.
-// Returns an error if the provided base64 string is not a valid ECDSA256 private key.
-// Also, returns an error if the curve used is not equal to elliptic.P256.
-func NewSigner(base64String string, issuer string, acceptAudience []string) (*Signer, error) {
+// ParseSigningKey decodes a base64 encoded PEM string which is expected to hold an ECDSA256 private key.
+// Returns an error if the string is not a valid ECDSA256 private key, or ErrWrongECDSAFormat
+// if the curve used is not equal to elliptic.P256.
+func ParseSigningKey(base64String string) (*ecdsa.PrivateKey, error) {
 	key, err := base64.StdEncoding.DecodeString(base64String)
 	if err != nil {
 		return nil, err
@@ -50,15 +49,42 @@ func NewSigner(base64String string, issuer string, acceptAudience []string) (*Si
 		return nil, ErrWrongECDSAFormat
 	}
 
-	return &Signer{PrivateKey: pKey, Issuer: issuer, AcceptAudience: acceptAudience}, nil
+	return pKey, nil
 }
 
-// GenerateJWT creates a new JWT using the signers private key and returns it as a string.
-// Will error if the key is not ECDSA256.
+// NewSigner creates a new instance of Signer using the provided base64 encoded string
+// which is expected to be an ECDSA256 private key, issuer and an accepted audience slice.
+// The key becomes the only key of the signer's Keyring.
+// Returns an error if the provided base64 string is not a valid ECDSA256 private key.
+// Also, returns an error if the curve used is not equal to elliptic.P256.
+func NewSigner(base64String string, issuer string, acceptAudience []string) (*Signer, error) {
+	pKey, err := ParseSigningKey(base64String)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := NewKeyring(pKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyringSigner(keys, issuer, acceptAudience), nil
+}
+
+// NewKeyringSigner creates a new instance of Signer that signs with the active key of the keyring.
+func NewKeyringSigner(keys *Keyring, issuer string, acceptAudience []string) *Signer {
+	return &Signer{Keys: keys, Settings: Settings{Issuer: issuer, Audience: acceptAudience}}
+}
+
+// GenerateJWT creates a new JWT signed with the keyring's active key and returns it as a string.
+// The key's ID is set as the token's kid header so verifiers can select the matching public key.
 func (signer *Signer) GenerateJWT(claims jwt.Claims) (string, error) {
+	key := signer.Keys.Active()
+
 	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = key.ID
 
-	tokenString, err := token.SignedString(signer.PrivateKey)
+	tokenString, err := token.SignedString(key.PrivateKey)
 	if err != nil {
 		return tokenString, err
 	}
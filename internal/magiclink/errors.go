@@ -0,0 +1,9 @@
+package magiclink
+
+import "errors"
+
+var (
+	ErrInvalidLink      = errors.New("sign in link is invalid, expired or already used")
+	ErrApprovalRequired = errors.New("sign in link was opened on another device than the one it was requested from")
+	ErrNotOpened        = errors.New("sign in link has not been opened on another device")
+)
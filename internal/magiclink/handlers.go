@@ -0,0 +1,204 @@
+package magiclink
+
+import (
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type linkRequester interface {
+	RequestLink(email, ip, userAgent string) (string, error)
+}
+
+type signer interface {
+	SignIn(token, deviceToken, openerToken, ip, userAgent string) (*users.User, string, error)
+}
+
+type approver interface {
+	PendingApproval(deviceToken string) (*Opening, error)
+	Approve(deviceToken string) (*Opening, error)
+}
+
+// RequestLinkHandler mails a sign in link to the email, and responds with the device token the client
+// must send along with the link's token to sign in, or to approve a sign in on another device. The response is the same
+// whether or not the email belongs to a user.
+func RequestLinkHandler(logger *slog.Logger, requester linkRequester) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Email string `json:"email"`
+		}
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		input.Email = strings.TrimSpace(input.Email)
+
+		v := validator.New()
+		if users.ValidateEmail(v, input.Email); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		deviceToken, err := requester.RequestLink(input.Email, middleware.ClientIP(r), r.UserAgent())
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		env := apiutils.Envelope{
+			"message":     "a sign in link has been sent to this email address",
+			"deviceToken": deviceToken,
+		}
+		err = apiutils.WriteJson(w, http.StatusAccepted, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RequestLinkHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// SignInHandler signs in with the token of a sign in link, and responds with the user and the tokens
+// the issuer grants them. When the device token is not the one the link was requested with, it responds
+// with an "Accepted" status instead, until the requesting device approves the sign in with ApproveHandler.
+// The first of these responses carries an opener token, which the client must send along with the link's
+// token from then on, retrying until the sign in is approved.
+func SignInHandler(logger *slog.Logger, signer signer, issuer auth.Issuer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Token       string `json:"token"`
+			DeviceToken string `json:"deviceToken"`
+			OpenerToken string `json:"openerToken"`
+		}
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		v.Check(input.Token != "", "token", "must be provided")
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		user, openerToken, err := signer.SignIn(input.Token, input.DeviceToken, input.OpenerToken, middleware.ClientIP(r), r.UserAgent())
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrApprovalRequired):
+				env := apiutils.Envelope{
+					"approvalRequired": true,
+					"message":          "approve this sign in on the device the link was requested from",
+				}
+				if openerToken != "" {
+					env["openerToken"] = openerToken
+				}
+				if err = apiutils.WriteJson(w, http.StatusAccepted, env, http.Header{}); err != nil {
+					requestId, _ := middleware.GetRequestID(r)
+					logger.Error("SignInHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+				}
+			case errors.Is(err, ErrInvalidLink):
+				v.AddError("token", "invalid or expired sign in link")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		if message := users.InactiveUserMessage(user); message != "" {
+			apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+			return
+		}
+
+		tokens, err := issuer.Issue(auth.ContextSetMethod(r, auth.MethodMagicLink), user)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"user": user, "tokens": tokens}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("SignInHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// PendingApprovalHandler responds with where the link requested with the device token was opened, when it
+// was opened on another device that waits for approval. The requesting device polls it after requesting
+// a link, and shows the user where the link was opened, so they can tell whether to approve the sign in.
+func PendingApprovalHandler(logger *slog.Logger, approver approver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceToken, ok := readDeviceToken(w, r, logger)
+		if !ok {
+			return
+		}
+
+		env := apiutils.Envelope{"pending": false}
+		opening, err := approver.PendingApproval(deviceToken)
+		switch {
+		case err == nil:
+			env = apiutils.Envelope{"pending": true, "opening": opening}
+		case !errors.Is(err, ErrNotOpened):
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		if err = apiutils.WriteJson(w, http.StatusOK, env, http.Header{}); err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("PendingApprovalHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ApproveHandler lets the device that opened the link requested with the device token sign in with it.
+func ApproveHandler(logger *slog.Logger, approver approver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deviceToken, ok := readDeviceToken(w, r, logger)
+		if !ok {
+			return
+		}
+
+		opening, err := approver.Approve(deviceToken)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrNotOpened):
+				apiutils.ErrorResponse(w, r, logger, http.StatusNotFound, "no sign in is waiting for approval")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		env := apiutils.Envelope{"message": "sign in approved", "opening": opening}
+		if err = apiutils.WriteJson(w, http.StatusOK, env, http.Header{}); err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ApproveHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// readDeviceToken reads the device token from the body, and responds with an error when it is missing.
+func readDeviceToken(w http.ResponseWriter, r *http.Request, logger *slog.Logger) (string, bool) {
+	var input struct {
+		DeviceToken string `json:"deviceToken"`
+	}
+	if err := apiutils.ReadJSON(w, r, &input); err != nil {
+		apiutils.BadRequestResponse(w, r, logger, err)
+		return "", false
+	}
+
+	v := validator.New()
+	if v.Check(input.DeviceToken != "", "deviceToken", "must be provided"); !v.Valid() {
+		apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+		return "", false
+	}
+
+	return input.DeviceToken, true
+}
@@ -0,0 +1,73 @@
+package magiclink
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"time"
+)
+
+const tokenBytes = 32
+
+// Link is a single-use sign in link mailed to an email address. Only the SHA-256 hashes of its token,
+// of the device token handed to the client that requested it, and of the opener token handed to the
+// device that opened it elsewhere, are stored. The email may not belong to a user yet: they are created
+// when they sign in.
+type Link struct {
+	Hash            []byte
+	Email           string
+	DeviceHash      []byte
+	IP              string
+	UserAgent       string
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	UsedAt          *time.Time
+	OpenerHash      []byte
+	OpenedIP        string
+	OpenedUserAgent string
+	OpenedAt        *time.Time
+	ApprovedAt      *time.Time
+}
+
+// Opening describes where a link was opened on another device than the one it was requested from,
+// shown to the user on the requesting device, so they can tell whether to approve the sign in.
+type Opening struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	OpenedAt  time.Time `json:"openedAt"`
+}
+
+// Opening returns where the link was opened, if it was opened on another device.
+func (l *Link) Opening() *Opening {
+	if l.OpenedAt == nil {
+		return nil
+	}
+	return &Opening{IP: l.OpenedIP, UserAgent: l.OpenedUserAgent, OpenedAt: *l.OpenedAt}
+}
+
+// boundTo reports whether the device token is the one handed out when the link was requested.
+func (l *Link) boundTo(deviceToken string) bool {
+	return deviceToken != "" && subtle.ConstantTimeCompare(hashToken(deviceToken), l.DeviceHash) == 1
+}
+
+// openedBy reports whether the opener token is the one handed out when the link was opened on another device.
+func (l *Link) openedBy(openerToken string) bool {
+	return openerToken != "" && l.OpenerHash != nil && subtle.ConstantTimeCompare(hashToken(openerToken), l.OpenerHash) == 1
+}
+
+// generateToken returns a new opaque token and its hash.
+func generateToken() (string, []byte, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,145 @@
+package magiclink
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type LinkPsqlRepo struct {
+	DB *database.DB
+}
+
+func (m LinkPsqlRepo) Insert(link *Link) error {
+	query := `INSERT INTO magic_links (hash, email, device_hash, ip, user_agent, expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{link.Hash, link.Email, link.DeviceHash, link.IP, link.UserAgent, link.ExpiresAt}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&link.CreatedAt)
+}
+
+// Get returns the link stored under the hash, if it is unused and has not expired.
+// Returns database.ErrRecordNotFound otherwise.
+func (m LinkPsqlRepo) Get(hash []byte) (*Link, error) {
+	query := `SELECT ` + linkColumns + `
+              FROM magic_links
+              WHERE hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+
+	return m.scanOne(query, hash)
+}
+
+// Open records that the link stored under the hash was opened on another device than the one it was
+// requested from, by the device holding the opener token with the hash. Returns database.ErrRecordNotFound
+// if the link is used, expired or was already opened, so that only one other device may open it.
+func (m LinkPsqlRepo) Open(hash, openerHash []byte, ip, userAgent string) error {
+	query := `UPDATE magic_links
+              SET opener_hash = $2, opened_ip = $3, opened_user_agent = $4, opened_at = CURRENT_TIMESTAMP
+              WHERE hash = $1 AND opener_hash IS NULL AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, hash, openerHash, ip, userAgent)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetOpened returns the link requested with the device token with the hash, if it was opened on another
+// device and is waiting for approval. Returns database.ErrRecordNotFound otherwise.
+func (m LinkPsqlRepo) GetOpened(deviceHash []byte) (*Link, error) {
+	query := `SELECT ` + linkColumns + `
+              FROM magic_links
+              WHERE device_hash = $1 AND opened_at IS NOT NULL AND approved_at IS NULL
+                  AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+
+	return m.scanOne(query, deviceHash)
+}
+
+// Approve approves the sign in of the device that opened the link requested with the device token
+// with the hash. Returns database.ErrRecordNotFound if no such link is waiting for approval.
+func (m LinkPsqlRepo) Approve(deviceHash []byte) (*Link, error) {
+	query := `UPDATE magic_links
+              SET approved_at = CURRENT_TIMESTAMP
+              WHERE device_hash = $1 AND opened_at IS NOT NULL AND approved_at IS NULL
+                  AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+              RETURNING ` + linkColumns
+
+	return m.scanOne(query, deviceHash)
+}
+
+// Consume marks the link stored under the hash as used and returns it, if it was unused and
+// has not expired. Returns database.ErrRecordNotFound otherwise, so that of two requests
+// consuming the same link at once only one succeeds.
+func (m LinkPsqlRepo) Consume(hash []byte) (*Link, error) {
+	query := `UPDATE magic_links
+              SET used_at = CURRENT_TIMESTAMP
+              WHERE hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+              RETURNING ` + linkColumns
+
+	return m.scanOne(query, hash)
+}
+
+const linkColumns = `hash, email, device_hash, ip, user_agent, created_at, expires_at, used_at,
+              opener_hash, opened_ip, opened_user_agent, opened_at, approved_at`
+
+func (m LinkPsqlRepo) scanOne(query string, hash []byte) (*Link, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var link Link
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(
+		&link.Hash,
+		&link.Email,
+		&link.DeviceHash,
+		&link.IP,
+		&link.UserAgent,
+		&link.CreatedAt,
+		&link.ExpiresAt,
+		&link.UsedAt,
+		&link.OpenerHash,
+		&link.OpenedIP,
+		&link.OpenedUserAgent,
+		&link.OpenedAt,
+		&link.ApprovedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// DeleteExpired deletes the links that have expired, used or not, and returns how many it deleted.
+func (m LinkPsqlRepo) DeleteExpired() (int64, error) {
+	query := `DELETE FROM magic_links WHERE expires_at < CURRENT_TIMESTAMP`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
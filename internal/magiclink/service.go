@@ -0,0 +1,302 @@
+package magiclink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/mailer"
+	"go-web-api-starter/internal/ratelimit"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTTL   = 15 * time.Minute
+	templateFile = "magic_link.tmpl"
+)
+
+type linkRepository interface {
+	Insert(link *Link) error
+	Get(hash []byte) (*Link, error)
+	Open(hash, openerHash []byte, ip, userAgent string) error
+	GetOpened(deviceHash []byte) (*Link, error)
+	Approve(deviceHash []byte) (*Link, error)
+	Consume(hash []byte) (*Link, error)
+	DeleteExpired() (int64, error)
+}
+
+type userService interface {
+	GetById(id uuid.UUID) (*users.User, error)
+	GetByEmail(email string) (*users.User, error)
+	InsertDefaultUser(email string, id uuid.UUID) error
+	VerifyUserEmail(userId uuid.UUID, email string) error
+}
+
+type mailSender interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// Service mails single-use sign in links and signs in the users who open them, creating users
+// for emails that have none yet.
+//
+// Each link is bound to the device that requested it by a device token the client keeps. A link
+// opened without that token, such as on another device, only signs in once the requesting device
+// approves it, so that whoever gets hold of a link cannot sign in with it alone.
+type Service struct {
+	logger       *slog.Logger
+	links        linkRepository
+	users        userService
+	mailer       mailSender
+	baseURL      *url.URL
+	ttl          time.Duration
+	emailLimiter *ratelimit.Limiter
+	background   func(fn func())
+}
+
+type ServiceOption func(*Service)
+
+// WithTTL sets how long links are valid for.
+func WithTTL(ttl time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.ttl = ttl
+	}
+}
+
+// WithEmailLimiter limits how many links are mailed to each email address, on top of
+// any limit on the requests themselves.
+func WithEmailLimiter(limiter *ratelimit.Limiter) ServiceOption {
+	return func(s *Service) {
+		s.emailLimiter = limiter
+	}
+}
+
+// WithBackground sets how emails are sent in the background, so the caller can wait for
+// them on shutdown. By default they are sent in a plain goroutine.
+func WithBackground(background func(fn func())) ServiceOption {
+	return func(s *Service) {
+		s.background = background
+	}
+}
+
+// NewService returns a Service mailing links to the baseURL, with the token in its token query
+// parameter. The page at the URL signs in by sending the token to the API with SignInHandler.
+func NewService(
+	logger *slog.Logger,
+	links linkRepository,
+	users userService,
+	mailer mailSender,
+	baseURL string,
+	opts ...ServiceOption,
+) (*Service, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid magic link url %q", baseURL)
+	}
+
+	s := &Service{
+		logger:     logger,
+		links:      links,
+		users:      users,
+		mailer:     mailer,
+		baseURL:    u,
+		ttl:        defaultTTL,
+		background: func(fn func()) { go fn() },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// RequestLink mails a sign in link to the email, and returns the device token that binds it to the
+// requesting client. Requests over the per-email limit get a device token too, but no email, so that
+// the response does not tell them apart.
+func (s *Service) RequestLink(email, ip, userAgent string) (string, error) {
+	deviceToken, deviceHash, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("could not generate device token: %w", err)
+	}
+
+	if s.emailLimiter != nil {
+		if allowed, _ := s.emailLimiter.Allow(strings.ToLower(email)); !allowed {
+			s.logger.Warn("magic link request over the email rate limit", "ip", ip)
+			return deviceToken, nil
+		}
+	}
+
+	token, hash, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("could not generate magic link token: %w", err)
+	}
+
+	link := &Link{
+		Hash:       hash,
+		Email:      email,
+		DeviceHash: deviceHash,
+		IP:         ip,
+		UserAgent:  userAgent,
+		ExpiresAt:  time.Now().Add(s.ttl),
+	}
+	if err = s.links.Insert(link); err != nil {
+		return "", fmt.Errorf("could not insert magic link: %w", err)
+	}
+
+	data := map[string]any{
+		"link":      s.linkURL(token),
+		"expiresIn": mailer.HumanizeDuration(s.ttl),
+		"ip":        ip,
+	}
+	s.background(func() {
+		if err := s.mailer.Send(email, templateFile, data); err != nil {
+			s.logger.Error("failed to send magic link email", "error", err)
+		}
+	})
+
+	return deviceToken, nil
+}
+
+// SignIn consumes the link and returns the user with its email, who is created if they do not exist,
+// and whose email is verified by that, when the device token is the one the link was requested with.
+//
+// Otherwise the link was opened on another device, which must wait for the requesting device to approve
+// it with Approve. The first time, SignIn records where the link was opened from and returns a new opener
+// token along with ErrApprovalRequired. The opening device then sends the link's token again with the
+// opener token, getting ErrApprovalRequired until the sign in is approved. Only the device that opened the
+// link first may sign in with it this way. Returns ErrInvalidLink if the link is unknown, used, expired,
+// or was opened on yet another device.
+func (s *Service) SignIn(token, deviceToken, openerToken, ip, userAgent string) (*users.User, string, error) {
+	hash := hashToken(token)
+
+	link, err := s.links.Get(hash)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, "", ErrInvalidLink
+		}
+		return nil, "", err
+	}
+
+	switch {
+	case link.boundTo(deviceToken):
+	case link.OpenerHash == nil:
+		openerToken, openerHash, err := generateToken()
+		if err != nil {
+			return nil, "", fmt.Errorf("could not generate opener token: %w", err)
+		}
+		if err = s.links.Open(hash, openerHash, ip, userAgent); err != nil {
+			if errors.Is(err, database.ErrRecordNotFound) {
+				return nil, "", ErrInvalidLink
+			}
+			return nil, "", err
+		}
+		return nil, openerToken, ErrApprovalRequired
+	case !link.openedBy(openerToken):
+		return nil, "", ErrInvalidLink
+	case link.ApprovedAt == nil:
+		return nil, "", ErrApprovalRequired
+	}
+
+	if link, err = s.links.Consume(hash); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, "", ErrInvalidLink
+		}
+		return nil, "", err
+	}
+
+	user, err := s.getOrCreateUser(link.Email)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !user.IsEmailVerified() && users.InactiveUserMessage(user) == "" {
+		if err = s.users.VerifyUserEmail(user.ID, user.Email); err != nil {
+			s.logger.Error("failed to verify email after magic link sign in", "user id", user.ID, "error", err)
+		} else {
+			verifiedAt := time.Now()
+			user.EmailVerifiedAt = &verifiedAt
+		}
+	}
+
+	return user, "", nil
+}
+
+// PendingApproval returns where the link requested with the device token was opened, when it was opened
+// on another device that waits for approval. Returns ErrNotOpened otherwise.
+func (s *Service) PendingApproval(deviceToken string) (*Opening, error) {
+	link, err := s.links.GetOpened(hashToken(deviceToken))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrNotOpened
+		}
+		return nil, err
+	}
+
+	return link.Opening(), nil
+}
+
+// Approve lets the device that opened the link requested with the device token sign in with it, and
+// returns where it was opened. Returns ErrNotOpened if no link requested with it waits for approval.
+func (s *Service) Approve(deviceToken string) (*Opening, error) {
+	link, err := s.links.Approve(hashToken(deviceToken))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrNotOpened
+		}
+		return nil, err
+	}
+
+	return link.Opening(), nil
+}
+
+// Run deletes expired links every interval until the context is cancelled.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.links.DeleteExpired(); err != nil {
+				s.logger.Error("failed to delete expired magic links", "error", err)
+			}
+		}
+	}
+}
+
+// getOrCreateUser returns the user with the email, creating them with the default role if there is none.
+func (s *Service) getOrCreateUser(email string) (*users.User, error) {
+	user, err := s.users.GetByEmail(email)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	id := uuid.New()
+	if err = s.users.InsertDefaultUser(email, id); err != nil {
+		// Another request created the user in the meantime
+		if errors.Is(err, users.ErrDuplicateEmail) {
+			return s.users.GetByEmail(email)
+		}
+		return nil, err
+	}
+	s.logger.Info("user created from magic link sign in", "user id", id)
+
+	return s.users.GetById(id)
+}
+
+func (s *Service) linkURL(token string) string {
+	u := *s.baseURL
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
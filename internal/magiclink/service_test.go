@@ -0,0 +1,347 @@
+package magiclink
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/ratelimit"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryLinkRepo struct {
+	links map[string]*Link
+}
+
+func (m *memoryLinkRepo) Insert(link *Link) error {
+	link.CreatedAt = time.Now()
+	m.links[string(link.Hash)] = link
+	return nil
+}
+
+func (m *memoryLinkRepo) Get(hash []byte) (*Link, error) {
+	link, ok := m.links[string(hash)]
+	if !ok || link.UsedAt != nil || !link.ExpiresAt.After(time.Now()) {
+		return nil, database.ErrRecordNotFound
+	}
+	return link, nil
+}
+
+func (m *memoryLinkRepo) Consume(hash []byte) (*Link, error) {
+	link, err := m.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	link.UsedAt = &now
+	return link, nil
+}
+
+func (m *memoryLinkRepo) Open(hash, openerHash []byte, ip, userAgent string) error {
+	link, err := m.Get(hash)
+	if err != nil || link.OpenerHash != nil {
+		return database.ErrRecordNotFound
+	}
+	now := time.Now()
+	link.OpenerHash, link.OpenedIP, link.OpenedUserAgent, link.OpenedAt = openerHash, ip, userAgent, &now
+	return nil
+}
+
+func (m *memoryLinkRepo) GetOpened(deviceHash []byte) (*Link, error) {
+	for _, link := range m.links {
+		if string(link.DeviceHash) == string(deviceHash) && link.OpenedAt != nil && link.ApprovedAt == nil &&
+			link.UsedAt == nil && link.ExpiresAt.After(time.Now()) {
+			return link, nil
+		}
+	}
+	return nil, database.ErrRecordNotFound
+}
+
+func (m *memoryLinkRepo) Approve(deviceHash []byte) (*Link, error) {
+	link, err := m.GetOpened(deviceHash)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	link.ApprovedAt = &now
+	return link, nil
+}
+
+func (m *memoryLinkRepo) DeleteExpired() (int64, error) {
+	return 0, nil
+}
+
+type mockUserService struct {
+	users map[uuid.UUID]*users.User
+}
+
+func (m *mockUserService) GetById(id uuid.UUID) (*users.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting user: %w", database.ErrRecordNotFound)
+	}
+	return user, nil
+}
+
+func (m *mockUserService) GetByEmail(email string) (*users.User, error) {
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, fmt.Errorf("error getting user: %w", database.ErrRecordNotFound)
+}
+
+func (m *mockUserService) InsertDefaultUser(email string, id uuid.UUID) error {
+	m.users[id] = &users.User{ID: id, Email: email, Role: users.RegularRole}
+	return nil
+}
+
+func (m *mockUserService) VerifyUserEmail(id uuid.UUID, email string) error {
+	now := time.Now()
+	m.users[id].EmailVerifiedAt = &now
+	return nil
+}
+
+type stubIssuer struct{}
+
+func (stubIssuer) Issue(r *http.Request, user *users.User) (*auth.Tokens, error) {
+	return &auth.Tokens{AccessToken: "access-" + user.ID.String(), TokenType: auth.TokenTypeBearer}, nil
+}
+
+type mockMailer struct {
+	sent []map[string]any
+}
+
+func (m *mockMailer) Send(_, _ string, data any) error {
+	m.sent = append(m.sent, data.(map[string]any))
+	return nil
+}
+
+// lastToken returns the token of the link in the last mail sent.
+func (m *mockMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("Expected a mail to be sent")
+	}
+	u, err := url.Parse(m.sent[len(m.sent)-1]["link"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get("token")
+}
+
+type testService struct {
+	*Service
+	users  *mockUserService
+	mailer *mockMailer
+}
+
+func newTestService(t *testing.T, opts ...ServiceOption) *testService {
+	t.Helper()
+	ts := &testService{
+		users:  &mockUserService{users: map[uuid.UUID]*users.User{}},
+		mailer: &mockMailer{},
+	}
+
+	opts = append([]ServiceOption{WithBackground(func(fn func()) { fn() })}, opts...)
+	service, err := NewService(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		&memoryLinkRepo{links: map[string]*Link{}},
+		ts.users,
+		ts.mailer,
+		"https://app.example.com/auth/magic-link?source=email",
+		opts...,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Service = service
+	return ts
+}
+
+func TestSignInCreatesUser(t *testing.T) {
+	ts := newTestService(t)
+
+	deviceToken, err := ts.RequestLink("jane@example.com", "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := ts.mailer.sent[0]["link"].(string)
+	if !strings.HasPrefix(link, "https://app.example.com/auth/magic-link?") || !strings.Contains(link, "source=email") {
+		t.Errorf("Expected the link to keep the base URL, got %s", link)
+	}
+
+	user, _, err := ts.SignIn(ts.mailer.lastToken(t), deviceToken, "", "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jane@example.com" || !user.IsEmailVerified() {
+		t.Errorf("Expected a new user with a verified email, got %+v", user)
+	}
+	if len(ts.users.users) != 1 {
+		t.Errorf("Expected one user to be created, got %d", len(ts.users.users))
+	}
+}
+
+func TestSignInExistingUser(t *testing.T) {
+	ts := newTestService(t)
+	existing := &users.User{ID: uuid.New(), Email: "jane@example.com"}
+	ts.users.users[existing.ID] = existing
+
+	deviceToken, _ := ts.RequestLink("JANE@example.com", "192.0.2.1", "test")
+	user, _, err := ts.SignIn(ts.mailer.lastToken(t), deviceToken, "", "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != existing.ID || len(ts.users.users) != 1 {
+		t.Errorf("Expected the existing user to sign in, got %+v", user)
+	}
+}
+
+func TestSignInOnAnotherDeviceNeedsApproval(t *testing.T) {
+	ts := newTestService(t)
+
+	deviceToken, err := ts.RequestLink("jane@example.com", "192.0.2.1", "requesting browser")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := ts.mailer.lastToken(t)
+
+	if _, err = ts.PendingApproval(deviceToken); !errors.Is(err, ErrNotOpened) {
+		t.Errorf("Expected ErrNotOpened before the link is opened, got %v", err)
+	}
+
+	_, openerToken, err := ts.SignIn(token, "", "", "198.51.100.7", "other browser")
+	if !errors.Is(err, ErrApprovalRequired) || openerToken == "" {
+		t.Fatalf("Expected ErrApprovalRequired with an opener token, got %q, %v", openerToken, err)
+	}
+
+	// Until the requesting device approves, the opening device keeps waiting, and no other device may sign in
+	if _, _, err = ts.SignIn(token, "", openerToken, "198.51.100.7", "other browser"); !errors.Is(err, ErrApprovalRequired) {
+		t.Errorf("Expected ErrApprovalRequired before approval, got %v", err)
+	}
+	if _, _, err = ts.SignIn(token, "another device token", "", "203.0.113.9", "third browser"); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Expected a third device to be refused, got %v", err)
+	}
+	if _, err = ts.Approve("another device token"); !errors.Is(err, ErrNotOpened) {
+		t.Errorf("Expected another device token not to approve, got %v", err)
+	}
+
+	opening, err := ts.PendingApproval(deviceToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opening.IP != "198.51.100.7" || opening.UserAgent != "other browser" {
+		t.Errorf("Expected where the link was opened, got %+v", opening)
+	}
+	if _, err = ts.Approve(deviceToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = ts.SignIn(token, "", "", "203.0.113.9", "third browser"); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Expected an approved link to need the opener token, got %v", err)
+	}
+	user, _, err := ts.SignIn(token, "", openerToken, "198.51.100.7", "other browser")
+	if err != nil {
+		t.Fatalf("Expected the approved sign in to succeed, got %v", err)
+	}
+	if user.Email != "jane@example.com" {
+		t.Errorf("Expected the user with the link's email, got %+v", user)
+	}
+}
+
+func TestSignInHandlerWithoutDeviceToken(t *testing.T) {
+	ts := newTestService(t)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := SignInHandler(logger, ts, stubIssuer{})
+
+	if _, err := ts.RequestLink("jane@example.com", "192.0.2.1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	token := ts.mailer.lastToken(t)
+
+	testCases := map[string]struct {
+		body     string
+		expected int
+	}{
+		"confirmed by the client": {fmt.Sprintf(`{"token": %q, "confirm": true}`, token), http.StatusBadRequest},
+		"without device token":    {fmt.Sprintf(`{"token": %q}`, token), http.StatusAccepted},
+		"with a forged opener":    {fmt.Sprintf(`{"token": %q, "openerToken": "forged"}`, token), http.StatusUnprocessableEntity},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/auth/magic-link/verify", strings.NewReader(tc.body))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status %d, got %d: %s", tc.expected, rec.Code, rec.Body)
+			}
+			if strings.Contains(rec.Body.String(), "accessToken") {
+				t.Error("Expected no tokens to be issued")
+			}
+		})
+	}
+
+	if len(ts.users.users) != 0 {
+		t.Error("Expected no user to be created")
+	}
+}
+
+func TestLinksAreSingleUse(t *testing.T) {
+	ts := newTestService(t)
+
+	deviceToken, _ := ts.RequestLink("jane@example.com", "192.0.2.1", "test")
+	token := ts.mailer.lastToken(t)
+
+	if _, _, err := ts.SignIn(token, deviceToken, "", "192.0.2.1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ts.SignIn(token, deviceToken, "", "192.0.2.1", "test"); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Expected a used link to be invalid, got %v", err)
+	}
+	if _, _, err := ts.SignIn("unknown", deviceToken, "", "192.0.2.1", "test"); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Expected an unknown link to be invalid, got %v", err)
+	}
+}
+
+func TestExpiredLink(t *testing.T) {
+	ts := newTestService(t, WithTTL(-time.Minute))
+
+	deviceToken, _ := ts.RequestLink("jane@example.com", "192.0.2.1", "test")
+	if _, _, err := ts.SignIn(ts.mailer.lastToken(t), deviceToken, "", "192.0.2.1", "test"); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("Expected an expired link to be invalid, got %v", err)
+	}
+}
+
+func TestEmailLimiter(t *testing.T) {
+	ts := newTestService(t, WithEmailLimiter(ratelimit.New(2, time.Hour)))
+
+	for i := 0; i < 3; i++ {
+		deviceToken, err := ts.RequestLink("jane@example.com", "192.0.2.1", "test")
+		if err != nil || deviceToken == "" {
+			t.Fatalf("Expected a device token, got %q, %v", deviceToken, err)
+		}
+	}
+	if len(ts.mailer.sent) != 2 {
+		t.Errorf("Expected 2 mails, got %d", len(ts.mailer.sent))
+	}
+}
+
+func TestNewServiceRejectsInvalidURL(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	if _, err := NewService(logger, nil, nil, nil, "/relative"); err == nil {
+		t.Error("Expected a relative URL to be rejected")
+	}
+}
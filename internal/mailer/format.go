@@ -0,0 +1,25 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+)
+
+// HumanizeDuration formats a duration for an email, such as "3 days", "1 hour" or "15 minutes".
+func HumanizeDuration(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d.Round(time.Minute)/time.Minute), "minute")
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
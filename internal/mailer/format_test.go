@@ -0,0 +1,21 @@
+package mailer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		72 * time.Hour:   "3 days",
+		24 * time.Hour:   "1 day",
+		time.Hour:        "1 hour",
+		36 * time.Hour:   "36 hours",
+		90 * time.Minute: "90 minutes",
+	}
+	for d, want := range tests {
+		if got := HumanizeDuration(d); got != want {
+			t.Errorf("%v: expected %q, got %q", d, want, got)
+		}
+	}
+}
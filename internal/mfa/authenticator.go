@@ -0,0 +1,36 @@
+package mfa
+
+import (
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/validator"
+	"strings"
+	"time"
+)
+
+// Authenticator is a TOTP authenticator app a user enrolled. It only counts as a second factor once
+// confirmed with a code, which proves the user set it up. The secret is stored as is, since it is
+// needed to compute codes, so the table must be protected like the credentials one.
+type Authenticator struct {
+	ID           uuid.UUID  `json:"id"`
+	UserID       uuid.UUID  `json:"-"`
+	Name         string     `json:"name"`
+	Secret       []byte     `json:"-"`
+	ConfirmedAt  *time.Time `json:"confirmedAt"`
+	LastUsedStep int64      `json:"-"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+// Confirmed reports whether the authenticator was confirmed with a code.
+func (a *Authenticator) Confirmed() bool {
+	return a.ConfirmedAt != nil
+}
+
+func ValidateName(v *validator.Validator, name string) {
+	v.Check(strings.TrimSpace(name) != "", "name", "must be provided")
+	v.MaxLength(name, "name", 100)
+}
+
+func ValidateCode(v *validator.Validator, code string) {
+	v.Check(code != "", "code", "must be provided")
+	v.Check(len(strings.TrimSpace(code)) == digits, "code", "must be 6 digits long")
+}
@@ -0,0 +1,9 @@
+package mfa
+
+import "errors"
+
+var (
+	ErrInvalidCode      = errors.New("invalid or expired code")
+	ErrAlreadyConfirmed = errors.New("authenticator is already confirmed")
+	ErrNotEnrolled      = errors.New("user has no confirmed authenticator")
+)
@@ -0,0 +1,276 @@
+package mfa
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type enroller interface {
+	Enroll(user *users.User, name string) (*Enrollment, error)
+	Confirm(userID, id uuid.UUID, code string) (*Authenticator, []string, error)
+}
+
+type authenticatorManager interface {
+	Authenticators(userID uuid.UUID) ([]Authenticator, error)
+	Remove(userID, id uuid.UUID) error
+}
+
+type verifier interface {
+	VerifyCode(userID uuid.UUID, code string) error
+	VerifyRecoveryCode(userID uuid.UUID, code string) (int, error)
+}
+
+type recoveryCodeRegenerator interface {
+	RegenerateRecoveryCodes(userID uuid.UUID) ([]string, error)
+}
+
+// StepUpIssuer issues an access token recording the methods the user authenticated with, that keeps
+// the claims of the token it replaces.
+type StepUpIssuer interface {
+	IssueWithMethods(user *users.User, source *jwtauth.Claims, methods []jwtauth.AuthenticationMethod) (*auth.Tokens, error)
+}
+
+// EnrollHandler creates an unconfirmed authenticator for the authenticated user, and responds
+// with the secret and provisioning URI to set it up in an authenticator app.
+func EnrollHandler(logger *slog.Logger, enroller enroller) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name string `json:"name"`
+		}
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		input.Name = strings.TrimSpace(input.Name)
+
+		v := validator.New()
+		if ValidateName(v, input.Name); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		enrollment, err := enroller.Enroll(users.ContextGetUser(r), input.Name)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetResource(r, "mfa_authenticator", enrollment.Authenticator.ID.String())
+		audit.SetAfter(r, enrollment.Authenticator)
+
+		err = apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"enrollment": enrollment}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("EnrollHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ConfirmHandler confirms an authenticator of the authenticated user with a code from their app.
+// Confirming the first one responds with the user's recovery codes, which are never shown again.
+func ConfirmHandler(logger *slog.Logger, enroller enroller) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Code string `json:"code"`
+		}
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		id := apiutils.ReadUUIDPath(r, "id", v)
+		if ValidateCode(v, input.Code); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "mfa_authenticator", id.String())
+
+		authenticator, recoveryCodes, err := enroller.Confirm(users.ContextGetUserId(r), id, input.Code)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			case errors.Is(err, ErrAlreadyConfirmed):
+				apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "this authenticator is already confirmed")
+			case errors.Is(err, ErrInvalidCode):
+				v.AddError("code", "invalid or expired code")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		env := apiutils.Envelope{"authenticator": authenticator}
+		if recoveryCodes != nil {
+			env["recoveryCodes"] = recoveryCodes
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ConfirmHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListAuthenticatorsHandler responds with the authenticators of the authenticated user.
+func ListAuthenticatorsHandler(logger *slog.Logger, manager authenticatorManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticators, err := manager.Authenticators(users.ContextGetUserId(r))
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"authenticators": authenticators}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListAuthenticatorsHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RemoveAuthenticatorHandler removes an authenticator of the authenticated user.
+func RemoveAuthenticatorHandler(logger *slog.Logger, manager authenticatorManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		id := apiutils.ReadUUIDPath(r, "id", v)
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "mfa_authenticator", id.String())
+
+		err := manager.Remove(users.ContextGetUserId(r), id)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "authenticator removed"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RemoveAuthenticatorHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// VerifyHandler verifies a TOTP code, or a recovery code, of the authenticated user, and responds with
+// an access token at AAL2 that passes RequireMFA. The token keeps the methods of the one the request was
+// authenticated with, and its claims, so that roles mapped from them are unchanged. It is not refreshable:
+// clients step up again once it expires.
+//
+// Only requests authenticated with an access token may step up. Others, such as with an API key or a
+// cookie session, get a "Forbidden" status: the issued token would not be limited like their credential.
+func VerifyHandler(logger *slog.Logger, verifier verifier, issuer StepUpIssuer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := users.ContextGetClaims(r)
+		if !ok {
+			message := "you must be authenticated with an access token to verify a second factor"
+			apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+			return
+		}
+
+		var input struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		v.Check(input.Code == "" || input.RecoveryCode == "", "code", "must not be provided with a recovery code")
+		if input.RecoveryCode == "" {
+			ValidateCode(v, input.Code)
+		}
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		user := users.ContextGetUser(r)
+		env := apiutils.Envelope{}
+
+		method := auth.MethodTOTP
+		var err error
+		if input.RecoveryCode != "" {
+			method = auth.MethodRecoveryCode
+			env["recoveryCodesRemaining"], err = verifier.VerifyRecoveryCode(user.ID, input.RecoveryCode)
+		} else {
+			err = verifier.VerifyCode(user.ID, input.Code)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrNotEnrolled):
+				apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "you have no confirmed authenticator")
+			case errors.Is(err, ErrInvalidCode):
+				v.AddError("code", "invalid or expired code")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		methods := append(slices.Clone(claims.AMR), jwtauth.AuthenticationMethod{Method: method, Timestamp: time.Now().Unix()})
+
+		env["tokens"], err = issuer.IssueWithMethods(user, claims, methods)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("VerifyHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RegenerateRecoveryCodesHandler replaces the recovery codes of the authenticated user, and responds
+// with the new ones, which are never shown again.
+func RegenerateRecoveryCodesHandler(logger *slog.Logger, regenerator recoveryCodeRegenerator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		codes, err := regenerator.RegenerateRecoveryCodes(users.ContextGetUserId(r))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrNotEnrolled):
+				apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "you have no confirmed authenticator")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"recoveryCodes": codes}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RegenerateRecoveryCodesHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
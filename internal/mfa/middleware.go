@@ -0,0 +1,93 @@
+package mfa
+
+import (
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RequireMFA creates a middleware that only lets through requests authenticated with an access token
+// at AAL2, whose second factor was verified within maxAge. Others get a "Forbidden" status, and must
+// verify a second factor with VerifyHandler and retry with the token it issues. Requests authenticated
+// without an access token, such as with an API key or a cookie session, are always rejected.
+//
+// This middleware must be called after the Authenticate middleware.
+func RequireMFA(logger *slog.Logger, maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := users.ContextGetClaims(r)
+			if !ok || !recentlyVerified(claims, time.Now(), maxAge) {
+				message := "you must verify a second factor to access this resource"
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+type enrollmentChecker interface {
+	IsEnrolled(userID uuid.UUID) (bool, error)
+}
+
+// RequireMFAIfEnrolled creates a middleware that behaves like RequireMFA for users who have a confirmed
+// authenticator, and lets through the requests of users who have none. It guards enrolling and confirming
+// authenticators, so that only the first one may be added with a single factor: otherwise, anyone holding
+// a single factor could add an authenticator of their own and step up with it.
+//
+// This middleware must be called after the Authenticate middleware.
+func RequireMFAIfEnrolled(logger *slog.Logger, checker enrollmentChecker, maxAge time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		requireMFA := RequireMFA(logger, maxAge)(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			enrolled, err := checker.IsEnrolled(users.ContextGetUserId(r))
+			if err != nil {
+				apiutils.ServerErrorResponse(w, r, logger, err)
+				return
+			}
+			if enrolled {
+				requireMFA.ServeHTTP(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// recentlyVerified reports whether the claims are at AAL2 and record a second factor verified within maxAge.
+// A second factor without a timestamp is taken to have been verified when the token was issued.
+func recentlyVerified(claims *jwtauth.Claims, now time.Time, maxAge time.Duration) bool {
+	if claims.AAL != auth.AAL2 {
+		return false
+	}
+
+	for _, method := range claims.AMR {
+		if !auth.IsSecondFactor(method.Method) {
+			continue
+		}
+
+		var verifiedAt time.Time
+		switch {
+		case method.Timestamp != 0:
+			verifiedAt = time.Unix(method.Timestamp, 0)
+		case claims.IssuedAt != nil:
+			verifiedAt = claims.IssuedAt.Time
+		default:
+			continue
+		}
+
+		if now.Sub(verifiedAt) <= maxAge {
+			return true
+		}
+	}
+
+	return false
+}
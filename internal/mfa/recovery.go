@@ -0,0 +1,44 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"strings"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets. Each can be used once, in place
+// of a TOTP code, when they have lost their authenticator.
+const recoveryCodeCount = 10
+
+// generateRecoveryCodes returns new recovery codes, formatted like "abcde-fghij", and their hashes.
+func generateRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([][]byte, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(secretEncoding.EncodeToString(b))[:10]
+
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode hashes the code as the user typed it, ignoring case, spaces and dashes.
+// Recovery codes are random enough that a fast hash is safe.
+func hashRecoveryCode(code string) []byte {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+
+	sum := sha256.Sum256([]byte(normalized))
+	return sum[:]
+}
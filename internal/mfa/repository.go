@@ -0,0 +1,179 @@
+package mfa
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type AuthenticatorPsqlRepo struct {
+	DB *database.DB
+}
+
+func (m AuthenticatorPsqlRepo) Insert(authenticator *Authenticator) error {
+	query := `INSERT INTO mfa_authenticators (id, user_id, name, secret)
+              VALUES ($1, $2, $3, $4)
+              RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{authenticator.ID, authenticator.UserID, authenticator.Name, authenticator.Secret}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&authenticator.CreatedAt)
+}
+
+// ListForUser returns the authenticators of the user, oldest first.
+func (m AuthenticatorPsqlRepo) ListForUser(userID uuid.UUID) ([]Authenticator, error) {
+	query := `SELECT id, user_id, name, secret, confirmed_at, last_used_step, created_at
+              FROM mfa_authenticators
+              WHERE user_id = $1
+              ORDER BY created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	authenticators := []Authenticator{}
+	for rows.Next() {
+		var a Authenticator
+		err = rows.Scan(&a.ID, &a.UserID, &a.Name, &a.Secret, &a.ConfirmedAt, &a.LastUsedStep, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return authenticators, nil
+}
+
+// Confirm marks the authenticator as confirmed with the code of the time step.
+func (m AuthenticatorPsqlRepo) Confirm(id uuid.UUID, step int64) error {
+	query := `UPDATE mfa_authenticators
+              SET confirmed_at = CURRENT_TIMESTAMP, last_used_step = $2
+              WHERE id = $1 AND confirmed_at IS NULL`
+
+	return m.exec(query, id, step)
+}
+
+// UseStep records that the code of the time step was used, unless a code of that step or a later
+// one already was. Returns database.ErrRecordNotFound then, so that of two requests with the same
+// code only one succeeds.
+func (m AuthenticatorPsqlRepo) UseStep(id uuid.UUID, step int64) error {
+	query := `UPDATE mfa_authenticators
+              SET last_used_step = $2
+              WHERE id = $1 AND last_used_step < $2`
+
+	return m.exec(query, id, step)
+}
+
+// Delete deletes the authenticator of the user.
+// Returns database.ErrRecordNotFound if the user has no such authenticator.
+func (m AuthenticatorPsqlRepo) Delete(userID, id uuid.UUID) error {
+	query := `DELETE FROM mfa_authenticators WHERE id = $1 AND user_id = $2`
+
+	return m.exec(query, id, userID)
+}
+
+func (m AuthenticatorPsqlRepo) exec(query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+type RecoveryCodePsqlRepo struct {
+	DB *database.DB
+}
+
+// Replace replaces the recovery codes of the user with the ones with the hashes.
+func (m RecoveryCodePsqlRepo) Replace(userID uuid.UUID, hashes [][]byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	deleteCodes := func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+		return err
+	}
+
+	insertCodes := func(tx *sql.Tx) error {
+		for _, hash := range hashes {
+			_, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return m.DB.WithTransaction(ctx, deleteCodes, insertCodes)
+}
+
+// Consume marks the recovery code of the user with the hash as used.
+// Returns database.ErrRecordNotFound if the user has no such unused code.
+func (m RecoveryCodePsqlRepo) Consume(userID uuid.UUID, hash []byte) error {
+	query := `UPDATE mfa_recovery_codes
+              SET used_at = CURRENT_TIMESTAMP
+              WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, userID, hash)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// CountUnused returns how many unused recovery codes the user has left.
+func (m RecoveryCodePsqlRepo) CountUnused(userID uuid.UUID) (int, error) {
+	query := `SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&count)
+	return count, err
+}
+
+// DeleteForUser deletes every recovery code of the user.
+func (m RecoveryCodePsqlRepo) DeleteForUser(userID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	return err
+}
@@ -0,0 +1,285 @@
+package mfa
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"time"
+)
+
+const defaultIssuerName = "Greenlight"
+
+type authenticatorRepository interface {
+	Insert(authenticator *Authenticator) error
+	ListForUser(userID uuid.UUID) ([]Authenticator, error)
+	Confirm(id uuid.UUID, step int64) error
+	UseStep(id uuid.UUID, step int64) error
+	Delete(userID, id uuid.UUID) error
+}
+
+type recoveryCodeRepository interface {
+	Replace(userID uuid.UUID, hashes [][]byte) error
+	Consume(userID uuid.UUID, hash []byte) error
+	CountUnused(userID uuid.UUID) (int, error)
+	DeleteForUser(userID uuid.UUID) error
+}
+
+// Enrollment is a new authenticator along with what the user needs to set it up in their app:
+// the secret to type in, and the URI to show as a QR code. It is only ever shown once.
+type Enrollment struct {
+	Authenticator *Authenticator `json:"authenticator"`
+	Secret        string         `json:"secret"`
+	URI           string         `json:"uri"`
+}
+
+// Service enrolls, verifies and removes the TOTP authenticators of users, and manages the
+// recovery codes they get when they confirm their first one.
+type Service struct {
+	logger         *slog.Logger
+	authenticators authenticatorRepository
+	recoveryCodes  recoveryCodeRepository
+	issuerName     string
+	skew           int64
+	now            func() time.Time
+}
+
+type ServiceOption func(*Service)
+
+// WithIssuerName sets the name authenticator apps show the codes under.
+func WithIssuerName(name string) ServiceOption {
+	return func(s *Service) {
+		s.issuerName = name
+	}
+}
+
+// WithSkew sets how many time steps of clock drift are allowed either way. Defaults to 1.
+func WithSkew(steps int64) ServiceOption {
+	return func(s *Service) {
+		s.skew = steps
+	}
+}
+
+func NewService(
+	logger *slog.Logger,
+	authenticators authenticatorRepository,
+	recoveryCodes recoveryCodeRepository,
+	opts ...ServiceOption,
+) *Service {
+	s := &Service{
+		logger:         logger,
+		authenticators: authenticators,
+		recoveryCodes:  recoveryCodes,
+		issuerName:     defaultIssuerName,
+		skew:           1,
+		now:            time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Enroll creates an unconfirmed authenticator for the user, which must be confirmed with Confirm
+// before it counts as a second factor.
+func (s *Service) Enroll(user *users.User, name string) (*Enrollment, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate totp secret: %w", err)
+	}
+
+	authenticator := &Authenticator{
+		ID:     uuid.New(),
+		UserID: user.ID,
+		Name:   name,
+		Secret: secret,
+	}
+	if err = s.authenticators.Insert(authenticator); err != nil {
+		return nil, fmt.Errorf("could not insert authenticator: %w", err)
+	}
+
+	return &Enrollment{
+		Authenticator: authenticator,
+		Secret:        EncodeSecret(secret),
+		URI:           ProvisioningURI(s.issuerName, user.Email, secret),
+	}, nil
+}
+
+// Confirm confirms the authenticator with a code from the app it was set up in. When it is the user's
+// first confirmed authenticator, they get new recovery codes, which are returned in plaintext this once.
+// Returns database.ErrRecordNotFound if the user has no such authenticator, ErrAlreadyConfirmed if it
+// was confirmed before, and ErrInvalidCode if the code does not match.
+func (s *Service) Confirm(userID, id uuid.UUID, code string) (*Authenticator, []string, error) {
+	authenticators, err := s.authenticators.ListForUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var authenticator *Authenticator
+	enrolled := false
+	for i := range authenticators {
+		if authenticators[i].ID == id {
+			authenticator = &authenticators[i]
+		} else if authenticators[i].Confirmed() {
+			enrolled = true
+		}
+	}
+
+	switch {
+	case authenticator == nil:
+		return nil, nil, database.ErrRecordNotFound
+	case authenticator.Confirmed():
+		return nil, nil, ErrAlreadyConfirmed
+	}
+
+	step, ok := validateCode(authenticator.Secret, code, s.now(), s.skew, 0)
+	if !ok {
+		return nil, nil, ErrInvalidCode
+	}
+
+	if err = s.authenticators.Confirm(id, step); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil, ErrAlreadyConfirmed
+		}
+		return nil, nil, err
+	}
+	confirmedAt := s.now()
+	authenticator.ConfirmedAt = &confirmedAt
+	authenticator.LastUsedStep = step
+
+	if enrolled {
+		return authenticator, nil, nil
+	}
+
+	codes, err := s.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return authenticator, codes, nil
+}
+
+// Authenticators returns the authenticators of the user, confirmed or not.
+func (s *Service) Authenticators(userID uuid.UUID) ([]Authenticator, error) {
+	return s.authenticators.ListForUser(userID)
+}
+
+// IsEnrolled reports whether the user has a confirmed authenticator.
+func (s *Service) IsEnrolled(userID uuid.UUID) (bool, error) {
+	authenticators, err := s.authenticators.ListForUser(userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, authenticator := range authenticators {
+		if authenticator.Confirmed() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Remove deletes the authenticator of the user. Removing their last confirmed authenticator
+// also deletes their recovery codes. Returns database.ErrRecordNotFound if the user has no such authenticator.
+func (s *Service) Remove(userID, id uuid.UUID) error {
+	if err := s.authenticators.Delete(userID, id); err != nil {
+		return err
+	}
+
+	enrolled, err := s.IsEnrolled(userID)
+	if err != nil {
+		return err
+	}
+	if !enrolled {
+		if err = s.recoveryCodes.DeleteForUser(userID); err != nil {
+			return fmt.Errorf("could not delete recovery codes: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// VerifyCode checks the code against the user's confirmed authenticators. A code is only accepted once.
+// Returns ErrNotEnrolled if the user has no confirmed authenticator, and ErrInvalidCode if no authenticator
+// matches the code.
+func (s *Service) VerifyCode(userID uuid.UUID, code string) error {
+	authenticators, err := s.authenticators.ListForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	enrolled := false
+	for _, authenticator := range authenticators {
+		if !authenticator.Confirmed() {
+			continue
+		}
+		enrolled = true
+
+		step, ok := validateCode(authenticator.Secret, code, s.now(), s.skew, authenticator.LastUsedStep)
+		if !ok {
+			continue
+		}
+
+		// A concurrent request used the code first
+		if err = s.authenticators.UseStep(authenticator.ID, step); err != nil {
+			if errors.Is(err, database.ErrRecordNotFound) {
+				return ErrInvalidCode
+			}
+			return err
+		}
+
+		return nil
+	}
+
+	if !enrolled {
+		return ErrNotEnrolled
+	}
+
+	return ErrInvalidCode
+}
+
+// VerifyRecoveryCode uses up one of the user's recovery codes, and returns how many they have left.
+// Returns ErrInvalidCode if the user has no such unused code.
+func (s *Service) VerifyRecoveryCode(userID uuid.UUID, code string) (int, error) {
+	if err := s.recoveryCodes.Consume(userID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return 0, ErrInvalidCode
+		}
+		return 0, err
+	}
+
+	remaining, err := s.recoveryCodes.CountUnused(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Info("recovery code used", "user id", userID, "remaining", remaining)
+	return remaining, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes with new ones, which are returned
+// in plaintext this once. Returns ErrNotEnrolled if the user has no confirmed authenticator.
+func (s *Service) RegenerateRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	enrolled, err := s.IsEnrolled(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !enrolled {
+		return nil, ErrNotEnrolled
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate recovery codes: %w", err)
+	}
+
+	if err = s.recoveryCodes.Replace(userID, hashes); err != nil {
+		return nil, fmt.Errorf("could not store recovery codes: %w", err)
+	}
+
+	return codes, nil
+}
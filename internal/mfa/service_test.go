@@ -0,0 +1,447 @@
+package mfa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apikeys"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/testutils"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryAuthenticatorRepo struct {
+	authenticators []*Authenticator
+}
+
+func (m *memoryAuthenticatorRepo) Insert(authenticator *Authenticator) error {
+	authenticator.CreatedAt = time.Now()
+	m.authenticators = append(m.authenticators, authenticator)
+	return nil
+}
+
+func (m *memoryAuthenticatorRepo) ListForUser(userID uuid.UUID) ([]Authenticator, error) {
+	authenticators := []Authenticator{}
+	for _, a := range m.authenticators {
+		if a.UserID == userID {
+			authenticators = append(authenticators, *a)
+		}
+	}
+	return authenticators, nil
+}
+
+func (m *memoryAuthenticatorRepo) find(id uuid.UUID) *Authenticator {
+	for _, a := range m.authenticators {
+		if a.ID == id {
+			return a
+		}
+	}
+	return nil
+}
+
+func (m *memoryAuthenticatorRepo) Confirm(id uuid.UUID, step int64) error {
+	a := m.find(id)
+	if a == nil || a.Confirmed() {
+		return database.ErrRecordNotFound
+	}
+	now := time.Now()
+	a.ConfirmedAt = &now
+	a.LastUsedStep = step
+	return nil
+}
+
+func (m *memoryAuthenticatorRepo) UseStep(id uuid.UUID, step int64) error {
+	a := m.find(id)
+	if a == nil || a.LastUsedStep >= step {
+		return database.ErrRecordNotFound
+	}
+	a.LastUsedStep = step
+	return nil
+}
+
+func (m *memoryAuthenticatorRepo) Delete(userID, id uuid.UUID) error {
+	for i, a := range m.authenticators {
+		if a.ID == id && a.UserID == userID {
+			m.authenticators = append(m.authenticators[:i], m.authenticators[i+1:]...)
+			return nil
+		}
+	}
+	return database.ErrRecordNotFound
+}
+
+type memoryRecoveryCodeRepo struct {
+	codes map[string]bool // unused by hash
+}
+
+func (m *memoryRecoveryCodeRepo) Replace(_ uuid.UUID, hashes [][]byte) error {
+	m.codes = map[string]bool{}
+	for _, hash := range hashes {
+		m.codes[string(hash)] = true
+	}
+	return nil
+}
+
+func (m *memoryRecoveryCodeRepo) Consume(_ uuid.UUID, hash []byte) error {
+	if !m.codes[string(hash)] {
+		return database.ErrRecordNotFound
+	}
+	m.codes[string(hash)] = false
+	return nil
+}
+
+func (m *memoryRecoveryCodeRepo) CountUnused(uuid.UUID) (int, error) {
+	count := 0
+	for _, unused := range m.codes {
+		if unused {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memoryRecoveryCodeRepo) DeleteForUser(uuid.UUID) error {
+	m.codes = map[string]bool{}
+	return nil
+}
+
+type testService struct {
+	*Service
+	clock         time.Time
+	user          *users.User
+	recoveryCodes *memoryRecoveryCodeRepo
+}
+
+func newTestService() *testService {
+	ts := &testService{
+		clock:         time.Unix(1700000000, 0),
+		user:          &users.User{ID: uuid.New(), Email: "jane@example.com"},
+		recoveryCodes: &memoryRecoveryCodeRepo{codes: map[string]bool{}},
+	}
+	ts.Service = NewService(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		&memoryAuthenticatorRepo{},
+		ts.recoveryCodes,
+	)
+	ts.now = func() time.Time { return ts.clock }
+	return ts
+}
+
+// code returns the current code of the enrollment's secret.
+func (ts *testService) code(t *testing.T, enrollment *Enrollment) string {
+	t.Helper()
+	secret, err := secretEncoding.DecodeString(enrollment.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hotp(secret, timeStep(ts.clock), digits)
+}
+
+// enroll enrolls and confirms an authenticator, and returns it with the recovery codes it came with.
+func (ts *testService) enroll(t *testing.T) (*Enrollment, []string) {
+	t.Helper()
+	enrollment, err := ts.Enroll(ts.user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, codes, err := ts.Confirm(ts.user.ID, enrollment.Authenticator.ID, ts.code(t, enrollment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.clock = ts.clock.Add(period)
+	return enrollment, codes
+}
+
+func TestEnrollAndConfirm(t *testing.T) {
+	ts := newTestService()
+
+	enrollment, err := ts.Enroll(ts.user, "phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enrolled, _ := ts.IsEnrolled(ts.user.ID); enrolled {
+		t.Error("Expected an unconfirmed authenticator not to count")
+	}
+	if err = ts.VerifyCode(ts.user.ID, ts.code(t, enrollment)); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("Expected ErrNotEnrolled, got %v", err)
+	}
+
+	if _, _, err = ts.Confirm(ts.user.ID, enrollment.Authenticator.ID, "000000"); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Expected ErrInvalidCode, got %v", err)
+	}
+	if _, _, err = ts.Confirm(uuid.New(), enrollment.Authenticator.ID, ts.code(t, enrollment)); !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("Expected another user's authenticator not to be found, got %v", err)
+	}
+
+	authenticator, codes, err := ts.Confirm(ts.user.ID, enrollment.Authenticator.ID, ts.code(t, enrollment))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !authenticator.Confirmed() || len(codes) != recoveryCodeCount {
+		t.Errorf("Expected a confirmed authenticator and recovery codes, got %+v, %v", authenticator, codes)
+	}
+
+	if _, _, err = ts.Confirm(ts.user.ID, enrollment.Authenticator.ID, ts.code(t, enrollment)); !errors.Is(err, ErrAlreadyConfirmed) {
+		t.Errorf("Expected ErrAlreadyConfirmed, got %v", err)
+	}
+
+	second, err := ts.Enroll(ts.user, "tablet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, codes, err = ts.Confirm(ts.user.ID, second.Authenticator.ID, ts.code(t, second)); err != nil || codes != nil {
+		t.Errorf("Expected no new recovery codes for a second authenticator, got %v, %v", codes, err)
+	}
+}
+
+func TestVerifyCodeRejectsReplay(t *testing.T) {
+	ts := newTestService()
+	enrollment, _ := ts.enroll(t)
+
+	code := ts.code(t, enrollment)
+	if err := ts.VerifyCode(ts.user.ID, code); err != nil {
+		t.Fatalf("Expected the code to be valid, got %v", err)
+	}
+	if err := ts.VerifyCode(ts.user.ID, code); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Expected a replayed code to be rejected, got %v", err)
+	}
+}
+
+func TestRecoveryCodesAreSingleUse(t *testing.T) {
+	ts := newTestService()
+	_, codes := ts.enroll(t)
+
+	remaining, err := ts.VerifyRecoveryCode(ts.user.ID, codes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != recoveryCodeCount-1 {
+		t.Errorf("Expected %d remaining codes, got %d", recoveryCodeCount-1, remaining)
+	}
+	if _, err = ts.VerifyRecoveryCode(ts.user.ID, codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Expected a used recovery code to be rejected, got %v", err)
+	}
+
+	regenerated, err := ts.RegenerateRecoveryCodes(ts.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ts.VerifyRecoveryCode(ts.user.ID, codes[1]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Expected a replaced recovery code to be rejected, got %v", err)
+	}
+	if _, err = ts.VerifyRecoveryCode(ts.user.ID, regenerated[0]); err != nil {
+		t.Errorf("Expected a new recovery code to be valid, got %v", err)
+	}
+}
+
+func TestRemoveLastAuthenticatorDeletesRecoveryCodes(t *testing.T) {
+	ts := newTestService()
+	enrollment, codes := ts.enroll(t)
+
+	if err := ts.Remove(ts.user.ID, enrollment.Authenticator.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.VerifyRecoveryCode(ts.user.ID, codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Expected recovery codes to be deleted, got %v", err)
+	}
+	if _, err := ts.RegenerateRecoveryCodes(ts.user.ID); !errors.Is(err, ErrNotEnrolled) {
+		t.Errorf("Expected ErrNotEnrolled, got %v", err)
+	}
+}
+
+func TestRequireMFA(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := RequireMFA(logger, 15*time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	now := time.Now()
+	testCases := map[string]struct {
+		claims   *jwtauth.Claims
+		expected int
+	}{
+		"recent totp": {
+			&jwtauth.Claims{AAL: auth.AAL2, AMR: []jwtauth.AuthenticationMethod{
+				{Method: auth.MethodPassword, Timestamp: now.Add(-time.Hour).Unix()},
+				{Method: auth.MethodTOTP, Timestamp: now.Add(-time.Minute).Unix()},
+			}},
+			http.StatusNoContent,
+		},
+		"stale totp": {
+			&jwtauth.Claims{AAL: auth.AAL2, AMR: []jwtauth.AuthenticationMethod{
+				{Method: auth.MethodTOTP, Timestamp: now.Add(-time.Hour).Unix()},
+			}},
+			http.StatusForbidden,
+		},
+		"totp without timestamp issued recently": {
+			&jwtauth.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(now)},
+				AAL:              auth.AAL2,
+				AMR:              []jwtauth.AuthenticationMethod{{Method: auth.MethodRecoveryCode}},
+			},
+			http.StatusNoContent,
+		},
+		"single factor": {
+			&jwtauth.Claims{AAL: auth.AAL1, AMR: []jwtauth.AuthenticationMethod{
+				{Method: auth.MethodPassword, Timestamp: now.Unix()},
+			}},
+			http.StatusForbidden,
+		},
+		"no token": {nil, http.StatusForbidden},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			if tc.claims != nil {
+				req = users.ContextSetClaims(req, tc.claims)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tc.expected == http.StatusForbidden {
+				testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you must verify a second factor to access this resource")
+			} else if rec.Code != tc.expected {
+				t.Errorf("Expected status %d, got %d", tc.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequireMFAIfEnrolled(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts := newTestService()
+	handler := RequireMFAIfEnrolled(logger, ts, 15*time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	singleFactor := &jwtauth.Claims{AAL: auth.AAL1, AMR: []jwtauth.AuthenticationMethod{
+		{Method: auth.MethodPassword, Timestamp: time.Now().Unix()},
+	}}
+	secondFactor := &jwtauth.Claims{AAL: auth.AAL2, AMR: []jwtauth.AuthenticationMethod{
+		{Method: auth.MethodPassword, Timestamp: time.Now().Unix()},
+		{Method: auth.MethodTOTP, Timestamp: time.Now().Unix()},
+	}}
+
+	serve := func(claims *jwtauth.Claims) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req = users.ContextSetClaims(users.ContextSetUser(req, ts.user), claims)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := serve(singleFactor); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected the first authenticator to be added with a single factor, got status %d", rec.Code)
+	}
+
+	ts.enroll(t)
+
+	rec := serve(singleFactor)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you must verify a second factor to access this resource")
+	if rec := serve(secondFactor); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected another authenticator to be added with a second factor, got status %d", rec.Code)
+	}
+}
+
+type stubKeyAuthenticator struct {
+	user *users.User
+}
+
+func (s stubKeyAuthenticator) Authenticate(string) (*users.User, *apikeys.APIKey, error) {
+	return s.user, &apikeys.APIKey{ID: uuid.New(), UserID: s.user.ID}, nil
+}
+
+type stubStepUpIssuer struct{}
+
+func (stubStepUpIssuer) IssueWithMethods(*users.User, *jwtauth.Claims, []jwtauth.AuthenticationMethod) (*auth.Tokens, error) {
+	return &auth.Tokens{}, nil
+}
+
+func TestVerifyHandlerRejectsAPIKeys(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts := newTestService()
+	enrollment, _ := ts.enroll(t)
+
+	authenticate := apikeys.Authenticate(logger, stubKeyAuthenticator{ts.user}, "", nil)
+	handler := authenticate(VerifyHandler(logger, ts, stubStepUpIssuer{}))
+
+	body := fmt.Sprintf(`{"code": %q}`, ts.code(t, enrollment))
+	req := httptest.NewRequest(http.MethodPost, "/v1/mfa/verify", strings.NewReader(body))
+	req.Header.Set("Authorization", apikeys.AuthorizationScheme+" secret")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you must be authenticated with an access token to verify a second factor")
+}
+
+func TestVerifyHandlerKeepsClaimRoles(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ts := newTestService()
+	enrollment, _ := ts.enroll(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := jwtauth.NewKeyring(key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := auth.NewAccessTokenIssuer(jwtauth.NewKeyringSigner(keys, "https://api.example.com", []string{"authenticated"}), time.Hour)
+
+	// The roles are in the claims of a token of the identity provider, as in the claims role source
+	var claims jwtauth.Claims
+	payload := `{"sub": "` + ts.user.ID.String() + `", "role": "authenticated", "aal": "aal1",
+		"amr": [{"method": "password", "timestamp": 1700000000}],
+		"app_metadata": {"roles": ["admin"]}, "groups": ["ops"]}`
+	if err := json.Unmarshal([]byte(payload), &claims); err != nil {
+		t.Fatal(err)
+	}
+	mapping := &users.ClaimMapping{
+		RoleClaims: []string{"app_metadata.roles"},
+		Rules:      []users.RoleRule{{Claim: "groups", Values: []string{"ops"}, Role: "support"}},
+		Roles:      map[string]users.Permissions{"admin": {"users:manage"}, "support": {"users:read"}},
+	}
+
+	body := fmt.Sprintf(`{"code": %q}`, ts.code(t, enrollment))
+	req := httptest.NewRequest(http.MethodPost, "/v1/mfa/verify", strings.NewReader(body))
+	req = users.ContextSetClaims(users.ContextSetUser(req, ts.user), &claims)
+	rec := httptest.NewRecorder()
+	VerifyHandler(logger, ts, issuer).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Tokens auth.Tokens `json:"tokens"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	var stepUp jwtauth.Claims
+	if _, err := jwt.ParseWithClaims(response.Tokens.AccessToken, &stepUp, keys.Key); err != nil {
+		t.Fatal(err)
+	}
+	if stepUp.AAL != auth.AAL2 {
+		t.Errorf("Expected the token at %s, got %q", auth.AAL2, stepUp.AAL)
+	}
+	role := mapping.Apply(users.RolesFromClaims, users.Role{}, &stepUp)
+	if role.Name != "admin" || !role.Allows("users:manage", "users:read") {
+		t.Errorf("Expected the roles mapped from the claims to be kept, got %+v", role)
+	}
+}
@@ -0,0 +1,94 @@
+package mfa
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Test vectors of RFC 6238 appendix B, for SHA-1
+func TestHOTPMatchesRFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+
+	for unix, expected := range vectors {
+		if got := hotp(secret, timeStep(time.Unix(unix, 0)), 8); got != expected {
+			t.Errorf("At %d, expected %s, got %s", unix, expected, got)
+		}
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	step := timeStep(now)
+
+	if matched, ok := validateCode(secret, hotp(secret, step, digits), now, 1, 0); !ok || matched != step {
+		t.Errorf("Expected the current code to match step %d, got %d, %v", step, matched, ok)
+	}
+	if _, ok := validateCode(secret, hotp(secret, step-1, digits), now, 1, 0); !ok {
+		t.Error("Expected the previous code to be accepted within the skew")
+	}
+	if _, ok := validateCode(secret, hotp(secret, step-2, digits), now, 1, 0); ok {
+		t.Error("Expected a code outside the skew to be rejected")
+	}
+	if _, ok := validateCode(secret, hotp(secret, step, digits), now, 1, step); ok {
+		t.Error("Expected a used code to be rejected")
+	}
+	if _, ok := validateCode(secret, "12345", now, 1, 0); ok {
+		t.Error("Expected a code of the wrong length to be rejected")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Greenlight", "jane@example.com", []byte("12345678901234567890"))
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Greenlight:jane@example.com" {
+		t.Errorf("Unexpected URI %s", uri)
+	}
+
+	query := u.Query()
+	if query.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("Unexpected secret %s", query.Get("secret"))
+	}
+	if query.Get("issuer") != "Greenlight" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("Unexpected parameters %v", query)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("Expected %d codes, got %d", recoveryCodeCount, len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("Unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("Duplicate code %q", code)
+		}
+		seen[code] = true
+	}
+
+	typed := " " + codes[0][:5] + codes[0][6:] + " "
+	if string(hashRecoveryCode(typed)) != string(hashes[0]) {
+		t.Error("Expected a code typed without its dash to match")
+	}
+}
@@ -55,3 +55,13 @@ func realIP(r *http.Request) string {
 	}
 	return ip
 }
+
+// ClientIP returns the client address without the port. RemoteAddr holds the real IP
+// when the RealIP middleware runs earlier in the chain.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
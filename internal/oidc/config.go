@@ -0,0 +1,98 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-web-api-starter/internal/validator"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// ProviderConfig declares an OpenID Connect provider users can sign in with.
+// Providers are usually loaded from a JSON file with LoadProviderConfigs, for example:
+//
+//	[{
+//	  "name": "google",
+//	  "issuer": "https://accounts.google.com",
+//	  "clientId": "1234.apps.googleusercontent.com",
+//	  "clientSecretEnv": "GOOGLE_CLIENT_SECRET",
+//	  "redirectUrl": "https://api.example.com/v1/auth/oidc/google/callback",
+//	  "scopes": ["openid", "email", "profile"]
+//	}]
+type ProviderConfig struct {
+	// Name identifies the provider in the login and callback paths and in linked identities.
+	Name   string `json:"name"`
+	Issuer string `json:"issuer"`
+	// ClientID is the id of the client registered with the provider.
+	ClientID string `json:"clientId"`
+	// ClientSecret authenticates confidential clients. Public clients rely on PKCE alone.
+	ClientSecret string `json:"clientSecret"`
+	// ClientSecretEnv names an environment variable to read the client secret from,
+	// so that it does not have to be written in the config file.
+	ClientSecretEnv string `json:"clientSecretEnv"`
+	// RedirectURL is the callback URL registered with the provider.
+	RedirectURL string   `json:"redirectUrl"`
+	Scopes      []string `json:"scopes"`
+	// TrustEmail creates users with the email of ID tokens that have no email_verified claim,
+	// for providers that only share verified emails but do not say so. It must not be set otherwise:
+	// whoever signs up first with an unverified email would own the account of that email.
+	TrustEmail bool `json:"trustEmail"`
+}
+
+// LoadProviderConfigs parses a JSON list of provider configs, resolves secrets from the
+// environment and validates every provider.
+func LoadProviderConfigs(data []byte, getEnv func(string) string) ([]ProviderConfig, error) {
+	var configs []ProviderConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("invalid oidc provider config: %w", err)
+	}
+
+	names := make([]string, 0, len(configs))
+	for i := range configs {
+		cfg := &configs[i]
+		if cfg.ClientSecretEnv != "" {
+			cfg.ClientSecret = getEnv(cfg.ClientSecretEnv)
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		v := validator.New()
+		if ValidateProviderConfig(v, cfg); !v.Valid() {
+			return nil, fmt.Errorf("invalid oidc provider %q: %v", cfg.Name, v.Errors)
+		}
+		names = append(names, cfg.Name)
+	}
+
+	if !validator.Unique(names) {
+		return nil, fmt.Errorf("invalid oidc provider config: provider names must be unique")
+	}
+
+	return configs, nil
+}
+
+// LoadProviderConfigsFile reads the provider configs from a JSON file, see LoadProviderConfigs.
+func LoadProviderConfigsFile(path string, getEnv func(string) string) ([]ProviderConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read oidc provider config: %w", err)
+	}
+
+	return LoadProviderConfigs(data, getEnv)
+}
+
+func ValidateProviderConfig(v *validator.Validator, cfg *ProviderConfig) {
+	v.Check(cfg.Name != "", "name", "must be provided")
+	v.OnlyOneWord(cfg.Name, "name")
+	v.AllLettersLowercase(cfg.Name, "name")
+	v.Check(validURL(cfg.Issuer), "issuer", "must be an absolute URL")
+	v.Check(cfg.ClientID != "", "clientId", "must be provided")
+	v.Check(validURL(cfg.RedirectURL), "redirectUrl", "must be an absolute URL")
+	v.Check(slices.Contains(cfg.Scopes, "openid"), "scopes", "must include openid")
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs() && u.Host != ""
+}
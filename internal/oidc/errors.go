@@ -0,0 +1,17 @@
+package oidc
+
+import "errors"
+
+var (
+	ErrUnknownProvider = errors.New("unknown oidc provider")
+	ErrInvalidState    = errors.New("oidc login state is invalid or expired")
+	ErrInvalidIDToken  = errors.New("id token is invalid")
+	ErrInvalidNonce    = errors.New("id token nonce does not match the login")
+	ErrMissingEmail    = errors.New("id token has no verified email to create the user with")
+	ErrEmailTaken      = errors.New("a user with the id token's email already exists")
+	ErrUserDeleted     = errors.New("the user linked to the identity was deleted")
+	ErrDiscoveryFailed = errors.New("failed to fetch the provider's discovery document")
+	ErrIssuerMismatch  = errors.New("discovery document issuer does not match the configured issuer")
+	ErrTokenExchange   = errors.New("failed to exchange the authorization code")
+	ErrMissingIDToken  = errors.New("token response has no id token")
+)
@@ -0,0 +1,132 @@
+package oidc
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+)
+
+// stateCookie binds the login state to the browser that started the login, so that an
+// attacker cannot have a victim complete a login the attacker started.
+const stateCookie = "oidc_state"
+
+type loginStarter interface {
+	BeginLogin(ctx context.Context, providerName string) (*LoginState, string, error)
+}
+
+type loginCompleter interface {
+	CompleteLogin(ctx context.Context, providerName, state, code string) (*users.User, error)
+}
+
+// LoginHandler starts a login with the provider in the path, and redirects the user to it.
+func LoginHandler(
+	logger *slog.Logger,
+	starter loginStarter,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, authURL, err := starter.BeginLogin(r.Context(), r.PathValue("provider"))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUnknownProvider):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     stateCookie,
+			Value:    state.State,
+			Path:     "/v1/auth/oidc/",
+			Expires:  state.ExpiresAt,
+			HttpOnly: true,
+			Secure:   true,
+			// Lax so the cookie is sent on the provider's top level redirect back to the callback
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		http.Redirect(w, r, authURL, http.StatusFound)
+	})
+}
+
+// CallbackHandler completes the login the provider redirected the user back from, and responds
+// with the user and the tokens the issuer grants them.
+func CallbackHandler(
+	logger *slog.Logger,
+	completer loginCompleter,
+	issuer auth.Issuer,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+
+		if providerErr := qs.Get("error"); providerErr != "" {
+			apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "the provider did not authorize the login: "+providerErr)
+			return
+		}
+
+		v := validator.New()
+		v.Check(qs.Get("code") != "", "code", "must be provided")
+		v.Check(qs.Get("state") != "", "state", "must be provided")
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		// Clear the state cookie whatever the outcome, the state can only be used once
+		http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/v1/auth/oidc/", MaxAge: -1, HttpOnly: true, Secure: true})
+
+		cookie, err := r.Cookie(stateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(qs.Get("state"))) != 1 {
+			apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "the login was not started from this browser")
+			return
+		}
+
+		user, err := completer.CompleteLogin(r.Context(), r.PathValue("provider"), qs.Get("state"), qs.Get("code"))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrUnknownProvider):
+				apiutils.NotFoundResponse(w, r, logger)
+			case errors.Is(err, ErrInvalidState):
+				apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "the login expired or was already completed, please try again")
+			case errors.Is(err, ErrTokenExchange), errors.Is(err, ErrMissingIDToken),
+				errors.Is(err, ErrInvalidIDToken), errors.Is(err, ErrInvalidNonce):
+				logger.Warn("oidc login failed", "provider", r.PathValue("provider"), "error", err)
+				apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "the login could not be verified with the provider")
+			case errors.Is(err, ErrMissingEmail):
+				apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "the provider did not share a verified email address")
+			case errors.Is(err, ErrEmailTaken):
+				apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "a user with this email address already exists, sign in with it instead")
+			case errors.Is(err, ErrUserDeleted):
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "this account has been deleted")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		if message := users.InactiveUserMessage(user); message != "" {
+			apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+			return
+		}
+
+		tokens, err := issuer.Issue(auth.ContextSetMethod(r, auth.MethodOIDC), user)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"user": user, "tokens": tokens}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("CallbackHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go-web-api-starter/internal/jwtauth"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Discovery is the subset of an OpenID Provider's discovery document the relying party uses.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is a configured OpenID Provider. Its discovery document is fetched on first use,
+// so that a provider being unreachable at startup does not prevent the API from starting.
+type Provider struct {
+	Config ProviderConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *Discovery
+	reader    *jwtauth.Reader
+}
+
+func NewProvider(cfg ProviderConfig, client *http.Client) *Provider {
+	return &Provider{Config: cfg, client: client}
+}
+
+// discover fetches and caches the discovery document, and builds the reader that verifies
+// the provider's ID tokens with the keys at its jwks_uri.
+func (p *Provider) discover(ctx context.Context) (*Discovery, *jwtauth.Reader, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, p.reader, nil
+	}
+
+	discoveryURL := strings.TrimSuffix(p.Config.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrDiscoveryFailed, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: status %d", ErrDiscoveryFailed, res.StatusCode)
+	}
+
+	var discovery Discovery
+	if err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&discovery); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrDiscoveryFailed, err)
+	}
+
+	// The issuer must match exactly, see OpenID Connect Discovery 1.0 section 4.3
+	if discovery.Issuer != p.Config.Issuer {
+		return nil, nil, ErrIssuerMismatch
+	}
+
+	policy := jwtauth.DefaultPolicy()
+	policy.RequiredClaims = map[string]jwtauth.ClaimType{
+		"iat":   jwtauth.ClaimNumber,
+		"nonce": jwtauth.ClaimString,
+	}
+
+	reader, err := jwtauth.NewReaderFromConfig(jwtauth.ReaderConfig{
+		Settings: jwtauth.Settings{Issuer: discovery.Issuer, Audience: []string{p.Config.ClientID}},
+		JWKS:     jwtauth.NewJWKS(discovery.JWKSURI, jwtauth.WithHTTPClient(p.client)),
+		Policy:   &policy,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.discovery = &discovery
+	p.reader = reader
+
+	return p.discovery, p.reader, nil
+}
+
+// AuthCodeURL returns the URL of the provider's authorization endpoint to send the user to.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	discovery, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization endpoint: %w", ErrDiscoveryFailed, err)
+	}
+
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.Config.ClientID)
+	query.Set("redirect_uri", p.Config.RedirectURL)
+	query.Set("scope", strings.Join(p.Config.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+
+	return authURL.String(), nil
+}
+
+// Exchange redeems the authorization code at the token endpoint and returns the ID token.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	discovery, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"code_verifier": {codeVerifier},
+	}
+	if p.Config.ClientSecret == "" {
+		form.Set("client_id", p.Config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrTokenExchange, err)
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrTokenExchange, err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: %s %s", ErrTokenExchange, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", ErrMissingIDToken
+	}
+
+	return body.IDToken, nil
+}
+
+// Verify checks the ID token's signature with the provider's keys, validates its claims
+// and that its nonce is the one the login was started with.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*jwtauth.Claims, error) {
+	_, reader, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := reader.Read(idToken)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	if err = reader.ValidateClaims(claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, err)
+	}
+
+	// When the token has several audiences the authorized party must be this client
+	if azp, ok := claims.Get("azp"); ok && len(claims.Audience) > 1 && azp != p.Config.ClientID {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidIDToken, jwtauth.ErrInvalidAudience)
+	}
+
+	if got, _ := claims.Get("nonce"); got != nonce {
+		return nil, ErrInvalidNonce
+	}
+
+	return claims, nil
+}
+
+// newHTTPClient returns the client used to talk to providers when none is given.
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: 10 * time.Second}
+}
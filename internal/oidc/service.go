@@ -0,0 +1,160 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const stateTTL = 10 * time.Minute
+
+type stateRepository interface {
+	Insert(state *LoginState) error
+	Consume(state string) (*LoginState, error)
+}
+
+type identityRepository interface {
+	InsertWithUser(user *users.User, identity *Identity) error
+	Get(provider, subject string) (*Identity, error)
+}
+
+type userService interface {
+	GetById(id uuid.UUID) (*users.User, error)
+}
+
+// Service signs users in with OpenID Connect providers using the authorization code flow with PKCE.
+type Service struct {
+	logger     *slog.Logger
+	providers  map[string]*Provider
+	states     stateRepository
+	identities identityRepository
+	users      userService
+}
+
+// NewService creates a Service for the providers. A nil client uses one with a ten second timeout.
+func NewService(
+	logger *slog.Logger,
+	configs []ProviderConfig,
+	client *http.Client,
+	states stateRepository,
+	identities identityRepository,
+	users userService,
+) *Service {
+	if client == nil {
+		client = newHTTPClient()
+	}
+
+	providers := make(map[string]*Provider, len(configs))
+	for _, cfg := range configs {
+		providers[cfg.Name] = NewProvider(cfg, client)
+	}
+
+	return &Service{logger: logger, providers: providers, states: states, identities: identities, users: users}
+}
+
+// BeginLogin stores a new login state for the provider and returns it with the URL
+// to send the user to. Returns ErrUnknownProvider if no provider has the name.
+func (s *Service) BeginLogin(ctx context.Context, providerName string) (*LoginState, string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, "", ErrUnknownProvider
+	}
+
+	state := &LoginState{Provider: providerName, ExpiresAt: time.Now().Add(stateTTL)}
+	for _, field := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		value, err := randomString(32)
+		if err != nil {
+			return nil, "", err
+		}
+		*field = value
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, state.State, state.Nonce, codeChallenge(state.CodeVerifier))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err = s.states.Insert(state); err != nil {
+		return nil, "", fmt.Errorf("could not insert oidc login state: %w", err)
+	}
+
+	return state, authURL, nil
+}
+
+// CompleteLogin consumes the login state, redeems the code and verifies the ID token, then
+// returns the user linked to the token's subject. On a subject's first login a user is
+// created with the default role and the identity is linked to them.
+func (s *Service) CompleteLogin(ctx context.Context, providerName, state, code string) (*users.User, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	loginState, err := s.states.Consume(state)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrInvalidState
+		}
+		return nil, err
+	}
+	if loginState.Provider != providerName || time.Now().After(loginState.ExpiresAt) {
+		return nil, ErrInvalidState
+	}
+
+	idToken, err := provider.Exchange(ctx, code, loginState.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := provider.Verify(ctx, idToken, loginState.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.userForIdentity(provider, claims)
+}
+
+func (s *Service) userForIdentity(provider *Provider, claims *jwtauth.Claims) (*users.User, error) {
+	providerName := provider.Config.Name
+	identity, err := s.identities.Get(providerName, claims.Subject)
+	if err == nil {
+		user, err := s.users.GetById(identity.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user.IsDeleted {
+			return nil, ErrUserDeleted
+		}
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Only an email the provider verified is trusted to create a user with. Providers trusted with
+	// TrustEmail may leave the email_verified claim out, but not set it to false.
+	verified, ok := claims.Get("email_verified")
+	if claims.Email == "" || (ok && verified != true) || (!ok && !provider.Config.TrustEmail) {
+		return nil, ErrMissingEmail
+	}
+
+	user := users.NewDefaultUser(claims.Email, uuid.New())
+	identity = &Identity{Provider: providerName, Subject: claims.Subject, UserID: user.ID, Email: claims.Email}
+	if err = s.identities.InsertWithUser(user, identity); err != nil {
+		if errors.Is(err, users.ErrDuplicateEmail) {
+			return nil, ErrEmailTaken
+		}
+		return nil, fmt.Errorf("could not insert user with identity: %w", err)
+	}
+
+	s.logger.Info("user created from oidc identity", "provider", providerName, "user id", user.ID)
+
+	return s.users.GetById(user.ID)
+}
@@ -0,0 +1,390 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type memoryStateRepo struct {
+	states map[string]*LoginState
+}
+
+func (m *memoryStateRepo) Insert(state *LoginState) error {
+	m.states[state.State] = state
+	return nil
+}
+
+func (m *memoryStateRepo) Consume(state string) (*LoginState, error) {
+	s, ok := m.states[state]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	delete(m.states, state)
+	return s, nil
+}
+
+type memoryIdentityRepo struct {
+	identities map[string]*Identity
+	users      *memoryUserService
+}
+
+func (m *memoryIdentityRepo) InsertWithUser(user *users.User, identity *Identity) error {
+	if err := m.users.insert(user); err != nil {
+		return err
+	}
+	m.identities[identity.Provider+"|"+identity.Subject] = identity
+	return nil
+}
+
+func (m *memoryIdentityRepo) Get(provider, subject string) (*Identity, error) {
+	identity, ok := m.identities[provider+"|"+subject]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return identity, nil
+}
+
+type memoryUserService struct {
+	users map[uuid.UUID]*users.User
+}
+
+func (m *memoryUserService) insert(user *users.User) error {
+	for _, u := range m.users {
+		if u.Email == user.Email {
+			return users.ErrDuplicateEmail
+		}
+	}
+	m.users[user.ID] = user
+	return nil
+}
+
+func (m *memoryUserService) GetById(id uuid.UUID) (*users.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+type stubIssuer struct{}
+
+func (stubIssuer) Issue(r *http.Request, user *users.User) (*auth.Tokens, error) {
+	return &auth.Tokens{AccessToken: "access-" + user.ID.String(), TokenType: auth.TokenTypeBearer}, nil
+}
+
+type testFlow struct {
+	stub     *stubProvider
+	users    *memoryUserService
+	login    http.Handler
+	callback http.Handler
+}
+
+// newTestFlow creates a flow with the stub provider, whose config may be changed with configure.
+func newTestFlow(t *testing.T, configure ...func(cfg *ProviderConfig)) *testFlow {
+	t.Helper()
+	stub := newStubProvider(t, "test-client")
+	userService := &memoryUserService{users: map[uuid.UUID]*users.User{}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cfg := ProviderConfig{
+		Name:        "stub",
+		Issuer:      stub.Issuer(),
+		ClientID:    "test-client",
+		RedirectURL: "https://api.example.com/v1/auth/oidc/stub/callback",
+		Scopes:      []string{"openid", "email"},
+	}
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	service := NewService(
+		logger,
+		[]ProviderConfig{cfg},
+		stub.server.Client(),
+		&memoryStateRepo{states: map[string]*LoginState{}},
+		&memoryIdentityRepo{identities: map[string]*Identity{}, users: userService},
+		userService,
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /v1/auth/oidc/{provider}/login", LoginHandler(logger, service))
+	mux.Handle("GET /v1/auth/oidc/{provider}/callback", CallbackHandler(logger, service, stubIssuer{}))
+
+	return &testFlow{stub: stub, users: userService, login: mux, callback: mux}
+}
+
+// begin starts a login and returns the authorization request parameters and the state cookie.
+func (f *testFlow) begin(t *testing.T) (url.Values, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	f.login.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/auth/oidc/stub/login", nil))
+
+	if rec.Code != http.StatusFound {
+		t.Fatalf("Expected a redirect to the provider, got %d: %s", rec.Code, rec.Body)
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != stateCookie {
+		t.Fatal("Expected the state cookie to be set")
+	}
+
+	return location.Query(), cookies[0]
+}
+
+func (f *testFlow) complete(t *testing.T, state, code string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/v1/auth/oidc/stub/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	f.callback.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoginRedirectsWithPKCE(t *testing.T) {
+	flow := newTestFlow(t)
+	params, cookie := flow.begin(t)
+
+	for _, name := range []string{"state", "nonce", "code_challenge"} {
+		if params.Get(name) == "" {
+			t.Errorf("Expected the authorization request to carry a %s", name)
+		}
+	}
+	if params.Get("code_challenge_method") != "S256" || params.Get("response_type") != "code" {
+		t.Error("Expected an authorization code request with an S256 code challenge")
+	}
+	if params.Get("client_id") != "test-client" || params.Get("scope") != "openid email" {
+		t.Error("Expected the configured client id and scopes")
+	}
+	if cookie.Value != params.Get("state") || !cookie.HttpOnly || !cookie.Secure {
+		t.Error("Expected a secure http only cookie holding the state")
+	}
+}
+
+func TestLoginWithUnknownProvider(t *testing.T) {
+	flow := newTestFlow(t)
+	rec := httptest.NewRecorder()
+	flow.login.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/auth/oidc/unknown/login", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestCallbackCreatesAndLinksUser(t *testing.T) {
+	flow := newTestFlow(t)
+
+	params, cookie := flow.begin(t)
+	code := flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge"))
+	rec := flow.complete(t, params.Get("state"), code, cookie)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the first login to succeed, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(flow.users.users) != 1 {
+		t.Fatalf("Expected a user to be created on first login, got %d users", len(flow.users.users))
+	}
+
+	// Signing in again finds the linked user, even after the email changed at the provider
+	flow.stub.Email = "changed@example.com"
+	params, cookie = flow.begin(t)
+	code = flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge"))
+	rec = flow.complete(t, params.Get("state"), code, cookie)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the second login to succeed, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(flow.users.users) != 1 {
+		t.Errorf("Expected the linked user to be reused, got %d users", len(flow.users.users))
+	}
+}
+
+func TestCallbackRejectsInvalidLogins(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tamper   func(flow *testFlow, params url.Values, cookie *http.Cookie) (state, code string, c *http.Cookie)
+		expected int
+	}{
+		{
+			name: "wrong nonce",
+			tamper: func(flow *testFlow, params url.Values, cookie *http.Cookie) (string, string, *http.Cookie) {
+				flow.stub.NonceOverride = "another-nonce"
+				return params.Get("state"), flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge")), cookie
+			},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name: "wrong code verifier",
+			tamper: func(flow *testFlow, params url.Values, cookie *http.Cookie) (string, string, *http.Cookie) {
+				return params.Get("state"), flow.stub.authorize(t, params.Get("nonce"), codeChallenge("attacker-verifier")), cookie
+			},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name: "missing state cookie",
+			tamper: func(flow *testFlow, params url.Values, cookie *http.Cookie) (string, string, *http.Cookie) {
+				return params.Get("state"), flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge")), nil
+			},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown state",
+			tamper: func(flow *testFlow, params url.Values, cookie *http.Cookie) (string, string, *http.Cookie) {
+				forged := &http.Cookie{Name: stateCookie, Value: "forged"}
+				return "forged", flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge")), forged
+			},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name: "unverified email",
+			tamper: func(flow *testFlow, params url.Values, cookie *http.Cookie) (string, string, *http.Cookie) {
+				flow.stub.EmailVerified = false
+				return params.Get("state"), flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge")), cookie
+			},
+			expected: http.StatusUnauthorized,
+		},
+		{
+			name: "email not said to be verified",
+			tamper: func(flow *testFlow, params url.Values, cookie *http.Cookie) (string, string, *http.Cookie) {
+				flow.stub.OmitEmailVerified = true
+				return params.Get("state"), flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge")), cookie
+			},
+			expected: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			flow := newTestFlow(t)
+			params, cookie := flow.begin(t)
+
+			state, code, c := tc.tamper(flow, params, cookie)
+			rec := flow.complete(t, state, code, c)
+
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status code %d, got %d: %s", tc.expected, rec.Code, rec.Body)
+			}
+			if len(flow.users.users) != 0 {
+				t.Error("Expected no user to be created")
+			}
+		})
+	}
+}
+
+func TestCallbackStateIsSingleUse(t *testing.T) {
+	flow := newTestFlow(t)
+
+	params, cookie := flow.begin(t)
+	code := flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge"))
+	if rec := flow.complete(t, params.Get("state"), code, cookie); rec.Code != http.StatusOK {
+		t.Fatalf("Expected the login to succeed, got %d: %s", rec.Code, rec.Body)
+	}
+
+	code = flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge"))
+	if rec := flow.complete(t, params.Get("state"), code, cookie); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a replayed state to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestCallbackRejectsTakenEmail(t *testing.T) {
+	flow := newTestFlow(t)
+	_ = flow.users.insert(&users.User{ID: uuid.New(), Email: flow.stub.Email, Role: users.RegularRole})
+
+	params, cookie := flow.begin(t)
+	code := flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge"))
+	rec := flow.complete(t, params.Get("state"), code, cookie)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d: %s", http.StatusConflict, rec.Code, rec.Body)
+	}
+}
+
+func TestCallbackWithTrustedEmail(t *testing.T) {
+	flow := newTestFlow(t, func(cfg *ProviderConfig) { cfg.TrustEmail = true })
+
+	flow.stub.OmitEmailVerified = true
+	params, cookie := flow.begin(t)
+	code := flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge"))
+	if rec := flow.complete(t, params.Get("state"), code, cookie); rec.Code != http.StatusOK {
+		t.Fatalf("Expected an email without email_verified to be trusted, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// An email the provider says is not verified is never trusted
+	flow.stub.OmitEmailVerified = false
+	flow.stub.EmailVerified = false
+	flow.stub.Subject = "another-subject"
+	flow.stub.Email = "another@example.com"
+	params, cookie = flow.begin(t)
+	code = flow.stub.authorize(t, params.Get("nonce"), params.Get("code_challenge"))
+	if rec := flow.complete(t, params.Get("state"), code, cookie); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an unverified email to be rejected, got %d: %s", rec.Code, rec.Body)
+	}
+	if len(flow.users.users) != 1 {
+		t.Errorf("Expected a single user to be created, got %d", len(flow.users.users))
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	stub := newStubProvider(t, "test-client")
+	// The discovery document is found with the trailing slash, but it names the issuer without one
+	provider := NewProvider(ProviderConfig{Name: "stub", Issuer: stub.Issuer() + "/", ClientID: "test-client"}, stub.server.Client())
+
+	if _, _, err := provider.discover(context.Background()); !errors.Is(err, ErrIssuerMismatch) {
+		t.Errorf("Expected ErrIssuerMismatch, got %v", err)
+	}
+}
+
+func TestLoadProviderConfigs(t *testing.T) {
+	getEnv := func(key string) string {
+		if key == "STUB_SECRET" {
+			return "from-env"
+		}
+		return ""
+	}
+
+	configs, err := LoadProviderConfigs([]byte(`[{
+		"name": "stub",
+		"issuer": "https://id.example.com",
+		"clientId": "client",
+		"clientSecretEnv": "STUB_SECRET",
+		"redirectUrl": "https://api.example.com/v1/auth/oidc/stub/callback"
+	}]`), getEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configs[0].ClientSecret != "from-env" {
+		t.Error("Expected the client secret to be read from the environment")
+	}
+	if len(configs[0].Scopes) == 0 {
+		t.Error("Expected default scopes")
+	}
+
+	invalid := map[string]string{
+		"missing issuer":  `[{"name": "stub", "clientId": "client", "redirectUrl": "https://api.example.com/cb"}]`,
+		"no openid scope": `[{"name": "stub", "issuer": "https://id.example.com", "clientId": "client", "redirectUrl": "https://api.example.com/cb", "scopes": ["email"]}]`,
+		"duplicate names": `[{"name": "stub", "issuer": "https://id.example.com", "clientId": "a", "redirectUrl": "https://api.example.com/cb"},
+		                     {"name": "stub", "issuer": "https://id.example.com", "clientId": "b", "redirectUrl": "https://api.example.com/cb"}]`,
+	}
+	for name, data := range invalid {
+		if _, err = LoadProviderConfigs([]byte(data), getEnv); err == nil {
+			t.Errorf("Expected the config with %s to be rejected", name)
+		}
+	}
+}
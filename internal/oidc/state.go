@@ -0,0 +1,154 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"time"
+)
+
+// LoginState is what the relying party remembers between sending the user to the provider
+// and the provider redirecting them back. It is single use.
+type LoginState struct {
+	State        string
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	ExpiresAt    time.Time
+}
+
+// randomString returns a URL safe string of n random bytes. With n = 32 it is a valid
+// PKCE code verifier, as well as an unguessable state and nonce.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge derives the S256 PKCE code challenge from the code verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type StatePsqlRepo struct {
+	DB *database.DB
+}
+
+func (m StatePsqlRepo) Insert(state *LoginState) error {
+	query := `INSERT INTO oidc_login_states (state, provider, nonce, code_verifier, expires_at)
+              VALUES ($1, $2, $3, $4, $5)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, state.State, state.Provider, state.Nonce, state.CodeVerifier, state.ExpiresAt)
+	return err
+}
+
+// Consume deletes the state and returns it, so that it can only be used once.
+// Expired states are deleted along the way. Returns database.ErrRecordNotFound if there is none.
+func (m StatePsqlRepo) Consume(state string) (*LoginState, error) {
+	query := `DELETE FROM oidc_login_states
+              WHERE state = $1 OR expires_at < CURRENT_TIMESTAMP
+              RETURNING state, provider, nonce, code_verifier, expires_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, state)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var found *LoginState
+	for rows.Next() {
+		var s LoginState
+		if err = rows.Scan(&s.State, &s.Provider, &s.Nonce, &s.CodeVerifier, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if s.State == state {
+			found = &s
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, database.ErrRecordNotFound
+	}
+
+	return found, nil
+}
+
+// Identity links a user to their subject at an OpenID Provider.
+type Identity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	UserID      uuid.UUID `json:"userId"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"createdAt"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+}
+
+type IdentityPsqlRepo struct {
+	DB *database.DB
+}
+
+// InsertWithUser inserts the user and the identity linked to them in a single transaction, so that a user
+// is never left without the identity they signed up with. Returns users.ErrDuplicateEmail if the email is taken.
+func (m IdentityPsqlRepo) InsertWithUser(user *users.User, identity *Identity) error {
+	query := `INSERT INTO user_identities (provider, subject, user_id, email)
+              VALUES ($1, $2, $3, $4)
+              RETURNING created_at, last_login_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	insert := func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, query, identity.Provider, identity.Subject, identity.UserID, identity.Email).
+			Scan(&identity.CreatedAt, &identity.LastLoginAt)
+	}
+
+	return m.DB.WithTransaction(ctx, users.InsertUser(ctx, user), insert)
+}
+
+// Get returns the identity and records the login on it.
+// Returns database.ErrRecordNotFound if the subject is not linked to a user.
+func (m IdentityPsqlRepo) Get(provider, subject string) (*Identity, error) {
+	query := `UPDATE user_identities
+              SET last_login_at = CURRENT_TIMESTAMP
+              WHERE provider = $1 AND subject = $2
+              RETURNING provider, subject, user_id, email, created_at, last_login_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var identity Identity
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(
+		&identity.Provider,
+		&identity.Subject,
+		&identity.UserID,
+		&identity.Email,
+		&identity.CreatedAt,
+		&identity.LastLoginAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &identity, nil
+}
@@ -0,0 +1,161 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"go-web-api-starter/internal/jwtauth"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubProvider is an in-process OpenID Provider. It serves discovery, the key set and the
+// token endpoint, and signs ID tokens for the codes handed out by authorize.
+type stubProvider struct {
+	server   *httptest.Server
+	key      *ecdsa.PrivateKey
+	clientID string
+
+	mu    sync.Mutex
+	codes map[string]stubGrant
+
+	// Subject, Email and EmailVerified are put in the next ID tokens
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// OmitEmailVerified leaves the email_verified claim out of the next ID tokens when set
+	OmitEmailVerified bool
+	// NonceOverride replaces the nonce of the login in the next ID tokens when set
+	NonceOverride string
+}
+
+type stubGrant struct {
+	nonce     string
+	challenge string
+}
+
+func newStubProvider(t *testing.T, clientID string) *stubProvider {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &stubProvider{
+		key:           key,
+		clientID:      clientID,
+		codes:         map[string]stubGrant{},
+		Subject:       "stub-subject",
+		Email:         "oidc@example.com",
+		EmailVerified: true,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", stub.discovery)
+	mux.HandleFunc("GET /jwks", stub.jwks)
+	mux.HandleFunc("POST /token", stub.token)
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+
+	return stub
+}
+
+func (s *stubProvider) Issuer() string {
+	return s.server.URL
+}
+
+// authorize stands in for the user consenting at the authorization endpoint,
+// and returns the code the provider would redirect back with.
+func (s *stubProvider) authorize(t *testing.T, nonce, challenge string) string {
+	t.Helper()
+	code, err := randomString(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code] = stubGrant{nonce: nonce, challenge: challenge}
+
+	return code
+}
+
+func (s *stubProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(Discovery{
+		Issuer:                s.server.URL,
+		AuthorizationEndpoint: s.server.URL + "/authorize",
+		TokenEndpoint:         s.server.URL + "/token",
+		JWKSURI:               s.server.URL + "/jwks",
+	})
+}
+
+func (s *stubProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(jwtauth.JWKSet{Keys: []jwtauth.JWK{
+		jwtauth.NewECJWK("stub-key", &s.key.PublicKey),
+	}})
+}
+
+func (s *stubProvider) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError("invalid_request")
+		return
+	}
+
+	s.mu.Lock()
+	grant, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	if !ok || r.PostForm.Get("client_id") != s.clientID {
+		tokenError("invalid_grant")
+		return
+	}
+	if codeChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	nonce := grant.nonce
+	if s.NonceOverride != "" {
+		nonce = s.NonceOverride
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.server.URL,
+		"sub":            s.Subject,
+		"aud":            s.clientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          s.Email,
+		"email_verified": s.EmailVerified,
+	}
+	if s.OmitEmailVerified {
+		delete(claims, "email_verified")
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = "stub-key"
+
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "stub-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,19 @@
+package orgs
+
+import "errors"
+
+var (
+	// ErrDuplicateSlug is returned when an organization is created or updated with the slug of another one
+	ErrDuplicateSlug = errors.New("duplicate slug in organizations table")
+	// ErrAlreadyMember is returned when a user joins an organization they are a member of
+	ErrAlreadyMember = errors.New("user is already a member of the organization")
+	// ErrLastOwner is returned by changes that would leave an organization without an owner
+	ErrLastOwner = errors.New("change would remove the last owner of the organization")
+	// ErrInvalidInvitation is returned for invitation tokens that are unknown, expired, revoked or accepted
+	ErrInvalidInvitation = errors.New("invitation is invalid, expired or already used")
+	// ErrInvitationEmail is returned when an invitation is accepted by a user with another email than the invited one
+	ErrInvitationEmail = errors.New("invitation was sent to another email address")
+	// ErrEmailNotVerified is returned when an invitation is accepted by a user who has not verified their email,
+	// which would let anyone who signs up with the invited email join
+	ErrEmailNotVerified = errors.New("email address is not verified")
+)
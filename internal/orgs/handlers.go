@@ -0,0 +1,496 @@
+package orgs
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/policy"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type orgCreator interface {
+	Create(owner *users.User, org *Org) error
+	ListForUser(userID uuid.UUID) ([]*MembershipOrg, error)
+}
+
+type orgManager interface {
+	Update(org *Org) error
+	Delete(id uuid.UUID) error
+}
+
+type memberManager interface {
+	ListMembers(orgID uuid.UUID) ([]*Membership, error)
+	GetMembership(orgID, userID uuid.UUID) (*Membership, error)
+	UpdateMemberRole(orgID, userID uuid.UUID, role Role) (*Membership, error)
+	RemoveMember(orgID, userID uuid.UUID) error
+}
+
+type invitationManager interface {
+	Invite(org *Org, inviter *users.User, inv *Invitation) (string, error)
+	ListInvitations(orgID uuid.UUID) ([]*Invitation, error)
+	RevokeInvitation(orgID, id uuid.UUID) error
+}
+
+type invitationAccepter interface {
+	AcceptInvitation(user *users.User, token string) (*Membership, error)
+}
+
+// CreateOrgHandler creates an organization with the context user as its owner.
+func CreateOrgHandler(logger *slog.Logger, creator orgCreator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name string `json:"name"`
+			Slug string `json:"slug"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		org := &Org{Name: strings.TrimSpace(input.Name), Slug: strings.TrimSpace(input.Slug)}
+
+		v := validator.New()
+		if ValidateOrg(v, org); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if err := creator.Create(users.ContextGetUser(r), org); err != nil {
+			switch {
+			case errors.Is(err, ErrDuplicateSlug):
+				apiutils.UniqueViolationResponse(w, r, logger, "slug")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		audit.SetResource(r, "org", org.ID.String())
+		audit.SetAfter(r, org)
+
+		err := apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"org": org, "role": RoleOwner}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("CreateOrgHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListOrgsHandler responds with the organizations the context user is a member of, with their role in each.
+func ListOrgsHandler(logger *slog.Logger, lister orgCreator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		memberships, err := lister.ListForUser(users.ContextGetUserId(r))
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"orgs": memberships}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListOrgsHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// GetOrgHandler responds with the organization the request acts within, and the context user's
+// membership of it, which is null for users who are not a member.
+func GetOrgHandler(logger *slog.Logger, engine *policy.Engine) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org := ContextGetOrg(r)
+		if !policy.Authorize(w, r, logger, engine, ResourceOrg, ActionRead, org) {
+			return
+		}
+
+		membership, _ := ContextGetMembership(r)
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"org": org, "membership": membership}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("GetOrgHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// UpdateOrgHandler changes the name or slug of the organization the request acts within.
+func UpdateOrgHandler(logger *slog.Logger, engine *policy.Engine, manager orgManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org := ContextGetOrg(r)
+		if !policy.Authorize(w, r, logger, engine, ResourceOrg, ActionUpdate, org) {
+			return
+		}
+
+		var input struct {
+			Name *string `json:"name"`
+			Slug *string `json:"slug"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetResource(r, "org", org.ID.String())
+		audit.SetBefore(r, org)
+
+		updated := *org
+		if input.Name != nil {
+			updated.Name = strings.TrimSpace(*input.Name)
+		}
+		if input.Slug != nil {
+			updated.Slug = strings.TrimSpace(*input.Slug)
+		}
+
+		v := validator.New()
+		if ValidateOrg(v, &updated); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if err := manager.Update(&updated); err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			case errors.Is(err, ErrDuplicateSlug):
+				apiutils.UniqueViolationResponse(w, r, logger, "slug")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		audit.SetAfter(r, &updated)
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"org": &updated}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("UpdateOrgHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// DeleteOrgHandler deletes the organization the request acts within, with its memberships and invitations.
+func DeleteOrgHandler(logger *slog.Logger, engine *policy.Engine, manager orgManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org := ContextGetOrg(r)
+		if !policy.Authorize(w, r, logger, engine, ResourceOrg, ActionDelete, org) {
+			return
+		}
+
+		audit.SetResource(r, "org", org.ID.String())
+		audit.SetBefore(r, org)
+
+		if err := manager.Delete(org.ID); err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "organization deleted"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("DeleteOrgHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListMembersHandler responds with the members of the organization the request acts within.
+func ListMembersHandler(logger *slog.Logger, engine *policy.Engine, manager memberManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org := ContextGetOrg(r)
+		if !policy.Authorize(w, r, logger, engine, ResourceMember, ActionRead, nil) {
+			return
+		}
+
+		members, err := manager.ListMembers(org.ID)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+		members = policy.Filter(r, engine, ResourceMember, ActionRead, members)
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"members": members}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListMembersHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// getMember returns the member of the organization the request acts within with the userId in the path,
+// or writes an error response and returns nil.
+func getMember(w http.ResponseWriter, r *http.Request, logger *slog.Logger, manager memberManager) *Membership {
+	v := validator.New()
+	userID := apiutils.ReadUUIDPath(r, "userId", v)
+	if !v.Valid() {
+		apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+		return nil
+	}
+
+	member, err := manager.GetMembership(ContextGetOrg(r).ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			apiutils.NotFoundResponse(w, r, logger)
+		default:
+			apiutils.ServerErrorResponse(w, r, logger, err)
+		}
+		return nil
+	}
+
+	return member
+}
+
+// memberErrorResponse writes the response for an error changing a member.
+func memberErrorResponse(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error) {
+	switch {
+	case errors.Is(err, database.ErrRecordNotFound):
+		apiutils.NotFoundResponse(w, r, logger)
+	case errors.Is(err, ErrLastOwner):
+		apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "the organization must keep at least one owner")
+	default:
+		apiutils.ServerErrorResponse(w, r, logger, err)
+	}
+}
+
+// UpdateMemberHandler changes the role of the member with the userId in the path.
+func UpdateMemberHandler(logger *slog.Logger, engine *policy.Engine, manager memberManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		member := getMember(w, r, logger, manager)
+		if member == nil {
+			return
+		}
+		if !policy.Authorize(w, r, logger, engine, ResourceMember, ActionUpdate, member) {
+			return
+		}
+
+		var input struct {
+			Role Role `json:"role"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		if ValidateRole(v, input.Role); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "org_member", member.OrgID.String()+"/"+member.UserID.String())
+		audit.SetBefore(r, member)
+
+		updated, err := manager.UpdateMemberRole(member.OrgID, member.UserID, input.Role)
+		if err != nil {
+			memberErrorResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetAfter(r, updated)
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"member": updated}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("UpdateMemberHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RemoveMemberHandler removes the member with the userId in the path from the organization.
+// Members may remove themselves, to leave it.
+func RemoveMemberHandler(logger *slog.Logger, engine *policy.Engine, manager memberManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		member := getMember(w, r, logger, manager)
+		if member == nil {
+			return
+		}
+		if !policy.Authorize(w, r, logger, engine, ResourceMember, ActionDelete, member) {
+			return
+		}
+
+		audit.SetResource(r, "org_member", member.OrgID.String()+"/"+member.UserID.String())
+		audit.SetBefore(r, member)
+
+		if err := manager.RemoveMember(member.OrgID, member.UserID); err != nil {
+			memberErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "member removed"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RemoveMemberHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListInvitationsHandler responds with the invitations to the organization that may still be accepted.
+func ListInvitationsHandler(logger *slog.Logger, engine *policy.Engine, manager invitationManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org := ContextGetOrg(r)
+		if !policy.Authorize(w, r, logger, engine, ResourceInvitation, ActionRead, nil) {
+			return
+		}
+
+		invitations, err := manager.ListInvitations(org.ID)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"invitations": invitations}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListInvitationsHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// CreateInvitationHandler invites an email to join the organization with a role, member by default.
+// The token of the invitation is part of the response when invitations are not mailed.
+func CreateInvitationHandler(logger *slog.Logger, engine *policy.Engine, manager invitationManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Email string `json:"email"`
+			Role  Role   `json:"role"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		if input.Role == "" {
+			input.Role = RoleMember
+		}
+
+		org := ContextGetOrg(r)
+		inv := &Invitation{OrgID: org.ID, Email: strings.TrimSpace(input.Email), Role: input.Role}
+
+		v := validator.New()
+		users.ValidateEmail(v, inv.Email)
+		if ValidateRole(v, inv.Role); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if !policy.Authorize(w, r, logger, engine, ResourceInvitation, ActionCreate, inv) {
+			return
+		}
+
+		token, err := manager.Invite(org, users.ContextGetUser(r), inv)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetResource(r, "org_invitation", inv.ID.String())
+		audit.SetAfter(r, inv)
+
+		env := apiutils.Envelope{"invitation": inv}
+		if token != "" {
+			env["token"] = token
+		}
+		err = apiutils.WriteJson(w, http.StatusCreated, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("CreateInvitationHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RevokeInvitationHandler revokes the invitation to the organization with the id in the path.
+func RevokeInvitationHandler(logger *slog.Logger, engine *policy.Engine, manager invitationManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		org := ContextGetOrg(r)
+		if !policy.Authorize(w, r, logger, engine, ResourceInvitation, ActionDelete, nil) {
+			return
+		}
+
+		v := validator.New()
+		id := apiutils.ReadUUIDPath(r, "id", v)
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "org_invitation", id.String())
+
+		if err := manager.RevokeInvitation(org.ID, id); err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "invitation revoked"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RevokeInvitationHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// AcceptInvitationHandler makes the context user a member of the organization they were invited to
+// with the token.
+func AcceptInvitationHandler(logger *slog.Logger, accepter invitationAccepter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Token string `json:"token"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		if ValidateToken(v, input.Token); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		membership, err := accepter.AcceptInvitation(users.ContextGetUser(r), input.Token)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidInvitation):
+				v.AddError("token", "invalid or expired invitation")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			case errors.Is(err, ErrInvitationEmail):
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "this invitation was sent to another email address")
+			case errors.Is(err, ErrEmailNotVerified):
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "you must verify your email address to accept invitations")
+			case errors.Is(err, ErrAlreadyMember):
+				apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "you are already a member of this organization")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		audit.SetResource(r, "org_member", membership.OrgID.String()+"/"+membership.UserID.String())
+		audit.SetAfter(r, membership)
+
+		err = apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"member": membership}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("AcceptInvitationHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
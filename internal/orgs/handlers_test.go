@@ -0,0 +1,418 @@
+package orgs
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/policy"
+	"go-web-api-starter/internal/testutils"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memberKey struct {
+	orgID, userID uuid.UUID
+}
+
+type memoryOrgRepo struct {
+	orgs        map[uuid.UUID]*Org
+	members     map[memberKey]*Membership
+	invitations map[uuid.UUID]*Invitation
+}
+
+func newMemoryOrgRepo() *memoryOrgRepo {
+	return &memoryOrgRepo{
+		orgs:        map[uuid.UUID]*Org{},
+		members:     map[memberKey]*Membership{},
+		invitations: map[uuid.UUID]*Invitation{},
+	}
+}
+
+func (m *memoryOrgRepo) Create(org *Org, ownerID uuid.UUID) error {
+	for _, other := range m.orgs {
+		if other.Slug == org.Slug {
+			return ErrDuplicateSlug
+		}
+	}
+	org.CreatedAt, org.UpdatedAt = time.Now(), time.Now()
+	m.orgs[org.ID] = org
+	m.members[memberKey{org.ID, ownerID}] = &Membership{OrgID: org.ID, UserID: ownerID, Role: RoleOwner}
+	return nil
+}
+
+func (m *memoryOrgRepo) Get(id uuid.UUID) (*Org, error) {
+	org, ok := m.orgs[id]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return org, nil
+}
+
+func (m *memoryOrgRepo) GetBySlug(slug string) (*Org, error) {
+	for _, org := range m.orgs {
+		if org.Slug == slug {
+			return org, nil
+		}
+	}
+	return nil, database.ErrRecordNotFound
+}
+
+func (m *memoryOrgRepo) Update(org *Org) error {
+	if _, ok := m.orgs[org.ID]; !ok {
+		return database.ErrRecordNotFound
+	}
+	m.orgs[org.ID] = org
+	return nil
+}
+
+func (m *memoryOrgRepo) Delete(id uuid.UUID) error {
+	if _, ok := m.orgs[id]; !ok {
+		return database.ErrRecordNotFound
+	}
+	delete(m.orgs, id)
+	return nil
+}
+
+func (m *memoryOrgRepo) ListForUser(userID uuid.UUID) ([]*MembershipOrg, error) {
+	memberships := []*MembershipOrg{}
+	for key, membership := range m.members {
+		if key.userID == userID {
+			memberships = append(memberships, &MembershipOrg{Org: m.orgs[key.orgID], Role: membership.Role})
+		}
+	}
+	return memberships, nil
+}
+
+func (m *memoryOrgRepo) GetMembership(orgID, userID uuid.UUID) (*Membership, error) {
+	membership, ok := m.members[memberKey{orgID, userID}]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	copied := *membership
+	return &copied, nil
+}
+
+func (m *memoryOrgRepo) ListMembers(orgID uuid.UUID) ([]*Membership, error) {
+	memberships := []*Membership{}
+	for key, membership := range m.members {
+		if key.orgID == orgID {
+			memberships = append(memberships, membership)
+		}
+	}
+	return memberships, nil
+}
+
+func (m *memoryOrgRepo) owners(orgID uuid.UUID) int {
+	owners := 0
+	for key, membership := range m.members {
+		if key.orgID == orgID && membership.Role == RoleOwner {
+			owners++
+		}
+	}
+	return owners
+}
+
+func (m *memoryOrgRepo) UpdateMemberRole(orgID, userID uuid.UUID, role Role) (*Membership, error) {
+	membership, ok := m.members[memberKey{orgID, userID}]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	if membership.Role == RoleOwner && role != RoleOwner && m.owners(orgID) <= 1 {
+		return nil, ErrLastOwner
+	}
+	membership.Role = role
+	return membership, nil
+}
+
+func (m *memoryOrgRepo) RemoveMember(orgID, userID uuid.UUID) error {
+	membership, ok := m.members[memberKey{orgID, userID}]
+	if !ok {
+		return database.ErrRecordNotFound
+	}
+	if membership.Role == RoleOwner && m.owners(orgID) <= 1 {
+		return ErrLastOwner
+	}
+	delete(m.members, memberKey{orgID, userID})
+	return nil
+}
+
+func (m *memoryOrgRepo) InsertInvitation(inv *Invitation) error {
+	for _, other := range m.invitations {
+		if other.OrgID == inv.OrgID && strings.EqualFold(other.Email, inv.Email) && other.IsOpen(time.Now()) {
+			now := time.Now()
+			other.RevokedAt = &now
+		}
+	}
+	inv.CreatedAt = time.Now()
+	m.invitations[inv.ID] = inv
+	return nil
+}
+
+func (m *memoryOrgRepo) ListInvitations(orgID uuid.UUID) ([]*Invitation, error) {
+	invitations := []*Invitation{}
+	for _, inv := range m.invitations {
+		if inv.OrgID == orgID && inv.IsOpen(time.Now()) {
+			invitations = append(invitations, inv)
+		}
+	}
+	return invitations, nil
+}
+
+func (m *memoryOrgRepo) RevokeInvitation(orgID, id uuid.UUID) error {
+	inv, ok := m.invitations[id]
+	if !ok || inv.OrgID != orgID || inv.AcceptedAt != nil || inv.RevokedAt != nil {
+		return database.ErrRecordNotFound
+	}
+	now := time.Now()
+	inv.RevokedAt = &now
+	return nil
+}
+
+func (m *memoryOrgRepo) AcceptInvitation(tokenHash []byte, userID uuid.UUID, email string) (*Membership, error) {
+	for _, inv := range m.invitations {
+		if !bytes.Equal(inv.TokenHash, tokenHash) || !inv.IsOpen(time.Now()) {
+			continue
+		}
+		if !strings.EqualFold(inv.Email, email) {
+			return nil, ErrInvitationEmail
+		}
+		if _, ok := m.members[memberKey{inv.OrgID, userID}]; ok {
+			return nil, ErrAlreadyMember
+		}
+		now := time.Now()
+		inv.AcceptedAt = &now
+		membership := &Membership{OrgID: inv.OrgID, UserID: userID, Email: email, Role: inv.Role}
+		m.members[memberKey{inv.OrgID, userID}] = membership
+		return membership, nil
+	}
+	return nil, ErrInvalidInvitation
+}
+
+type testEnv struct {
+	repo    *memoryOrgRepo
+	service *Service
+	engine  *policy.Engine
+	mux     *http.ServeMux
+	org     *Org
+	owner   *users.User
+	member  *users.User
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	verified := time.Now()
+	env := &testEnv{
+		repo:   newMemoryOrgRepo(),
+		engine: policy.NewEngine(),
+		mux:    http.NewServeMux(),
+		owner:  &users.User{ID: uuid.New(), Email: "owner@example.com", Role: users.RegularRole, EmailVerifiedAt: &verified},
+		member: &users.User{ID: uuid.New(), Email: "member@example.com", Role: users.RegularRole, EmailVerifiedAt: &verified},
+	}
+	env.service = NewService(logger, env.repo)
+	RegisterPolicies(env.engine)
+
+	env.org = &Org{Slug: "acme", Name: "Acme"}
+	if err := env.service.Create(env.owner, env.org); err != nil {
+		t.Fatal(err)
+	}
+	env.repo.members[memberKey{env.org.ID, env.member.ID}] = &Membership{
+		OrgID: env.org.ID, UserID: env.member.ID, Role: RoleMember,
+	}
+
+	inOrg := Resolve(logger, env.service, FromPath("org"), FromHeader("X-Org-ID"), FromClaim("app_metadata.org"))
+	handle := func(pattern string, h http.Handler) {
+		env.mux.Handle(pattern, middleware.Chain(h, inOrg))
+	}
+
+	handle("GET /org", GetOrgHandler(logger, env.engine))
+	handle("GET /orgs/{org}", GetOrgHandler(logger, env.engine))
+	handle("PATCH /orgs/{org}", UpdateOrgHandler(logger, env.engine, env.service))
+	handle("GET /orgs/{org}/members", ListMembersHandler(logger, env.engine, env.service))
+	handle("PATCH /orgs/{org}/members/{userId}", UpdateMemberHandler(logger, env.engine, env.service))
+	handle("DELETE /orgs/{org}/members/{userId}", RemoveMemberHandler(logger, env.engine, env.service))
+	handle("POST /orgs/{org}/invitations", CreateInvitationHandler(logger, env.engine, env.service))
+	env.mux.Handle("POST /invitations/accept", AcceptInvitationHandler(logger, env.service))
+
+	return env
+}
+
+func (env *testEnv) do(user *users.User, method, target string, body any, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reader = bytes.NewReader(b)
+	}
+
+	req := httptest.NewRequest(method, target, reader)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req = users.ContextSetUser(req, user)
+
+	rec := httptest.NewRecorder()
+	env.mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResolve(t *testing.T) {
+	env := newTestEnv(t)
+	stranger := &users.User{ID: uuid.New(), Role: users.RegularRole}
+	staff := &users.User{ID: uuid.New(), Role: users.NewRole("admin", users.Permissions{users.PermUsersManage})}
+
+	var claims jwtauth.Claims
+	if err := json.Unmarshal([]byte(`{"app_metadata": {"org": "acme"}}`), &claims); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		user           *users.User
+		target         string
+		header         http.Header
+		claims         *jwtauth.Claims
+		expectedStatus int
+	}{
+		{"member by slug", env.member, "/orgs/acme", nil, nil, http.StatusOK},
+		{"member by id", env.member, "/orgs/" + env.org.ID.String(), nil, nil, http.StatusOK},
+		{"member by header", env.member, "/org", http.Header{"X-Org-Id": {"acme"}}, nil, http.StatusOK},
+		{"member by claim", env.member, "/org", nil, &claims, http.StatusOK},
+		{"no organization", env.member, "/org", nil, nil, http.StatusNotFound},
+		{"unknown organization", env.member, "/orgs/initech", nil, nil, http.StatusNotFound},
+		{"not a member", stranger, "/orgs/acme", nil, nil, http.StatusNotFound},
+		{"staff not a member", staff, "/orgs/acme", nil, nil, http.StatusOK},
+		{"anonymous", users.AnonymousUser, "/orgs/acme", nil, nil, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			for name, values := range tt.header {
+				req.Header[name] = values
+			}
+			req = users.ContextSetUser(req, tt.user)
+			if tt.claims != nil {
+				req = users.ContextSetClaims(req, tt.claims)
+			}
+
+			rec := httptest.NewRecorder()
+			env.mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUpdateOrgHandler(t *testing.T) {
+	env := newTestEnv(t)
+
+	rec := env.do(env.member, http.MethodPatch, "/orgs/acme", map[string]any{"name": "Acme Corp"}, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you are not authorized to access this resource")
+
+	rec = env.do(env.owner, http.MethodPatch, "/orgs/acme", map[string]any{"name": "Acme Corp"}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if env.repo.orgs[env.org.ID].Name != "Acme Corp" {
+		t.Errorf("expected the name to be updated, got %q", env.repo.orgs[env.org.ID].Name)
+	}
+}
+
+func TestMemberHandlers(t *testing.T) {
+	env := newTestEnv(t)
+	memberPath := "/orgs/acme/members/" + env.member.ID.String()
+	ownerPath := "/orgs/acme/members/" + env.owner.ID.String()
+
+	rec := env.do(env.member, http.MethodPatch, memberPath, map[string]any{"role": "owner"}, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you are not authorized to access this resource")
+
+	rec = env.do(env.owner, http.MethodPatch, ownerPath, map[string]any{"role": "member"}, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusConflict, "the organization must keep at least one owner")
+
+	rec = env.do(env.owner, http.MethodPatch, memberPath, map[string]any{"role": "root"}, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for an unknown role, got %d", rec.Code)
+	}
+
+	rec = env.do(env.owner, http.MethodPatch, memberPath, map[string]any{"role": "admin"}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if role := env.repo.members[memberKey{env.org.ID, env.member.ID}].Role; role != RoleAdmin {
+		t.Errorf("expected the member to be an admin, got %s", role)
+	}
+
+	rec = env.do(env.member, http.MethodDelete, "/orgs/acme/members/"+uuid.New().String(), nil, nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for a user who is not a member, got %d", rec.Code)
+	}
+
+	rec = env.do(env.member, http.MethodDelete, memberPath, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected members to be able to leave, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = env.do(env.owner, http.MethodDelete, ownerPath, nil, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusConflict, "the organization must keep at least one owner")
+}
+
+func TestInvitationFlow(t *testing.T) {
+	env := newTestEnv(t)
+	verified := time.Now()
+	invitee := &users.User{ID: uuid.New(), Email: "Jane@Example.com", Role: users.RegularRole, EmailVerifiedAt: &verified}
+
+	rec := env.do(env.member, http.MethodPost, "/orgs/acme/invitations", map[string]any{"email": "jane@example.com"}, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you are not authorized to access this resource")
+
+	rec = env.do(env.owner, http.MethodPost, "/orgs/acme/invitations", map[string]any{"email": "jane@example.com", "role": "admin"}, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		Invitation Invitation `json:"invitation"`
+		Token      string     `json:"token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.Token == "" {
+		t.Fatal("expected the token in the response without a mailer")
+	}
+
+	rec = env.do(env.member, http.MethodPost, "/invitations/accept", map[string]any{"token": created.Token}, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "this invitation was sent to another email address")
+
+	unverified := *invitee
+	unverified.EmailVerifiedAt = nil
+	rec = env.do(&unverified, http.MethodPost, "/invitations/accept", map[string]any{"token": created.Token}, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you must verify your email address to accept invitations")
+
+	rec = env.do(invitee, http.MethodPost, "/invitations/accept", map[string]any{"token": created.Token}, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if membership, err := env.repo.GetMembership(env.org.ID, invitee.ID); err != nil || membership.Role != RoleAdmin {
+		t.Fatalf("expected the invitee to be an admin, got %v, %v", membership, err)
+	}
+
+	rec = env.do(invitee, http.MethodPost, "/invitations/accept", map[string]any{"token": created.Token}, nil)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for a used invitation, got %d", rec.Code)
+	}
+
+	// Admins may invite members, but not owners
+	rec = env.do(invitee, http.MethodPost, "/orgs/acme/invitations", map[string]any{"email": "joe@example.com", "role": "owner"}, nil)
+	testutils.CheckJSONResponseError(t, rec, http.StatusForbidden, "you are not authorized to access this resource")
+}
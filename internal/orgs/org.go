@@ -0,0 +1,145 @@
+// Package orgs groups users into organizations, the tenants of the application. Users join an
+// organization with an invitation, and hold a role in each organization they are a member of,
+// which grants them permissions on it besides the ones of their global role.
+package orgs
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"regexp"
+	"time"
+)
+
+// Org is an organization.
+type Org struct {
+	ID        uuid.UUID `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Role is the role of a member within an organization.
+type Role string
+
+const (
+	// RoleOwner manages the organization and all its members. Organizations always have one.
+	RoleOwner Role = "owner"
+	// RoleAdmin updates the organization and invites members, but does not manage existing ones.
+	RoleAdmin Role = "admin"
+	// RoleMember reads the organization and its members.
+	RoleMember Role = "member"
+)
+
+// Roles are the organization roles, from the most to the least privileged.
+var Roles = []Role{RoleOwner, RoleAdmin, RoleMember}
+
+const (
+	PermOrgRead           = "org:read"
+	PermOrgUpdate         = "org:update"
+	PermOrgDelete         = "org:delete"
+	PermMembersRead       = "members:read"
+	PermMembersManage     = "members:manage"
+	PermInvitationsManage = "invitations:manage"
+)
+
+// rolePermissions are the permissions each role grants within its organization.
+var rolePermissions = map[Role]*users.PermissionSet{
+	RoleOwner:  users.NewPermissionSet("org:*", "members:*", "invitations:*"),
+	RoleAdmin:  users.NewPermissionSet(PermOrgRead, PermOrgUpdate, PermMembersRead, PermInvitationsManage),
+	RoleMember: users.NewPermissionSet(PermOrgRead, PermMembersRead),
+}
+
+// Membership is a user's membership of an organization. Email is the user's, for listing members.
+type Membership struct {
+	OrgID     uuid.UUID `json:"orgId"`
+	UserID    uuid.UUID `json:"userId"`
+	Email     string    `json:"email,omitempty"`
+	Role      Role      `json:"role"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Allows reports whether the member's role grants every one of the permissions within the organization.
+func (m *Membership) Allows(codes ...string) bool {
+	permissions, ok := rolePermissions[m.Role]
+	return ok && permissions.Includes(codes...)
+}
+
+// MembershipOrg is a membership of the user listing their organizations, along with the organization.
+type MembershipOrg struct {
+	Org  *Org `json:"org"`
+	Role Role `json:"role"`
+}
+
+// Invitation invites the holder of an email address to join an organization with a role.
+// Only the SHA-256 hash of its token is stored.
+type Invitation struct {
+	ID         uuid.UUID     `json:"id"`
+	OrgID      uuid.UUID     `json:"orgId"`
+	Email      string        `json:"email"`
+	Role       Role          `json:"role"`
+	TokenHash  []byte        `json:"-"`
+	InvitedBy  uuid.NullUUID `json:"invitedBy"`
+	CreatedAt  time.Time     `json:"createdAt"`
+	ExpiresAt  time.Time     `json:"expiresAt"`
+	AcceptedAt *time.Time    `json:"acceptedAt,omitempty"`
+	AcceptedBy uuid.NullUUID `json:"acceptedBy"`
+	RevokedAt  *time.Time    `json:"revokedAt,omitempty"`
+}
+
+// IsOpen reports whether the invitation may still be accepted at the time.
+func (i *Invitation) IsOpen(now time.Time) bool {
+	return i.AcceptedAt == nil && i.RevokedAt == nil && now.Before(i.ExpiresAt)
+}
+
+var slugRX = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+// ValidateOrg checks the name and slug of an organization. Slugs are lowercase words and digits
+// separated by dashes, and are not UUIDs, which identify organizations too.
+func ValidateOrg(v *validator.Validator, org *Org) {
+	v.Check(org.Name != "", "name", "must be provided")
+	v.MaxLength(org.Name, "name", 200)
+	v.Check(org.Slug != "", "slug", "must be provided")
+	v.MaxLength(org.Slug, "slug", 63)
+	v.Check(validator.Matches(org.Slug, slugRX), "slug", "must be lowercase letters and digits separated by dashes")
+	_, err := uuid.Parse(org.Slug)
+	v.Check(err != nil, "slug", "must not be a uuid")
+}
+
+// ValidateRole checks that the role is an organization role.
+func ValidateRole(v *validator.Validator, role Role) {
+	v.Check(validator.PermittedValue(role, Roles...), "role", "must be owner, admin or member")
+}
+
+// tokenBytes of randomness encode to a token of tokenLength characters.
+const (
+	tokenBytes  = 20
+	tokenLength = 32
+)
+
+// generateToken returns a new invitation token, and the hash it is stored under.
+func generateToken() (string, []byte, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	token := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// ValidateToken checks that the invitation token was provided and has the length of an issued token.
+func ValidateToken(v *validator.Validator, token string) {
+	v.Check(token != "", "token", "must be provided")
+	v.Check(len(token) == tokenLength, "token", "must be 32 bytes long")
+}
@@ -0,0 +1,113 @@
+package orgs
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/policy"
+	"go-web-api-starter/internal/users"
+)
+
+// Resource types of the organization policies.
+const (
+	ResourceOrg        = "org"
+	ResourceMember     = "org_member"
+	ResourceInvitation = "org_invitation"
+)
+
+const (
+	ActionRead   = "read"
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// actionPermissions are the organization permissions the member's role must grant for each action,
+// by resource type.
+var actionPermissions = map[string]map[string]string{
+	ResourceOrg: {
+		ActionRead:   PermOrgRead,
+		ActionUpdate: PermOrgUpdate,
+		ActionDelete: PermOrgDelete,
+	},
+	ResourceMember: {
+		ActionRead:   PermMembersRead,
+		ActionUpdate: PermMembersManage,
+		ActionDelete: PermMembersManage,
+	},
+	ResourceInvitation: {
+		ActionRead:   PermInvitationsManage,
+		ActionCreate: PermInvitationsManage,
+		ActionDelete: PermInvitationsManage,
+	},
+}
+
+// RegisterPolicies registers the policies of organizations, their members and invitations with the engine.
+// They are evaluated with the organization the request acts within, set by Resolve, and resources of it:
+// *Org, *Membership and *Invitation, or nil for actions on none in particular, like listing them.
+//
+// Members may act as their organization role allows, and users whose global role has the users:manage
+// permission may act on any organization. Besides, members may leave their organization, and only
+// owners may invite new owners.
+func RegisterPolicies(engine *policy.Engine) {
+	admins := policy.HasPermissions(users.PermUsersManage)
+
+	for resourceType := range actionPermissions {
+		engine.Register(resourceType, policy.AnyAction, policy.Authenticated(), admins, memberRole(resourceType))
+	}
+
+	engine.Register(ResourceMember, ActionDelete, policy.For(func(ctx context.Context, subject *users.User, m *Membership) policy.Decision {
+		if m != nil && m.UserID == subject.ID {
+			return policy.Allow("members may leave their organization")
+		}
+		return policy.Abstain()
+	}))
+
+	engine.Register(ResourceInvitation, ActionCreate, policy.For(func(ctx context.Context, subject *users.User, inv *Invitation) policy.Decision {
+		membership, ok := membershipFromContext(ctx)
+		if inv != nil && inv.Role == RoleOwner && ok && membership.Role != RoleOwner {
+			return policy.Deny("only owners may invite owners")
+		}
+		return policy.Abstain()
+	}))
+}
+
+// memberRole allows members of the organization the request acts within whose role grants the permission
+// of the action on the resource type. It denies actions on resources of other organizations.
+func memberRole(resourceType string) policy.Policy {
+	return policy.PolicyFunc(func(ctx context.Context, in policy.Input) policy.Decision {
+		membership, ok := membershipFromContext(ctx)
+		if !ok {
+			return policy.Abstain()
+		}
+
+		if orgID, ok := resourceOrgID(in.Resource); ok && orgID != membership.OrgID {
+			return policy.Deny(fmt.Sprintf("the %s belongs to another organization", resourceType))
+		}
+
+		code, ok := actionPermissions[resourceType][in.Action]
+		if ok && membership.Allows(code) {
+			return policy.Allow(fmt.Sprintf("org role %s has %s", membership.Role, code))
+		}
+		return policy.Abstain()
+	})
+}
+
+// resourceOrgID returns the ID of the organization the resource belongs to, if it is one of this package.
+func resourceOrgID(resource any) (uuid.UUID, bool) {
+	switch r := resource.(type) {
+	case *Org:
+		if r != nil {
+			return r.ID, true
+		}
+	case *Membership:
+		if r != nil {
+			return r.OrgID, true
+		}
+	case *Invitation:
+		if r != nil {
+			return r.OrgID, true
+		}
+	}
+	return uuid.Nil, false
+}
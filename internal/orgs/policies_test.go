@@ -0,0 +1,81 @@
+package orgs
+
+import (
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/policy"
+	"go-web-api-starter/internal/users"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicies(t *testing.T) {
+	engine := policy.NewEngine()
+	RegisterPolicies(engine)
+
+	org := &Org{ID: uuid.New(), Slug: "acme", Name: "Acme"}
+	otherOrg := &Org{ID: uuid.New(), Slug: "globex", Name: "Globex"}
+
+	regular := users.NewRole("regular", users.Permissions{})
+	owner := &users.User{ID: uuid.New(), Role: regular}
+	admin := &users.User{ID: uuid.New(), Role: regular}
+	member := &users.User{ID: uuid.New(), Role: regular}
+	staff := &users.User{ID: uuid.New(), Role: users.NewRole("admin", users.Permissions{users.PermUsersManage})}
+
+	memberships := map[*users.User]*Membership{
+		owner:  {OrgID: org.ID, UserID: owner.ID, Role: RoleOwner},
+		admin:  {OrgID: org.ID, UserID: admin.ID, Role: RoleAdmin},
+		member: {OrgID: org.ID, UserID: member.ID, Role: RoleMember},
+	}
+
+	tests := []struct {
+		name         string
+		subject      *users.User
+		resourceType string
+		action       string
+		resource     any
+		want         bool
+	}{
+		{"member reads the org", member, ResourceOrg, ActionRead, org, true},
+		{"member cannot update the org", member, ResourceOrg, ActionUpdate, org, false},
+		{"admin updates the org", admin, ResourceOrg, ActionUpdate, org, true},
+		{"admin cannot delete the org", admin, ResourceOrg, ActionDelete, org, false},
+		{"owner deletes the org", owner, ResourceOrg, ActionDelete, org, true},
+		{"owner cannot act on another org", owner, ResourceOrg, ActionRead, otherOrg, false},
+		{"staff reads any org", staff, ResourceOrg, ActionDelete, otherOrg, true},
+		{"member lists members", member, ResourceMember, ActionRead, nil, true},
+		{"member cannot change a role", member, ResourceMember, ActionUpdate, memberships[admin], false},
+		{"admin cannot change a role", admin, ResourceMember, ActionUpdate, memberships[member], false},
+		{"owner changes a role", owner, ResourceMember, ActionUpdate, memberships[admin], true},
+		{"owner removes a member", owner, ResourceMember, ActionDelete, memberships[member], true},
+		{"member leaves", member, ResourceMember, ActionDelete, memberships[member], true},
+		{"member cannot remove another member", member, ResourceMember, ActionDelete, memberships[admin], false},
+		{"staff removes a member", staff, ResourceMember, ActionDelete, memberships[member], true},
+		{"member cannot list invitations", member, ResourceInvitation, ActionRead, nil, false},
+		{"admin invites a member", admin, ResourceInvitation, ActionCreate,
+			&Invitation{OrgID: org.ID, Role: RoleMember}, true},
+		{"admin cannot invite an owner", admin, ResourceInvitation, ActionCreate,
+			&Invitation{OrgID: org.ID, Role: RoleOwner}, false},
+		{"owner invites an owner", owner, ResourceInvitation, ActionCreate,
+			&Invitation{OrgID: org.ID, Role: RoleOwner}, true},
+		{"owner cannot invite to another org", owner, ResourceInvitation, ActionCreate,
+			&Invitation{OrgID: otherOrg.ID, Role: RoleMember}, false},
+		{"staff invites an owner", staff, ResourceInvitation, ActionCreate,
+			&Invitation{OrgID: org.ID, Role: RoleOwner}, true},
+		{"anonymous user cannot read", users.AnonymousUser, ResourceOrg, ActionRead, org, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req = users.ContextSetUser(req, tt.subject)
+			req = ContextSetOrg(req, org, memberships[tt.subject])
+
+			got := policy.Check(req, engine, tt.resourceType, tt.action, tt.resource)
+
+			if got.Allowed() != tt.want {
+				t.Errorf("expected allowed to be %t, got %s", tt.want, got)
+			}
+		})
+	}
+}
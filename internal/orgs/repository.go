@@ -0,0 +1,473 @@
+package orgs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go-web-api-starter/internal/database"
+	"strings"
+	"time"
+)
+
+// OrgPsqlRepo stores organizations, their memberships and invitations. Queries on memberships and
+// invitations go through a database.Tenant, which scopes them to one organization.
+type OrgPsqlRepo struct {
+	DB *database.DB
+}
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == database.PsqlUniqueViolation
+}
+
+const orgColumns = `id, slug, name, created_at, updated_at`
+
+func scanOrg(row interface{ Scan(dest ...any) error }) (*Org, error) {
+	var org Org
+	err := row.Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt, &org.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// Create inserts the organization with the owner as its first member.
+// Returns ErrDuplicateSlug if another organization has its slug.
+func (m OrgPsqlRepo) Create(org *Org, ownerID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	insertOrg := func(tx *sql.Tx) error {
+		query := `INSERT INTO organizations (id, slug, name)
+                  VALUES ($1, $2, $3)
+                  RETURNING created_at, updated_at`
+
+		err := tx.QueryRowContext(ctx, query, org.ID, org.Slug, org.Name).Scan(&org.CreatedAt, &org.UpdatedAt)
+		if isUniqueViolation(err) {
+			return ErrDuplicateSlug
+		}
+		return err
+	}
+
+	insertOwner := func(tx *sql.Tx) error {
+		query := `INSERT INTO org_memberships (org_id, user_id, role) VALUES ($1, $2, $3)`
+
+		_, err := database.NewTenant(tx, org.ID).ExecContext(ctx, query, ownerID, RoleOwner)
+		return err
+	}
+
+	return m.DB.WithTransaction(ctx, insertOrg, insertOwner)
+}
+
+// Get returns the organization with the id. Returns database.ErrRecordNotFound if there is none.
+func (m OrgPsqlRepo) Get(id uuid.UUID) (*Org, error) {
+	query := `SELECT ` + orgColumns + ` FROM organizations WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanOrg(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetBySlug returns the organization with the slug. Returns database.ErrRecordNotFound if there is none.
+func (m OrgPsqlRepo) GetBySlug(slug string) (*Org, error) {
+	query := `SELECT ` + orgColumns + ` FROM organizations WHERE slug = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanOrg(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// Update saves the name and slug of the organization. Returns database.ErrRecordNotFound if there
+// is no such organization, and ErrDuplicateSlug if another one has the slug.
+func (m OrgPsqlRepo) Update(org *Org) error {
+	query := `UPDATE organizations
+              SET slug = $2, name = $3
+              WHERE id = $1
+              RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, org.ID, org.Slug, org.Name).Scan(&org.UpdatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return database.ErrRecordNotFound
+	case isUniqueViolation(err):
+		return ErrDuplicateSlug
+	default:
+		return err
+	}
+}
+
+// Delete deletes the organization, with its memberships and invitations.
+// Returns database.ErrRecordNotFound if there is no such organization.
+func (m OrgPsqlRepo) Delete(id uuid.UUID) error {
+	query := `DELETE FROM organizations WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func checkAffected(res sql.Result) error {
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ListForUser returns the organizations the user is a member of, with their role, by name.
+func (m OrgPsqlRepo) ListForUser(userID uuid.UUID) ([]*MembershipOrg, error) {
+	query := `SELECT o.id, o.slug, o.name, o.created_at, o.updated_at, m.role
+              FROM org_memberships m
+              JOIN organizations o ON o.id = m.org_id
+              WHERE m.user_id = $1
+              ORDER BY o.name, o.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*MembershipOrg{}
+	for rows.Next() {
+		var org Org
+		var role Role
+		if err = rows.Scan(&org.ID, &org.Slug, &org.Name, &org.CreatedAt, &org.UpdatedAt, &role); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, &MembershipOrg{Org: &org, Role: role})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+const membershipColumns = `m.org_id, m.user_id, u.email, m.role, m.created_at, m.updated_at`
+
+func scanMembership(row interface{ Scan(dest ...any) error }) (*Membership, error) {
+	var membership Membership
+	err := row.Scan(
+		&membership.OrgID,
+		&membership.UserID,
+		&membership.Email,
+		&membership.Role,
+		&membership.CreatedAt,
+		&membership.UpdatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &membership, nil
+}
+
+// GetMembership returns the user's membership of the organization.
+// Returns database.ErrRecordNotFound if they are not a member.
+func (m OrgPsqlRepo) GetMembership(orgID, userID uuid.UUID) (*Membership, error) {
+	query := `SELECT ` + membershipColumns + `
+              FROM org_memberships m
+              JOIN users u ON u.id = m.user_id
+              WHERE m.org_id = $1 AND m.user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanMembership(database.NewTenant(m.DB, orgID).QueryRowContext(ctx, query, userID))
+}
+
+// ListMembers returns the memberships of the organization, by email.
+func (m OrgPsqlRepo) ListMembers(orgID uuid.UUID) ([]*Membership, error) {
+	query := `SELECT ` + membershipColumns + `
+              FROM org_memberships m
+              JOIN users u ON u.id = m.user_id
+              WHERE m.org_id = $1
+              ORDER BY lower(u.email), m.user_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := database.NewTenant(m.DB, orgID).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []*Membership{}
+	for rows.Next() {
+		membership, err := scanMembership(rows)
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+
+// lockMember locks the user's membership of the tenant's organization, and the memberships of its owners,
+// and returns the user's role and the number of owners. Returns database.ErrRecordNotFound if the user
+// is not a member. Changes to owners are serialized by the lock, so that two of them cannot each remove
+// one of the last two owners.
+func lockMember(ctx context.Context, tenant database.Tenant, userID uuid.UUID) (Role, int, error) {
+	var role Role
+	query := `SELECT role FROM org_memberships WHERE org_id = $1 AND user_id = $2 FOR UPDATE`
+	err := tenant.QueryRowContext(ctx, query, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 0, database.ErrRecordNotFound
+		}
+		return "", 0, err
+	}
+
+	var owners int
+	query = `SELECT count(*) FROM (
+                 SELECT 1 FROM org_memberships WHERE org_id = $1 AND role = $2 FOR UPDATE
+             ) owners`
+	if err = tenant.QueryRowContext(ctx, query, RoleOwner).Scan(&owners); err != nil {
+		return "", 0, err
+	}
+
+	return role, owners, nil
+}
+
+// UpdateMemberRole changes the role of the user within the organization, and returns their membership.
+// Returns database.ErrRecordNotFound if they are not a member, and ErrLastOwner if they are its last owner
+// and the role is not owner.
+func (m OrgPsqlRepo) UpdateMemberRole(orgID, userID uuid.UUID, role Role) (*Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	update := func(tx *sql.Tx) error {
+		tenant := database.NewTenant(tx, orgID)
+
+		current, owners, err := lockMember(ctx, tenant, userID)
+		if err != nil {
+			return err
+		}
+		if current == RoleOwner && role != RoleOwner && owners <= 1 {
+			return ErrLastOwner
+		}
+
+		query := `UPDATE org_memberships SET role = $3 WHERE org_id = $1 AND user_id = $2`
+		_, err = tenant.ExecContext(ctx, query, userID, role)
+		return err
+	}
+
+	if err := m.DB.WithTransaction(ctx, update); err != nil {
+		return nil, err
+	}
+
+	return m.GetMembership(orgID, userID)
+}
+
+// RemoveMember removes the user from the organization. Returns database.ErrRecordNotFound if they are
+// not a member, and ErrLastOwner if they are its last owner.
+func (m OrgPsqlRepo) RemoveMember(orgID, userID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	remove := func(tx *sql.Tx) error {
+		tenant := database.NewTenant(tx, orgID)
+
+		current, owners, err := lockMember(ctx, tenant, userID)
+		if err != nil {
+			return err
+		}
+		if current == RoleOwner && owners <= 1 {
+			return ErrLastOwner
+		}
+
+		query := `DELETE FROM org_memberships WHERE org_id = $1 AND user_id = $2`
+		_, err = tenant.ExecContext(ctx, query, userID)
+		return err
+	}
+
+	return m.DB.WithTransaction(ctx, remove)
+}
+
+const invitationColumns = `id, org_id, email, role, token_hash, invited_by, created_at, expires_at,
+                           accepted_at, accepted_by, revoked_at`
+
+func scanInvitation(row interface{ Scan(dest ...any) error }) (*Invitation, error) {
+	var inv Invitation
+	err := row.Scan(
+		&inv.ID,
+		&inv.OrgID,
+		&inv.Email,
+		&inv.Role,
+		&inv.TokenHash,
+		&inv.InvitedBy,
+		&inv.CreatedAt,
+		&inv.ExpiresAt,
+		&inv.AcceptedAt,
+		&inv.AcceptedBy,
+		&inv.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+	return &inv, nil
+}
+
+// InsertInvitation inserts the invitation, revoking any other open invitation of its email to its organization.
+func (m OrgPsqlRepo) InsertInvitation(inv *Invitation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tenant := func(tx *sql.Tx) database.Tenant { return database.NewTenant(tx, inv.OrgID) }
+
+	revokePrevious := func(tx *sql.Tx) error {
+		query := `UPDATE org_invitations
+                  SET revoked_at = CURRENT_TIMESTAMP
+                  WHERE org_id = $1 AND lower(email) = lower($2) AND accepted_at IS NULL AND revoked_at IS NULL`
+
+		_, err := tenant(tx).ExecContext(ctx, query, inv.Email)
+		return err
+	}
+
+	insert := func(tx *sql.Tx) error {
+		query := `INSERT INTO org_invitations (org_id, id, email, role, token_hash, invited_by, expires_at)
+                  VALUES ($1, $2, $3, $4, $5, $6, $7)
+                  RETURNING created_at`
+
+		args := []any{inv.ID, inv.Email, inv.Role, inv.TokenHash, inv.InvitedBy, inv.ExpiresAt}
+		return tenant(tx).QueryRowContext(ctx, query, args...).Scan(&inv.CreatedAt)
+	}
+
+	return m.DB.WithTransaction(ctx, revokePrevious, insert)
+}
+
+// ListInvitations returns the invitations to the organization that may still be accepted, newest first.
+func (m OrgPsqlRepo) ListInvitations(orgID uuid.UUID) ([]*Invitation, error) {
+	query := `SELECT ` + invitationColumns + `
+              FROM org_invitations
+              WHERE org_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+              ORDER BY created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := database.NewTenant(m.DB, orgID).QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []*Invitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, inv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation revokes the invitation to the organization, if it was neither accepted nor revoked.
+// Returns database.ErrRecordNotFound otherwise.
+func (m OrgPsqlRepo) RevokeInvitation(orgID, id uuid.UUID) error {
+	query := `UPDATE org_invitations
+              SET revoked_at = CURRENT_TIMESTAMP
+              WHERE org_id = $1 AND id = $2 AND accepted_at IS NULL AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := database.NewTenant(m.DB, orgID).ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+// AcceptInvitation makes the user a member of the organization of the invitation stored under the hash,
+// with its role, and marks it accepted. Returns ErrInvalidInvitation if the invitation may not be accepted,
+// ErrInvitationEmail if it was sent to another email than the user's, and ErrAlreadyMember if the user
+// is a member of the organization already, in which case the invitation stays open.
+func (m OrgPsqlRepo) AcceptInvitation(tokenHash []byte, userID uuid.UUID, email string) (*Membership, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv *Invitation
+	accept := func(tx *sql.Tx) error {
+		// The organization is not known before the invitation is found, so this query is not scoped to it
+		query := `SELECT ` + invitationColumns + `
+                  FROM org_invitations
+                  WHERE token_hash = $1 AND accepted_at IS NULL AND revoked_at IS NULL
+                      AND expires_at > CURRENT_TIMESTAMP
+                  FOR UPDATE`
+
+		var err error
+		inv, err = scanInvitation(tx.QueryRowContext(ctx, query, tokenHash))
+		if err != nil {
+			if errors.Is(err, database.ErrRecordNotFound) {
+				return ErrInvalidInvitation
+			}
+			return err
+		}
+		if !strings.EqualFold(inv.Email, email) {
+			return ErrInvitationEmail
+		}
+
+		tenant := database.NewTenant(tx, inv.OrgID)
+
+		query = `INSERT INTO org_memberships (org_id, user_id, role) VALUES ($1, $2, $3)`
+		if _, err = tenant.ExecContext(ctx, query, userID, inv.Role); err != nil {
+			if isUniqueViolation(err) {
+				return ErrAlreadyMember
+			}
+			return err
+		}
+
+		query = `UPDATE org_invitations
+                 SET accepted_at = CURRENT_TIMESTAMP, accepted_by = $3
+                 WHERE org_id = $1 AND id = $2`
+		_, err = tenant.ExecContext(ctx, query, inv.ID, userID)
+		return err
+	}
+
+	if err := m.DB.WithTransaction(ctx, accept); err != nil {
+		return nil, err
+	}
+
+	return m.GetMembership(inv.OrgID, userID)
+}
@@ -0,0 +1,215 @@
+package orgs
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/mailer"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultInvitationTTL = 7 * 24 * time.Hour
+	invitationTemplate   = "org_invitation.tmpl"
+)
+
+type orgRepository interface {
+	Create(org *Org, ownerID uuid.UUID) error
+	Get(id uuid.UUID) (*Org, error)
+	GetBySlug(slug string) (*Org, error)
+	Update(org *Org) error
+	Delete(id uuid.UUID) error
+	ListForUser(userID uuid.UUID) ([]*MembershipOrg, error)
+	GetMembership(orgID, userID uuid.UUID) (*Membership, error)
+	ListMembers(orgID uuid.UUID) ([]*Membership, error)
+	UpdateMemberRole(orgID, userID uuid.UUID, role Role) (*Membership, error)
+	RemoveMember(orgID, userID uuid.UUID) error
+	InsertInvitation(inv *Invitation) error
+	ListInvitations(orgID uuid.UUID) ([]*Invitation, error)
+	RevokeInvitation(orgID, id uuid.UUID) error
+	AcceptInvitation(tokenHash []byte, userID uuid.UUID, email string) (*Membership, error)
+}
+
+type mailSender interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// Service manages organizations, their members and the invitations to join them.
+type Service struct {
+	logger        *slog.Logger
+	repo          orgRepository
+	mailer        mailSender
+	invitationTTL time.Duration
+	background    func(fn func())
+}
+
+type ServiceOption func(*Service)
+
+// WithMailer mails invitations to the invited email addresses. Without a mailer, the token of an
+// invitation is returned to whoever created it, to pass on to the invited user.
+func WithMailer(mailer mailSender) ServiceOption {
+	return func(s *Service) {
+		s.mailer = mailer
+	}
+}
+
+// WithInvitationTTL sets how long invitations may be accepted for.
+func WithInvitationTTL(ttl time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.invitationTTL = ttl
+	}
+}
+
+// WithBackground sets how emails are sent in the background, so the caller can wait for
+// them on shutdown. By default they are sent in a plain goroutine.
+func WithBackground(background func(fn func())) ServiceOption {
+	return func(s *Service) {
+		s.background = background
+	}
+}
+
+func NewService(logger *slog.Logger, repo orgRepository, opts ...ServiceOption) *Service {
+	s := &Service{
+		logger:        logger,
+		repo:          repo,
+		invitationTTL: defaultInvitationTTL,
+		background:    func(fn func()) { go fn() },
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Create creates the organization with the owner as its first member and owner.
+// Returns ErrDuplicateSlug if another organization has its slug.
+func (s *Service) Create(owner *users.User, org *Org) error {
+	org.ID = uuid.New()
+	if err := s.repo.Create(org, owner.ID); err != nil {
+		return err
+	}
+
+	s.logger.Info("organization created", "org id", org.ID, "owner id", owner.ID)
+	return nil
+}
+
+// Resolve returns the organization identified by its ID or its slug.
+// Returns database.ErrRecordNotFound if there is none.
+func (s *Service) Resolve(identifier string) (*Org, error) {
+	if id, err := uuid.Parse(identifier); err == nil {
+		return s.repo.Get(id)
+	}
+	return s.repo.GetBySlug(identifier)
+}
+
+// Update saves the name and slug of the organization.
+func (s *Service) Update(org *Org) error {
+	return s.repo.Update(org)
+}
+
+// Delete deletes the organization, with its memberships and invitations.
+func (s *Service) Delete(id uuid.UUID) error {
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+
+	s.logger.Info("organization deleted", "org id", id)
+	return nil
+}
+
+// ListForUser returns the organizations the user is a member of, with their role in each.
+func (s *Service) ListForUser(userID uuid.UUID) ([]*MembershipOrg, error) {
+	return s.repo.ListForUser(userID)
+}
+
+// GetMembership returns the user's membership of the organization.
+// Returns database.ErrRecordNotFound if they are not a member.
+func (s *Service) GetMembership(orgID, userID uuid.UUID) (*Membership, error) {
+	return s.repo.GetMembership(orgID, userID)
+}
+
+// ListMembers returns the memberships of the organization.
+func (s *Service) ListMembers(orgID uuid.UUID) ([]*Membership, error) {
+	return s.repo.ListMembers(orgID)
+}
+
+// UpdateMemberRole changes the role of the member of the organization.
+// Returns ErrLastOwner if that would leave the organization without an owner.
+func (s *Service) UpdateMemberRole(orgID, userID uuid.UUID, role Role) (*Membership, error) {
+	return s.repo.UpdateMemberRole(orgID, userID, role)
+}
+
+// RemoveMember removes the member from the organization.
+// Returns ErrLastOwner if that would leave the organization without an owner.
+func (s *Service) RemoveMember(orgID, userID uuid.UUID) error {
+	return s.repo.RemoveMember(orgID, userID)
+}
+
+// Invite invites the email to join the organization with the role of the invitation, replacing any
+// open invitation of the email. With a mailer, the invitation is mailed in the background and the
+// returned token is empty; without one, the token is returned, as it cannot be recovered later.
+func (s *Service) Invite(org *Org, inviter *users.User, inv *Invitation) (string, error) {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("could not generate invitation token: %w", err)
+	}
+
+	inv.ID = uuid.New()
+	inv.OrgID = org.ID
+	inv.TokenHash = tokenHash
+	inv.InvitedBy = uuid.NullUUID{UUID: inviter.ID, Valid: true}
+	inv.ExpiresAt = time.Now().Add(s.invitationTTL)
+
+	if err = s.repo.InsertInvitation(inv); err != nil {
+		return "", fmt.Errorf("could not insert invitation: %w", err)
+	}
+
+	if s.mailer == nil {
+		return token, nil
+	}
+
+	data := map[string]any{
+		"orgName":   org.Name,
+		"inviter":   inviter.Email,
+		"role":      inv.Role,
+		"token":     token,
+		"expiresIn": mailer.HumanizeDuration(s.invitationTTL),
+	}
+	s.background(func() {
+		if err := s.mailer.Send(inv.Email, invitationTemplate, data); err != nil {
+			s.logger.Error("failed to send invitation email", "org id", org.ID, "invitation id", inv.ID, "error", err)
+		}
+	})
+
+	return "", nil
+}
+
+// ListInvitations returns the invitations to the organization that may still be accepted.
+func (s *Service) ListInvitations(orgID uuid.UUID) ([]*Invitation, error) {
+	return s.repo.ListInvitations(orgID)
+}
+
+// RevokeInvitation revokes the open invitation to the organization with the id.
+// Returns database.ErrRecordNotFound if there is no such invitation.
+func (s *Service) RevokeInvitation(orgID, id uuid.UUID) error {
+	return s.repo.RevokeInvitation(orgID, id)
+}
+
+// AcceptInvitation makes the user a member of the organization they were invited to with the token.
+// The invitation must have been sent to the user's email, which they must have verified.
+// Returns ErrInvalidInvitation, ErrInvitationEmail, ErrEmailNotVerified or ErrAlreadyMember otherwise.
+func (s *Service) AcceptInvitation(user *users.User, token string) (*Membership, error) {
+	if !user.IsEmailVerified() {
+		return nil, ErrEmailNotVerified
+	}
+
+	membership, err := s.repo.AcceptInvitation(hashToken(token), user.ID, user.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("invitation accepted", "org id", membership.OrgID, "user id", user.ID, "role", membership.Role)
+	return membership, nil
+}
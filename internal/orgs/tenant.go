@@ -0,0 +1,152 @@
+package orgs
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const (
+	orgContextKey        = contextKey("org")
+	membershipContextKey = contextKey("membership")
+)
+
+// ContextSetOrg associates the organization the request acts within, its tenant, and the context user's
+// membership of it with the *http.Request. The membership is nil for users acting on organizations they
+// are not a member of with their global permissions.
+func ContextSetOrg(r *http.Request, org *Org, membership *Membership) *http.Request {
+	ctx := context.WithValue(r.Context(), orgContextKey, org)
+	ctx = context.WithValue(ctx, membershipContextKey, membership)
+	return r.WithContext(ctx)
+}
+
+// ContextGetOrg retrieves the organization the request acts within.
+// This method will panic if the Resolve middleware did not set one.
+func ContextGetOrg(r *http.Request) *Org {
+	org, ok := r.Context().Value(orgContextKey).(*Org)
+	if !ok || org == nil {
+		panic("missing org value in request context")
+	}
+
+	return org
+}
+
+// ContextLookupOrg retrieves the organization the request acts within, and reports whether there is one.
+func ContextLookupOrg(r *http.Request) (*Org, bool) {
+	org, ok := r.Context().Value(orgContextKey).(*Org)
+	return org, ok && org != nil
+}
+
+// ContextGetMembership retrieves the context user's membership of the organization the request
+// acts within, and reports whether they are a member.
+func ContextGetMembership(r *http.Request) (*Membership, bool) {
+	return membershipFromContext(r.Context())
+}
+
+// membershipFromContext is ContextGetMembership for policies, which are given the request context.
+func membershipFromContext(ctx context.Context) (*Membership, bool) {
+	membership, ok := ctx.Value(membershipContextKey).(*Membership)
+	return membership, ok && membership != nil
+}
+
+// TenantSource returns the ID or slug of the organization a request acts within, or "" if it names none.
+type TenantSource func(r *http.Request) string
+
+// FromPath takes the organization from the path value with the name, as in /v1/orgs/{org}.
+func FromPath(name string) TenantSource {
+	return func(r *http.Request) string {
+		return r.PathValue(name)
+	}
+}
+
+// FromHeader takes the organization from the request header with the name, such as X-Org-ID.
+func FromHeader(name string) TenantSource {
+	return func(r *http.Request) string {
+		return strings.TrimSpace(r.Header.Get(name))
+	}
+}
+
+// FromClaim takes the organization from the claim at the dotted path of the token the request was
+// authenticated with, such as app_metadata.org_id. Requests authenticated otherwise name none.
+func FromClaim(path string) TenantSource {
+	return func(r *http.Request) string {
+		claims, ok := users.ContextGetClaims(r)
+		if !ok {
+			return ""
+		}
+		value, _ := claims.Lookup(path)
+		org, _ := value.(string)
+		return org
+	}
+}
+
+type tenantResolver interface {
+	Resolve(identifier string) (*Org, error)
+	GetMembership(orgID, userID uuid.UUID) (*Membership, error)
+}
+
+// Resolve sets the organization the request acts within, taken from the first of the sources that
+// names one, and the context user's membership of it, for ContextGetOrg and ContextGetMembership.
+// Sources are usually tried from the most to the least explicit: path, header, then claim.
+//
+// Only members of the organization, and users whose global role has the users:manage permission,
+// are let through. The others get a 404 response, as requests naming no organization or an unknown
+// one do, so that the existence of organizations is not revealed to non members.
+//
+// This middleware must be called after getting the User, or it will panic.
+func Resolve(logger *slog.Logger, resolver tenantResolver, sources ...TenantSource) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := users.ContextGetUser(r)
+			if user.IsAnonymous() {
+				apiutils.AuthenticationRequiredResponse(w, r, logger)
+				return
+			}
+
+			var identifier string
+			for _, source := range sources {
+				if identifier = source(r); identifier != "" {
+					break
+				}
+			}
+			if identifier == "" {
+				apiutils.NotFoundResponse(w, r, logger)
+				return
+			}
+
+			org, err := resolver.Resolve(identifier)
+			if err != nil {
+				switch {
+				case errors.Is(err, database.ErrRecordNotFound):
+					apiutils.NotFoundResponse(w, r, logger)
+				default:
+					apiutils.ServerErrorResponse(w, r, logger, err)
+				}
+				return
+			}
+
+			membership, err := resolver.GetMembership(org.ID, user.ID)
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				if !user.Role.Allows(users.PermUsersManage) {
+					apiutils.NotFoundResponse(w, r, logger)
+					return
+				}
+				membership = nil
+			case err != nil:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+				return
+			}
+
+			next.ServeHTTP(w, ContextSetOrg(r, org, membership))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package policy
+
+import (
+	"context"
+	"go-web-api-starter/internal/users"
+	"sync"
+)
+
+type policyKey struct {
+	resourceType string
+	action       string
+}
+
+// Engine holds the policies of each resource type and action, and evaluates them.
+// It is safe for concurrent use.
+type Engine struct {
+	mu       sync.RWMutex
+	policies map[policyKey][]Policy
+}
+
+func NewEngine() *Engine {
+	return &Engine{policies: map[policyKey][]Policy{}}
+}
+
+// Register adds the policies for the action on the resource type. Policies registered for
+// AnyAction apply to every action on the type, along with the ones of the action itself.
+func (e *Engine) Register(resourceType, action string, policies ...Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key := policyKey{resourceType: resourceType, action: action}
+	e.policies[key] = append(e.policies[key], policies...)
+}
+
+// Evaluate decides whether the subject may perform the action on the resource of the type, by
+// combining the decisions of every policy registered for them. It denies actions no policy is
+// registered for.
+func (e *Engine) Evaluate(ctx context.Context, subject *users.User, resourceType, action string, resource any) Decision {
+	e.mu.RLock()
+	var policies []Policy
+	policies = append(policies, e.policies[policyKey{resourceType: resourceType, action: AnyAction}]...)
+	if action != AnyAction {
+		policies = append(policies, e.policies[policyKey{resourceType: resourceType, action: action}]...)
+	}
+	e.mu.RUnlock()
+
+	in := Input{Subject: subject, ResourceType: resourceType, Action: action, Resource: resource}
+
+	decisions := make([]Decision, 0, len(policies))
+	for _, policy := range policies {
+		decisions = append(decisions, policy.Evaluate(ctx, in))
+	}
+
+	return combine(resourceType, action, decisions)
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+)
+
+type contextKey string
+
+const resourceContextKey = contextKey("resource")
+
+// ContextSetResource associates the resource loaded for the request, and authorized, with the *http.Request.
+func ContextSetResource(r *http.Request, resource any) *http.Request {
+	ctx := context.WithValue(r.Context(), resourceContextKey, resource)
+	return r.WithContext(ctx)
+}
+
+// ContextGetResource retrieves the resource loaded for the request by Middleware, and reports whether
+// there is one of the type T.
+func ContextGetResource[T any](r *http.Request) (T, bool) {
+	resource, ok := r.Context().Value(resourceContextKey).(T)
+	return resource, ok
+}
+
+// Loader loads the resource a request acts on, usually from an ID in its path. It returns an error
+// wrapping database.ErrRecordNotFound if there is no such resource.
+type Loader func(r *http.Request) (any, error)
+
+// Check evaluates the policies of the engine for the context user of the request, which must be set.
+func Check(r *http.Request, engine *Engine, resourceType, action string, resource any) Decision {
+	return engine.Evaluate(r.Context(), users.ContextGetUser(r), resourceType, action, resource)
+}
+
+// Authorize checks that the context user of the request may perform the action on the resource.
+// If not, it logs the reasons, writes a 401 response for anonymous users and a 403 one for the others,
+// and returns false: handlers should then return without writing anything else.
+func Authorize(
+	w http.ResponseWriter,
+	r *http.Request,
+	logger *slog.Logger,
+	engine *Engine,
+	resourceType, action string,
+	resource any,
+) bool {
+	decision := Check(r, engine, resourceType, action, resource)
+	if decision.Allowed() {
+		return true
+	}
+
+	requestId, _ := middleware.GetRequestID(r)
+	logger.Info("policy denied request", middleware.RequestIdLog, requestId,
+		"resource", resourceType, "action", action, "reasons", decision.Reasons)
+
+	if users.ContextGetUser(r).IsAnonymous() {
+		apiutils.AuthenticationRequiredResponse(w, r, logger)
+	} else {
+		apiutils.ForbiddenResponse(w, r, logger)
+	}
+	return false
+}
+
+// Filter returns the resources the context user of the request may perform the action on,
+// for list handlers to leave out the others.
+func Filter[T any](r *http.Request, engine *Engine, resourceType, action string, resources []T) []T {
+	user := users.ContextGetUser(r)
+
+	allowed := make([]T, 0, len(resources))
+	for _, resource := range resources {
+		if engine.Evaluate(r.Context(), user, resourceType, action, resource).Allowed() {
+			allowed = append(allowed, resource)
+		}
+	}
+	return allowed
+}
+
+// Middleware loads the resource of the request with the loader, and only lets the request through
+// if the context user may perform the action on it, with the resource set in the request context
+// for ContextGetResource. Without a loader, the action is authorized on no resource in particular.
+// It responds 404 if the resource does not exist.
+//
+// This middleware must be called after getting the User, or it will panic.
+func Middleware(
+	logger *slog.Logger,
+	engine *Engine,
+	resourceType, action string,
+	load Loader,
+) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var resource any
+			if load != nil {
+				var err error
+				resource, err = load(r)
+				switch {
+				case errors.Is(err, database.ErrRecordNotFound):
+					apiutils.NotFoundResponse(w, r, logger)
+					return
+				case err != nil:
+					apiutils.ServerErrorResponse(w, r, logger, err)
+					return
+				}
+			}
+
+			if !Authorize(w, r, logger, engine, resourceType, action, resource) {
+				return
+			}
+
+			if resource != nil {
+				r = ContextSetResource(r, resource)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/testutils"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	engine := testEngine()
+
+	owner := &users.User{ID: uuid.New(), Role: users.NewRole("user", users.Permissions{})}
+	other := &users.User{ID: uuid.New(), Role: users.NewRole("user", users.Permissions{})}
+
+	load := func(r *http.Request) (any, error) {
+		switch r.PathValue("id") {
+		case owner.ID.String():
+			return &profile{UserID: owner.ID}, nil
+		case "missing":
+			return nil, fmt.Errorf("loading profile: %w", database.ErrRecordNotFound)
+		default:
+			return nil, errors.New("connection refused")
+		}
+	}
+
+	tests := []struct {
+		name           string
+		user           *users.User
+		id             string
+		expectedStatus int
+		expectedError  string
+	}{
+		{
+			name:           "allowed",
+			user:           owner,
+			id:             owner.ID.String(),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "denied",
+			user:           other,
+			id:             owner.ID.String(),
+			expectedStatus: http.StatusForbidden,
+			expectedError:  "you are not authorized to access this resource",
+		},
+		{
+			name:           "anonymous",
+			user:           users.AnonymousUser,
+			id:             owner.ID.String(),
+			expectedStatus: http.StatusUnauthorized,
+			expectedError:  "you must be authenticated to access this resource",
+		},
+		{
+			name:           "not found",
+			user:           owner,
+			id:             "missing",
+			expectedStatus: http.StatusNotFound,
+			expectedError:  "the requested resource could not be found",
+		},
+		{
+			name:           "load error",
+			user:           owner,
+			id:             "broken",
+			expectedStatus: http.StatusInternalServerError,
+			expectedError:  "the server encountered a problem and could not process your request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p, ok := ContextGetResource[*profile](r)
+				if !ok || p.UserID != owner.ID {
+					t.Errorf("expected the loaded profile in the context, got %v", p)
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			mux := http.NewServeMux()
+			mux.Handle("PATCH /profiles/{id}", Middleware(logger, engine, "profile", "edit", load)(next))
+
+			req := httptest.NewRequest(http.MethodPatch, "/profiles/"+tt.id, nil)
+			req = users.ContextSetUser(req, tt.user)
+			rec := httptest.NewRecorder()
+
+			mux.ServeHTTP(rec, req)
+
+			if tt.expectedError != "" {
+				testutils.CheckJSONResponseError(t, rec, tt.expectedStatus, tt.expectedError)
+			} else if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	engine := testEngine()
+	subject := &users.User{ID: uuid.New()}
+
+	profiles := []*profile{{UserID: uuid.New()}, {UserID: subject.ID}, {UserID: uuid.New()}}
+
+	req := httptest.NewRequest(http.MethodGet, "/profiles", nil)
+	req = users.ContextSetUser(req, subject)
+
+	got := Filter(req, engine, "profile", "edit", profiles)
+
+	if len(got) != 1 || got[0] != profiles[1] {
+		t.Errorf("expected only the subject's profile, got %v", got)
+	}
+}
@@ -0,0 +1,150 @@
+// Package policy decides whether a user may perform an action on a resource, for rules that depend
+// on the resource itself, like "users may edit only their own profile", and that role permissions
+// checked by users.RequirePermissions cannot express.
+//
+// Policies are registered on an Engine per resource type and action, and evaluated with the subject,
+// the loaded resource and the request context. Each policy allows, denies or abstains, with reasons:
+// a request is allowed when at least one policy allows it and none denies it.
+package policy
+
+import (
+	"context"
+	"fmt"
+	"go-web-api-starter/internal/users"
+	"strings"
+)
+
+// AnyAction registers a policy for every action on a resource type.
+const AnyAction = "*"
+
+// Effect is the outcome of a policy.
+type Effect string
+
+const (
+	// EffectAllow allows the action, unless another policy denies it.
+	EffectAllow Effect = "allow"
+	// EffectDeny denies the action, whatever the other policies decide.
+	EffectDeny Effect = "deny"
+	// EffectAbstain leaves the decision to the other policies.
+	EffectAbstain Effect = "abstain"
+)
+
+// Decision is the effect of a policy, or of all the policies for an action, and the reasons for it.
+type Decision struct {
+	Effect  Effect   `json:"effect"`
+	Reasons []string `json:"reasons"`
+}
+
+// Allow returns a decision allowing the action for the reason.
+func Allow(reason string) Decision {
+	return Decision{Effect: EffectAllow, Reasons: []string{reason}}
+}
+
+// Deny returns a decision denying the action for the reason.
+func Deny(reason string) Decision {
+	return Decision{Effect: EffectDeny, Reasons: []string{reason}}
+}
+
+// Abstain returns a decision leaving the action to other policies.
+func Abstain() Decision {
+	return Decision{Effect: EffectAbstain}
+}
+
+// Allowed reports whether the decision allows the action.
+func (d Decision) Allowed() bool {
+	return d.Effect == EffectAllow
+}
+
+func (d Decision) String() string {
+	if len(d.Reasons) == 0 {
+		return string(d.Effect)
+	}
+	return fmt.Sprintf("%s: %s", d.Effect, strings.Join(d.Reasons, "; "))
+}
+
+// Input is what a policy decides on.
+type Input struct {
+	// Subject is the user performing the action, which may be the users.AnonymousUser.
+	Subject *users.User
+	// ResourceType and Action are what the policy was registered for.
+	ResourceType string
+	Action       string
+	// Resource is the loaded resource, or nil for actions on no resource in particular, like creating one.
+	Resource any
+}
+
+// Policy decides whether the subject of the input may perform its action on its resource.
+type Policy interface {
+	Evaluate(ctx context.Context, in Input) Decision
+}
+
+// PolicyFunc is a function used as a Policy.
+type PolicyFunc func(ctx context.Context, in Input) Decision
+
+func (f PolicyFunc) Evaluate(ctx context.Context, in Input) Decision {
+	return f(ctx, in)
+}
+
+// For returns a policy for resources of the type T, which denies the action on resources of other types.
+// A nil resource is passed to the function as the zero value of T.
+func For[T any](fn func(ctx context.Context, subject *users.User, resource T) Decision) Policy {
+	return PolicyFunc(func(ctx context.Context, in Input) Decision {
+		if in.Resource == nil {
+			var zero T
+			return fn(ctx, in.Subject, zero)
+		}
+
+		resource, ok := in.Resource.(T)
+		if !ok {
+			return Deny(fmt.Sprintf("%s is not a %T", in.ResourceType, resource))
+		}
+		return fn(ctx, in.Subject, resource)
+	})
+}
+
+// HasPermissions allows subjects whose role has every one of the permissions, and abstains otherwise,
+// so that roles like administrators may act on any resource.
+func HasPermissions(codes ...string) Policy {
+	return PolicyFunc(func(ctx context.Context, in Input) Decision {
+		if in.Subject != nil && !in.Subject.IsAnonymous() && in.Subject.Role.Allows(codes...) {
+			return Allow(fmt.Sprintf("role %s has %s", in.Subject.Role.Name, strings.Join(codes, ", ")))
+		}
+		return Abstain()
+	})
+}
+
+// Authenticated denies anonymous subjects, and abstains for the others.
+func Authenticated() Policy {
+	return PolicyFunc(func(ctx context.Context, in Input) Decision {
+		if in.Subject == nil || in.Subject.IsAnonymous() {
+			return Deny("the subject is not authenticated")
+		}
+		return Abstain()
+	})
+}
+
+// combine merges the decisions of the policies for an action: any deny wins, then any allow,
+// and the action is denied when every policy abstained.
+func combine(resourceType, action string, decisions []Decision) Decision {
+	allowed := Decision{Effect: EffectAbstain}
+	denied := Decision{Effect: EffectAbstain}
+	for _, decision := range decisions {
+		switch decision.Effect {
+		case EffectDeny:
+			denied.Effect = EffectDeny
+			denied.Reasons = append(denied.Reasons, decision.Reasons...)
+		case EffectAllow:
+			allowed.Effect = EffectAllow
+			allowed.Reasons = append(allowed.Reasons, decision.Reasons...)
+		}
+	}
+
+	switch {
+	case denied.Effect == EffectDeny:
+		return denied
+	case allowed.Effect == EffectAllow:
+		return allowed
+	default:
+		return Deny(fmt.Sprintf("no policy allows %s on %s", action, resourceType))
+	}
+}
@@ -0,0 +1,234 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/users"
+	"slices"
+	"testing"
+)
+
+// profile and member are the resources of the example policies
+type profile struct {
+	UserID uuid.UUID
+}
+
+type member struct {
+	UserID uuid.UUID
+	OrgID  int64
+}
+
+// orgsKey lets tests pass the organizations managed by the subject in the context,
+// the way a middleware would after loading them
+type orgsKey struct{}
+
+func testEngine() *Engine {
+	engine := NewEngine()
+
+	// Users may edit only their own profile, unless they manage users
+	engine.Register("profile", "edit",
+		Authenticated(),
+		HasPermissions(users.PermUsersManage),
+		For(func(ctx context.Context, subject *users.User, p *profile) Decision {
+			if p != nil && p.UserID == subject.ID {
+				return Allow("the profile is the subject's own")
+			}
+			return Abstain()
+		}),
+	)
+
+	// Managers may see users in their org
+	engine.Register("member", "read",
+		For(func(ctx context.Context, subject *users.User, m *member) Decision {
+			managed, _ := ctx.Value(orgsKey{}).([]int64)
+			if m != nil && slices.Contains(managed, m.OrgID) {
+				return Allow(fmt.Sprintf("the subject manages org %d", m.OrgID))
+			}
+			return Abstain()
+		}),
+	)
+
+	// Suspended users may do nothing with members, whatever else allows them to
+	engine.Register("member", AnyAction, PolicyFunc(func(ctx context.Context, in Input) Decision {
+		if in.Subject.Suspension != nil {
+			return Deny("the subject is suspended")
+		}
+		return Abstain()
+	}))
+
+	return engine
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	engine := testEngine()
+
+	owner := &users.User{ID: uuid.New(), Role: users.NewRole("user", users.Permissions{})}
+	other := &users.User{ID: uuid.New(), Role: users.NewRole("user", users.Permissions{})}
+	admin := &users.User{ID: uuid.New(), Role: users.NewRole("admin", users.Permissions{"users:*"})}
+	suspended := &users.User{ID: uuid.New(), Suspension: &users.Suspension{}}
+
+	tests := []struct {
+		name         string
+		subject      *users.User
+		orgs         []int64
+		resourceType string
+		action       string
+		resource     any
+		want         Effect
+		wantReasons  []string
+	}{
+		{
+			name:         "owner edits own profile",
+			subject:      owner,
+			resourceType: "profile",
+			action:       "edit",
+			resource:     &profile{UserID: owner.ID},
+			want:         EffectAllow,
+			wantReasons:  []string{"the profile is the subject's own"},
+		},
+		{
+			name:         "user edits another profile",
+			subject:      other,
+			resourceType: "profile",
+			action:       "edit",
+			resource:     &profile{UserID: owner.ID},
+			want:         EffectDeny,
+			wantReasons:  []string{"no policy allows edit on profile"},
+		},
+		{
+			name:         "user manager edits any profile",
+			subject:      admin,
+			resourceType: "profile",
+			action:       "edit",
+			resource:     &profile{UserID: owner.ID},
+			want:         EffectAllow,
+			wantReasons:  []string{"role admin has users:manage"},
+		},
+		{
+			name:         "anonymous user edits a profile",
+			subject:      users.AnonymousUser,
+			resourceType: "profile",
+			action:       "edit",
+			resource:     &profile{},
+			want:         EffectDeny,
+			wantReasons:  []string{"the subject is not authenticated"},
+		},
+		{
+			name:         "wrong resource type",
+			subject:      owner,
+			resourceType: "profile",
+			action:       "edit",
+			resource:     &member{UserID: owner.ID},
+			want:         EffectDeny,
+			wantReasons:  []string{"profile is not a *policy.profile"},
+		},
+		{
+			name:         "no resource",
+			subject:      owner,
+			resourceType: "profile",
+			action:       "edit",
+			want:         EffectDeny,
+			wantReasons:  []string{"no policy allows edit on profile"},
+		},
+		{
+			name:         "manager sees user in their org",
+			subject:      other,
+			orgs:         []int64{1, 2},
+			resourceType: "member",
+			action:       "read",
+			resource:     &member{UserID: owner.ID, OrgID: 2},
+			want:         EffectAllow,
+			wantReasons:  []string{"the subject manages org 2"},
+		},
+		{
+			name:         "manager sees user in another org",
+			subject:      other,
+			orgs:         []int64{1},
+			resourceType: "member",
+			action:       "read",
+			resource:     &member{UserID: owner.ID, OrgID: 2},
+			want:         EffectDeny,
+		},
+		{
+			name:         "deny wins over allow",
+			subject:      suspended,
+			orgs:         []int64{2},
+			resourceType: "member",
+			action:       "read",
+			resource:     &member{UserID: owner.ID, OrgID: 2},
+			want:         EffectDeny,
+			wantReasons:  []string{"the subject is suspended"},
+		},
+		{
+			name:         "action without policies",
+			subject:      other,
+			orgs:         []int64{2},
+			resourceType: "member",
+			action:       "delete",
+			resource:     &member{OrgID: 2},
+			want:         EffectDeny,
+			wantReasons:  []string{"no policy allows delete on member"},
+		},
+		{
+			name:         "resource type without policies",
+			subject:      admin,
+			resourceType: "invoice",
+			action:       "read",
+			want:         EffectDeny,
+			wantReasons:  []string{"no policy allows read on invoice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.WithValue(context.Background(), orgsKey{}, tt.orgs)
+
+			got := engine.Evaluate(ctx, tt.subject, tt.resourceType, tt.action, tt.resource)
+
+			if got.Effect != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+			if tt.wantReasons != nil && !slices.Equal(got.Reasons, tt.wantReasons) {
+				t.Errorf("expected reasons %q, got %q", tt.wantReasons, got.Reasons)
+			}
+		})
+	}
+}
+
+func TestCombine(t *testing.T) {
+	tests := []struct {
+		name      string
+		decisions []Decision
+		want      Decision
+	}{
+		{
+			name: "no decisions",
+			want: Deny("no policy allows read on doc"),
+		},
+		{
+			name:      "all abstain",
+			decisions: []Decision{Abstain(), Abstain()},
+			want:      Deny("no policy allows read on doc"),
+		},
+		{
+			name:      "allows are merged",
+			decisions: []Decision{Allow("a"), Abstain(), Allow("b")},
+			want:      Decision{Effect: EffectAllow, Reasons: []string{"a", "b"}},
+		},
+		{
+			name:      "denies win and are merged",
+			decisions: []Decision{Deny("a"), Allow("b"), Deny("c")},
+			want:      Decision{Effect: EffectDeny, Reasons: []string{"a", "c"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := combine("doc", "read", tt.decisions)
+			if got.Effect != tt.want.Effect || !slices.Equal(got.Reasons, tt.want.Reasons) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
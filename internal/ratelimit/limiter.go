@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket rate limiter per key, such as a client IP or an email address.
+// Each key may make a burst of requests at once, and earns them back at a steady rate.
+//
+// Buckets live in the memory of the instance, so with several instances the limit applies
+// to each of them. That is enough to stop a client from flooding one endpoint.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	burst   float64
+	rate    float64 // tokens per second
+	now     func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter that allows a burst of limit requests per key, refilled evenly over the period.
+func New(limit int, period time.Duration) *Limiter {
+	return &Limiter{
+		buckets: map[string]*bucket{},
+		burst:   float64(limit),
+		rate:    float64(limit) / period.Seconds(),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the key's bucket. When the bucket is empty it returns false
+// and how long until the next token is earned.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// Run forgets the keys whose bucket has refilled every interval until the context is cancelled,
+// so keys that stopped making requests do not use memory.
+func (l *Limiter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.prune()
+		}
+	}
+}
+
+func (l *Limiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"go-web-api-starter/internal/testutils"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(limit int, period time.Duration) (*Limiter, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := New(limit, period)
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterAllowsBurstThenRefills(t *testing.T) {
+	l, now := newTestLimiter(3, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("a"); !ok {
+			t.Fatalf("request %d: expected to be allowed", i+1)
+		}
+	}
+
+	ok, retryAfter := l.Allow("a")
+	if ok {
+		t.Fatal("expected the fourth request to be limited")
+	}
+	if retryAfter != 20*time.Second {
+		t.Errorf("expected to retry after 20s, got %v", retryAfter)
+	}
+
+	if ok, _ = l.Allow("b"); !ok {
+		t.Error("expected another key to have its own bucket")
+	}
+
+	*now = now.Add(20 * time.Second)
+	if ok, _ = l.Allow("a"); !ok {
+		t.Error("expected a token to be earned back after 20s")
+	}
+	if ok, _ = l.Allow("a"); ok {
+		t.Error("expected only one token to be earned back")
+	}
+}
+
+func TestLimiterPrunesFullBuckets(t *testing.T) {
+	l, now := newTestLimiter(2, time.Minute)
+	l.Allow("a")
+
+	l.prune()
+	if len(l.buckets) != 1 {
+		t.Fatal("expected a bucket that is not full to be kept")
+	}
+
+	*now = now.Add(30 * time.Second)
+	l.prune()
+	if len(l.buckets) != 0 {
+		t.Error("expected a full bucket to be pruned")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newTestLimiter(1, time.Hour)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := Middleware(logger, l, ByIP)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the first request to pass, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	testutils.CheckJSONResponseError(t, rec, http.StatusTooManyRequests, "rate limit exceeded, please try again later")
+	if got := rec.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("expected Retry-After 3600, got %q", got)
+	}
+}
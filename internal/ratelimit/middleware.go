@@ -0,0 +1,32 @@
+package ratelimit
+
+import (
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/middleware"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// ByIP keys requests by their client IP.
+func ByIP(r *http.Request) string {
+	return middleware.ClientIP(r)
+}
+
+// Middleware rejects requests over the limiter's limit for their key with a
+// 429 status and a Retry-After header.
+func Middleware(logger *slog.Logger, limiter *Limiter, key func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, retryAfter := limiter.Allow(r.Pattern + "|" + key(r))
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				apiutils.RateLimitExceededResponse(w, r, logger)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
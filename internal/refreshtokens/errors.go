@@ -0,0 +1,8 @@
+package refreshtokens
+
+import "errors"
+
+var (
+	ErrInvalidToken = errors.New("refresh token is invalid, expired or revoked")
+	ErrTokenReused  = errors.New("refresh token was already used, its family has been revoked")
+)
@@ -0,0 +1,190 @@
+package refreshtokens
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+)
+
+type rotator interface {
+	Rotate(r *http.Request, tokenString string) (*users.User, string, error)
+}
+
+type revoker interface {
+	Revoke(userID uuid.UUID, tokenString string) error
+}
+
+type sessionManager interface {
+	ListSessions(userID uuid.UUID) ([]Session, error)
+	RevokeSession(userID, sessionID uuid.UUID) error
+	RevokeAllSessions(userID uuid.UUID) error
+}
+
+type refreshTokenInput struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func readRefreshToken(w http.ResponseWriter, r *http.Request, logger *slog.Logger) (string, bool) {
+	var input refreshTokenInput
+	if err := apiutils.ReadJSON(w, r, &input); err != nil {
+		apiutils.BadRequestResponse(w, r, logger, err)
+		return "", false
+	}
+
+	v := validator.New()
+	if v.Check(input.RefreshToken != "", "refreshToken", "must be provided"); !v.Valid() {
+		apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+		return "", false
+	}
+
+	return input.RefreshToken, true
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new refresh token.
+// The presented refresh token cannot be used again.
+func RefreshHandler(
+	logger *slog.Logger,
+	rotator rotator,
+	access auth.Issuer,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := readRefreshToken(w, r, logger)
+		if !ok {
+			return
+		}
+
+		user, refreshToken, err := rotator.Rotate(r, tokenString)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrTokenReused):
+				apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "invalid or expired refresh token")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		if message := users.InactiveUserMessage(user); message != "" {
+			apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+			return
+		}
+
+		tokens, err := access.Issue(r, user)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+		tokens.RefreshToken = refreshToken
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"tokens": tokens}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RefreshHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// LogoutHandler revokes the session of the refresh token in the body, if it belongs to the authenticated user.
+// Access tokens already issued for the session stay valid until they expire.
+func LogoutHandler(
+	logger *slog.Logger,
+	revoker revoker,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString, ok := readRefreshToken(w, r, logger)
+		if !ok {
+			return
+		}
+
+		err := revoker.Revoke(users.ContextGetUserId(r), tokenString)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "signed out"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("LogoutHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListSessionsHandler responds with the authenticated user's active sessions.
+func ListSessionsHandler(
+	logger *slog.Logger,
+	sessions sessionManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, err := sessions.ListSessions(users.ContextGetUserId(r))
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"sessions": list}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListSessionsHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RevokeSessionHandler revokes the authenticated user's session with the id in the path.
+func RevokeSessionHandler(
+	logger *slog.Logger,
+	sessions sessionManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		sessionID := apiutils.ReadUUIDPath(r, "id", v)
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		err := sessions.RevokeSession(users.ContextGetUserId(r), sessionID)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "session revoked"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RevokeSessionHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RevokeAllSessionsHandler revokes every session of the authenticated user.
+func RevokeAllSessionsHandler(
+	logger *slog.Logger,
+	sessions sessionManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := sessions.RevokeAllSessions(users.ContextGetUserId(r))
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "all sessions revoked"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RevokeAllSessionsHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package refreshtokens
+
+import (
+	"go-web-api-starter/internal/auth"
+	"go-web-api-starter/internal/users"
+	"net/http"
+)
+
+// Issuer wraps an auth.Issuer so that every sign in also starts a refresh token session.
+type Issuer struct {
+	access  auth.Issuer
+	service *Service
+}
+
+func NewIssuer(access auth.Issuer, service *Service) *Issuer {
+	return &Issuer{access: access, service: service}
+}
+
+func (i *Issuer) Issue(r *http.Request, user *users.User) (*auth.Tokens, error) {
+	tokens, err := i.access.Issue(r, user)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens.RefreshToken, err = i.service.StartSession(r, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
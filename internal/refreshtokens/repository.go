@@ -0,0 +1,216 @@
+package refreshtokens
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type RefreshTokenPsqlRepo struct {
+	DB *database.DB
+}
+
+const insertQuery = `INSERT INTO refresh_tokens (id, family_id, parent_id, user_id, token_hash, user_agent, ip, expires_at)
+                     VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+                     RETURNING created_at`
+
+func insertArgs(token *RefreshToken) []any {
+	return []any{
+		token.ID,
+		token.FamilyID,
+		token.ParentID,
+		token.UserID,
+		token.TokenHash,
+		token.UserAgent,
+		token.IP,
+		token.ExpiresAt,
+	}
+}
+
+func (m RefreshTokenPsqlRepo) Insert(token *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, insertQuery, insertArgs(token)...).Scan(&token.CreatedAt)
+}
+
+// GetByHash returns the token stored under the hash, whatever its state.
+// Returns database.ErrRecordNotFound if there is none.
+func (m RefreshTokenPsqlRepo) GetByHash(tokenHash []byte) (*RefreshToken, error) {
+	query := `SELECT id, family_id, parent_id, user_id, token_hash, user_agent, ip,
+                     created_at, expires_at, rotated_at, revoked_at
+              FROM refresh_tokens
+              WHERE token_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token RefreshToken
+	err := m.DB.QueryRowContext(ctx, query, tokenHash).Scan(
+		&token.ID,
+		&token.FamilyID,
+		&token.ParentID,
+		&token.UserID,
+		&token.TokenHash,
+		&token.UserAgent,
+		&token.IP,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.RotatedAt,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Rotate marks the current token as rotated and inserts the next one in the same transaction.
+// Returns database.ErrEditConflict if the current token was rotated or revoked in the meantime,
+// so that of two concurrent refreshes with the same token only one succeeds.
+func (m RefreshTokenPsqlRepo) Rotate(current *RefreshToken, next *RefreshToken) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	markRotated := func(tx *sql.Tx) error {
+		query := `UPDATE refresh_tokens
+                  SET rotated_at = CURRENT_TIMESTAMP
+                  WHERE id = $1 AND rotated_at IS NULL AND revoked_at IS NULL`
+
+		res, err := tx.ExecContext(ctx, query, current.ID)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return database.ErrEditConflict
+		}
+		return nil
+	}
+
+	insertNext := func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, insertQuery, insertArgs(next)...).Scan(&next.CreatedAt)
+	}
+
+	return m.DB.WithTransaction(ctx, markRotated, insertNext)
+}
+
+// RevokeFamily revokes every token of the family that is not revoked yet.
+func (m RefreshTokenPsqlRepo) RevokeFamily(familyID uuid.UUID) error {
+	query := `UPDATE refresh_tokens
+              SET revoked_at = CURRENT_TIMESTAMP
+              WHERE family_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// RevokeSession revokes the family if it belongs to the user and can still be refreshed.
+// Returns database.ErrRecordNotFound otherwise.
+func (m RefreshTokenPsqlRepo) RevokeSession(userID, familyID uuid.UUID) error {
+	query := `UPDATE refresh_tokens
+              SET revoked_at = CURRENT_TIMESTAMP
+              WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, familyID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// RevokeAllForUser revokes every token of the user, signing them out of all sessions.
+func (m RefreshTokenPsqlRepo) RevokeAllForUser(userID uuid.UUID) error {
+	query := `UPDATE refresh_tokens
+              SET revoked_at = CURRENT_TIMESTAMP
+              WHERE user_id = $1 AND revoked_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// ListSessions returns the user's families that have a token which can still be refreshed,
+// most recently used first. The device and IP are the ones of the latest refresh.
+func (m RefreshTokenPsqlRepo) ListSessions(userID uuid.UUID) ([]Session, error) {
+	query := `SELECT t.family_id, f.started_at, t.created_at, t.expires_at, t.user_agent, t.ip
+              FROM refresh_tokens t
+              INNER JOIN (
+                  SELECT family_id, min(created_at) AS started_at
+                  FROM refresh_tokens
+                  WHERE user_id = $1
+                  GROUP BY family_id
+              ) f ON f.family_id = t.family_id
+              WHERE t.user_id = $1
+                AND t.rotated_at IS NULL
+                AND t.revoked_at IS NULL
+                AND t.expires_at > CURRENT_TIMESTAMP
+              ORDER BY t.created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		err = rows.Scan(&s.ID, &s.StartedAt, &s.LastUsedAt, &s.ExpiresAt, &s.UserAgent, &s.IP)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteExpired removes tokens that expired before the cutoff. Reuse of a deleted token
+// is no longer detected, it is rejected as an unknown token instead.
+func (m RefreshTokenPsqlRepo) DeleteExpired(before time.Time) (int64, error) {
+	query := `DELETE FROM refresh_tokens WHERE expires_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
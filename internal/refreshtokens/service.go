@@ -0,0 +1,195 @@
+package refreshtokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type refreshTokenRepository interface {
+	Insert(token *RefreshToken) error
+	GetByHash(tokenHash []byte) (*RefreshToken, error)
+	Rotate(current *RefreshToken, next *RefreshToken) error
+	RevokeFamily(familyID uuid.UUID) error
+	RevokeSession(userID, familyID uuid.UUID) error
+	RevokeAllForUser(userID uuid.UUID) error
+	ListSessions(userID uuid.UUID) ([]Session, error)
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+type userGetter interface {
+	GetById(id uuid.UUID) (*users.User, error)
+}
+
+// Service issues, rotates and revokes refresh tokens.
+//
+// Every refresh rotates the token: the presented token is marked as rotated and a new one
+// is issued in the same family. Presenting a rotated token again means it was copied, so
+// the whole family is revoked and both the legitimate client and the attacker have to sign in again.
+type Service struct {
+	logger *slog.Logger
+	repo   refreshTokenRepository
+	users  userGetter
+	ttl    time.Duration
+}
+
+func NewService(logger *slog.Logger, repo refreshTokenRepository, users userGetter, ttl time.Duration) *Service {
+	return &Service{logger: logger, repo: repo, users: users, ttl: ttl}
+}
+
+// StartSession issues the first refresh token of a new family for the user.
+func (s *Service) StartSession(r *http.Request, userID uuid.UUID) (string, error) {
+	token, next, err := s.newToken(r, userID, uuid.New(), uuid.NullUUID{})
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.repo.Insert(next); err != nil {
+		return "", fmt.Errorf("could not insert refresh token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Rotate exchanges a refresh token for a new one in the same family, and returns the user it belongs to.
+// Returns ErrTokenReused, after revoking the family, if the token was already rotated,
+// and ErrInvalidToken if it is unknown, expired, revoked or its user was deleted.
+func (s *Service) Rotate(r *http.Request, tokenString string) (*users.User, string, error) {
+	current, err := s.repo.GetByHash(hashToken(tokenString))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, "", ErrInvalidToken
+		}
+		return nil, "", err
+	}
+
+	switch {
+	case current.RevokedAt != nil:
+		return nil, "", ErrInvalidToken
+	case current.RotatedAt != nil:
+		return nil, "", s.revokeReusedFamily(r, current)
+	case time.Now().After(current.ExpiresAt):
+		return nil, "", ErrInvalidToken
+	}
+
+	user, err := s.users.GetById(current.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	if user.IsDeleted {
+		if err = s.repo.RevokeFamily(current.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrInvalidToken
+	}
+
+	token, next, err := s.newToken(r, current.UserID, current.FamilyID, uuid.NullUUID{UUID: current.ID, Valid: true})
+	if err != nil {
+		return nil, "", err
+	}
+
+	err = s.repo.Rotate(current, next)
+	if err != nil {
+		// Another request rotated the token between the read and the update
+		if errors.Is(err, database.ErrEditConflict) {
+			return nil, "", s.revokeReusedFamily(r, current)
+		}
+		return nil, "", err
+	}
+
+	return user, token, nil
+}
+
+func (s *Service) revokeReusedFamily(r *http.Request, token *RefreshToken) error {
+	requestId, _ := middleware.GetRequestID(r)
+	s.logger.Warn("refresh token reused, revoking its family",
+		middleware.RequestIdLog, requestId,
+		"user id", token.UserID,
+		"family id", token.FamilyID,
+		"ip", middleware.ClientIP(r),
+	)
+
+	if err := s.repo.RevokeFamily(token.FamilyID); err != nil {
+		return err
+	}
+	return ErrTokenReused
+}
+
+// Revoke revokes the family of the refresh token, if it belongs to the user.
+// Unknown tokens and tokens of other users are ignored, so signing out is idempotent.
+func (s *Service) Revoke(userID uuid.UUID, tokenString string) error {
+	token, err := s.repo.GetByHash(hashToken(tokenString))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	if token.UserID != userID {
+		return nil
+	}
+
+	return s.repo.RevokeFamily(token.FamilyID)
+}
+
+// ListSessions returns the user's sessions that can still be refreshed.
+func (s *Service) ListSessions(userID uuid.UUID) ([]Session, error) {
+	return s.repo.ListSessions(userID)
+}
+
+// RevokeSession revokes one of the user's sessions.
+// Returns database.ErrRecordNotFound if the user has no such session.
+func (s *Service) RevokeSession(userID, sessionID uuid.UUID) error {
+	return s.repo.RevokeSession(userID, sessionID)
+}
+
+// RevokeAllSessions revokes every session of the user.
+func (s *Service) RevokeAllSessions(userID uuid.UUID) error {
+	return s.repo.RevokeAllForUser(userID)
+}
+
+// Run deletes tokens that expired more than a day ago every interval until the context is cancelled.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.repo.DeleteExpired(time.Now().Add(-24 * time.Hour))
+			if err != nil {
+				s.logger.Error("failed to delete expired refresh tokens", "error", err)
+				continue
+			}
+			s.logger.Debug("deleted expired refresh tokens", "count", deleted)
+		}
+	}
+}
+
+func (s *Service) newToken(r *http.Request, userID, familyID uuid.UUID, parentID uuid.NullUUID) (string, *RefreshToken, error) {
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("could not generate refresh token: %w", err)
+	}
+
+	return token, &RefreshToken{
+		ID:        uuid.New(),
+		FamilyID:  familyID,
+		ParentID:  parentID,
+		UserID:    userID,
+		TokenHash: tokenHash,
+		UserAgent: r.UserAgent(),
+		IP:        middleware.ClientIP(r),
+		ExpiresAt: time.Now().Add(s.ttl),
+	}, nil
+}
@@ -0,0 +1,200 @@
+package refreshtokens
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockRefreshTokenRepo struct {
+	tokens []*RefreshToken
+}
+
+func (m *mockRefreshTokenRepo) Insert(token *RefreshToken) error {
+	token.CreatedAt = time.Now()
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+
+func (m *mockRefreshTokenRepo) GetByHash(tokenHash []byte) (*RefreshToken, error) {
+	for _, token := range m.tokens {
+		if bytes.Equal(token.TokenHash, tokenHash) {
+			copied := *token
+			return &copied, nil
+		}
+	}
+	return nil, database.ErrRecordNotFound
+}
+
+func (m *mockRefreshTokenRepo) Rotate(current *RefreshToken, next *RefreshToken) error {
+	for _, token := range m.tokens {
+		if token.ID == current.ID {
+			if token.RotatedAt != nil || token.RevokedAt != nil {
+				return database.ErrEditConflict
+			}
+			now := time.Now()
+			token.RotatedAt = &now
+		}
+	}
+	return m.Insert(next)
+}
+
+func (m *mockRefreshTokenRepo) RevokeFamily(familyID uuid.UUID) error {
+	now := time.Now()
+	for _, token := range m.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *mockRefreshTokenRepo) RevokeSession(userID, familyID uuid.UUID) error {
+	return m.RevokeFamily(familyID)
+}
+
+func (m *mockRefreshTokenRepo) RevokeAllForUser(userID uuid.UUID) error {
+	return nil
+}
+
+func (m *mockRefreshTokenRepo) ListSessions(userID uuid.UUID) ([]Session, error) {
+	return nil, nil
+}
+
+func (m *mockRefreshTokenRepo) DeleteExpired(before time.Time) (int64, error) {
+	return 0, nil
+}
+
+type mockUserGetter struct {
+	user *users.User
+}
+
+func (m mockUserGetter) GetById(id uuid.UUID) (*users.User, error) {
+	return m.user, nil
+}
+
+func newTestService(ttl time.Duration) (*Service, *mockRefreshTokenRepo, *users.User) {
+	user := &users.User{ID: uuid.New(), Email: "test@example.com", Role: users.RegularRole}
+	repo := &mockRefreshTokenRepo{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return NewService(logger, repo, mockUserGetter{user: user}, ttl), repo, user
+}
+
+func TestRotateIssuesNewTokenInFamily(t *testing.T) {
+	service, repo, user := newTestService(time.Hour)
+	r := httptest.NewRequest("POST", "/v1/auth/refresh", nil)
+	r.Header.Set("User-Agent", "test-agent")
+
+	first, err := service.StartSession(r, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, second, err := service.Rotate(r, first)
+	if err != nil {
+		t.Fatalf("Expected the token to be rotated, got %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("Expected user %v, got %v", user.ID, got.ID)
+	}
+	if second == first {
+		t.Error("Expected a new refresh token")
+	}
+
+	if len(repo.tokens) != 2 || repo.tokens[0].FamilyID != repo.tokens[1].FamilyID {
+		t.Fatal("Expected the new token to join the family of the first")
+	}
+	if repo.tokens[1].ParentID.UUID != repo.tokens[0].ID || repo.tokens[1].UserAgent != "test-agent" {
+		t.Error("Expected the new token to record its parent and device")
+	}
+	if bytes.Contains(repo.tokens[1].TokenHash, []byte(second)) {
+		t.Error("Expected only the hash of the token to be stored")
+	}
+}
+
+func TestRotateDetectsReuse(t *testing.T) {
+	service, repo, user := newTestService(time.Hour)
+	r := httptest.NewRequest("POST", "/v1/auth/refresh", nil)
+
+	first, _ := service.StartSession(r, user.ID)
+	_, second, err := service.Rotate(r, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = service.Rotate(r, first); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("Expected ErrTokenReused when reusing a rotated token, got %v", err)
+	}
+
+	for _, token := range repo.tokens {
+		if token.RevokedAt == nil {
+			t.Error("Expected every token of the family to be revoked")
+		}
+	}
+
+	if _, _, err = service.Rotate(r, second); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected the latest token of a revoked family to be invalid, got %v", err)
+	}
+}
+
+func TestRotateLeavesOtherFamiliesAlone(t *testing.T) {
+	service, _, user := newTestService(time.Hour)
+	r := httptest.NewRequest("POST", "/v1/auth/refresh", nil)
+
+	stolen, _ := service.StartSession(r, user.ID)
+	other, _ := service.StartSession(r, user.ID)
+
+	_, _, _ = service.Rotate(r, stolen)
+	_, _, _ = service.Rotate(r, stolen)
+
+	if _, _, err := service.Rotate(r, other); err != nil {
+		t.Errorf("Expected a token of another session to still rotate, got %v", err)
+	}
+}
+
+func TestRotateRejectsInvalidTokens(t *testing.T) {
+	service, repo, user := newTestService(time.Hour)
+	r := httptest.NewRequest("POST", "/v1/auth/refresh", nil)
+
+	expired, _ := service.StartSession(r, user.ID)
+	repo.tokens[0].ExpiresAt = time.Now().Add(-time.Minute)
+
+	revoked, _ := service.StartSession(r, user.ID)
+	_ = service.Revoke(user.ID, revoked)
+
+	testCases := map[string]string{
+		"unknown": "not-a-refresh-token",
+		"expired": expired,
+		"revoked": revoked,
+	}
+
+	for name, tokenString := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := service.Rotate(r, tokenString); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRevokeIgnoresOtherUsersTokens(t *testing.T) {
+	service, repo, user := newTestService(time.Hour)
+	r := httptest.NewRequest("POST", "/v1/auth/logout", nil)
+
+	token, _ := service.StartSession(r, user.ID)
+	if err := service.Revoke(uuid.New(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	if repo.tokens[0].RevokedAt != nil {
+		t.Error("Expected another user not to be able to revoke the session")
+	}
+}
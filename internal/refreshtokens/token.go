@@ -0,0 +1,53 @@
+package refreshtokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"time"
+)
+
+const tokenBytes = 32
+
+// RefreshToken is a stored refresh token. Only the SHA-256 hash of the token is kept.
+// Every token issued from a sign in, and the tokens it is rotated into, share a family.
+type RefreshToken struct {
+	ID        uuid.UUID
+	FamilyID  uuid.UUID
+	ParentID  uuid.NullUUID
+	UserID    uuid.UUID
+	TokenHash []byte
+	UserAgent string
+	IP        string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
+
+// Session is a token family that can still be refreshed, as shown to the user it belongs to.
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	StartedAt  time.Time `json:"startedAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+}
+
+// generateToken returns a new opaque token and the hash it is stored under.
+func generateToken() (string, []byte, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
@@ -0,0 +1,147 @@
+package revocation
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type revoker interface {
+	RevokeToken(rev *TokenRevocation) error
+	RevokeUser(rev *UserRevocation) error
+}
+
+func actorID(r *http.Request) uuid.NullUUID {
+	return uuid.NullUUID{UUID: users.ContextGetUserId(r), Valid: true}
+}
+
+// RevokeTokenHandler revokes a single token by its jti. The revocation is kept until the
+// expiresAt in the body, which should be the token's exp, or for the store's maximum token
+// lifetime when it is not given.
+func RevokeTokenHandler(
+	logger *slog.Logger,
+	revoker revoker,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			JTI       string     `json:"jti"`
+			UserID    *uuid.UUID `json:"userId"`
+			ExpiresAt *time.Time `json:"expiresAt"`
+			Reason    string     `json:"reason"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		v.Check(input.JTI != "", "jti", "must be provided")
+		v.MaxLength(input.JTI, "jti", 255)
+		v.MaxLength(input.Reason, "reason", 500)
+		if input.ExpiresAt != nil {
+			v.Check(input.ExpiresAt.After(time.Now()), "expiresAt", "must be in the future")
+		}
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		rev := &TokenRevocation{
+			JTI:       input.JTI,
+			RevokedBy: actorID(r),
+			Reason:    input.Reason,
+		}
+		if input.UserID != nil {
+			rev.UserID = uuid.NullUUID{UUID: *input.UserID, Valid: true}
+		}
+		if input.ExpiresAt != nil {
+			rev.ExpiresAt = *input.ExpiresAt
+		}
+
+		audit.SetResource(r, "token", rev.JTI)
+
+		if err := revoker.RevokeToken(rev); err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetAfter(r, rev)
+
+		err := apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"revocation": rev}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RevokeTokenHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RevokeUserTokensHandler revokes every token issued until now to the user with the id in the path,
+// for the optional reason in the body. The user's sessions are also ended with every revoker, so that
+// they cannot get new tokens with a refresh token or keep using a cookie session.
+func RevokeUserTokensHandler(
+	logger *slog.Logger,
+	revoker revoker,
+	sessionRevokers ...users.SessionRevoker,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Reason string `json:"reason"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil && !errors.Is(err, apiutils.ErrEmptyBody) {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		userID := apiutils.ReadUUIDPath(r, "id", v)
+		v.MaxLength(input.Reason, "reason", 500)
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		rev := &UserRevocation{
+			UserID:    userID,
+			RevokedBy: actorID(r),
+			Reason:    input.Reason,
+		}
+
+		audit.SetResource(r, "user", userID.String())
+
+		if err := revoker.RevokeUser(rev); err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		for _, sessionRevoker := range sessionRevokers {
+			if err := sessionRevoker.RevokeAllSessions(userID); err != nil {
+				apiutils.ServerErrorResponse(w, r, logger, fmt.Errorf("tokens revoked but sessions not revoked: %w", err))
+				return
+			}
+		}
+
+		audit.SetAfter(r, rev)
+
+		err := apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"revocation": rev}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RevokeUserTokensHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
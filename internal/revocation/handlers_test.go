@@ -0,0 +1,55 @@
+package revocation
+
+import (
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRevokeUserTokensHandler(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	admin := &users.User{ID: uuid.New()}
+
+	testCases := map[string]struct {
+		body   string
+		reason string
+	}{
+		"with a reason":  {`{"reason": "leaked credentials"}`, "leaked credentials"},
+		"without a body": {"", ""},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			store, repo := newTestStore()
+			userID := uuid.New()
+
+			var revokedSessions []uuid.UUID
+			sessionRevoker := users.SessionRevokerFunc(func(id uuid.UUID) error {
+				revokedSessions = append(revokedSessions, id)
+				return nil
+			})
+			handler := RevokeUserTokensHandler(logger, store, sessionRevoker, sessionRevoker)
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/admin/users/"+userID.String()+"/revoke-tokens", strings.NewReader(tc.body))
+			req.SetPathValue("id", userID.String())
+			req = users.ContextSetUser(req, admin)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+			}
+			if len(repo.users) != 1 || repo.users[0].UserID != userID || repo.users[0].Reason != tc.reason {
+				t.Errorf("Expected the user's tokens to be revoked with reason %q, got %+v", tc.reason, repo.users)
+			}
+			if len(revokedSessions) != 2 || revokedSessions[0] != userID || revokedSessions[1] != userID {
+				t.Errorf("Expected the user's sessions to be revoked by every revoker, got %v", revokedSessions)
+			}
+		})
+	}
+}
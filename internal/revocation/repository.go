@@ -0,0 +1,149 @@
+package revocation
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/lib/pq"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type RevocationPsqlRepo struct {
+	DB *database.DB
+}
+
+// InsertToken stores the token revocation and announces it on NotifyChannel once committed.
+// Revoking a jti that is already revoked keeps the original revocation.
+func (m RevocationPsqlRepo) InsertToken(rev *TokenRevocation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	insert := func(tx *sql.Tx) error {
+		query := `INSERT INTO revoked_tokens (jti, user_id, expires_at, revoked_by, reason)
+                  VALUES ($1, $2, $3, $4, $5)
+                  ON CONFLICT (jti) DO UPDATE SET jti = EXCLUDED.jti
+                  RETURNING revoked_at`
+
+		return tx.QueryRowContext(ctx, query, rev.JTI, rev.UserID, rev.ExpiresAt, rev.RevokedBy, rev.Reason).
+			Scan(&rev.RevokedAt)
+	}
+
+	notify := func(tx *sql.Tx) error {
+		payload, err := json.Marshal(notification{Token: rev})
+		if err != nil {
+			return err
+		}
+		return database.Notify(ctx, tx, NotifyChannel, string(payload))
+	}
+
+	return m.DB.WithTransaction(ctx, insert, notify)
+}
+
+// UpsertUser stores the user revocation and announces it on NotifyChannel once committed.
+// A user revocation never moves RevokedBefore back in time.
+func (m RevocationPsqlRepo) UpsertUser(rev *UserRevocation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	upsert := func(tx *sql.Tx) error {
+		query := `INSERT INTO revoked_user_tokens (user_id, revoked_before, revoked_by, reason)
+                  VALUES ($1, CURRENT_TIMESTAMP, $2, $3)
+                  ON CONFLICT (user_id) DO UPDATE
+                  SET revoked_before = GREATEST(revoked_user_tokens.revoked_before, EXCLUDED.revoked_before),
+                      revoked_by = EXCLUDED.revoked_by,
+                      reason = EXCLUDED.reason,
+                      updated_at = CURRENT_TIMESTAMP
+                  RETURNING revoked_before`
+
+		return tx.QueryRowContext(ctx, query, rev.UserID, rev.RevokedBy, rev.Reason).Scan(&rev.RevokedBefore)
+	}
+
+	notify := func(tx *sql.Tx) error {
+		payload, err := json.Marshal(notification{User: rev})
+		if err != nil {
+			return err
+		}
+		return database.Notify(ctx, tx, NotifyChannel, string(payload))
+	}
+
+	err := m.DB.WithTransaction(ctx, upsert, notify)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == database.PsqlForeignKeyViolation {
+			return database.ErrRecordNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ListSince returns the unexpired token revocations and the user revocations changed after since.
+// The zero time returns all of them.
+func (m RevocationPsqlRepo) ListSince(since time.Time) ([]TokenRevocation, []UserRevocation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tokenQuery := `SELECT jti, user_id, expires_at, revoked_at, revoked_by, reason
+                   FROM revoked_tokens
+                   WHERE revoked_at > $1 AND expires_at > CURRENT_TIMESTAMP`
+
+	rows, err := m.DB.QueryContext(ctx, tokenQuery, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var tokens []TokenRevocation
+	for rows.Next() {
+		var rev TokenRevocation
+		err = rows.Scan(&rev.JTI, &rev.UserID, &rev.ExpiresAt, &rev.RevokedAt, &rev.RevokedBy, &rev.Reason)
+		if err != nil {
+			return nil, nil, err
+		}
+		tokens = append(tokens, rev)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	userQuery := `SELECT user_id, revoked_before, revoked_by, reason
+                  FROM revoked_user_tokens
+                  WHERE updated_at > $1`
+
+	userRows, err := m.DB.QueryContext(ctx, userQuery, since)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer userRows.Close()
+
+	var users []UserRevocation
+	for userRows.Next() {
+		var rev UserRevocation
+		err = userRows.Scan(&rev.UserID, &rev.RevokedBefore, &rev.RevokedBy, &rev.Reason)
+		if err != nil {
+			return nil, nil, err
+		}
+		users = append(users, rev)
+	}
+	if err = userRows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return tokens, users, nil
+}
+
+// DeleteExpired removes token revocations of tokens that have expired.
+func (m RevocationPsqlRepo) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < CURRENT_TIMESTAMP`)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
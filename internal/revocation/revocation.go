@@ -0,0 +1,35 @@
+package revocation
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// NotifyChannel is the Postgres channel revocations are announced on, so that every
+// instance applies them without waiting for its next sync.
+const NotifyChannel = "token_revocations"
+
+// TokenRevocation revokes the single token with the jti. It is kept until ExpiresAt,
+// after which the token would be rejected as expired anyway.
+type TokenRevocation struct {
+	JTI       string        `json:"jti"`
+	UserID    uuid.NullUUID `json:"userId"`
+	ExpiresAt time.Time     `json:"expiresAt"`
+	RevokedAt time.Time     `json:"revokedAt"`
+	RevokedBy uuid.NullUUID `json:"revokedBy"`
+	Reason    string        `json:"reason"`
+}
+
+// UserRevocation revokes every token of the user issued before RevokedBefore.
+type UserRevocation struct {
+	UserID        uuid.UUID     `json:"userId"`
+	RevokedBefore time.Time     `json:"revokedBefore"`
+	RevokedBy     uuid.NullUUID `json:"revokedBy"`
+	Reason        string        `json:"reason"`
+}
+
+// notification is the payload sent on NotifyChannel. Exactly one of the fields is set.
+type notification struct {
+	Token *TokenRevocation `json:"token,omitempty"`
+	User  *UserRevocation  `json:"user,omitempty"`
+}
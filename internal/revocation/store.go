@@ -0,0 +1,214 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/jwtauth"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// syncOverlap is how far back each sync looks past the previous one, to pick up revocations
+// from transactions that committed after a later one had already been synced.
+const syncOverlap = time.Minute
+
+const defaultMaxTokenLifetime = 24 * time.Hour
+
+type revocationRepository interface {
+	InsertToken(rev *TokenRevocation) error
+	UpsertUser(rev *UserRevocation) error
+	ListSince(since time.Time) ([]TokenRevocation, []UserRevocation, error)
+	DeleteExpired() (int64, error)
+}
+
+// Store keeps revocations in Postgres and answers IsRevoked from an in-memory copy, so that
+// checking a token on every request does not cost a query. The copy is kept up to date by
+// Run, which syncs it on an interval, and by Apply, which is fed the revocations other
+// instances announce on NotifyChannel.
+type Store struct {
+	logger           *slog.Logger
+	repo             revocationRepository
+	maxTokenLifetime time.Duration
+
+	mu       sync.RWMutex
+	tokens   map[string]time.Time
+	users    map[uuid.UUID]time.Time
+	lastSync time.Time
+}
+
+type StoreOption func(*Store)
+
+// WithMaxTokenLifetime sets how long a token revocation without an expiry is kept.
+// It must be at least the lifetime of the longest lived tokens the API accepts.
+func WithMaxTokenLifetime(lifetime time.Duration) StoreOption {
+	return func(s *Store) {
+		s.maxTokenLifetime = lifetime
+	}
+}
+
+func NewStore(logger *slog.Logger, repo revocationRepository, opts ...StoreOption) *Store {
+	s := &Store{
+		logger:           logger,
+		repo:             repo,
+		maxTokenLifetime: defaultMaxTokenLifetime,
+		tokens:           make(map[string]time.Time),
+		users:            make(map[uuid.UUID]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// IsRevoked reports whether the token's jti was revoked, or whether its subject had all their
+// tokens revoked after it was issued. When a user's tokens are revoked, tokens without an iat
+// claim are considered revoked too.
+func (s *Store) IsRevoked(claims *jwtauth.Claims) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if claims.ID != "" {
+		if _, ok := s.tokens[claims.ID]; ok {
+			return true
+		}
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return false
+	}
+
+	before, ok := s.users[userID]
+	if !ok {
+		return false
+	}
+
+	return claims.IssuedAt == nil || claims.IssuedAt.Before(before)
+}
+
+// RevokeToken revokes the token with the jti until it expires. A revocation without
+// ExpiresAt is kept for the maximum token lifetime.
+func (s *Store) RevokeToken(rev *TokenRevocation) error {
+	if rev.ExpiresAt.IsZero() {
+		rev.ExpiresAt = time.Now().Add(s.maxTokenLifetime)
+	}
+
+	if err := s.repo.InsertToken(rev); err != nil {
+		return fmt.Errorf("could not revoke token: %w", err)
+	}
+
+	s.applyToken(*rev)
+	return nil
+}
+
+// RevokeUser revokes every token of the user issued until now.
+func (s *Store) RevokeUser(rev *UserRevocation) error {
+	if err := s.repo.UpsertUser(rev); err != nil {
+		return fmt.Errorf("could not revoke user tokens: %w", err)
+	}
+
+	s.applyUser(*rev)
+	return nil
+}
+
+// Sync loads the revocations made since the previous sync, or all of them on the first one,
+// and forgets revoked tokens that have expired.
+func (s *Store) Sync() error {
+	s.mu.RLock()
+	since := s.lastSync
+	s.mu.RUnlock()
+
+	if !since.IsZero() {
+		since = since.Add(-syncOverlap)
+	}
+	syncedAt := time.Now()
+
+	tokens, users, err := s.repo.ListSince(since)
+	if err != nil {
+		return err
+	}
+
+	for _, rev := range tokens {
+		s.applyToken(rev)
+	}
+	for _, rev := range users {
+		s.applyUser(rev)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for jti, expiresAt := range s.tokens {
+		if syncedAt.After(expiresAt) {
+			delete(s.tokens, jti)
+		}
+	}
+	s.lastSync = syncedAt
+
+	return nil
+}
+
+// Apply applies a revocation announced on NotifyChannel.
+func (s *Store) Apply(payload string) {
+	var n notification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		s.logger.Error("invalid revocation notification", "error", err)
+		return
+	}
+
+	if n.Token != nil {
+		s.applyToken(*n.Token)
+	}
+	if n.User != nil {
+		s.applyUser(*n.User)
+	}
+}
+
+// Run syncs the store every interval, and deletes expired token revocations from the
+// database, until the context is cancelled.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Sync(); err != nil {
+				s.logger.Error("failed to sync token revocations", "error", err)
+			}
+			if _, err := s.repo.DeleteExpired(); err != nil {
+				s.logger.Error("failed to delete expired token revocations", "error", err)
+			}
+		}
+	}
+}
+
+// Resync is called when the notification listener reconnects, to pick up the revocations
+// announced while it was disconnected.
+func (s *Store) Resync() {
+	if err := s.Sync(); err != nil {
+		s.logger.Error("failed to sync token revocations", "error", err)
+	}
+}
+
+func (s *Store) applyToken(rev TokenRevocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.tokens[rev.JTI] = rev.ExpiresAt
+}
+
+func (s *Store) applyUser(rev UserRevocation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rev.RevokedBefore.After(s.users[rev.UserID]) {
+		s.users[rev.UserID] = rev.RevokedBefore
+	}
+}
@@ -0,0 +1,131 @@
+package revocation
+
+import (
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/jwtauth"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+type mockRevocationRepo struct {
+	tokens []TokenRevocation
+	users  []UserRevocation
+}
+
+func (m *mockRevocationRepo) InsertToken(rev *TokenRevocation) error {
+	rev.RevokedAt = time.Now()
+	m.tokens = append(m.tokens, *rev)
+	return nil
+}
+
+func (m *mockRevocationRepo) UpsertUser(rev *UserRevocation) error {
+	rev.RevokedBefore = time.Now()
+	m.users = append(m.users, *rev)
+	return nil
+}
+
+func (m *mockRevocationRepo) ListSince(since time.Time) ([]TokenRevocation, []UserRevocation, error) {
+	return m.tokens, m.users, nil
+}
+
+func (m *mockRevocationRepo) DeleteExpired() (int64, error) {
+	return 0, nil
+}
+
+func newTestStore() (*Store, *mockRevocationRepo) {
+	repo := &mockRevocationRepo{}
+	return NewStore(slog.New(slog.NewTextHandler(io.Discard, nil)), repo), repo
+}
+
+func testClaims(jti string, subject uuid.UUID, issuedAt time.Time) *jwtauth.Claims {
+	return &jwtauth.Claims{RegisteredClaims: jwt.RegisteredClaims{
+		ID:       jti,
+		Subject:  subject.String(),
+		IssuedAt: jwt.NewNumericDate(issuedAt),
+	}}
+}
+
+func TestRevokeToken(t *testing.T) {
+	store, _ := newTestStore()
+	userID := uuid.New()
+
+	err := store.RevokeToken(&TokenRevocation{JTI: "leaked", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.IsRevoked(testClaims("leaked", userID, time.Now())) {
+		t.Error("Expected the token with the revoked jti to be revoked")
+	}
+	if store.IsRevoked(testClaims("other", userID, time.Now())) {
+		t.Error("Expected a token with another jti not to be revoked")
+	}
+}
+
+func TestRevokeUser(t *testing.T) {
+	store, _ := newTestStore()
+	userID := uuid.New()
+	issuedBefore := time.Now().Add(-time.Minute)
+
+	if err := store.RevokeUser(&UserRevocation{UserID: userID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.IsRevoked(testClaims("a", userID, issuedBefore)) {
+		t.Error("Expected a token issued before the revocation to be revoked")
+	}
+	if store.IsRevoked(testClaims("b", userID, time.Now().Add(time.Minute))) {
+		t.Error("Expected a token issued after the revocation not to be revoked")
+	}
+	if store.IsRevoked(testClaims("c", uuid.New(), issuedBefore)) {
+		t.Error("Expected another user's token not to be revoked")
+	}
+
+	withoutIat := testClaims("d", userID, time.Now())
+	withoutIat.IssuedAt = nil
+	if !store.IsRevoked(withoutIat) {
+		t.Error("Expected a token without iat to be revoked once the user's tokens are revoked")
+	}
+}
+
+func TestSyncLoadsAndForgetsExpiredRevocations(t *testing.T) {
+	store, repo := newTestStore()
+	userID := uuid.New()
+
+	repo.tokens = []TokenRevocation{
+		{JTI: "active", ExpiresAt: time.Now().Add(time.Hour)},
+		{JTI: "expired", ExpiresAt: time.Now().Add(-time.Hour)},
+	}
+
+	if err := store.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !store.IsRevoked(testClaims("active", userID, time.Now())) {
+		t.Error("Expected a synced revocation to be applied")
+	}
+	if _, ok := store.tokens["expired"]; ok {
+		t.Error("Expected the revocation of an expired token to be forgotten")
+	}
+}
+
+func TestApplyNotification(t *testing.T) {
+	store, _ := newTestStore()
+	userID := uuid.New()
+
+	payload, err := json.Marshal(notification{Token: &TokenRevocation{JTI: "remote", ExpiresAt: time.Now().Add(time.Hour)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Apply(string(payload))
+
+	if !store.IsRevoked(testClaims("remote", userID, time.Now())) {
+		t.Error("Expected a revocation announced by another instance to be applied")
+	}
+
+	store.Apply("not json")
+}
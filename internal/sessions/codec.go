@@ -0,0 +1,102 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+)
+
+const keyLength = 32
+
+// Codec seals session IDs into cookie values with AES-256-GCM, which both encrypts the
+// value and authenticates it, so a cookie that was tampered with fails to open.
+//
+// The first key seals new cookies, and every key opens them, so keys can be rotated by
+// prepending a new one and dropping the old one once the cookies it sealed have expired.
+type Codec struct {
+	aeads []cipher.AEAD
+}
+
+func NewCodec(keys ...[]byte) (*Codec, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	codec := &Codec{}
+	for _, key := range keys {
+		if len(key) != keyLength {
+			return nil, ErrInvalidKey
+		}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.aeads = append(codec.aeads, aead)
+	}
+
+	return codec, nil
+}
+
+// ParseKeys decodes a comma separated list of base64 encoded 32 byte keys.
+func ParseKeys(s string) ([][]byte, error) {
+	var keys [][]byte
+	for _, encoded := range strings.Split(s, ",") {
+		encoded = strings.TrimSpace(encoded)
+		if encoded == "" {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keyLength {
+			return nil, ErrInvalidKey
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return keys, nil
+}
+
+// Seal encrypts the value for the named cookie. The name is authenticated with the value,
+// so a value sealed for one cookie cannot be replayed in another.
+func (c *Codec) Seal(name, value string) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value sealed for the named cookie with any of the keys.
+// Returns ErrInvalidSession if no key opens it.
+func (c *Codec) Open(name, sealed string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", ErrInvalidSession
+	}
+
+	for _, aead := range c.aeads {
+		if len(data) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		if value, err := aead.Open(nil, nonce, ciphertext, []byte(name)); err == nil {
+			return string(value), nil
+		}
+	}
+
+	return "", ErrInvalidSession
+}
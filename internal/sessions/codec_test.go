@@ -0,0 +1,91 @@
+package sessions
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec, err := NewCodec(bytes.Repeat([]byte{1}, keyLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := codec.Seal("session", "session-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains([]byte(sealed), []byte("session-id")) {
+		t.Error("Expected the sealed value not to contain the session id")
+	}
+
+	value, err := codec.Open("session", sealed)
+	if err != nil || value != "session-id" {
+		t.Fatalf("Expected the sealed value to open, got %q, %v", value, err)
+	}
+}
+
+func TestCodecRejectsTamperedValues(t *testing.T) {
+	codec, _ := NewCodec(bytes.Repeat([]byte{1}, keyLength))
+	sealed, _ := codec.Seal("session", "session-id")
+
+	// Change the first character, whose bits all decode, unlike the spare bits of the last one
+	tampered := []byte(sealed)
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+
+	testCases := map[string]struct {
+		name   string
+		sealed string
+	}{
+		"tampered":    {"session", string(tampered)},
+		"other name":  {"other", sealed},
+		"not base64":  {"session", "!!!"},
+		"too short":   {"session", "AAAA"},
+		"empty value": {"session", ""},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := codec.Open(tc.name, tc.sealed); !errors.Is(err, ErrInvalidSession) {
+				t.Errorf("Expected ErrInvalidSession, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCodecKeyRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, keyLength)
+	newKey := bytes.Repeat([]byte{2}, keyLength)
+
+	old, _ := NewCodec(oldKey)
+	sealed, _ := old.Seal("session", "session-id")
+
+	rotated, _ := NewCodec(newKey, oldKey)
+	if value, err := rotated.Open("session", sealed); err != nil || value != "session-id" {
+		t.Errorf("Expected a value sealed with a retiring key to open, got %q, %v", value, err)
+	}
+
+	resealed, _ := rotated.Seal("session", "session-id")
+	if _, err := old.Open("session", resealed); err == nil {
+		t.Error("Expected new values to be sealed with the first key")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	if _, err := ParseKeys(""); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("Expected ErrNoKeys, got %v", err)
+	}
+	if _, err := ParseKeys("c2hvcnQ="); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for a short key, got %v", err)
+	}
+
+	keys, err := ParseKeys("AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=, AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=")
+	if err != nil || len(keys) != 2 {
+		t.Fatalf("Expected two keys, got %d, %v", len(keys), err)
+	}
+}
@@ -0,0 +1,10 @@
+package sessions
+
+import "errors"
+
+var (
+	ErrNoSession      = errors.New("request has no session cookie")
+	ErrInvalidSession = errors.New("session is invalid, expired or signed out")
+	ErrInvalidKey     = errors.New("session keys must be 32 bytes, base64 encoded")
+	ErrNoKeys         = errors.New("at least one session key is required")
+)
@@ -0,0 +1,125 @@
+package sessions
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/credentials"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+type authenticator interface {
+	Authenticate(email, password string) (*users.User, error)
+}
+
+type sessionStarter interface {
+	Start(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*Session, error)
+}
+
+type sessionEnder interface {
+	End(w http.ResponseWriter, r *http.Request) error
+}
+
+type loginInput struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// LoginHandler authenticates a user by email and password and starts a cookie session.
+// It responds with the user and the CSRF token unsafe requests of the session must carry.
+func LoginHandler(
+	logger *slog.Logger,
+	authenticator authenticator,
+	starter sessionStarter,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input loginInput
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		input.Email = strings.TrimSpace(input.Email)
+
+		v := validator.New()
+		users.ValidateEmail(v, input.Email)
+		v.Check(input.Password != "", "password", "must be provided")
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		user, err := authenticator.Authenticate(input.Email, input.Password)
+		if err != nil {
+			switch {
+			case errors.Is(err, credentials.ErrInvalidCredentials):
+				apiutils.InvalidCredentialsResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		if message := users.InactiveUserMessage(user); message != "" {
+			apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+			return
+		}
+
+		session, err := starter.Start(w, r, user.ID)
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		env := apiutils.Envelope{"user": user, "csrfToken": session.CSRFToken, "expiresAt": session.ExpiresAt}
+		err = apiutils.WriteJson(w, http.StatusOK, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("LoginHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// CurrentSessionHandler responds with the user of the request's session and its CSRF token,
+// so a client that was reloaded can pick the token up again.
+func CurrentSessionHandler(
+	logger *slog.Logger,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := ContextGetSession(r)
+		if !ok {
+			apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "missing session cookie")
+			return
+		}
+
+		env := apiutils.Envelope{"user": users.ContextGetUser(r), "csrfToken": session.CSRFToken, "expiresAt": session.ExpiresAt}
+		err := apiutils.WriteJson(w, http.StatusOK, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("CurrentSessionHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// LogoutHandler ends the request's session and clears its cookie.
+func LogoutHandler(
+	logger *slog.Logger,
+	ender sessionEnder,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ender.End(w, r); err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "signed out"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("LogoutHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
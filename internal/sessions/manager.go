@@ -0,0 +1,302 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultCookieName uses the __Host- prefix, so browsers only accept the cookie when it is
+	// Secure, has no Domain and its Path is /, which keeps sibling subdomains from overwriting it.
+	DefaultCookieName = "__Host-session"
+	// CSRFHeader is the header unsafe requests authenticated with a session must carry the CSRF token in.
+	CSRFHeader = "X-CSRF-Token"
+)
+
+type userGetter interface {
+	GetById(id uuid.UUID) (*users.User, error)
+}
+
+// Manager starts, resumes and ends cookie sessions.
+//
+// The cookie holds the session ID sealed with the Codec, and the session itself is kept in
+// the Store, so signing out, or revoking every session of a user, takes effect immediately.
+// Each session has a synchronizer CSRF token that unsafe requests must echo in the CSRFHeader.
+type Manager struct {
+	logger *slog.Logger
+	store  Store
+	users  userGetter
+	codec  *Codec
+
+	cookieName      string
+	secure          bool
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+	touchInterval   time.Duration
+}
+
+type ManagerOption func(*Manager)
+
+// WithCookieName sets the name of the session cookie. Defaults to DefaultCookieName.
+func WithCookieName(name string) ManagerOption {
+	return func(m *Manager) {
+		m.cookieName = name
+	}
+}
+
+// WithSecureCookie sets whether the cookie is only sent over HTTPS. Defaults to true,
+// turn it off for local development over plain HTTP along with the __Host- cookie name.
+func WithSecureCookie(secure bool) ManagerOption {
+	return func(m *Manager) {
+		m.secure = secure
+	}
+}
+
+// WithIdleTimeout sets how long a session lasts without requests. Defaults to 12 hours.
+func WithIdleTimeout(timeout time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.idleTimeout = timeout
+	}
+}
+
+// WithAbsoluteTimeout sets how long a session lasts at most, however active. Defaults to 7 days.
+func WithAbsoluteTimeout(timeout time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.absoluteTimeout = timeout
+	}
+}
+
+// WithTouchInterval sets how often the expiry of an active session is slid forward.
+// Requests within the interval of the last one do not write to the store. Defaults to a minute.
+func WithTouchInterval(interval time.Duration) ManagerOption {
+	return func(m *Manager) {
+		m.touchInterval = interval
+	}
+}
+
+func NewManager(logger *slog.Logger, store Store, users userGetter, codec *Codec, opts ...ManagerOption) *Manager {
+	m := &Manager{
+		logger:          logger,
+		store:           store,
+		users:           users,
+		codec:           codec,
+		cookieName:      DefaultCookieName,
+		secure:          true,
+		idleTimeout:     12 * time.Hour,
+		absoluteTimeout: 7 * 24 * time.Hour,
+		touchInterval:   time.Minute,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Start starts a session for the user and sets its cookie on the response.
+// Any session the request already had is ended first, so a session ID planted
+// in the browser before signing in is never promoted to an authenticated one.
+func (m *Manager) Start(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (*Session, error) {
+	if err := m.deleteRequestSession(r); err != nil {
+		return nil, err
+	}
+
+	id, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate session id: %w", err)
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("could not generate csrf token: %w", err)
+	}
+
+	now := time.Now()
+	session := &Session{
+		IDHash:            hashID(id),
+		UserID:            userID,
+		CSRFToken:         csrfToken,
+		UserAgent:         r.UserAgent(),
+		IP:                middleware.ClientIP(r),
+		LastSeenAt:        now,
+		ExpiresAt:         now.Add(m.idleTimeout),
+		AbsoluteExpiresAt: now.Add(m.absoluteTimeout),
+	}
+	if session.ExpiresAt.After(session.AbsoluteExpiresAt) {
+		session.ExpiresAt = session.AbsoluteExpiresAt
+	}
+
+	if err = m.store.Insert(session); err != nil {
+		return nil, fmt.Errorf("could not insert session: %w", err)
+	}
+
+	if err = m.setCookie(w, id, session.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Resume returns the session of the request's cookie and the user it belongs to, and slides
+// its expiry. Returns ErrNoSession if the request has no session cookie, and ErrInvalidSession,
+// after clearing the cookie, if it cannot be opened, is unknown or expired.
+// The user is returned whatever their account status.
+func (m *Manager) Resume(w http.ResponseWriter, r *http.Request) (*users.User, *Session, error) {
+	cookie, err := r.Cookie(m.cookieName)
+	if err != nil {
+		return nil, nil, ErrNoSession
+	}
+
+	user, session, id, err := m.resume(cookie.Value)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSession) {
+			m.clearCookie(w)
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < m.touchInterval {
+		return user, session, nil
+	}
+
+	expiresAt := now.Add(m.idleTimeout)
+	if expiresAt.After(session.AbsoluteExpiresAt) {
+		expiresAt = session.AbsoluteExpiresAt
+	}
+
+	if err = m.store.Touch(session.IDHash, now, expiresAt); err != nil {
+		// The session was ended by another request in the meantime
+		if errors.Is(err, database.ErrRecordNotFound) {
+			m.clearCookie(w)
+			return nil, nil, ErrInvalidSession
+		}
+		return nil, nil, err
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = expiresAt
+
+	if err = m.setCookie(w, id, expiresAt); err != nil {
+		return nil, nil, err
+	}
+
+	return user, session, nil
+}
+
+func (m *Manager) resume(sealed string) (*users.User, *Session, string, error) {
+	id, err := m.codec.Open(m.cookieName, sealed)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	session, err := m.store.Get(hashID(id))
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil, "", ErrInvalidSession
+		}
+		return nil, nil, "", err
+	}
+
+	if session.Expired(time.Now()) {
+		if err = m.store.Delete(session.IDHash); err != nil {
+			return nil, nil, "", err
+		}
+		return nil, nil, "", ErrInvalidSession
+	}
+
+	user, err := m.users.GetById(session.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil, "", ErrInvalidSession
+		}
+		return nil, nil, "", err
+	}
+	return user, session, id, nil
+}
+
+// End ends the session of the request's cookie, if any, and clears the cookie.
+// Requests without a valid session cookie are ignored, so signing out is idempotent.
+func (m *Manager) End(w http.ResponseWriter, r *http.Request) error {
+	if _, err := r.Cookie(m.cookieName); err != nil {
+		return nil
+	}
+	m.clearCookie(w)
+
+	return m.deleteRequestSession(r)
+}
+
+func (m *Manager) deleteRequestSession(r *http.Request) error {
+	cookie, err := r.Cookie(m.cookieName)
+	if err != nil {
+		return nil
+	}
+
+	id, err := m.codec.Open(m.cookieName, cookie.Value)
+	if err != nil {
+		return nil
+	}
+
+	return m.store.Delete(hashID(id))
+}
+
+// RevokeAllSessions ends every session of the user.
+func (m *Manager) RevokeAllSessions(userID uuid.UUID) error {
+	return m.store.DeleteForUser(userID)
+}
+
+// Run deletes expired sessions every interval until the context is cancelled.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := m.store.DeleteExpired(time.Now())
+			if err != nil {
+				m.logger.Error("failed to delete expired sessions", "error", err)
+				continue
+			}
+			m.logger.Debug("deleted expired sessions", "count", deleted)
+		}
+	}
+}
+
+func (m *Manager) setCookie(w http.ResponseWriter, id string, expiresAt time.Time) error {
+	sealed, err := m.codec.Seal(m.cookieName, id)
+	if err != nil {
+		return fmt.Errorf("could not seal session cookie: %w", err)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    sealed,
+		Path:     "/",
+		Expires:  expiresAt,
+		MaxAge:   int(time.Until(expiresAt).Seconds()),
+		Secure:   m.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (m *Manager) clearCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.cookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   m.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,94 @@
+package sessions
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"net/http"
+)
+
+type contextKey string
+
+const sessionContextKey = contextKey("session")
+
+// ContextGetSession returns the session the request was authenticated with, if any.
+func ContextGetSession(r *http.Request) (*Session, bool) {
+	session, ok := r.Context().Value(sessionContextKey).(*Session)
+	return session, ok
+}
+
+type sessionResumer interface {
+	Resume(w http.ResponseWriter, r *http.Request) (*users.User, *Session, error)
+}
+
+// safeMethod reports whether the method cannot change state, and so needs no CSRF token.
+func safeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// Authenticate authenticates requests that carry a session cookie. The session's user is set
+// as the context user, so users.ContextGetUser and users.RequirePermissions work as with a token.
+// Requests with an unsafe method must carry the session's CSRF token in the CSRFHeader.
+//
+// Requests without a session cookie are passed to the fallback middleware, typically
+// users.Authenticate, so routes accept either. With a nil fallback they are rejected.
+func Authenticate(
+	logger *slog.Logger,
+	resumer sessionResumer,
+	fallback func(http.Handler) http.Handler,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		var fallbackHandler http.Handler
+		if fallback != nil {
+			fallbackHandler = fallback(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, session, err := resumer.Resume(w, r)
+			if err != nil {
+				switch {
+				case errors.Is(err, ErrNoSession) && fallbackHandler != nil:
+					fallbackHandler.ServeHTTP(w, r)
+				case errors.Is(err, ErrNoSession):
+					apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "missing session cookie")
+				case errors.Is(err, ErrInvalidSession):
+					apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "invalid or expired session")
+				default:
+					apiutils.ServerErrorResponse(w, r, logger, err)
+				}
+				return
+			}
+
+			if message := users.InactiveUserMessage(user); message != "" {
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+				return
+			}
+
+			// The cookie is sent with cross-site requests too, the token is not
+			if !safeMethod(r.Method) {
+				token := r.Header.Get(CSRFHeader)
+				if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+					apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "invalid or missing csrf token")
+					return
+				}
+			}
+
+			r = users.ContextSetUser(r, user)
+			r = r.WithContext(context.WithValue(r.Context(), sessionContextKey, session))
+
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Info("user authenticated", "request id", requestId, "user id", user.ID, "session", true)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package sessions
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockUserGetter struct {
+	users map[uuid.UUID]*users.User
+}
+
+func (m mockUserGetter) GetById(id uuid.UUID) (*users.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func newTestManager(t *testing.T, opts ...ManagerOption) (*Manager, *MemoryStore, *users.User) {
+	t.Helper()
+	codec, err := NewCodec(bytes.Repeat([]byte{1}, keyLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &users.User{
+		ID:    uuid.New(),
+		Email: "session@example.com",
+		Role:  users.Role{Name: "admin", Permissions: users.Permissions{users.PermUsersManage}},
+	}
+	store := NewMemoryStore()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	manager := NewManager(logger, store, mockUserGetter{users: map[uuid.UUID]*users.User{user.ID: user}}, codec, opts...)
+	return manager, store, user
+}
+
+// startSession starts a session for the user and returns its cookie.
+func startSession(t *testing.T, manager *Manager, user *users.User) (*http.Cookie, *Session) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	session, err := manager.Start(rec, httptest.NewRequest("POST", "/v1/auth/session", nil), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected one cookie to be set, got %d", len(cookies))
+	}
+	return cookies[0], session
+}
+
+func createTestHandler(manager *Manager, fallback func(http.Handler) http.Handler, perms ...string) http.Handler {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	return Authenticate(logger, manager, fallback)(
+		users.RequirePermissions(logger, perms...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(users.ContextGetUserId(r).String()))
+		})),
+	)
+}
+
+func TestStartSetsSecureCookie(t *testing.T) {
+	manager, _, user := newTestManager(t)
+	cookie, session := startSession(t, manager, user)
+
+	if cookie.Name != DefaultCookieName || cookie.Path != "/" {
+		t.Errorf("Expected a __Host- cookie for the whole site, got %s with path %s", cookie.Name, cookie.Path)
+	}
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Error("Expected a Secure, HttpOnly, SameSite=Lax cookie")
+	}
+	if session.CSRFToken == "" {
+		t.Error("Expected the session to have a csrf token")
+	}
+}
+
+func TestAuthenticateWithSession(t *testing.T) {
+	manager, _, user := newTestManager(t)
+	cookie, session := startSession(t, manager, user)
+
+	testCases := []struct {
+		name     string
+		method   string
+		csrf     string
+		perms    []string
+		expected int
+	}{
+		{"safe method needs no csrf token", "GET", "", nil, http.StatusOK},
+		{"unsafe method with csrf token", "POST", session.CSRFToken, nil, http.StatusOK},
+		{"unsafe method without csrf token", "POST", "", nil, http.StatusForbidden},
+		{"unsafe method with wrong csrf token", "DELETE", "wrong", nil, http.StatusForbidden},
+		{"user permissions apply", "GET", "", []string{users.PermUsersManage}, http.StatusOK},
+		{"missing permission", "GET", "", []string{users.PermAuditRead}, http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/test", nil)
+			req.AddCookie(cookie)
+			if tc.csrf != "" {
+				req.Header.Set(CSRFHeader, tc.csrf)
+			}
+
+			rec := httptest.NewRecorder()
+			createTestHandler(manager, nil, tc.perms...).ServeHTTP(rec, req)
+
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status code %d, got %d: %s", tc.expected, rec.Code, rec.Body)
+			}
+			if tc.expected == http.StatusOK && rec.Body.String() != user.ID.String() {
+				t.Error("Expected the session's user to be the context user")
+			}
+		})
+	}
+}
+
+func TestAuthenticateFallsBackWithoutCookie(t *testing.T) {
+	manager, _, _ := newTestManager(t)
+
+	fallbackCalled := false
+	fallback := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fallbackCalled = true
+			w.WriteHeader(http.StatusTeapot)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	createTestHandler(manager, fallback).ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	if !fallbackCalled || rec.Code != http.StatusTeapot {
+		t.Error("Expected a request without a session cookie to be passed to the fallback")
+	}
+
+	rec = httptest.NewRecorder()
+	createTestHandler(manager, nil).ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without a fallback, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestAuthenticateRejectsInvalidSessions(t *testing.T) {
+	manager, store, user := newTestManager(t)
+
+	forged := &http.Cookie{Name: DefaultCookieName, Value: "forged"}
+
+	ended, _ := startSession(t, manager, user)
+	if err := manager.RevokeAllSessions(user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	expired, session := startSession(t, manager, user)
+	stored, _ := store.Get(session.IDHash)
+	_ = store.Touch(stored.IDHash, stored.LastSeenAt, time.Now().Add(-time.Second))
+
+	for name, cookie := range map[string]*http.Cookie{"forged": forged, "ended": ended, "expired": expired} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/test", nil)
+			req.AddCookie(cookie)
+
+			rec := httptest.NewRecorder()
+			createTestHandler(manager, nil).ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+			}
+			if cookies := rec.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+				t.Error("Expected the invalid cookie to be cleared")
+			}
+		})
+	}
+}
+
+func TestResumeSlidesExpiry(t *testing.T) {
+	manager, store, user := newTestManager(t, WithIdleTimeout(time.Hour), WithAbsoluteTimeout(90*time.Minute))
+	cookie, session := startSession(t, manager, user)
+
+	// Pretend the last request was made half an hour ago
+	lastSeen := time.Now().Add(-30 * time.Minute)
+	_ = store.Touch(session.IDHash, lastSeen, lastSeen.Add(time.Hour))
+
+	req := httptest.NewRequest("GET", "/test", nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	if _, _, err := manager.Resume(rec, req); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _ := store.Get(session.IDHash)
+	if !stored.LastSeenAt.After(lastSeen) || !stored.ExpiresAt.After(lastSeen.Add(time.Hour)) {
+		t.Error("Expected the session expiry to slide forward")
+	}
+	if stored.ExpiresAt.After(stored.AbsoluteExpiresAt) {
+		t.Error("Expected the expiry to never pass the absolute timeout")
+	}
+	if len(rec.Result().Cookies()) != 1 {
+		t.Error("Expected the cookie to be refreshed with the new expiry")
+	}
+
+	// A request right after does not write the session again
+	rec = httptest.NewRecorder()
+	if _, _, err := manager.Resume(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Error("Expected no cookie within the touch interval")
+	}
+}
+
+func TestStartEndsPreviousSession(t *testing.T) {
+	manager, store, user := newTestManager(t)
+	previous, previousSession := startSession(t, manager, user)
+
+	req := httptest.NewRequest("POST", "/v1/auth/session", nil)
+	req.AddCookie(previous)
+	if _, err := manager.Start(httptest.NewRecorder(), req, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Get(previousSession.IDHash); err == nil {
+		t.Error("Expected the previous session to be ended when a new one starts")
+	}
+}
@@ -0,0 +1,122 @@
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+// SessionPsqlRepo is a Store backed by Postgres, shared by every instance of the API.
+type SessionPsqlRepo struct {
+	DB *database.DB
+}
+
+func (m SessionPsqlRepo) Insert(session *Session) error {
+	query := `INSERT INTO sessions (id_hash, user_id, csrf_token, user_agent, ip, last_seen_at, expires_at, absolute_expires_at)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+              RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query,
+		session.IDHash,
+		session.UserID,
+		session.CSRFToken,
+		session.UserAgent,
+		session.IP,
+		session.LastSeenAt,
+		session.ExpiresAt,
+		session.AbsoluteExpiresAt,
+	).Scan(&session.CreatedAt)
+}
+
+func (m SessionPsqlRepo) Get(idHash []byte) (*Session, error) {
+	query := `SELECT id_hash, user_id, csrf_token, user_agent, ip, created_at, last_seen_at, expires_at, absolute_expires_at
+              FROM sessions
+              WHERE id_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var session Session
+	err := m.DB.QueryRowContext(ctx, query, idHash).Scan(
+		&session.IDHash,
+		&session.UserID,
+		&session.CSRFToken,
+		&session.UserAgent,
+		&session.IP,
+		&session.CreatedAt,
+		&session.LastSeenAt,
+		&session.ExpiresAt,
+		&session.AbsoluteExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// Touch records activity on the session and slides its expiry.
+func (m SessionPsqlRepo) Touch(idHash []byte, lastSeenAt, expiresAt time.Time) error {
+	query := `UPDATE sessions
+              SET last_seen_at = $2, expires_at = $3
+              WHERE id_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, idHash, lastSeenAt, expiresAt)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return database.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (m SessionPsqlRepo) Delete(idHash []byte) error {
+	query := `DELETE FROM sessions WHERE id_hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, idHash)
+	return err
+}
+
+func (m SessionPsqlRepo) DeleteForUser(userID uuid.UUID) error {
+	query := `DELETE FROM sessions WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteExpired deletes the sessions that expired before the given time, and returns how many it deleted.
+func (m SessionPsqlRepo) DeleteExpired(before time.Time) (int64, error) {
+	query := `DELETE FROM sessions WHERE expires_at < $1 OR absolute_expires_at < $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,47 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/google/uuid"
+	"time"
+)
+
+const tokenBytes = 32
+
+// Session is a server side session started by signing in with a cookie.
+// Only the SHA-256 hash of the session ID is stored, the ID itself lives in the sealed cookie.
+//
+// A session expires after the idle timeout without requests, and after the absolute
+// timeout whatever its activity.
+type Session struct {
+	IDHash            []byte
+	UserID            uuid.UUID
+	CSRFToken         string
+	UserAgent         string
+	IP                string
+	CreatedAt         time.Time
+	LastSeenAt        time.Time
+	ExpiresAt         time.Time
+	AbsoluteExpiresAt time.Time
+}
+
+// Expired reports whether the session can no longer be used at the given time.
+func (s *Session) Expired(now time.Time) bool {
+	return !now.Before(s.ExpiresAt) || !now.Before(s.AbsoluteExpiresAt)
+}
+
+// randomToken returns a random URL safe token.
+func randomToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashID(id string) []byte {
+	sum := sha256.Sum256([]byte(id))
+	return sum[:]
+}
@@ -0,0 +1,99 @@
+package sessions
+
+import (
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"sync"
+	"time"
+)
+
+// Store keeps sessions by the hash of their ID.
+// Implementations return database.ErrRecordNotFound for sessions they do not have.
+type Store interface {
+	Insert(session *Session) error
+	Get(idHash []byte) (*Session, error)
+	Touch(idHash []byte, lastSeenAt, expiresAt time.Time) error
+	Delete(idHash []byte) error
+	DeleteForUser(userID uuid.UUID) error
+	DeleteExpired(before time.Time) (int64, error)
+}
+
+// MemoryStore is a Store for a single instance, for development and tests.
+// Its sessions are lost when the process exits.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: map[string]Session{}}
+}
+
+func (m *MemoryStore) Insert(session *Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session.CreatedAt = time.Now()
+	m.sessions[string(session.IDHash)] = *session
+	return nil
+}
+
+func (m *MemoryStore) Get(idHash []byte) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[string(idHash)]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return &session, nil
+}
+
+func (m *MemoryStore) Touch(idHash []byte, lastSeenAt, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[string(idHash)]
+	if !ok {
+		return database.ErrRecordNotFound
+	}
+
+	session.LastSeenAt = lastSeenAt
+	session.ExpiresAt = expiresAt
+	m.sessions[string(idHash)] = session
+	return nil
+}
+
+func (m *MemoryStore) Delete(idHash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, string(idHash))
+	return nil
+}
+
+func (m *MemoryStore) DeleteForUser(userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, session := range m.sessions {
+		if session.UserID == userID {
+			delete(m.sessions, key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpired(before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for key, session := range m.sessions {
+		if session.Expired(before) {
+			delete(m.sessions, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
@@ -0,0 +1,5 @@
+package tokens
+
+import "errors"
+
+var ErrInvalidToken = errors.New("token is invalid, expired or already used")
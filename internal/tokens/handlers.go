@@ -0,0 +1,202 @@
+package tokens
+
+import (
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// requestedMessage answers every token request alike, so that it does not reveal whether an account exists.
+const requestedMessage = "if an account exists for this email address, you will receive an email shortly"
+
+type verificationRequester interface {
+	RequestVerification(email string) error
+}
+
+type verificationConfirmer interface {
+	ConfirmVerification(plaintext string) (*users.User, error)
+}
+
+type passwordResetRequester interface {
+	RequestPasswordReset(email string) error
+}
+
+type passwordResetter interface {
+	ValidatePasswordReset(v *validator.Validator, plaintext, password string)
+	ResetPassword(plaintext, password string) (*users.User, error)
+}
+
+type emailChangeRequester interface {
+	RequestEmailChange(user *users.User, newEmail string) error
+}
+
+type emailChangeConfirmer interface {
+	ConfirmEmailChange(plaintext string) (*users.User, error)
+}
+
+type emailInput struct {
+	Email string `json:"email"`
+}
+
+type tokenInput struct {
+	Token string `json:"token"`
+}
+
+type passwordResetInput struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// RequestVerificationHandler mails a verification token to the email's account, if it has one.
+func RequestVerificationHandler(logger *slog.Logger, requester verificationRequester) http.Handler {
+	return requestHandler(logger, "RequestVerificationHandler", requester.RequestVerification)
+}
+
+// RequestPasswordResetHandler mails a password reset token to the email's account, if it has one.
+func RequestPasswordResetHandler(logger *slog.Logger, requester passwordResetRequester) http.Handler {
+	return requestHandler(logger, "RequestPasswordResetHandler", requester.RequestPasswordReset)
+}
+
+func requestHandler(logger *slog.Logger, name string, request func(email string) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input emailInput
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		input.Email = strings.TrimSpace(input.Email)
+
+		v := validator.New()
+		if users.ValidateEmail(v, input.Email); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if err := request(input.Email); err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		writeRequested(w, r, logger, name)
+	})
+}
+
+// RequestEmailChangeHandler mails a token to the new email address of the authenticated user,
+// which changes their email when confirmed with ConfirmEmailChangeHandler.
+func RequestEmailChangeHandler(logger *slog.Logger, requester emailChangeRequester) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input emailInput
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+		input.Email = strings.TrimSpace(input.Email)
+
+		v := validator.New()
+		if users.ValidateEmail(v, input.Email); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if err := requester.RequestEmailChange(users.ContextGetUser(r), input.Email); err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		writeRequested(w, r, logger, "RequestEmailChangeHandler")
+	})
+}
+
+// ConfirmVerificationHandler verifies the email address the token was sent to.
+func ConfirmVerificationHandler(logger *slog.Logger, confirmer verificationConfirmer) http.Handler {
+	return confirmHandler(logger, "ConfirmVerificationHandler", confirmer.ConfirmVerification)
+}
+
+// ConfirmEmailChangeHandler changes the user's email to the address the token was sent to.
+func ConfirmEmailChangeHandler(logger *slog.Logger, confirmer emailChangeConfirmer) http.Handler {
+	return confirmHandler(logger, "ConfirmEmailChangeHandler", confirmer.ConfirmEmailChange)
+}
+
+func confirmHandler(logger *slog.Logger, name string, confirm func(plaintext string) (*users.User, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input tokenInput
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		if ValidateTokenPlaintext(v, input.Token); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		user, err := confirm(input.Token)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidToken):
+				v.AddError("token", "invalid or expired token")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			case errors.Is(err, users.ErrDuplicateEmail):
+				apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "a user with this email address already exists")
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		writeUser(w, r, logger, name, user)
+	})
+}
+
+// ResetPasswordHandler sets a new password for the user the password reset token was sent to.
+func ResetPasswordHandler(logger *slog.Logger, resetter passwordResetter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input passwordResetInput
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		if resetter.ValidatePasswordReset(v, input.Token, input.Password); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		user, err := resetter.ResetPassword(input.Token, input.Password)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvalidToken):
+				v.AddError("token", "invalid or expired token")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		writeUser(w, r, logger, "ResetPasswordHandler", user)
+	})
+}
+
+func writeRequested(w http.ResponseWriter, r *http.Request, logger *slog.Logger, name string) {
+	err := apiutils.WriteJson(w, http.StatusAccepted, apiutils.Envelope{"message": requestedMessage}, http.Header{})
+	if err != nil {
+		requestId, _ := middleware.GetRequestID(r)
+		logger.Error(name+" write failed", middleware.RequestIdLog, requestId, "error", err)
+	}
+}
+
+func writeUser(w http.ResponseWriter, r *http.Request, logger *slog.Logger, name string, user *users.User) {
+	err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"user": user}, http.Header{})
+	if err != nil {
+		requestId, _ := middleware.GetRequestID(r)
+		logger.Error(name+" write failed", middleware.RequestIdLog, requestId, "error", err)
+	}
+}
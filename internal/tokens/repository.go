@@ -0,0 +1,99 @@
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type TokenPsqlRepo struct {
+	DB *database.DB
+}
+
+func (m TokenPsqlRepo) Insert(token *Token) error {
+	query := `INSERT INTO tokens (hash, user_id, scope, email, expires_at)
+              VALUES ($1, $2, $3, $4, $5)
+              RETURNING created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{token.Hash, token.UserID, token.Scope, token.Email, token.ExpiresAt}
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&token.CreatedAt)
+}
+
+// Get returns the token stored under the hash for the scope, if it is unused and has not expired.
+// Returns database.ErrRecordNotFound otherwise.
+func (m TokenPsqlRepo) Get(hash []byte, scope Scope) (*Token, error) {
+	query := `SELECT hash, user_id, scope, email, created_at, expires_at, used_at
+              FROM tokens
+              WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+
+	return m.scanOne(query, hash, scope)
+}
+
+// Consume marks the token stored under the hash for the scope as used and returns it, if it was
+// unused and has not expired. Returns database.ErrRecordNotFound otherwise, so that of two requests
+// consuming the same token at once only one succeeds.
+func (m TokenPsqlRepo) Consume(hash []byte, scope Scope) (*Token, error) {
+	query := `UPDATE tokens
+              SET used_at = CURRENT_TIMESTAMP
+              WHERE hash = $1 AND scope = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+              RETURNING hash, user_id, scope, email, created_at, expires_at, used_at`
+
+	return m.scanOne(query, hash, scope)
+}
+
+func (m TokenPsqlRepo) scanOne(query string, hash []byte, scope Scope) (*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var token Token
+	err := m.DB.QueryRowContext(ctx, query, hash, scope).Scan(
+		&token.Hash,
+		&token.UserID,
+		&token.Scope,
+		&token.Email,
+		&token.CreatedAt,
+		&token.ExpiresAt,
+		&token.UsedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// DeleteForUser deletes the unused tokens of the user for the scope.
+func (m TokenPsqlRepo) DeleteForUser(userID uuid.UUID, scope Scope) error {
+	query := `DELETE FROM tokens WHERE user_id = $1 AND scope = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, scope)
+	return err
+}
+
+// DeleteExpired deletes the tokens that have expired, used or not, and returns how many it deleted.
+func (m TokenPsqlRepo) DeleteExpired() (int64, error) {
+	query := `DELETE FROM tokens WHERE expires_at < CURRENT_TIMESTAMP`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
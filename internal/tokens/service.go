@@ -0,0 +1,364 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/mailer"
+	"go-web-api-starter/internal/ratelimit"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+var defaultTTLs = map[Scope]time.Duration{
+	ScopeVerification:  72 * time.Hour,
+	ScopePasswordReset: time.Hour,
+	ScopeEmailChange:   24 * time.Hour,
+}
+
+var templates = map[Scope]string{
+	ScopeVerification:  "email_verification.tmpl",
+	ScopePasswordReset: "password_reset.tmpl",
+	ScopeEmailChange:   "email_change.tmpl",
+}
+
+type tokenRepository interface {
+	Insert(token *Token) error
+	Get(hash []byte, scope Scope) (*Token, error)
+	Consume(hash []byte, scope Scope) (*Token, error)
+	DeleteForUser(userID uuid.UUID, scope Scope) error
+	DeleteExpired() (int64, error)
+}
+
+type userService interface {
+	GetById(id uuid.UUID) (*users.User, error)
+	GetByEmail(email string) (*users.User, error)
+	UpdateUserEmail(userId uuid.UUID, email string) error
+	VerifyUserEmail(userId uuid.UUID, email string) error
+}
+
+type passwordService interface {
+	ValidatePassword(v *validator.Validator, password, email string)
+	SetPassword(userID uuid.UUID, password string) error
+}
+
+type mailSender interface {
+	Send(recipient, templateFile string, data any) error
+}
+
+// Service issues the single-use tokens mailed to users to verify their email address, reset their
+// password or change their email address, and carries out the action when a token comes back.
+//
+// Requesting a token never reveals whether an account exists for an email address: requests for
+// unknown, deleted or inactive accounts, and requests over the per-email limit, succeed without
+// sending anything.
+type Service struct {
+	logger       *slog.Logger
+	tokens       tokenRepository
+	users        userService
+	passwords    passwordService
+	mailer       mailSender
+	ttls         map[Scope]time.Duration
+	emailLimiter *ratelimit.Limiter
+	revokers     []users.SessionRevoker
+	background   func(fn func())
+}
+
+type ServiceOption func(*Service)
+
+// WithTTL sets how long tokens of the scope are valid for.
+func WithTTL(scope Scope, ttl time.Duration) ServiceOption {
+	return func(s *Service) {
+		s.ttls[scope] = ttl
+	}
+}
+
+// WithEmailLimiter limits how many tokens are mailed to each email address, on top of
+// any limit on the requests themselves.
+func WithEmailLimiter(limiter *ratelimit.Limiter) ServiceOption {
+	return func(s *Service) {
+		s.emailLimiter = limiter
+	}
+}
+
+// WithSessionRevokers ends the sessions of users who reset their password with each revoker,
+// so that whoever knew the old password is signed out.
+func WithSessionRevokers(revokers ...users.SessionRevoker) ServiceOption {
+	return func(s *Service) {
+		s.revokers = append(s.revokers, revokers...)
+	}
+}
+
+// WithBackground sets how emails are sent in the background, so the caller can wait for
+// them on shutdown. By default they are sent in a plain goroutine.
+func WithBackground(background func(fn func())) ServiceOption {
+	return func(s *Service) {
+		s.background = background
+	}
+}
+
+func NewService(
+	logger *slog.Logger,
+	tokens tokenRepository,
+	users userService,
+	passwords passwordService,
+	mailer mailSender,
+	opts ...ServiceOption,
+) *Service {
+	s := &Service{
+		logger:     logger,
+		tokens:     tokens,
+		users:      users,
+		passwords:  passwords,
+		mailer:     mailer,
+		ttls:       make(map[Scope]time.Duration, len(defaultTTLs)),
+		background: func(fn func()) { go fn() },
+	}
+	for scope, ttl := range defaultTTLs {
+		s.ttls[scope] = ttl
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// RequestVerification mails a verification token to the user with the email, unless they
+// have verified it already.
+func (s *Service) RequestVerification(email string) error {
+	user, err := s.activeUserByEmail(email)
+	if err != nil || user == nil {
+		return err
+	}
+	if user.IsEmailVerified() {
+		return nil
+	}
+
+	return s.issue(user, ScopeVerification, user.Email)
+}
+
+// RequestPasswordReset mails a password reset token to the user with the email.
+func (s *Service) RequestPasswordReset(email string) error {
+	user, err := s.activeUserByEmail(email)
+	if err != nil || user == nil {
+		return err
+	}
+
+	return s.issue(user, ScopePasswordReset, user.Email)
+}
+
+// RequestEmailChange mails a token to the new email address, which changes the user's email
+// to it when confirmed. Nothing is sent when the address belongs to another account.
+func (s *Service) RequestEmailChange(user *users.User, newEmail string) error {
+	if strings.EqualFold(user.Email, newEmail) {
+		return nil
+	}
+
+	other, err := s.activeUserByEmail(newEmail)
+	if err != nil {
+		return err
+	}
+	if other != nil {
+		s.logger.Info("email change requested to a taken email", "user id", user.ID)
+		return nil
+	}
+
+	return s.issue(user, ScopeEmailChange, newEmail)
+}
+
+// ConfirmVerification consumes the verification token and marks the email it was sent to as verified.
+// Returns ErrInvalidToken if the token is not valid, or the email is no longer the user's.
+func (s *Service) ConfirmVerification(plaintext string) (*users.User, error) {
+	token, err := s.consume(plaintext, ScopeVerification)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.users.VerifyUserEmail(token.UserID, token.Email); err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return s.users.GetById(token.UserID)
+}
+
+// ValidatePasswordReset checks that the password reset token is valid, without consuming it,
+// and that the new password follows the password policy.
+func (s *Service) ValidatePasswordReset(v *validator.Validator, plaintext, password string) {
+	if ValidateTokenPlaintext(v, plaintext); !v.Valid() {
+		return
+	}
+
+	token, err := s.tokens.Get(hashToken(plaintext), ScopePasswordReset)
+	if err != nil {
+		if !errors.Is(err, database.ErrRecordNotFound) {
+			s.logger.Error("failed to get password reset token", "error", err)
+		}
+		v.AddError("token", "invalid or expired token")
+		return
+	}
+
+	s.passwords.ValidatePassword(v, password, token.Email)
+}
+
+// ResetPassword consumes the password reset token, sets the user's password and ends their sessions.
+// A user without a password, who signed up with an external identity provider, gets one.
+// Returns ErrInvalidToken if the token is not valid.
+func (s *Service) ResetPassword(plaintext, password string) (*users.User, error) {
+	token, err := s.consume(plaintext, ScopePasswordReset)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.activeUser(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.passwords.SetPassword(user.ID, password); err != nil {
+		return nil, err
+	}
+
+	for _, revoker := range s.revokers {
+		if err = revoker.RevokeAllSessions(user.ID); err != nil {
+			s.logger.Error("failed to revoke sessions after password reset", "user id", user.ID, "error", err)
+		}
+	}
+
+	return user, nil
+}
+
+// ConfirmEmailChange consumes the email change token and changes the user's email to the address
+// it was sent to, which is verified by that. Unused password reset tokens sent to the previous
+// address are deleted. Returns ErrInvalidToken if the token is not valid, and users.ErrDuplicateEmail
+// if the address was taken since the change was requested.
+func (s *Service) ConfirmEmailChange(plaintext string) (*users.User, error) {
+	token, err := s.consume(plaintext, ScopeEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.activeUser(token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = s.users.UpdateUserEmail(user.ID, token.Email); err != nil {
+		return nil, err
+	}
+	if err = s.users.VerifyUserEmail(user.ID, token.Email); err != nil {
+		return nil, err
+	}
+	if err = s.tokens.DeleteForUser(user.ID, ScopePasswordReset); err != nil {
+		s.logger.Error("failed to delete password reset tokens after email change", "user id", user.ID, "error", err)
+	}
+
+	return s.users.GetById(user.ID)
+}
+
+// Run deletes expired tokens every interval until the context is cancelled.
+func (s *Service) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.tokens.DeleteExpired(); err != nil {
+				s.logger.Error("failed to delete expired tokens", "error", err)
+			}
+		}
+	}
+}
+
+// issue replaces the user's unused tokens of the scope with a new one, and mails it to the email
+// in the background. Mail failures are logged, since the request has already been answered.
+func (s *Service) issue(user *users.User, scope Scope, email string) error {
+	if s.emailLimiter != nil {
+		if allowed, _ := s.emailLimiter.Allow(string(scope) + "|" + strings.ToLower(email)); !allowed {
+			s.logger.Warn("token request over the email rate limit", "user id", user.ID, "scope", scope)
+			return nil
+		}
+	}
+
+	ttl := s.ttls[scope]
+	token, err := generateToken(user.ID, scope, email, ttl)
+	if err != nil {
+		return fmt.Errorf("could not generate token: %w", err)
+	}
+
+	if err = s.tokens.DeleteForUser(user.ID, scope); err != nil {
+		return fmt.Errorf("could not delete previous tokens: %w", err)
+	}
+	if err = s.tokens.Insert(token); err != nil {
+		return fmt.Errorf("could not insert token: %w", err)
+	}
+
+	data := map[string]any{
+		"userID":    user.ID,
+		"token":     token.Plaintext,
+		"expiresIn": mailer.HumanizeDuration(ttl),
+	}
+	s.background(func() {
+		if err := s.mailer.Send(email, templates[scope], data); err != nil {
+			s.logger.Error("failed to send token email", "user id", user.ID, "scope", scope, "error", err)
+		}
+	})
+
+	return nil
+}
+
+// consume consumes the token, returning ErrInvalidToken if it is unknown, used or expired.
+func (s *Service) consume(plaintext string, scope Scope) (*Token, error) {
+	token, err := s.tokens.Consume(hashToken(plaintext), scope)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// activeUserByEmail returns the user with the email, or nil if there is none or they may not authenticate.
+func (s *Service) activeUserByEmail(email string) (*users.User, error) {
+	user, err := s.users.GetByEmail(email)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if users.InactiveUserMessage(user) != "" {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+// activeUser returns the user a token was issued to, or ErrInvalidToken if they may no longer authenticate.
+func (s *Service) activeUser(id uuid.UUID) (*users.User, error) {
+	user, err := s.users.GetById(id)
+	if err != nil {
+		if errors.Is(err, database.ErrRecordNotFound) {
+			return nil, ErrInvalidToken
+		}
+		return nil, err
+	}
+	if users.InactiveUserMessage(user) != "" {
+		return nil, ErrInvalidToken
+	}
+
+	return user, nil
+}
@@ -0,0 +1,391 @@
+package tokens
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/ratelimit"
+	"go-web-api-starter/internal/users"
+	"go-web-api-starter/internal/validator"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryTokenRepo struct {
+	tokens map[string]*Token
+}
+
+func (m *memoryTokenRepo) Insert(token *Token) error {
+	token.CreatedAt = time.Now()
+	m.tokens[string(token.Hash)] = token
+	return nil
+}
+
+func (m *memoryTokenRepo) Get(hash []byte, scope Scope) (*Token, error) {
+	token, ok := m.tokens[string(hash)]
+	if !ok || token.Scope != scope || token.UsedAt != nil || !token.ExpiresAt.After(time.Now()) {
+		return nil, database.ErrRecordNotFound
+	}
+	return token, nil
+}
+
+func (m *memoryTokenRepo) Consume(hash []byte, scope Scope) (*Token, error) {
+	token, err := m.Get(hash, scope)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	token.UsedAt = &now
+	return token, nil
+}
+
+func (m *memoryTokenRepo) DeleteForUser(userID uuid.UUID, scope Scope) error {
+	for key, token := range m.tokens {
+		if token.UserID == userID && token.Scope == scope && token.UsedAt == nil {
+			delete(m.tokens, key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryTokenRepo) DeleteExpired() (int64, error) {
+	return 0, nil
+}
+
+type mockUserService struct {
+	users map[uuid.UUID]*users.User
+}
+
+func (m *mockUserService) GetById(id uuid.UUID) (*users.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting user: %w", database.ErrRecordNotFound)
+	}
+	return user, nil
+}
+
+func (m *mockUserService) GetByEmail(email string) (*users.User, error) {
+	for _, user := range m.users {
+		if strings.EqualFold(user.Email, email) && !user.IsDeleted {
+			return user, nil
+		}
+	}
+	return nil, fmt.Errorf("error getting user: %w", database.ErrRecordNotFound)
+}
+
+func (m *mockUserService) UpdateUserEmail(id uuid.UUID, email string) error {
+	if other, err := m.GetByEmail(email); err == nil && other.ID != id {
+		return users.ErrDuplicateEmail
+	}
+	if m.users[id].Email != email {
+		m.users[id].EmailVerifiedAt = nil
+	}
+	m.users[id].Email = email
+	return nil
+}
+
+func (m *mockUserService) VerifyUserEmail(id uuid.UUID, email string) error {
+	user, ok := m.users[id]
+	if !ok || user.Email != email || user.IsDeleted {
+		return fmt.Errorf("error verifying user email: %w", database.ErrRecordNotFound)
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return nil
+}
+
+type mockPasswords struct {
+	passwords map[uuid.UUID]string
+}
+
+func (m *mockPasswords) ValidatePassword(v *validator.Validator, password, email string) {
+	v.MinLength(password, "password", 8)
+}
+
+func (m *mockPasswords) SetPassword(userID uuid.UUID, password string) error {
+	m.passwords[userID] = password
+	return nil
+}
+
+type sentMail struct {
+	recipient string
+	template  string
+	data      map[string]any
+}
+
+type mockMailer struct {
+	sent []sentMail
+}
+
+func (m *mockMailer) Send(recipient, templateFile string, data any) error {
+	m.sent = append(m.sent, sentMail{recipient, templateFile, data.(map[string]any)})
+	return nil
+}
+
+// lastToken returns the plaintext token of the last mail sent.
+func (m *mockMailer) lastToken(t *testing.T) string {
+	t.Helper()
+	if len(m.sent) == 0 {
+		t.Fatal("expected a mail to be sent")
+	}
+	return m.sent[len(m.sent)-1].data["token"].(string)
+}
+
+type testService struct {
+	*Service
+	user      *users.User
+	users     *mockUserService
+	passwords *mockPasswords
+	mailer    *mockMailer
+	revoked   []uuid.UUID
+}
+
+func newTestService(t *testing.T, opts ...ServiceOption) *testService {
+	t.Helper()
+	user := &users.User{ID: uuid.New(), Email: "jane@example.com", Role: users.RegularRole}
+	ts := &testService{
+		user:      user,
+		users:     &mockUserService{users: map[uuid.UUID]*users.User{user.ID: user}},
+		passwords: &mockPasswords{passwords: map[uuid.UUID]string{}},
+		mailer:    &mockMailer{},
+	}
+
+	revoker := users.SessionRevokerFunc(func(userID uuid.UUID) error {
+		ts.revoked = append(ts.revoked, userID)
+		return nil
+	})
+	opts = append([]ServiceOption{
+		WithBackground(func(fn func()) { fn() }),
+		WithSessionRevokers(revoker),
+	}, opts...)
+
+	ts.Service = NewService(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		&memoryTokenRepo{tokens: map[string]*Token{}},
+		ts.users,
+		ts.passwords,
+		ts.mailer,
+		opts...,
+	)
+	return ts
+}
+
+func TestVerification(t *testing.T) {
+	ts := newTestService(t)
+
+	if err := ts.RequestVerification("JANE@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	mail := ts.mailer.sent[0]
+	if mail.recipient != "jane@example.com" || mail.template != "email_verification.tmpl" {
+		t.Errorf("unexpected mail %+v", mail)
+	}
+	if mail.data["expiresIn"] != "3 days" {
+		t.Errorf("expected the token to expire in 3 days, got %v", mail.data["expiresIn"])
+	}
+
+	plaintext := ts.mailer.lastToken(t)
+	user, err := ts.ConfirmVerification(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !user.IsEmailVerified() {
+		t.Error("expected the email to be verified")
+	}
+
+	if _, err = ts.ConfirmVerification(plaintext); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a used token to be invalid, got %v", err)
+	}
+
+	if err = ts.RequestVerification("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.mailer.sent) != 1 {
+		t.Error("expected no mail for a verified email")
+	}
+}
+
+func TestVerificationOfChangedEmail(t *testing.T) {
+	ts := newTestService(t)
+
+	if err := ts.RequestVerification("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ts.user.Email = "jane.doe@example.com"
+
+	if _, err := ts.ConfirmVerification(ts.mailer.lastToken(t)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a token for a previous email to be invalid, got %v", err)
+	}
+}
+
+func TestRequestsDoNotRevealAccounts(t *testing.T) {
+	ts := newTestService(t)
+	suspended := &users.User{
+		ID:         uuid.New(),
+		Email:      "suspended@example.com",
+		Suspension: &users.Suspension{Reason: "spam"},
+	}
+	ts.users.users[suspended.ID] = suspended
+
+	for _, email := range []string{"unknown@example.com", "suspended@example.com"} {
+		if err := ts.RequestPasswordReset(email); err != nil {
+			t.Errorf("%s: expected no error, got %v", email, err)
+		}
+		if err := ts.RequestVerification(email); err != nil {
+			t.Errorf("%s: expected no error, got %v", email, err)
+		}
+	}
+	if len(ts.mailer.sent) != 0 {
+		t.Errorf("expected no mail, got %d", len(ts.mailer.sent))
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	ts := newTestService(t)
+
+	if err := ts.RequestPasswordReset("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	plaintext := ts.mailer.lastToken(t)
+
+	v := validator.New()
+	ts.ValidatePasswordReset(v, plaintext, "short")
+	if _, ok := v.Errors["password"]; !ok {
+		t.Errorf("expected the password to be rejected, got %v", v.Errors)
+	}
+
+	v = validator.New()
+	ts.ValidatePasswordReset(v, strings.Repeat("A", plaintextLength), "a long password")
+	if v.Errors["token"] != "invalid or expired token" {
+		t.Errorf("expected an unknown token to be rejected, got %v", v.Errors)
+	}
+
+	v = validator.New()
+	if ts.ValidatePasswordReset(v, plaintext, "a long password"); !v.Valid() {
+		t.Fatalf("expected the reset to be valid, got %v", v.Errors)
+	}
+
+	if _, err := ts.ResetPassword(plaintext, "a long password"); err != nil {
+		t.Fatal(err)
+	}
+	if ts.passwords.passwords[ts.user.ID] != "a long password" {
+		t.Error("expected the password to be set")
+	}
+	if len(ts.revoked) != 1 || ts.revoked[0] != ts.user.ID {
+		t.Errorf("expected the user's sessions to be revoked, got %v", ts.revoked)
+	}
+
+	if _, err := ts.ResetPassword(plaintext, "another password"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a used token to be invalid, got %v", err)
+	}
+}
+
+func TestNewTokenReplacesPreviousOne(t *testing.T) {
+	ts := newTestService(t)
+
+	if err := ts.RequestPasswordReset("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	first := ts.mailer.lastToken(t)
+	if err := ts.RequestPasswordReset("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ts.ResetPassword(first, "a long password"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the previous token to be invalid, got %v", err)
+	}
+	if _, err := ts.ResetPassword(ts.mailer.lastToken(t), "a long password"); err != nil {
+		t.Errorf("expected the new token to be valid, got %v", err)
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	ts := newTestService(t)
+
+	if err := ts.RequestVerification("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.ResetPassword(ts.mailer.lastToken(t), "a long password"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected a verification token to be invalid for a password reset, got %v", err)
+	}
+}
+
+func TestExpiredToken(t *testing.T) {
+	ts := newTestService(t, WithTTL(ScopeVerification, -time.Minute))
+
+	if err := ts.RequestVerification("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.ConfirmVerification(ts.mailer.lastToken(t)); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an expired token to be invalid, got %v", err)
+	}
+}
+
+func TestEmailChange(t *testing.T) {
+	ts := newTestService(t)
+	other := &users.User{ID: uuid.New(), Email: "john@example.com"}
+	ts.users.users[other.ID] = other
+
+	if err := ts.RequestEmailChange(ts.user, "john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.mailer.sent) != 0 {
+		t.Fatal("expected no mail for a taken email")
+	}
+
+	if err := ts.RequestPasswordReset("jane@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	reset := ts.mailer.lastToken(t)
+
+	if err := ts.RequestEmailChange(ts.user, "jane.doe@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	mail := ts.mailer.sent[len(ts.mailer.sent)-1]
+	if mail.recipient != "jane.doe@example.com" || mail.template != "email_change.tmpl" {
+		t.Errorf("expected the token to be mailed to the new email, got %+v", mail)
+	}
+
+	user, err := ts.ConfirmEmailChange(ts.mailer.lastToken(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "jane.doe@example.com" || !user.IsEmailVerified() {
+		t.Errorf("expected the new email to be set and verified, got %+v", user)
+	}
+
+	if _, err = ts.ResetPassword(reset, "a long password"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected reset tokens sent to the previous email to be invalid, got %v", err)
+	}
+}
+
+func TestEmailChangeToTakenEmail(t *testing.T) {
+	ts := newTestService(t)
+
+	if err := ts.RequestEmailChange(ts.user, "john@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	other := &users.User{ID: uuid.New(), Email: "john@example.com"}
+	ts.users.users[other.ID] = other
+
+	if _, err := ts.ConfirmEmailChange(ts.mailer.lastToken(t)); !errors.Is(err, users.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail, got %v", err)
+	}
+}
+
+func TestEmailLimiter(t *testing.T) {
+	ts := newTestService(t, WithEmailLimiter(ratelimit.New(2, time.Hour)))
+
+	for i := 0; i < 3; i++ {
+		if err := ts.RequestPasswordReset("jane@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(ts.mailer.sent) != 2 {
+		t.Errorf("expected 2 mails, got %d", len(ts.mailer.sent))
+	}
+}
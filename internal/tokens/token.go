@@ -0,0 +1,69 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/validator"
+	"time"
+)
+
+// Scope is what a token may be used for. A token is only accepted for the scope it was issued for.
+type Scope string
+
+const (
+	ScopeVerification  Scope = "verification"
+	ScopePasswordReset Scope = "password-reset"
+	ScopeEmailChange   Scope = "email-change"
+)
+
+// tokenBytes of randomness encode to a plaintext of plaintextLength characters.
+const (
+	tokenBytes      = 16
+	plaintextLength = 26
+)
+
+// Token is a single-use token mailed to a user. Only the SHA-256 hash of the plaintext is stored.
+// Email is the address the token was sent to: the address to verify, or the new address of an email change.
+type Token struct {
+	Plaintext string
+	Hash      []byte
+	UserID    uuid.UUID
+	Scope     Scope
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// generateToken returns a new token for the user, valid for the ttl. The plaintext is base32
+// encoded, so it survives being copied out of an email by hand.
+func generateToken(userID uuid.UUID, scope Scope, email string, ttl time.Duration) (*Token, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	plaintext := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+
+	return &Token{
+		Plaintext: plaintext,
+		Hash:      hashToken(plaintext),
+		UserID:    userID,
+		Scope:     scope,
+		Email:     email,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+func hashToken(plaintext string) []byte {
+	sum := sha256.Sum256([]byte(plaintext))
+	return sum[:]
+}
+
+// ValidateTokenPlaintext checks that the token was provided and has the length of an issued token.
+func ValidateTokenPlaintext(v *validator.Validator, plaintext string) {
+	v.Check(plaintext != "", "token", "must be provided")
+	v.Check(len(plaintext) == plaintextLength, "token", "must be 26 bytes long")
+}
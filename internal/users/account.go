@@ -0,0 +1,38 @@
+package users
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"time"
+)
+
+// InactiveUserMessage returns why the user may not authenticate, for a 403 response,
+// or an empty string when the user is active. Every authentication path checks it,
+// so that suspended and deleted users are rejected however they sign in.
+func InactiveUserMessage(user *User) string {
+	switch user.Status() {
+	case StatusDeleted:
+		return "this account has been deleted"
+	case StatusSuspended:
+		if user.Suspension.Until != nil {
+			return fmt.Sprintf("this account is suspended until %s: %s",
+				user.Suspension.Until.UTC().Format(time.RFC3339), user.Suspension.Reason)
+		}
+		return fmt.Sprintf("this account is suspended: %s", user.Suspension.Reason)
+	default:
+		return ""
+	}
+}
+
+// SessionRevoker ends every session of a user, so that a suspension takes effect
+// for tokens and cookies that were issued before it.
+type SessionRevoker interface {
+	RevokeAllSessions(userID uuid.UUID) error
+}
+
+// SessionRevokerFunc adapts a function to a SessionRevoker.
+type SessionRevokerFunc func(userID uuid.UUID) error
+
+func (f SessionRevokerFunc) RevokeAllSessions(userID uuid.UUID) error {
+	return f(userID)
+}
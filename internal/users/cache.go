@@ -0,0 +1,260 @@
+package users
+
+import (
+	"container/list"
+	"encoding/json"
+	"github.com/google/uuid"
+	"golang.org/x/sync/singleflight"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NotifyChannel is the Postgres channel user and role changes are announced on, so that every
+// instance drops the users it cached before the change.
+const NotifyChannel = "user_changes"
+
+const (
+	defaultCacheCapacity = 10000
+	defaultCacheTTL      = time.Minute
+)
+
+// changeNotification is the payload sent on NotifyChannel. Exactly one of the fields is set.
+type changeNotification struct {
+	UserID *uuid.UUID `json:"userId,omitempty"`
+	Role   string     `json:"role,omitempty"`
+}
+
+// CacheStats are the counters of a Cache since it was created.
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Size          int    `json:"size"`
+}
+
+type cacheEntry struct {
+	id        uuid.UUID
+	user      *User
+	expiresAt time.Time
+}
+
+// Cache is a read-through cache of users in front of a userGetter. It keeps up to its capacity
+// of the most recently used users, each for at most its TTL, and loads a user missing from
+// it once however many requests ask for them at the same time.
+//
+// Users are dropped from the cache when they change, by the UserService on this instance
+// and by Apply for the changes other instances announce on NotifyChannel. The TTL bounds
+// how long a change made without an announcement goes unnoticed.
+type Cache struct {
+	logger   *slog.Logger
+	getter   userGetter
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[uuid.UUID]*list.Element
+	// order holds the cache entries, most recently used first
+	order *list.List
+	// generation is incremented by every invalidation, so that a load which started
+	// before one does not cache the user it read
+	generation uint64
+
+	loads singleflight.Group
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+type CacheOption func(*Cache)
+
+// WithCacheCapacity sets how many users the cache keeps before evicting the least recently used.
+func WithCacheCapacity(capacity int) CacheOption {
+	return func(c *Cache) {
+		c.capacity = capacity
+	}
+}
+
+// WithCacheTTL sets how long a user is cached for after being loaded.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+func NewCache(logger *slog.Logger, getter userGetter, opts ...CacheOption) *Cache {
+	c := &Cache{
+		logger:   logger,
+		getter:   getter,
+		capacity: defaultCacheCapacity,
+		ttl:      defaultCacheTTL,
+		now:      time.Now,
+		entries:  make(map[uuid.UUID]*list.Element),
+		order:    list.New(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// GetById returns a copy of the cached user, or loads the user with the getter when they are
+// not cached or have expired. Errors are returned as the getter returned them, and not cached.
+func (c *Cache) GetById(id uuid.UUID) (*User, error) {
+	if user, ok := c.get(id); ok {
+		c.hits.Add(1)
+		return user, nil
+	}
+	c.misses.Add(1)
+
+	v, err, _ := c.loads.Do(id.String(), func() (any, error) {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		user, err := c.getter.GetById(id)
+		if err != nil {
+			return nil, err
+		}
+
+		c.set(id, user, generation)
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user := *v.(*User)
+	return &user, nil
+}
+
+// Invalidate drops the user from the cache.
+func (c *Cache) Invalidate(id uuid.UUID) {
+	c.invalidations.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.entries[id]; ok {
+		c.remove(elem)
+	}
+}
+
+// InvalidateRole drops the users with the role from the cache, after its permissions changed.
+func (c *Cache) InvalidateRole(name string) {
+	c.invalidations.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, elem := range c.entries {
+		if elem.Value.(*cacheEntry).user.Role.Name == name {
+			c.remove(elem)
+		}
+	}
+}
+
+// Purge drops every user from the cache.
+func (c *Cache) Purge() {
+	c.invalidations.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.entries = make(map[uuid.UUID]*list.Element)
+	c.order.Init()
+}
+
+// Apply applies a change announced on NotifyChannel.
+func (c *Cache) Apply(payload string) {
+	var n changeNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		c.logger.Error("invalid user change notification", "error", err)
+		return
+	}
+
+	if n.UserID != nil {
+		c.Invalidate(*n.UserID)
+	}
+	if n.Role != "" {
+		c.InvalidateRole(n.Role)
+	}
+}
+
+// Resync is called when the notification listener reconnects. The changes announced while it
+// was disconnected are lost, so the whole cache is dropped.
+func (c *Cache) Resync() {
+	c.Purge()
+}
+
+// Stats returns the cache counters, for metrics.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.order.Len()
+	c.mu.Unlock()
+
+	return CacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Size:          size,
+	}
+}
+
+func (c *Cache) get(id uuid.UUID) (*User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	user := *entry.user
+	return &user, true
+}
+
+func (c *Cache) set(id uuid.UUID, user *User, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || c.capacity <= 0 {
+		return
+	}
+
+	entry := &cacheEntry{id: id, user: user, expiresAt: c.now().Add(c.ttl)}
+	if elem, ok := c.entries[id]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions.Add(1)
+	}
+}
+
+// remove drops the element from the cache. The caller must hold the lock.
+func (c *Cache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).id)
+}
@@ -0,0 +1,264 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingGetter returns the users it holds, counting how many times it was asked.
+// When release is set, each call waits for it to be closed first.
+type countingGetter struct {
+	mu      sync.Mutex
+	users   map[uuid.UUID]*User
+	calls   atomic.Int64
+	release chan struct{}
+}
+
+func newCountingGetter(users ...*User) *countingGetter {
+	g := &countingGetter{users: map[uuid.UUID]*User{}}
+	for _, user := range users {
+		copied := *user
+		g.users[user.ID] = &copied
+	}
+	return g
+}
+
+func (g *countingGetter) GetById(id uuid.UUID) (*User, error) {
+	g.calls.Add(1)
+	if g.release != nil {
+		<-g.release
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	user, ok := g.users[id]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (g *countingGetter) setEmail(id uuid.UUID, email string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.users[id].Email = email
+}
+
+func newTestCache(getter userGetter, opts ...CacheOption) *Cache {
+	return NewCache(slog.New(slog.NewTextHandler(io.Discard, nil)), getter, opts...)
+}
+
+func testUser(role string) *User {
+	id := uuid.New()
+	return &User{ID: id, Email: fmt.Sprintf("%s@example.com", id), Role: Role{Name: role}}
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	user := testUser(RoleRegularUser)
+	getter := newCountingGetter(user)
+	cache := newTestCache(getter)
+
+	for range 3 {
+		got, err := cache.GetById(user.ID)
+		if err != nil || got.Email != user.Email {
+			t.Fatalf("Expected the user, got %+v, %v", got, err)
+		}
+	}
+
+	if _, err := cache.GetById(uuid.New()); !errors.Is(err, database.ErrRecordNotFound) {
+		t.Errorf("Expected ErrRecordNotFound for an unknown user, got %v", err)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Size != 1 {
+		t.Errorf("Expected 2 hits, 2 misses and 1 user cached, got %+v", stats)
+	}
+	if calls := getter.calls.Load(); calls != 2 {
+		t.Errorf("Expected 2 reads from the getter, got %d", calls)
+	}
+}
+
+func TestCacheReturnsCopies(t *testing.T) {
+	user := testUser(RoleRegularUser)
+	cache := newTestCache(newCountingGetter(user))
+
+	got, _ := cache.GetById(user.ID)
+	got.Email = "changed@example.com"
+
+	if again, _ := cache.GetById(user.ID); again.Email != user.Email {
+		t.Errorf("Expected changes to a returned user not to reach the cache, got %s", again.Email)
+	}
+}
+
+func TestCacheExpiresUsers(t *testing.T) {
+	user := testUser(RoleRegularUser)
+	getter := newCountingGetter(user)
+	cache := newTestCache(getter, WithCacheTTL(time.Minute))
+
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	_, _ = cache.GetById(user.ID)
+	getter.setEmail(user.ID, "new@example.com")
+
+	now = now.Add(30 * time.Second)
+	if got, _ := cache.GetById(user.ID); got.Email != user.Email {
+		t.Errorf("Expected the cached user before the TTL, got %s", got.Email)
+	}
+
+	now = now.Add(time.Minute)
+	if got, _ := cache.GetById(user.ID); got.Email != "new@example.com" {
+		t.Errorf("Expected the user to be read again after the TTL, got %s", got.Email)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	first, second, third := testUser(RoleRegularUser), testUser(RoleRegularUser), testUser(RoleRegularUser)
+	getter := newCountingGetter(first, second, third)
+	cache := newTestCache(getter, WithCacheCapacity(2))
+
+	_, _ = cache.GetById(first.ID)
+	_, _ = cache.GetById(second.ID)
+	// Using the first user makes the second the least recently used
+	_, _ = cache.GetById(first.ID)
+	_, _ = cache.GetById(third.ID)
+
+	stats := cache.Stats()
+	if stats.Evictions != 1 || stats.Size != 2 {
+		t.Fatalf("Expected 1 eviction and 2 users cached, got %+v", stats)
+	}
+
+	calls := getter.calls.Load()
+	_, _ = cache.GetById(first.ID)
+	if getter.calls.Load() != calls {
+		t.Error("Expected the recently used user to stay cached")
+	}
+	_, _ = cache.GetById(second.ID)
+	if getter.calls.Load() != calls+1 {
+		t.Error("Expected the least recently used user to be evicted")
+	}
+}
+
+func TestCacheCollapsesConcurrentMisses(t *testing.T) {
+	user := testUser(RoleRegularUser)
+	getter := newCountingGetter(user)
+	getter.release = make(chan struct{})
+	cache := newTestCache(getter)
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetById(user.ID); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Wait for the first load to start, and give the others time to join it
+	for getter.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(getter.release)
+	wg.Wait()
+
+	if calls := getter.calls.Load(); calls != 1 {
+		t.Errorf("Expected concurrent misses to read the user once, got %d reads", calls)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	admin, regular := testUser("admin"), testUser(RoleRegularUser)
+	getter := newCountingGetter(admin, regular)
+	cache := newTestCache(getter)
+
+	_, _ = cache.GetById(admin.ID)
+	_, _ = cache.GetById(regular.ID)
+
+	getter.setEmail(regular.ID, "new@example.com")
+	cache.Apply(fmt.Sprintf(`{"userId": %q}`, regular.ID))
+	if got, _ := cache.GetById(regular.ID); got.Email != "new@example.com" {
+		t.Errorf("Expected the changed user to be read again, got %s", got.Email)
+	}
+
+	calls := getter.calls.Load()
+	cache.Apply(`{"role": "admin"}`)
+	_, _ = cache.GetById(admin.ID)
+	_, _ = cache.GetById(regular.ID)
+	if getter.calls.Load() != calls+1 {
+		t.Error("Expected only the users with the changed role to be read again")
+	}
+
+	cache.Resync()
+	if size := cache.Stats().Size; size != 0 {
+		t.Errorf("Expected a resync to drop every user, got %d cached", size)
+	}
+}
+
+func TestCacheDoesNotKeepLoadsRacingAnInvalidation(t *testing.T) {
+	user := testUser(RoleRegularUser)
+	getter := newCountingGetter(user)
+	getter.release = make(chan struct{})
+	cache := newTestCache(getter)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = cache.GetById(user.ID)
+	}()
+
+	// The user changes while the load that read them before the change is in flight
+	for getter.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cache.Invalidate(user.ID)
+	close(getter.release)
+	<-done
+
+	if size := cache.Stats().Size; size != 0 {
+		t.Errorf("Expected the user read before the invalidation not to be cached, got %d cached", size)
+	}
+}
+
+// cachedUserRepo is a userRepository over a countingGetter, for the service tests.
+type cachedUserRepo struct {
+	userRepository
+	*countingGetter
+}
+
+func (r cachedUserRepo) GetById(id uuid.UUID) (*User, error) {
+	return r.countingGetter.GetById(id)
+}
+
+func (r cachedUserRepo) UpdateEmail(user *User) error {
+	r.setEmail(user.ID, user.Email)
+	return nil
+}
+
+func TestUserServiceInvalidatesCache(t *testing.T) {
+	user := testUser(RoleRegularUser)
+	repo := cachedUserRepo{countingGetter: newCountingGetter(user)}
+	service := NewUserService(repo, WithCache(newTestCache(repo)))
+
+	_, _ = service.GetById(user.ID)
+	if err := service.UpdateUserEmail(user.ID, "new@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := service.GetById(user.ID)
+	if err != nil || got.Email != "new@example.com" {
+		t.Errorf("Expected the updated user, got %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,195 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"go-web-api-starter/internal/jwtauth"
+	"go-web-api-starter/internal/validator"
+	"os"
+	"slices"
+	"strings"
+)
+
+// RoleSource is where Authenticate takes the role and permissions of users authenticated with a token from.
+type RoleSource string
+
+const (
+	// RolesFromDatabase keeps the role users have in the database.
+	RolesFromDatabase RoleSource = "database"
+	// RolesFromClaims replaces the role users have in the database with the one their token's claims map to.
+	RolesFromClaims RoleSource = "claims"
+	// RolesFromBoth keeps the name of the role users have in the database, unless they have none,
+	// and grants them the permissions of that role and the ones their token's claims map to.
+	RolesFromBoth RoleSource = "hybrid"
+)
+
+// ParseRoleSource parses the name of a RoleSource.
+func ParseRoleSource(s string) (RoleSource, error) {
+	switch source := RoleSource(s); source {
+	case RolesFromDatabase, RolesFromClaims, RolesFromBoth:
+		return source, nil
+	default:
+		return "", fmt.Errorf("invalid role source %q, expected database, claims or hybrid", s)
+	}
+}
+
+// RoleRule maps a claim to a role: a token whose claim at the Claim path is one of Values,
+// or contains one of them when it is a list, is granted the Role.
+type RoleRule struct {
+	Claim  string   `json:"claim"`
+	Values []string `json:"values"`
+	Role   string   `json:"role"`
+}
+
+// ClaimMapping derives a role from the claims of a token, for deployments that keep authorization
+// in their identity provider. Mappings are usually loaded from a JSON file with LoadClaimMapping,
+// for example for roles kept in Supabase's app_metadata:
+//
+//	{
+//	  "roleClaims": ["app_metadata.roles"],
+//	  "permissionClaims": ["app_metadata.permissions"],
+//	  "rules": [{"claim": "email", "values": ["ops@example.com"], "role": "admin"}],
+//	  "roles": {"admin": ["users:*"], "support": ["*:read"], "regular": []},
+//	  "parents": {"admin": ["support"]},
+//	  "defaultRole": "regular"
+//	}
+type ClaimMapping struct {
+	// RoleClaims are the paths of the claims holding role names, as a string or a list of strings.
+	RoleClaims []string `json:"roleClaims"`
+	// PermissionClaims are the paths of the claims holding permission codes, granted as they are.
+	PermissionClaims []string `json:"permissionClaims"`
+	// Rules grant roles to tokens whose claims hold given values.
+	Rules []RoleRule `json:"rules"`
+	// Roles are the permissions each role grants. Roles missing from it grant none.
+	Roles map[string]Permissions `json:"roles"`
+	// Parents are the roles each role inherits the permissions of.
+	Parents RoleHierarchy `json:"parents"`
+	// DefaultRole is granted to tokens whose claims map to no role.
+	DefaultRole string `json:"defaultRole"`
+}
+
+// LoadClaimMapping parses and validates a JSON claim mapping.
+func LoadClaimMapping(data []byte) (*ClaimMapping, error) {
+	var mapping ClaimMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid claim mapping: %w", err)
+	}
+
+	v := validator.New()
+	if ValidateClaimMapping(v, &mapping); !v.Valid() {
+		return nil, fmt.Errorf("invalid claim mapping: %v", v.Errors)
+	}
+
+	return &mapping, nil
+}
+
+// LoadClaimMappingFile reads the claim mapping from a JSON file, see LoadClaimMapping.
+func LoadClaimMappingFile(path string) (*ClaimMapping, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read claim mapping: %w", err)
+	}
+
+	return LoadClaimMapping(data)
+}
+
+func ValidateClaimMapping(v *validator.Validator, m *ClaimMapping) {
+	v.Check(len(m.RoleClaims) > 0 || len(m.PermissionClaims) > 0 || len(m.Rules) > 0 || m.DefaultRole != "",
+		"claimMapping", "must map claims to roles or permissions, or have a default role")
+	v.Check(!slices.Contains(m.RoleClaims, ""), "roleClaims", "must not contain empty paths")
+	v.Check(!slices.Contains(m.PermissionClaims, ""), "permissionClaims", "must not contain empty paths")
+	for i, rule := range m.Rules {
+		key := fmt.Sprintf("rules[%d]", i)
+		v.Check(rule.Claim != "", key+".claim", "must be provided")
+		v.Check(len(rule.Values) > 0, key+".values", "must be provided")
+		v.Check(rule.Role != "", key+".role", "must be provided")
+	}
+	if err := m.Parents.CheckCycles(); err != nil {
+		v.AddError("parents", err.Error())
+	}
+}
+
+// Map returns the role the claims map to. Its name is the first role named by the RoleClaims,
+// then by the Rules, or else the DefaultRole. Its permissions are the ones of every role
+// the claims map to and of the roles they inherit from, and the ones the PermissionClaims hold.
+func (m *ClaimMapping) Map(claims *jwtauth.Claims) Role {
+	var roles []string
+	for _, path := range m.RoleClaims {
+		roles = append(roles, claimStrings(claims, path)...)
+	}
+	for _, rule := range m.Rules {
+		if slices.ContainsFunc(claimStrings(claims, rule.Claim), func(value string) bool {
+			return slices.Contains(rule.Values, value)
+		}) {
+			roles = append(roles, rule.Role)
+		}
+	}
+	if len(roles) == 0 && m.DefaultRole != "" {
+		roles = append(roles, m.DefaultRole)
+	}
+
+	permissions := Permissions{}
+	for _, role := range roles {
+		permissions = mergePermissions(permissions, m.Parents.Permissions(role, m.Roles))
+	}
+	for _, path := range m.PermissionClaims {
+		permissions = mergePermissions(permissions, claimStrings(claims, path))
+	}
+
+	var name string
+	if len(roles) > 0 {
+		name = roles[0]
+	}
+	return NewRole(name, permissions)
+}
+
+// Apply returns the role of a user, whose role in the database is dbRole, authenticated with the claims.
+func (m *ClaimMapping) Apply(source RoleSource, dbRole Role, claims *jwtauth.Claims) Role {
+	switch source {
+	case RolesFromClaims:
+		return m.Map(claims)
+	case RolesFromBoth:
+		mapped := m.Map(claims)
+		name := dbRole.Name
+		if name == "" {
+			name = mapped.Name
+		}
+		return NewRole(name, mergePermissions(append(Permissions{}, dbRole.Permissions...), mapped.Permissions))
+	default:
+		return dbRole
+	}
+}
+
+// claimStrings returns the non-empty strings of the claim at the path, which may be a string
+// or a list. A space separated string, like the OAuth scope claim, holds one value per word.
+func claimStrings(claims *jwtauth.Claims, path string) []string {
+	value, ok := claims.Lookup(path)
+	if !ok {
+		return nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok && s != "" {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// mergePermissions appends the codes missing from the permissions to them.
+func mergePermissions(permissions Permissions, codes []string) Permissions {
+	for _, code := range codes {
+		if !slices.Contains(permissions, code) {
+			permissions = append(permissions, code)
+		}
+	}
+	return permissions
+}
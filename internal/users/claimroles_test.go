@@ -0,0 +1,168 @@
+package users
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/jwtauth"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+const testClaimMapping = `{
+  "roleClaims": ["app_metadata.roles"],
+  "permissionClaims": ["app_metadata.permissions", "scope"],
+  "rules": [
+    {"claim": "email", "values": ["ops@example.com"], "role": "admin"},
+    {"claim": "app_metadata.groups", "values": ["support"], "role": "support"}
+  ],
+  "roles": {
+    "admin": ["users:manage", "audit:read"],
+    "support": ["audit:read"],
+    "regular": [],
+    "auditor": ["*:read"]
+  },
+  "parents": {"support": ["auditor"]},
+  "defaultRole": "regular"
+}`
+
+func readTestClaims(t *testing.T, data string) *jwtauth.Claims {
+	t.Helper()
+	var claims jwtauth.Claims
+	if err := json.Unmarshal([]byte(data), &claims); err != nil {
+		t.Fatal(err)
+	}
+	return &claims
+}
+
+func TestClaimMappingMap(t *testing.T) {
+	mapping, err := LoadClaimMapping([]byte(testClaimMapping))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name        string
+		claims      string
+		role        string
+		permissions []string
+	}{
+		{"no claims", `{}`, "regular", []string{}},
+		{"role list", `{"app_metadata": {"roles": ["support", "admin"]}}`, "support", []string{"audit:read", "*:read", "users:manage"}},
+		{"role string", `{"app_metadata": {"roles": "admin"}}`, "admin", []string{"users:manage", "audit:read"}},
+		{"unknown role", `{"app_metadata": {"roles": ["guest"]}}`, "guest", []string{}},
+		{"rule on a string", `{"email": "ops@example.com"}`, "admin", []string{"users:manage", "audit:read"}},
+		{"rule on a list", `{"app_metadata": {"groups": ["dev", "support"]}}`, "support", []string{"audit:read", "*:read"}},
+		{"rule not matching", `{"email": "jane@example.com", "app_metadata": {"groups": ["dev"]}}`, "regular", []string{}},
+		{"permission claims", `{"app_metadata": {"permissions": ["tokens:revoke"]}, "scope": "audit:read users:manage"}`, "regular", []string{"tokens:revoke", "audit:read", "users:manage"}},
+		{"role claims before rules", `{"email": "ops@example.com", "app_metadata": {"roles": ["support"]}}`, "support", []string{"audit:read", "*:read", "users:manage"}},
+		{"wrong types", `{"app_metadata": {"roles": 42, "permissions": [1, "", "audit:read"]}}`, "regular", []string{"audit:read"}},
+		{"path through a non-object", `{"app_metadata": "admin"}`, "regular", []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			role := mapping.Map(readTestClaims(t, tc.claims))
+			if role.Name != tc.role {
+				t.Errorf("Expected role %q, got %q", tc.role, role.Name)
+			}
+			if !slices.Equal(role.Permissions, tc.permissions) {
+				t.Errorf("Expected permissions %v, got %v", tc.permissions, role.Permissions)
+			}
+		})
+	}
+}
+
+func TestClaimMappingApply(t *testing.T) {
+	mapping, _ := LoadClaimMapping([]byte(testClaimMapping))
+	claims := readTestClaims(t, `{"app_metadata": {"roles": ["support"]}}`)
+	dbRole := Role{Name: "editor", Permissions: Permissions{"posts:write", "audit:read"}}
+
+	testCases := []struct {
+		source      RoleSource
+		dbRole      Role
+		role        string
+		permissions []string
+	}{
+		{RolesFromDatabase, dbRole, "editor", []string{"posts:write", "audit:read"}},
+		{RolesFromClaims, dbRole, "support", []string{"audit:read", "*:read"}},
+		{RolesFromBoth, dbRole, "editor", []string{"posts:write", "audit:read", "*:read"}},
+		{RolesFromBoth, Role{}, "support", []string{"audit:read", "*:read"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.source)+" "+tc.dbRole.Name, func(t *testing.T) {
+			role := mapping.Apply(tc.source, tc.dbRole, claims)
+			if role.Name != tc.role || !slices.Equal(role.Permissions, tc.permissions) {
+				t.Errorf("Expected %s with %v, got %s with %v", tc.role, tc.permissions, role.Name, role.Permissions)
+			}
+		})
+	}
+
+	if !slices.Equal(dbRole.Permissions, Permissions{"posts:write", "audit:read"}) {
+		t.Errorf("Expected the database role not to be changed, got %v", dbRole.Permissions)
+	}
+}
+
+func TestLoadClaimMappingValidates(t *testing.T) {
+	invalid := map[string]string{
+		"not json":          `[`,
+		"empty":             `{}`,
+		"empty path":        `{"roleClaims": [""]}`,
+		"incomplete rule":   `{"rules": [{"claim": "email", "values": ["ops@example.com"]}]}`,
+		"rule without vals": `{"rules": [{"claim": "email", "role": "admin"}]}`,
+		"parents cycle":     `{"defaultRole": "regular", "parents": {"admin": ["support"], "support": ["admin"]}}`,
+	}
+
+	for name, data := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadClaimMapping([]byte(data)); err == nil {
+				t.Error("Expected the mapping to be rejected")
+			}
+		})
+	}
+
+	if _, err := ParseRoleSource("ldap"); err == nil {
+		t.Error("Expected an unknown role source to be rejected")
+	}
+}
+
+func TestAuthenticateWithClaimRoles(t *testing.T) {
+	userID := uuid.New()
+	mapping, _ := LoadClaimMapping([]byte(testClaimMapping))
+
+	reader := &MockJWTReader{
+		ReadFunc: func(string) (*jwtauth.Claims, error) {
+			return readTestClaims(t, `{"sub": "`+userID.String()+`", "app_metadata": {"roles": ["admin"]}}`), nil
+		},
+		ValidateClaimsFunc: func(*jwtauth.Claims) error { return nil },
+	}
+	getter := &MockUserGetterInserter{
+		GetByIdFunc: func(id uuid.UUID) (*User, error) {
+			return &User{ID: id, Email: "jane@example.com", Role: RegularRole}, nil
+		},
+	}
+
+	var role Role
+	handler := Authenticate(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		reader,
+		getter,
+		WithClaimRoles(mapping, RolesFromClaims),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role = ContextGetUser(r).Role
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, createAuthTestRequest("GET", "/", "Bearer token"))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if role.Name != "admin" || !role.Permissions.Includes(PermUsersManage, PermAuditRead) {
+		t.Errorf("Expected the role from the claims, got %+v", role)
+	}
+}
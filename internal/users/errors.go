@@ -5,4 +5,18 @@ import "errors"
 var (
 	ErrRoleNotFound   = errors.New("role not found in database")
 	ErrDuplicateEmail = errors.New("duplicate email in users table")
+	// ErrDuplicateRole is returned when a role is created or renamed with the name of another role
+	ErrDuplicateRole = errors.New("duplicate name in roles table")
+	// ErrDuplicatePermission is returned when a permission is created with the code of another one
+	ErrDuplicatePermission = errors.New("duplicate code in permissions table")
+	// ErrPermissionNotFound is returned when a role is granted a permission that does not exist
+	ErrPermissionNotFound = errors.New("permission not found in database")
+	// ErrRoleInUse is returned when a role that users still hold is deleted
+	ErrRoleInUse = errors.New("role is held by users")
+	// ErrProtectedRole is returned when a role the application relies on is renamed or deleted
+	ErrProtectedRole = errors.New("role is protected")
+	// ErrRoleCycle is returned when a role would inherit from itself through its parents
+	ErrRoleCycle = errors.New("role inherits from itself")
+	// ErrLastAdmin is returned by role changes that would leave no active user able to manage users
+	ErrLastAdmin = errors.New("change would remove the last user with the users:manage permission")
 )
@@ -1,18 +1,30 @@
 package users
 
 import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
 	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/database"
 	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/validator"
 	"log/slog"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// GetCurrentUserHandler responds with the authenticated user, or with a null user
+// for anonymous requests let through by an optional Authenticate.
 func GetCurrentUserHandler(
 	logger *slog.Logger,
 ) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user := ContextGetUser(r)
-		responseData := apiutils.Envelope{"user": user}
+		responseData := apiutils.Envelope{"user": nil}
+		if user, ok := ContextLookupUser(r); ok && !user.IsAnonymous() {
+			responseData["user"] = user
+		}
 
 		err := apiutils.WriteJson(w, http.StatusOK, responseData, http.Header{})
 		if err != nil {
@@ -21,3 +33,147 @@ func GetCurrentUserHandler(
 		}
 	})
 }
+
+type accountSuspender interface {
+	GetById(id uuid.UUID) (*User, error)
+	SuspendUser(userId uuid.UUID, suspension *Suspension) error
+	ReinstateUser(userId uuid.UUID) error
+}
+
+// SuspendUserHandler suspends the user with the id in the path, for the reason in the body and,
+// when until is given, until then. The user's existing sessions are revoked with every revoker,
+// so the suspension also applies to the tokens and cookies they already hold.
+func SuspendUserHandler(
+	logger *slog.Logger,
+	suspender accountSuspender,
+	revokers ...SessionRevoker,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Reason string     `json:"reason"`
+			Until  *time.Time `json:"until"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		suspension := &Suspension{
+			Reason:      strings.TrimSpace(input.Reason),
+			SuspendedBy: uuid.NullUUID{UUID: ContextGetUserId(r), Valid: true},
+			Until:       input.Until,
+		}
+
+		v := validator.New()
+		userID := apiutils.ReadUUIDPath(r, "id", v)
+		ValidateSuspension(v, suspension)
+		v.Check(userID != ContextGetUserId(r), "id", "you cannot suspend your own account")
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "user", userID.String())
+
+		before, ok := getAccount(w, r, logger, suspender, userID)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := suspender.SuspendUser(userID, suspension); err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		for _, revoker := range revokers {
+			if err := revoker.RevokeAllSessions(userID); err != nil {
+				apiutils.ServerErrorResponse(w, r, logger, fmt.Errorf("user suspended but sessions not revoked: %w", err))
+				return
+			}
+		}
+
+		after := *before
+		after.Suspension = suspension
+		audit.SetAfter(r, after)
+
+		env := apiutils.Envelope{"user": after, "status": after.Status()}
+		err := apiutils.WriteJson(w, http.StatusOK, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("SuspendUserHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ReinstateUserHandler lifts the suspension of the user with the id in the path.
+// Sessions revoked by the suspension stay revoked, the user has to sign in again.
+func ReinstateUserHandler(
+	logger *slog.Logger,
+	suspender accountSuspender,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := validator.New()
+		userID := apiutils.ReadUUIDPath(r, "id", v)
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		audit.SetResource(r, "user", userID.String())
+
+		before, ok := getAccount(w, r, logger, suspender, userID)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := suspender.ReinstateUser(userID); err != nil {
+			switch {
+			case errors.Is(err, database.ErrRecordNotFound):
+				apiutils.NotFoundResponse(w, r, logger)
+			default:
+				apiutils.ServerErrorResponse(w, r, logger, err)
+			}
+			return
+		}
+
+		after := *before
+		after.Suspension = nil
+		audit.SetAfter(r, after)
+
+		env := apiutils.Envelope{"user": after, "status": after.Status()}
+		err := apiutils.WriteJson(w, http.StatusOK, env, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ReinstateUserHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// getAccount returns the user whose account is being changed, responding with
+// 404 if there is no such user or they were deleted.
+func getAccount(w http.ResponseWriter, r *http.Request, logger *slog.Logger, getter UserGetter, userID uuid.UUID) (*User, bool) {
+	user, err := getter.GetById(userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrRecordNotFound):
+			apiutils.NotFoundResponse(w, r, logger)
+		default:
+			apiutils.ServerErrorResponse(w, r, logger, err)
+		}
+		return nil, false
+	}
+	if user.IsDeleted {
+		apiutils.NotFoundResponse(w, r, logger)
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,113 @@
+package users
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type mockAccountSuspender struct {
+	users map[uuid.UUID]*User
+}
+
+func (m *mockAccountSuspender) GetById(id uuid.UUID) (*User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, database.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (m *mockAccountSuspender) SuspendUser(id uuid.UUID, suspension *Suspension) error {
+	m.users[id].Suspension = suspension
+	return nil
+}
+
+func (m *mockAccountSuspender) ReinstateUser(id uuid.UUID) error {
+	m.users[id].Suspension = nil
+	return nil
+}
+
+func serveAccountRequest(handler http.Handler, admin *User, path, userID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, bytes.NewBufferString(body))
+	req.SetPathValue("id", userID)
+
+	rec := httptest.NewRecorder()
+	addUserHandler(admin, handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSuspendUserRevokesSessions(t *testing.T) {
+	admin := &User{ID: uuid.New()}
+	target := &User{ID: uuid.New(), Email: "abuser@example.com"}
+	suspender := &mockAccountSuspender{users: map[uuid.UUID]*User{target.ID: target}}
+
+	var revoked []uuid.UUID
+	revoker := SessionRevokerFunc(func(userID uuid.UUID) error {
+		revoked = append(revoked, userID)
+		return nil
+	})
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := SuspendUserHandler(logger, suspender, revoker, revoker)
+
+	rec := serveAccountRequest(handler, admin, "/suspend", target.ID.String(), `{"reason": "spam"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	if target.Status() != StatusSuspended || target.Suspension.SuspendedBy.UUID != admin.ID {
+		t.Error("Expected the user to be suspended by the admin")
+	}
+	if len(revoked) != 2 || revoked[0] != target.ID {
+		t.Errorf("Expected every revoker to revoke the user's sessions, got %v", revoked)
+	}
+
+	handler = ReinstateUserHandler(logger, suspender)
+	rec = serveAccountRequest(handler, admin, "/reinstate", target.ID.String(), "")
+	if rec.Code != http.StatusOK || target.Status() != StatusActive {
+		t.Errorf("Expected the user to be reinstated, got %d", rec.Code)
+	}
+}
+
+func TestSuspendUserValidation(t *testing.T) {
+	admin := &User{ID: uuid.New()}
+	target := &User{ID: uuid.New()}
+	deleted := &User{ID: uuid.New(), IsDeleted: true}
+	suspender := &mockAccountSuspender{users: map[uuid.UUID]*User{target.ID: target, deleted.ID: deleted}}
+
+	failing := SessionRevokerFunc(func(userID uuid.UUID) error {
+		return errors.New("should not be called")
+	})
+	handler := SuspendUserHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), suspender, failing)
+
+	testCases := []struct {
+		name     string
+		userID   string
+		body     string
+		expected int
+	}{
+		{"missing reason", target.ID.String(), `{"reason": " "}`, http.StatusUnprocessableEntity},
+		{"expiry in the past", target.ID.String(), `{"reason": "spam", "until": "2000-01-01T00:00:00Z"}`, http.StatusUnprocessableEntity},
+		{"own account", admin.ID.String(), `{"reason": "spam"}`, http.StatusUnprocessableEntity},
+		{"unknown user", uuid.NewString(), `{"reason": "spam"}`, http.StatusNotFound},
+		{"deleted user", deleted.ID.String(), `{"reason": "spam"}`, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serveAccountRequest(handler, admin, "/suspend", tc.userID, tc.body)
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status code %d, got %d: %s", tc.expected, rec.Code, rec.Body)
+			}
+			if target.Suspension != nil {
+				t.Error("Expected the user not to be suspended")
+			}
+		})
+	}
+}
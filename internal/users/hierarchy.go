@@ -0,0 +1,77 @@
+package users
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// RoleHierarchy holds the names of the parent roles each role inherits the permissions of, by role name.
+type RoleHierarchy map[string][]string
+
+// CheckCycles returns an error wrapping ErrRoleCycle, naming the roles involved,
+// if a role inherits from itself through its parents.
+func (h RoleHierarchy) CheckCycles() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	state := map[string]int{}
+	var path []string
+
+	var visit func(role string) error
+	visit = func(role string) error {
+		switch state[role] {
+		case visiting:
+			cycle := append(slices.Clone(path[slices.Index(path, role):]), role)
+			return fmt.Errorf("%w: %s", ErrRoleCycle, strings.Join(cycle, " -> "))
+		case visited:
+			return nil
+		}
+
+		state[role] = visiting
+		path = append(path, role)
+		for _, parent := range h[role] {
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[role] = visited
+		return nil
+	}
+
+	for _, role := range slices.Sorted(maps.Keys(h)) {
+		if err := visit(role); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ancestors returns the role followed by every role it inherits from, directly or not,
+// nearest first and without duplicates. It terminates even if the hierarchy has cycles.
+func (h RoleHierarchy) Ancestors(role string) []string {
+	ancestors := []string{role}
+	for i := 0; i < len(ancestors); i++ {
+		for _, parent := range h[ancestors[i]] {
+			if !slices.Contains(ancestors, parent) {
+				ancestors = append(ancestors, parent)
+			}
+		}
+	}
+	return ancestors
+}
+
+// Permissions returns the permissions the role is granted, directly or through the roles it inherits
+// from, given the permissions granted directly to each role.
+func (h RoleHierarchy) Permissions(role string, granted map[string]Permissions) Permissions {
+	permissions := Permissions{}
+	for _, ancestor := range h.Ancestors(role) {
+		permissions = mergePermissions(permissions, granted[ancestor])
+	}
+	return permissions
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"go-web-api-starter/internal/apiutils"
 	"go-web-api-starter/internal/database"
@@ -18,20 +17,24 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	claimsContextKey = contextKey("claims")
+	actorContextKey  = contextKey("actor")
+)
 
-// contextSetUser associates the provided *data.User with the *http.Request using Context.
+// ContextSetUser associates the provided *data.User with the *http.Request using Context.
 // It can be later retrieved in other parts of the code that have access to this http.Request using contextGetUser.
 // This operation does not modify the incoming http.Request but instead returns a new http.Request
 // with the new Context. The original http.Request should be discarded and the returned http.Request should be used thereafter.
-func contextSetUser(r *http.Request, user *User) *http.Request {
+func ContextSetUser(r *http.Request, user *User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
 	return r.WithContext(ctx)
 }
 
 // ContextGetUser retrieves the *data.User associated with the *http.Request.
 // This method will panic with the message "missing user value in request context"
-// if no user value is available in the request context. This could happen if the contextSetUser method
+// if no user value is available in the request context. This could happen if the ContextSetUser method
 // was not called to associate a User value with this request, or if the value was associated
 // but is not of the expected *data.User type.
 func ContextGetUser(r *http.Request) *User {
@@ -43,11 +46,68 @@ func ContextGetUser(r *http.Request) *User {
 	return user
 }
 
+// ContextLookupUser retrieves the *User associated with the *http.Request, and reports whether there is one.
+// Unlike ContextGetUser it does not panic: when no user is set, it returns the AnonymousUser and false.
+// Requests let through by an optional Authenticate have the AnonymousUser set, so it returns true for them.
+func ContextLookupUser(r *http.Request) (*User, bool) {
+	user, ok := r.Context().Value(userContextKey).(*User)
+	if !ok || user == nil {
+		return AnonymousUser, false
+	}
+
+	return user, true
+}
+
 func ContextGetUserId(r *http.Request) uuid.UUID {
 	user := ContextGetUser(r)
 	return user.ID
 }
 
+// ContextSetClaims associates the claims of the access token the request was authenticated with
+// with the *http.Request. Requests authenticated without a token, such as with an API key, have none.
+func ContextSetClaims(r *http.Request, claims *jwtauth.Claims) *http.Request {
+	ctx := context.WithValue(r.Context(), claimsContextKey, claims)
+	return r.WithContext(ctx)
+}
+
+// ContextGetClaims retrieves the claims of the access token the request was authenticated with,
+// and reports whether there are any.
+func ContextGetClaims(r *http.Request) (*jwtauth.Claims, bool) {
+	claims, ok := r.Context().Value(claimsContextKey).(*jwtauth.Claims)
+	return claims, ok && claims != nil
+}
+
+// ContextSetActor associates the user really making the request with the *http.Request, when they act
+// as the context user set with ContextSetUser, such as support staff impersonating a user.
+func ContextSetActor(r *http.Request, actor *User) *http.Request {
+	ctx := context.WithValue(r.Context(), actorContextKey, actor)
+	return r.WithContext(ctx)
+}
+
+// ContextGetActor retrieves the user really making the request when they act as the context user,
+// and reports whether they do. Requests that are not impersonated have no actor.
+func ContextGetActor(r *http.Request) (*User, bool) {
+	actor, ok := r.Context().Value(actorContextKey).(*User)
+	return actor, ok && actor != nil
+}
+
+// ContextGetActorId returns the ID of the user really making the request: the actor of an
+// impersonated request, or else the context user. Audit entries are recorded under it.
+func ContextGetActorId(r *http.Request) uuid.UUID {
+	if actor, ok := ContextGetActor(r); ok {
+		return actor.ID
+	}
+	return ContextGetUserId(r)
+}
+
+// ContextGetImpersonatedUserId returns the ID of the context user when the request is impersonated.
+func ContextGetImpersonatedUserId(r *http.Request) uuid.NullUUID {
+	if _, ok := ContextGetActor(r); !ok {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: ContextGetUserId(r), Valid: true}
+}
+
 type userGetter interface {
 	GetById(id uuid.UUID) (*User, error)
 }
@@ -62,19 +122,98 @@ type userGetterInserter interface {
 }
 
 type JWTReader interface {
-	Read(tokenString string) (jwt.MapClaims, error)
-	ValidateClaims(claims jwt.MapClaims) error
+	Read(tokenString string) (*jwtauth.Claims, error)
+	ValidateClaims(claims *jwtauth.Claims) error
+}
+
+// RevocationChecker reports whether a token with valid claims has been revoked before it expired.
+type RevocationChecker interface {
+	IsRevoked(claims *jwtauth.Claims) bool
+}
+
+type authConfig struct {
+	revocations RevocationChecker
+	optional    bool
+	roleSource  RoleSource
+	mapping     *ClaimMapping
+}
+
+// AuthOption configures optional behaviour of the Authenticate middleware.
+type AuthOption func(*authConfig)
+
+// WithRevocationChecker rejects tokens the checker reports as revoked.
+func WithRevocationChecker(checker RevocationChecker) AuthOption {
+	return func(c *authConfig) {
+		c.revocations = checker
+	}
+}
+
+// WithOptionalAuth lets requests without an Authorization header through with the AnonymousUser
+// as their context user, for public endpoints that personalize their response for signed in users.
+// Requests with a header are authenticated as usual, and rejected if the token is invalid.
+func WithOptionalAuth() AuthOption {
+	return func(c *authConfig) {
+		c.optional = true
+	}
+}
+
+// WithClaimRoles takes the role and permissions of users from the claims of their token, mapped
+// with the mapping, instead of or besides the database according to the source.
+// Users authenticated otherwise, such as with an API key, keep their role in the database,
+// unless RequireTokenRoles takes it away.
+func WithClaimRoles(mapping *ClaimMapping, source RoleSource) AuthOption {
+	return func(c *authConfig) {
+		c.mapping = mapping
+		c.roleSource = source
+	}
+}
+
+// RequireTokenRoles creates a middleware that, when the source is RolesFromClaims, grants no permission
+// to users authenticated without a token, such as with an API key or a session cookie. Their role would
+// otherwise be the one they have in the database, which is not kept up to date when roles are managed
+// in the identity provider, so permission checks refuse them with a "Forbidden" status instead.
+// Users authenticated with a token, and every user with the other sources, are let through unchanged.
+//
+// This middleware must be called after authenticating the request.
+func RequireTokenRoles(source RoleSource) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if source != RolesFromClaims {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := ContextLookupUser(r)
+			if _, hasClaims := ContextGetClaims(r); !ok || user.IsAnonymous() || hasClaims {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			withoutRole := *user
+			withoutRole.Role = NewRole("", Permissions{})
+			next.ServeHTTP(w, ContextSetUser(r, &withoutRole))
+		})
+	}
 }
 
 func Authenticate(
 	logger *slog.Logger,
 	reader JWTReader,
 	userGetterInserter userGetterInserter,
+	opts ...AuthOption,
 ) func(http.Handler) http.Handler {
+	cfg := &authConfig{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Retrieve the auth header from the request and extract/verify it
 			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" && cfg.optional {
+				next.ServeHTTP(w, ContextSetUser(r, AnonymousUser))
+				return
+			}
 			if authHeader == "" {
 				apiutils.ErrorResponse(w, r, logger, http.StatusBadRequest, "missing authorization header")
 				return
@@ -98,27 +237,18 @@ func Authenticate(
 
 			err = reader.ValidateClaims(claims)
 			if err != nil {
-				message := ""
-				switch {
-				case errors.Is(err, jwtauth.ErrExpiredToken):
-					message = "token is expired"
-				case errors.Is(err, jwtauth.ErrInvalidIssuer):
-					message = "invalid issuer on jwt"
-				case errors.Is(err, jwtauth.ErrEmptySubject):
-					message = "the jwt has no subject"
-				}
-
-				apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, message)
+				apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, claimsErrorMessage(err))
 				return
 			}
 
-			// Retrieve the user from the database and add it to the context
-			userId, err := claims.GetSubject()
-			if err != nil {
-				apiutils.ErrorResponse(w, r, logger, http.StatusBadRequest, "userId not in JWT subject")
+			if cfg.revocations != nil && cfg.revocations.IsRevoked(claims) {
+				apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "token has been revoked")
 				return
 			}
 
+			// Retrieve the user from the database and add it to the context
+			userId := claims.Subject
+
 			userUuid, err := uuid.Parse(userId)
 			if err != nil {
 				apiutils.ErrorResponse(w, r, logger, http.StatusBadRequest, "userId not a valid UUID")
@@ -132,8 +262,11 @@ func Authenticate(
 					// If the user does not exist, it's because of an issue with the webhook
 					// We know the user is legitimate, because it's signed with the supabase jwt secret
 					// Therefor we add them to the database, and authenticate
-					email := claims["email"].(string)
-					user, err = insertAndRetrieveUnknownUser(userGetterInserter, email, userUuid)
+					if claims.Email == "" {
+						apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "the jwt has no email to register the user with")
+						return
+					}
+					user, err = insertAndRetrieveUnknownUser(userGetterInserter, claims.Email, userUuid)
 					if err != nil {
 						apiutils.ServerErrorResponse(w, r, logger, err)
 						return
@@ -144,7 +277,16 @@ func Authenticate(
 				}
 			}
 
-			ur := contextSetUser(r, user)
+			if message := InactiveUserMessage(user); message != "" {
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, message)
+				return
+			}
+
+			if cfg.mapping != nil {
+				user.Role = cfg.mapping.Apply(cfg.roleSource, user.Role, claims)
+			}
+
+			ur := ContextSetClaims(ContextSetUser(r, user), claims)
 
 			// Log the auth so we can associate with a request_id
 			requestId := r.Header.Get("X-Request-ID")
@@ -159,6 +301,35 @@ func Authenticate(
 	}
 }
 
+// claimsErrorMessage turns a claim validation error from the JWTReader into a message for the client.
+func claimsErrorMessage(err error) string {
+	var missingClaim jwtauth.ErrMissingClaim
+	var invalidClaim jwtauth.ErrInvalidClaim
+
+	switch {
+	case errors.Is(err, jwtauth.ErrExpiredToken):
+		return "token is expired"
+	case errors.Is(err, jwtauth.ErrTokenNotYetValid):
+		return "token is not valid yet"
+	case errors.Is(err, jwtauth.ErrIssuedInFuture):
+		return "token was issued in the future"
+	case errors.Is(err, jwtauth.ErrTokenTooOld):
+		return "token is too old, please authenticate again"
+	case errors.Is(err, jwtauth.ErrInvalidIssuer):
+		return "invalid issuer on jwt"
+	case errors.Is(err, jwtauth.ErrInvalidAudience):
+		return "invalid audience on jwt"
+	case errors.Is(err, jwtauth.ErrEmptySubject):
+		return "the jwt has no subject"
+	case errors.As(err, &missingClaim):
+		return fmt.Sprintf("the jwt is missing the %s claim", missingClaim.Claim)
+	case errors.As(err, &invalidClaim):
+		return fmt.Sprintf("the %s claim on the jwt must be %s", invalidClaim.Claim, invalidClaim.Expected)
+	default:
+		return "invalid token"
+	}
+}
+
 func insertAndRetrieveUnknownUser(uGetterInserter userGetterInserter, email string, id uuid.UUID) (*User, error) {
 	// Insert the new user
 	err := uGetterInserter.InsertDefaultUser(email, id)
@@ -179,14 +350,54 @@ func insertAndRetrieveUnknownUser(uGetterInserter userGetterInserter, email stri
 
 //region require permissions middleware
 
+// RequireAuthenticatedUser creates a middleware that rejects requests without an authenticated user
+// with an "Unauthorized" status. It lets routes behind an optional Authenticate require a user for
+// some methods only. Unlike RequirePermissions it does not panic when no user is set.
+func RequireAuthenticatedUser(logger *slog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, ok := ContextLookupUser(r); !ok || user.IsAnonymous() {
+				apiutils.AuthenticationRequiredResponse(w, r, logger)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireVerifiedEmail creates a middleware that rejects users who have not verified their current
+// email address with a "Forbidden" status, and anonymous users with an "Unauthorized" status.
+//
+// This middleware must be called after getting the User, or it will panic.
+func RequireVerifiedEmail(logger *slog.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := ContextGetUser(r)
+
+			if user.IsAnonymous() {
+				apiutils.AuthenticationRequiredResponse(w, r, logger)
+				return
+			}
+
+			if !user.IsEmailVerified() {
+				apiutils.ErrorResponse(w, r, logger, http.StatusForbidden, "you must verify your email address to access this resource")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // RequirePermissions creates a middleware that checks if the authenticated user's role
 // has the required permissions available in the ...string argument. These permissions are
-// checked against a set of permissions associated with this user's role. The permissions set
-// is an implementation-dependent feature of the role.
+// checked against a set of permissions associated with this user's role, in which wildcards
+// like users:* or *:read grant every permission they cover.
 //
-// The middleware uses the contextGetUser() method to retrieve the user object from the request's context.
-// If the user is authenticated as the AnonymousUser or if the user's role does not include
-// one or more of the required permissions, it responds with an "Unauthorized" status.
+// The middleware uses the ContextGetUser() method to retrieve the user object from the request's context.
+// If the user is the AnonymousUser, it responds with an "Unauthorized" status, and if the user's role
+// does not include one or more of the required permissions, it responds with a "Forbidden" status.
 // If the user's role includes all the required permissions, the request handling continues
 // with the next middleware in the chain.
 //
@@ -196,7 +407,36 @@ func RequirePermissions(logger *slog.Logger, permissions ...string) func(next ht
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user := ContextGetUser(r)
 
-			if !user.Role.Permissions.Includes(permissions...) {
+			if user.IsAnonymous() {
+				apiutils.AuthenticationRequiredResponse(w, r, logger)
+				return
+			}
+
+			if !user.Role.Allows(permissions...) {
+				apiutils.ForbiddenResponse(w, r, logger)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAnyPermission works like RequirePermissions, but lets the request through when the user's
+// role includes at least one of the permissions, for routes that serve several kinds of users.
+//
+// This middleware must be called after getting the User, or it will panic.
+func RequireAnyPermission(logger *slog.Logger, permissions ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := ContextGetUser(r)
+
+			if user.IsAnonymous() {
+				apiutils.AuthenticationRequiredResponse(w, r, logger)
+				return
+			}
+
+			if !user.Role.AllowsAny(permissions...) {
 				apiutils.ForbiddenResponse(w, r, logger)
 				return
 			}
@@ -13,21 +13,22 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 //region auth tests
 
 // Mock implementations
 type MockJWTReader struct {
-	ReadFunc           func(tokenString string) (jwt.MapClaims, error)
-	ValidateClaimsFunc func(claims jwt.MapClaims) error
+	ReadFunc           func(tokenString string) (*jwtauth.Claims, error)
+	ValidateClaimsFunc func(claims *jwtauth.Claims) error
 }
 
-func (m *MockJWTReader) Read(tokenString string) (jwt.MapClaims, error) {
+func (m *MockJWTReader) Read(tokenString string) (*jwtauth.Claims, error) {
 	return m.ReadFunc(tokenString)
 }
 
-func (m *MockJWTReader) ValidateClaims(claims jwt.MapClaims) error {
+func (m *MockJWTReader) ValidateClaims(claims *jwtauth.Claims) error {
 	return m.ValidateClaimsFunc(claims)
 }
 
@@ -44,7 +45,19 @@ func (m *MockUserGetterInserter) InsertDefaultUser(email string, id uuid.UUID) e
 	return m.InsertDefaultUserFunc(email, id)
 }
 
+type MockRevocationChecker struct {
+	IsRevokedFunc func(claims *jwtauth.Claims) bool
+}
+
+func (m *MockRevocationChecker) IsRevoked(claims *jwtauth.Claims) bool {
+	return m.IsRevokedFunc(claims)
+}
+
 // Helper functions
+func testClaims(subject string, email string) *jwtauth.Claims {
+	return &jwtauth.Claims{RegisteredClaims: jwt.RegisteredClaims{Subject: subject}, Email: email}
+}
+
 func createAuthTestRequest(method, target string, header string) *http.Request {
 	req := httptest.NewRequest(method, target, nil)
 	if header != "" {
@@ -54,11 +67,12 @@ func createAuthTestRequest(method, target string, header string) *http.Request {
 	return req
 }
 
-func createAuthTestHandler(jr JWTReader, ugi userGetterInserter) http.Handler {
+func createAuthTestHandler(jr JWTReader, ugi userGetterInserter, opts ...AuthOption) http.Handler {
 	return Authenticate(
 		slog.New(slog.NewTextHandler(io.Discard, nil)),
 		jr,
 		ugi,
+		opts...,
 	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
@@ -87,6 +101,46 @@ func TestAuthenticateMissingHeader(t *testing.T) {
 	testutils.CheckJSONResponseError(t, rec, http.StatusBadRequest, "missing authorization header")
 }
 
+func TestAuthenticateOptionalWithoutHeader(t *testing.T) {
+	req := createAuthTestRequest("GET", "/", "")
+	rec := httptest.NewRecorder()
+
+	var contextUser *User
+	handler := Authenticate(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		&MockJWTReader{},
+		&MockUserGetterInserter{},
+		WithOptionalAuth(),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contextUser = ContextGetUser(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+	}
+	if contextUser == nil || !contextUser.IsAnonymous() {
+		t.Error("Expected the anonymous user in the request context")
+	}
+}
+
+func TestAuthenticateOptionalInvalidToken(t *testing.T) {
+	mockJWTReader := &MockJWTReader{
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return nil, errors.New("invalid token")
+		},
+	}
+
+	req := createAuthTestRequest("GET", "/", "Bearer invalid_token")
+	rec := httptest.NewRecorder()
+
+	handler := createAuthTestHandler(mockJWTReader, &MockUserGetterInserter{}, WithOptionalAuth())
+	handler.ServeHTTP(rec, req)
+
+	testutils.CheckJSONResponseError(t, rec, http.StatusUnauthorized, "invalid token")
+}
+
 func TestAuthenticateInvalidHeaderFormat(t *testing.T) {
 	req := createAuthTestRequest("GET", "/", "InvalidFormat")
 	rec := httptest.NewRecorder()
@@ -99,7 +153,7 @@ func TestAuthenticateInvalidHeaderFormat(t *testing.T) {
 
 func TestAuthenticateInvalidToken(t *testing.T) {
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
 			return nil, errors.New("invalid token")
 		},
 	}
@@ -115,10 +169,10 @@ func TestAuthenticateInvalidToken(t *testing.T) {
 
 func TestAuthenticateExpiredToken(t *testing.T) {
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return &jwtauth.Claims{}, nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return jwtauth.ErrExpiredToken
 		},
 	}
@@ -134,10 +188,10 @@ func TestAuthenticateExpiredToken(t *testing.T) {
 
 func TestAuthenticateInvalidIssuer(t *testing.T) {
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return &jwtauth.Claims{}, nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return jwtauth.ErrInvalidIssuer
 		},
 	}
@@ -153,10 +207,10 @@ func TestAuthenticateInvalidIssuer(t *testing.T) {
 
 func TestAuthenticateEmptySubject(t *testing.T) {
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return &jwtauth.Claims{}, nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return jwtauth.ErrEmptySubject
 		},
 	}
@@ -170,14 +224,69 @@ func TestAuthenticateEmptySubject(t *testing.T) {
 	testutils.CheckJSONResponseError(t, rec, http.StatusUnauthorized, "the jwt has no subject")
 }
 
+func TestAuthenticateMissingRequiredClaim(t *testing.T) {
+	mockJWTReader := &MockJWTReader{
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return &jwtauth.Claims{}, nil
+		},
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
+			return jwtauth.ErrMissingClaim{Claim: "email"}
+		},
+	}
+
+	req := createAuthTestRequest("GET", "/", "Bearer missing_email_token")
+	rec := httptest.NewRecorder()
+
+	handler := createAuthTestHandler(mockJWTReader, &MockUserGetterInserter{})
+	handler.ServeHTTP(rec, req)
+
+	testutils.CheckJSONResponseError(t, rec, http.StatusUnauthorized, "the jwt is missing the email claim")
+}
+
+func TestAuthenticateRevokedToken(t *testing.T) {
+	userID := uuid.New()
+	mockJWTReader := &MockJWTReader{
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			claims := testClaims(userID.String(), "test@example.com")
+			claims.ID = tokenString
+			return claims, nil
+		},
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
+			return nil
+		},
+	}
+	mockUserGetterInserter := &MockUserGetterInserter{
+		GetByIdFunc: func(id uuid.UUID) (*User, error) {
+			return &User{ID: id, Email: "test@example.com"}, nil
+		},
+	}
+	mockRevocationChecker := &MockRevocationChecker{
+		IsRevokedFunc: func(claims *jwtauth.Claims) bool {
+			return claims.ID == "revoked-jti"
+		},
+	}
+
+	handler := createAuthTestHandler(mockJWTReader, mockUserGetterInserter, WithRevocationChecker(mockRevocationChecker))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, createAuthTestRequest("GET", "/", "Bearer revoked-jti"))
+	testutils.CheckJSONResponseError(t, rec, http.StatusUnauthorized, "token has been revoked")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, createAuthTestRequest("GET", "/", "Bearer other-jti"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status code %d for a token that is not revoked, got %d", http.StatusOK, rec.Code)
+	}
+}
+
 func TestAuthenticateValidTokenExistingUser(t *testing.T) {
 	userID := uuid.New()
 	testUser := &User{ID: userID, Email: "test@example.com"}
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{"sub": userID.String(), "email": "test@example.com"}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return testClaims(userID.String(), "test@example.com"), nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return nil
 		},
 	}
@@ -209,14 +318,67 @@ func TestAuthenticateValidTokenExistingUser(t *testing.T) {
 	}
 }
 
+func TestAuthenticateInactiveUsers(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	until := time.Date(2099, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		user     User
+		expected int
+		message  string
+	}{
+		{"deleted", User{IsDeleted: true}, http.StatusForbidden, "this account has been deleted"},
+		{"suspended", User{Suspension: &Suspension{Reason: "spam"}}, http.StatusForbidden, "this account is suspended: spam"},
+		{"suspended until", User{Suspension: &Suspension{Reason: "spam", Until: &until}}, http.StatusForbidden, "this account is suspended until 2099-01-02T03:04:05Z: spam"},
+		{"suspension expired", User{Suspension: &Suspension{Reason: "spam", Until: &past}}, http.StatusOK, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			userID := uuid.New()
+			user := tc.user
+			user.ID = userID
+
+			mockJWTReader := &MockJWTReader{
+				ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+					return testClaims(userID.String(), "test@example.com"), nil
+				},
+				ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
+					return nil
+				},
+			}
+			mockUserGetterInserter := &MockUserGetterInserter{
+				GetByIdFunc: func(id uuid.UUID) (*User, error) {
+					return &user, nil
+				},
+			}
+
+			req := createAuthTestRequest("GET", "/", "Bearer token")
+			rec := httptest.NewRecorder()
+
+			handler := createAuthTestHandler(mockJWTReader, mockUserGetterInserter)
+			handler.ServeHTTP(rec, req)
+
+			if tc.expected == http.StatusOK {
+				if rec.Code != http.StatusOK {
+					t.Errorf("Expected status code %d, got %d", http.StatusOK, rec.Code)
+				}
+				return
+			}
+			testutils.CheckJSONResponseError(t, rec, tc.expected, tc.message)
+		})
+	}
+}
+
 func TestAuthenticateValidTokenNewUser(t *testing.T) {
 	requestCount := 0
 	userID := uuid.New()
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{"sub": userID.String(), "email": "newuser@example.com"}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return testClaims(userID.String(), "newuser@example.com"), nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return nil
 		},
 	}
@@ -260,10 +422,10 @@ func TestAuthenticateValidTokenNewUser(t *testing.T) {
 func TestAuthenticateDatabaseError(t *testing.T) {
 	userID := uuid.New()
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{"sub": userID.String(), "email": "test@example.com"}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return testClaims(userID.String(), "test@example.com"), nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return nil
 		},
 	}
@@ -284,10 +446,10 @@ func TestAuthenticateDatabaseError(t *testing.T) {
 
 func TestAuthenticateInvalidUUID(t *testing.T) {
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{"sub": "not-a-valid-uuid", "email": "test@example.com"}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return testClaims("not-a-valid-uuid", "test@example.com"), nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return nil
 		},
 	}
@@ -304,10 +466,10 @@ func TestAuthenticateInvalidUUID(t *testing.T) {
 func TestAuthenticateMissingRequestID(t *testing.T) {
 	userID := uuid.New()
 	mockJWTReader := &MockJWTReader{
-		ReadFunc: func(tokenString string) (jwt.MapClaims, error) {
-			return jwt.MapClaims{"sub": userID.String(), "email": "test@example.com"}, nil
+		ReadFunc: func(tokenString string) (*jwtauth.Claims, error) {
+			return testClaims(userID.String(), "test@example.com"), nil
 		},
-		ValidateClaimsFunc: func(claims jwt.MapClaims) error {
+		ValidateClaimsFunc: func(claims *jwtauth.Claims) error {
 			return nil
 		},
 	}
@@ -335,7 +497,7 @@ func TestAuthenticateMissingRequestID(t *testing.T) {
 // helpers
 func addUserHandler(user *User, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		nr := contextSetUser(r, user)
+		nr := ContextSetUser(r, user)
 		next.ServeHTTP(w, nr)
 	})
 }
@@ -404,4 +566,128 @@ func TestPermissionsFailure(t *testing.T) {
 	}
 }
 
+func TestPermissionsAnonymousUser(t *testing.T) {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+
+	handler := createPermTestHandler(AnonymousUser)
+	handler.ServeHTTP(rec, req)
+
+	testutils.CheckJSONResponseError(t, rec, http.StatusUnauthorized, "you must be authenticated to access this resource")
+}
+
+func TestRequireAnyPermission(t *testing.T) {
+	user := &User{Role: NewRole("support", Permissions{"audit:read", "users:*"})}
+
+	testCases := []struct {
+		permissions []string
+		status      int
+	}{
+		{[]string{"audit:read"}, http.StatusOK},
+		{[]string{"tokens:revoke", "users:impersonate"}, http.StatusOK},
+		{[]string{"tokens:revoke", "audit:write"}, http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("permissions %v", tc.permissions), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler := addUserHandler(user, RequireAnyPermission(
+				slog.New(slog.NewTextHandler(io.Discard, nil)),
+				tc.permissions...,
+			)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			if rec.Code != tc.status {
+				t.Errorf("Expected status code %d, got %d", tc.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequireAuthenticatedUser(t *testing.T) {
+	testCases := []struct {
+		name     string
+		user     *User
+		expected int
+	}{
+		{"authenticated user", &User{ID: uuid.New(), Role: Role{Name: "user"}}, http.StatusOK},
+		{"anonymous user", AnonymousUser, http.StatusUnauthorized},
+		{"no user", nil, http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var handler http.Handler = RequireAuthenticatedUser(slog.New(slog.NewTextHandler(io.Discard, nil)))(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+			)
+			if tc.user != nil {
+				handler = addUserHandler(tc.user, handler)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status code %d, got %d", tc.expected, rec.Code)
+			}
+		})
+	}
+}
+
+func TestRequireTokenRoles(t *testing.T) {
+	admin := NewRole("admin", Permissions{"users:*"})
+
+	testCases := []struct {
+		name     string
+		source   RoleSource
+		claims   *jwtauth.Claims
+		expected int
+	}{
+		{"claims source with a token", RolesFromClaims, testClaims(uuid.NewString(), "admin@example.com"), http.StatusOK},
+		{"claims source without a token", RolesFromClaims, nil, http.StatusForbidden},
+		{"hybrid source without a token", RolesFromBoth, nil, http.StatusOK},
+		{"database source without a token", RolesFromDatabase, nil, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := &User{ID: uuid.New(), Email: "admin@example.com", Role: admin}
+
+			handler := RequireTokenRoles(tc.source)(RequirePermissions(
+				slog.New(slog.NewTextHandler(io.Discard, nil)),
+				PermUsersManage,
+			)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+			req := ContextSetUser(httptest.NewRequest("POST", "/", nil), user)
+			if tc.claims != nil {
+				req = ContextSetClaims(req, tc.claims)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expected {
+				t.Errorf("Expected status code %d, got %d", tc.expected, rec.Code)
+			}
+			if !user.Role.Allows(PermUsersManage) {
+				t.Error("Expected the authenticated user not to be changed")
+			}
+		})
+	}
+}
+
+func TestContextLookupUser(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	if user, ok := ContextLookupUser(req); ok || !user.IsAnonymous() {
+		t.Error("Expected the anonymous user and false when no user is set")
+	}
+
+	user := &User{ID: uuid.New()}
+	if got, ok := ContextLookupUser(ContextSetUser(req, user)); !ok || got != user {
+		t.Error("Expected the user set on the request")
+	}
+}
+
 //endregion
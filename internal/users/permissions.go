@@ -2,23 +2,135 @@ package users
 
 import (
 	"slices"
+	"strings"
 )
 
 const (
-	PermUsersManage = "users:manage"
+	PermUsersManage  = "users:manage"
+	PermAuditRead    = "audit:read"
+	PermTokensRevoke = "tokens:revoke"
+	// PermUsersImpersonate lets support staff act as another user with the Act-As header
+	PermUsersImpersonate = "users:impersonate"
 )
 
+// PermissionWildcard stands for any resource or any action in a permission code:
+// users:* grants every action on users, *:read grants reading every resource and *:* grants everything.
+const PermissionWildcard = "*"
+
 type Permissions []string
 
-// Includes checks if the given permission codes exist within the Permissions slice.
-// For each code in the provided variadic argument, it checks if the code is present
-// in the permission list. The function returns true only when all the given codes
-// are present. If any single code is not found, it stops further checking and returns false.
+// Includes checks if the given permission codes are granted by the Permissions.
+// A code is granted when it is present in the permission list, or when a wildcard
+// permission in the list covers it. The function returns true only when all the given
+// codes are granted. Roles check their permissions with a precomputed PermissionSet instead.
 func (p Permissions) Includes(codes ...string) bool {
-	for _, perm := range codes {
-		if !slices.Contains(p, perm) {
+	return NewPermissionSet(p...).Includes(codes...)
+}
+
+// IncludesAny checks if at least one of the given permission codes is granted by the Permissions.
+func (p Permissions) IncludesAny(codes ...string) bool {
+	return NewPermissionSet(p...).IncludesAny(codes...)
+}
+
+// Intersect returns the permissions granted by both p and other: the codes of each that the other includes.
+// Wildcards granted by both but covering different codes, like users:* and *:read, are left out.
+func (p Permissions) Intersect(other Permissions) Permissions {
+	mine, theirs := NewPermissionSet(p...), NewPermissionSet(other...)
+
+	permissions := Permissions{}
+	for _, code := range p {
+		if theirs.Includes(code) {
+			permissions = append(permissions, code)
+		}
+	}
+	for _, code := range other {
+		if mine.Includes(code) && !slices.Contains(permissions, code) {
+			permissions = append(permissions, code)
+		}
+	}
+	return permissions
+}
+
+// PermissionSet is a set of permission codes indexed for lookups that take constant time,
+// wildcards included.
+type PermissionSet struct {
+	codes map[string]struct{}
+	// resources are the resources every action is granted on, by resource:* permissions
+	resources map[string]struct{}
+	// actions are the actions granted on every resource, by *:action permissions
+	actions map[string]struct{}
+	// all is set by the *:* permission
+	all bool
+}
+
+func NewPermissionSet(codes ...string) *PermissionSet {
+	s := &PermissionSet{
+		codes:     make(map[string]struct{}, len(codes)),
+		resources: map[string]struct{}{},
+		actions:   map[string]struct{}{},
+	}
+
+	for _, code := range codes {
+		s.codes[code] = struct{}{}
+
+		resource, action, ok := strings.Cut(code, ":")
+		switch {
+		case !ok:
+		case resource == PermissionWildcard && action == PermissionWildcard:
+			s.all = true
+		case action == PermissionWildcard:
+			s.resources[resource] = struct{}{}
+		case resource == PermissionWildcard:
+			s.actions[action] = struct{}{}
+		}
+	}
+
+	return s
+}
+
+// Has reports whether the set grants the code. Codes that are wildcards themselves are only granted
+// by permissions at least as broad: users:* is granted by users:* and *:*, but not by users:manage.
+func (s *PermissionSet) Has(code string) bool {
+	if _, ok := s.codes[code]; ok || s.all {
+		return true
+	}
+
+	resource, action, ok := strings.Cut(code, ":")
+	if !ok {
+		return false
+	}
+	_, resourceGranted := s.resources[resource]
+	_, actionGranted := s.actions[action]
+	return resourceGranted || actionGranted
+}
+
+// Includes reports whether the set grants every one of the codes.
+func (s *PermissionSet) Includes(codes ...string) bool {
+	for _, code := range codes {
+		if !s.Has(code) {
 			return false
 		}
 	}
 	return true
 }
+
+// IncludesAny reports whether the set grants at least one of the codes.
+func (s *PermissionSet) IncludesAny(codes ...string) bool {
+	return slices.ContainsFunc(codes, s.Has)
+}
+
+// permissionPatterns returns the codes that grant the permission with the code: the code itself
+// and the wildcards covering it.
+func permissionPatterns(code string) []string {
+	resource, action, ok := strings.Cut(code, ":")
+	if !ok {
+		return []string{code}
+	}
+
+	return []string{
+		code,
+		resource + ":" + PermissionWildcard,
+		PermissionWildcard + ":" + action,
+		PermissionWildcard + ":" + PermissionWildcard,
+	}
+}
@@ -0,0 +1,123 @@
+package users
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPermissionSetWildcards(t *testing.T) {
+	testCases := []struct {
+		granted []string
+		code    string
+		want    bool
+	}{
+		{[]string{"users:manage"}, "users:manage", true},
+		{[]string{"users:manage"}, "users:read", false},
+		{[]string{"users:*"}, "users:manage", true},
+		{[]string{"users:*"}, "audit:manage", false},
+		{[]string{"*:read"}, "audit:read", true},
+		{[]string{"*:read"}, "audit:write", false},
+		{[]string{"*:*"}, "tokens:revoke", true},
+		{[]string{"users:*"}, "users:*", true},
+		{[]string{"users:manage"}, "users:*", false},
+		{[]string{"*:read"}, "users:*", false},
+		{[]string{"users:*"}, "*:read", false},
+		{[]string{"*:*"}, "*:read", true},
+		{[]string{"legacy"}, "legacy", true},
+		{[]string{"*:*"}, "legacy", true},
+		{[]string{"users:*"}, "users", false},
+		{nil, "users:manage", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.granted, ",")+" has "+tc.code, func(t *testing.T) {
+			if got := NewPermissionSet(tc.granted...).Has(tc.code); got != tc.want {
+				t.Errorf("Expected %v, got %v", tc.want, got)
+			}
+			if got := Permissions(tc.granted).Includes(tc.code); got != tc.want {
+				t.Errorf("Expected Includes to agree with the set, got %v", got)
+			}
+		})
+	}
+}
+
+func TestIncludesAny(t *testing.T) {
+	role := NewRole("support", Permissions{"audit:read", "users:*"})
+
+	if !role.AllowsAny("tokens:revoke", "users:impersonate") {
+		t.Error("Expected one of the permissions to be enough")
+	}
+	if role.AllowsAny("tokens:revoke", "audit:write") || role.AllowsAny() {
+		t.Error("Expected none of the permissions not to be enough")
+	}
+	if role.Allows("tokens:revoke", "audit:read") || !role.Allows("audit:read", "users:manage") {
+		t.Error("Expected Allows to require every permission")
+	}
+
+	literal := Role{Name: "support", Permissions: Permissions{"users:*"}}
+	if !literal.Allows("users:manage") || !literal.Permissions.IncludesAny("audit:read", "users:manage") {
+		t.Error("Expected roles built without NewRole to be checked the same way")
+	}
+}
+
+func TestPermissionsIntersect(t *testing.T) {
+	testCases := []struct {
+		owner, scopes, want Permissions
+	}{
+		{Permissions{"users:manage", "audit:read"}, Permissions{"audit:read"}, Permissions{"audit:read"}},
+		{Permissions{"users:*"}, Permissions{"users:manage", "audit:read"}, Permissions{"users:manage"}},
+		{Permissions{"users:manage"}, Permissions{"users:*"}, Permissions{"users:manage"}},
+		{Permissions{"*:*"}, Permissions{"*:read"}, Permissions{"*:read"}},
+		{Permissions{"users:*"}, Permissions{"*:read"}, Permissions{}},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.owner.Intersect(tc.scopes); !slices.Equal(got, tc.want) {
+			t.Errorf("Expected %v and %v to intersect as %v, got %v", tc.owner, tc.scopes, tc.want, got)
+		}
+	}
+}
+
+func TestRoleHierarchy(t *testing.T) {
+	hierarchy := RoleHierarchy{
+		"admin":   {"support", "billing"},
+		"support": {"viewer"},
+		"billing": {"viewer"},
+	}
+	granted := map[string]Permissions{
+		"admin":   {"users:manage"},
+		"support": {"users:impersonate"},
+		"billing": {"invoices:*"},
+		"viewer":  {"*:read"},
+	}
+
+	if err := hierarchy.CheckCycles(); err != nil {
+		t.Fatal(err)
+	}
+	if got := hierarchy.Ancestors("admin"); !slices.Equal(got, []string{"admin", "support", "billing", "viewer"}) {
+		t.Errorf("Expected the ancestors nearest first without duplicates, got %v", got)
+	}
+
+	want := Permissions{"users:manage", "users:impersonate", "invoices:*", "*:read"}
+	if got := hierarchy.Permissions("admin", granted); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if got := hierarchy.Permissions("guest", granted); len(got) != 0 {
+		t.Errorf("Expected an unknown role to have no permissions, got %v", got)
+	}
+
+	hierarchy["viewer"] = []string{"admin"}
+	err := hierarchy.CheckCycles()
+	if !errors.Is(err, ErrRoleCycle) || !strings.Contains(err.Error(), "admin -> support -> viewer -> admin") {
+		t.Errorf("Expected the cycle to be named, got %v", err)
+	}
+	if got := hierarchy.Ancestors("viewer"); len(got) != 4 {
+		t.Errorf("Expected the ancestors of a cycle to end, got %v", got)
+	}
+
+	if err := (RoleHierarchy{"admin": {"admin"}}).CheckCycles(); !errors.Is(err, ErrRoleCycle) {
+		t.Errorf("Expected a role inheriting from itself to be a cycle, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package users
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
@@ -20,8 +21,20 @@ func (m UserPsqlRepo) Insert(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	return insertUser(ctx, m.DB, user)
+}
+
+// InsertUser returns a database.TxFn that inserts the user like UserPsqlRepo.Insert, so that it is
+// inserted along with the rows of other tables referencing it, or not at all.
+func InsertUser(ctx context.Context, user *User) database.TxFn {
+	return func(tx *sql.Tx) error {
+		return insertUser(ctx, tx, user)
+	}
+}
+
+func insertUser(ctx context.Context, q database.Querier, user *User) error {
 	var roleID int64
-	err := m.DB.QueryRowContext(ctx, "SELECT id from roles WHERE name = $1", user.Role.Name).Scan(&roleID)
+	err := q.QueryRowContext(ctx, "SELECT id from roles WHERE name = $1", user.Role.Name).Scan(&roleID)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -37,8 +50,12 @@ func (m UserPsqlRepo) Insert(user *User) error {
 
 	args := []any{user.ID, user.Email, roleID}
 
-	err = m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err = q.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrDuplicateEmail
+		}
 		return err
 	}
 
@@ -46,21 +63,25 @@ func (m UserPsqlRepo) Insert(user *User) error {
 }
 
 func (m UserPsqlRepo) GetById(id uuid.UUID) (*User, error) {
-	query := `SELECT users.id, users.email, users.is_deleted, users.created_at, users.updated_at,
-                  roles.name,
+	query := roleAncestorsCTE + `SELECT users.id, users.email, users.is_deleted, users.created_at, users.updated_at,
+                  users.suspended_at, users.suspended_until, users.suspension_reason, users.suspended_by,
+                  users.email_verified_at, roles.name,
                   STRING_AGG(DISTINCT permissions.code, ',') as permissions
               FROM users
               LEFT JOIN roles ON users.role_id = roles.id
-              LEFT JOIN roles_permissions ON roles.id = roles_permissions.role_id
+              LEFT JOIN ancestors ON ancestors.role_id = roles.id
+              LEFT JOIN roles_permissions ON ancestors.ancestor_id = roles_permissions.role_id
               LEFT JOIN permissions ON roles_permissions.permission_id = permissions.id
               WHERE users.id = $1
-              GROUP BY users.id, users.email, users.is_deleted, users.created_at, users.updated_at, roles.name`
+              GROUP BY users.id, roles.name`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var user User
 	var roleName, permissions sql.NullString
+	var suspendedAt sql.NullTime
+	var suspension Suspension
 
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&user.ID,
@@ -68,6 +89,11 @@ func (m UserPsqlRepo) GetById(id uuid.UUID) (*User, error) {
 		&user.IsDeleted,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&suspendedAt,
+		&suspension.Until,
+		&suspension.Reason,
+		&suspension.SuspendedBy,
+		&user.EmailVerifiedAt,
 		&roleName,
 		&permissions,
 	)
@@ -79,19 +105,44 @@ func (m UserPsqlRepo) GetById(id uuid.UUID) (*User, error) {
 		return nil, err
 	}
 
-	user.Role.Name = roleName.String
+	if suspendedAt.Valid {
+		suspension.SuspendedAt = suspendedAt.Time
+		user.Suspension = &suspension
+	}
+
+	rolePermissions := Permissions{}
 	if permissions.Valid {
-		user.Role.Permissions = strings.Split(permissions.String, ",")
-	} else {
-		user.Role.Permissions = []string{}
+		rolePermissions = strings.Split(permissions.String, ",")
 	}
+	user.Role = NewRole(roleName.String, rolePermissions)
 
 	return &user, nil
 }
 
+// GetByEmail returns the user with the email, compared case-insensitively.
+// Returns database.ErrRecordNotFound if there is none, deleted users included.
+func (m UserPsqlRepo) GetByEmail(email string) (*User, error) {
+	query := `SELECT id FROM users WHERE lower(email) = lower($1) AND NOT is_deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id uuid.UUID
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, database.ErrRecordNotFound
+		}
+		return nil, err
+	}
+
+	return m.GetById(id)
+}
+
 func (m UserPsqlRepo) Update(user *User) error {
 	query := `UPDATE users
-              SET email = $1, updated_at = CURRENT_TIMESTAMP
+              SET email = $1, updated_at = CURRENT_TIMESTAMP,
+                  email_verified_at = CASE WHEN email = $1 THEN email_verified_at END
               WHERE id = $2 AND updated_at <= CURRENT_TIMESTAMP
               RETURNING updated_at`
 
@@ -103,7 +154,11 @@ func (m UserPsqlRepo) Update(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt)
+	update := func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt)
+	}
+
+	err := m.DB.WithTransaction(ctx, update, notifyUserChange(ctx, user.ID))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -124,8 +179,9 @@ func (m UserPsqlRepo) Update(user *User) error {
 }
 
 func (m UserPsqlRepo) UpdateEmail(user *User) error {
-	query := `UPDATE users 
-              SET email = $1, updated_at = CURRENT_TIMESTAMP
+	query := `UPDATE users
+              SET email = $1, updated_at = CURRENT_TIMESTAMP,
+                  email_verified_at = CASE WHEN email = $1 THEN email_verified_at END
               WHERE id = $2
               RETURNING updated_at`
 
@@ -134,7 +190,11 @@ func (m UserPsqlRepo) UpdateEmail(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt)
+	update := func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, query, args...).Scan(&user.UpdatedAt)
+	}
+
+	err := m.DB.WithTransaction(ctx, update, notifyUserChange(ctx, user.ID))
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -164,25 +224,70 @@ func (m UserPsqlRepo) Delete(id uuid.UUID, delEmail string) error {
 
 	args := []any{delEmail, id}
 
-	res, err := m.DB.ExecContext(ctx, query, args...)
-	if err != nil {
-		return err
+	return m.DB.WithTransaction(ctx, execOne(ctx, query, args...), notifyUserChange(ctx, id))
+}
+
+// MarkEmailVerified records that the user verified the email, if it is still their email.
+// Returns database.ErrRecordNotFound if there is no such user, they were deleted, or their email changed since.
+func (m UserPsqlRepo) MarkEmailVerified(id uuid.UUID, email string) error {
+	query := `UPDATE users
+              SET email_verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND email = $2 AND NOT is_deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithTransaction(ctx, execOne(ctx, query, id, email), notifyUserChange(ctx, id))
+}
+
+// Suspend suspends the user, replacing any suspension they already have.
+// Returns database.ErrRecordNotFound if there is no such user, or they were deleted.
+func (m UserPsqlRepo) Suspend(id uuid.UUID, suspension *Suspension) error {
+	query := `UPDATE users
+              SET suspended_at = CURRENT_TIMESTAMP, suspended_until = $2, suspension_reason = $3, suspended_by = $4,
+                  updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND NOT is_deleted
+              RETURNING suspended_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{id, suspension.Until, suspension.Reason, suspension.SuspendedBy}
+
+	suspend := func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, query, args...).Scan(&suspension.SuspendedAt)
 	}
-	rows, err := res.RowsAffected()
+
+	err := m.DB.WithTransaction(ctx, suspend, notifyUserChange(ctx, id))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.ErrRecordNotFound
+		}
 		return err
 	}
-	if rows == 0 {
-		return database.ErrRecordNotFound
-	}
 
 	return nil
 }
 
+// Reinstate lifts the user's suspension, if they have one.
+// Returns database.ErrRecordNotFound if there is no such user, or they were deleted.
+func (m UserPsqlRepo) Reinstate(id uuid.UUID) error {
+	query := `UPDATE users
+              SET suspended_at = NULL, suspended_until = NULL, suspension_reason = '', suspended_by = NULL,
+                  updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1 AND NOT is_deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.WithTransaction(ctx, execOne(ctx, query, id), notifyUserChange(ctx, id))
+}
+
 func (m RolePsqlRepo) GetRoleForUser(userID uuid.UUID) (*Role, error) {
-	query := `SELECT roles.name, permissions.code
+	query := roleAncestorsCTE + `SELECT DISTINCT roles.name, permissions.code
               FROM roles
-              INNER JOIN roles_permissions ON roles_permissions.role_id = roles.id
+              INNER JOIN ancestors ON ancestors.role_id = roles.id
+              INNER JOIN roles_permissions ON roles_permissions.role_id = ancestors.ancestor_id
               INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
               INNER JOIN users on roles.id = users.role_id
               WHERE users.id = $1`
@@ -213,7 +318,8 @@ func (m RolePsqlRepo) GetRoleForUser(userID uuid.UUID) (*Role, error) {
 		return nil, err
 	}
 
-	return role, nil
+	precomputed := NewRole(role.Name, role.Permissions)
+	return &precomputed, nil
 }
 
 func (m RolePsqlRepo) UpdateRoleForUser(userID uuid.UUID, roleName string) error {
@@ -222,6 +328,571 @@ func (m RolePsqlRepo) UpdateRoleForUser(userID uuid.UUID, roleName string) error
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, userID, roleName)
-	return err
+	update := func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, roleName)
+		return err
+	}
+
+	return m.DB.WithTransaction(ctx, update, notifyUserChange(ctx, userID))
+}
+
+// roleAncestorsCTE pairs every role with itself and every role it inherits from, directly or not, as ancestors.
+// UNION stops the recursion on cycles, though AddParent refuses to create them.
+const roleAncestorsCTE = `WITH RECURSIVE ancestors (role_id, ancestor_id) AS (
+                  SELECT id, id FROM roles
+                  UNION
+                  SELECT ancestors.role_id, roles_parents.parent_id
+                  FROM ancestors
+                  INNER JOIN roles_parents ON roles_parents.role_id = ancestors.ancestor_id
+              )
+              `
+
+// roleChangesLockID is the Postgres advisory lock taken by transactions changing roles or who holds them,
+// so that two of them cannot each remove one of the last two admins, or each add half of a cycle.
+const roleChangesLockID = 7_340_047
+
+// ListRoles returns every role, ordered by name, with its own permissions and its parents.
+func (m RolePsqlRepo) ListRoles() ([]RoleDetails, error) {
+	query := `SELECT roles.id, roles.name, roles.description, roles.created_at, roles.updated_at,
+                  COALESCE(ARRAY_AGG(permissions.code ORDER BY permissions.code)
+                      FILTER (WHERE permissions.code IS NOT NULL), '{}'),
+                  COALESCE((SELECT ARRAY_AGG(parents.name ORDER BY parents.name)
+                      FROM roles_parents
+                      INNER JOIN roles parents ON parents.id = roles_parents.parent_id
+                      WHERE roles_parents.role_id = roles.id), '{}'),
+                  (SELECT count(*) FROM users WHERE users.role_id = roles.id AND NOT users.is_deleted)
+              FROM roles
+              LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+              LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+              GROUP BY roles.id
+              ORDER BY roles.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []RoleDetails{}
+	for rows.Next() {
+		var role RoleDetails
+
+		err = rows.Scan(
+			&role.ID,
+			&role.Name,
+			&role.Description,
+			&role.CreatedAt,
+			&role.UpdatedAt,
+			pq.Array((*[]string)(&role.Permissions)),
+			pq.Array(&role.Parents),
+			&role.UserCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, role)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// InsertRole creates the role with its permissions, setting its ID and timestamps.
+// Returns ErrDuplicateRole if the name is taken, and ErrPermissionNotFound if one of the permissions does not exist.
+func (m RolePsqlRepo) InsertRole(role *RoleDetails) error {
+	query := `INSERT INTO roles (name, description)
+              VALUES ($1, $2)
+              RETURNING id, created_at, updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	insert := func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, role.Name, role.Description).Scan(&role.ID, &role.CreatedAt, &role.UpdatedAt)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == database.PsqlUniqueViolation {
+			return ErrDuplicateRole
+		}
+		return err
+	}
+
+	return m.DB.WithTransaction(ctx, insert, grantPermissions(ctx, &role.ID, role.Permissions))
+}
+
+// UpdateRole renames the role with the ID and changes its description, setting its UpdatedAt.
+// Returns ErrRoleNotFound if there is no such role, ErrDuplicateRole if the name is taken,
+// and ErrProtectedRole when renaming a protected role.
+func (m RolePsqlRepo) UpdateRole(role *RoleDetails) error {
+	query := `UPDATE roles SET name = $2, description = $3, updated_at = CURRENT_TIMESTAMP
+              WHERE id = $1
+              RETURNING updated_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var oldName string
+	checkName := func(tx *sql.Tx) error {
+		if IsProtectedRole(oldName) && role.Name != oldName {
+			return ErrProtectedRole
+		}
+		return nil
+	}
+
+	update := func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, role.ID, role.Name, role.Description).Scan(&role.UpdatedAt)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == database.PsqlUniqueViolation {
+			return ErrDuplicateRole
+		}
+		return err
+	}
+
+	// Cached users hold the name of their role, so they are dropped when it is renamed
+	notify := func(tx *sql.Tx) error {
+		if role.Name == oldName {
+			return nil
+		}
+		return notifyRoleChange(ctx, oldName)(tx)
+	}
+
+	return m.DB.WithTransaction(ctx, lockRole(ctx, role.ID, &oldName), checkName, update, notify)
+}
+
+// DeleteRole deletes the role with the id, moving the deleted users who held it to the regular role.
+// The roles inheriting from it lose its permissions.
+// Returns ErrRoleNotFound if there is no such role, ErrRoleInUse if users still hold it,
+// ErrProtectedRole when deleting a protected role, and ErrLastAdmin if no active user could manage users anymore.
+func (m RolePsqlRepo) DeleteRole(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var name string
+	checkName := func(tx *sql.Tx) error {
+		if IsProtectedRole(name) {
+			return ErrProtectedRole
+		}
+		return nil
+	}
+
+	moveDeletedUsers := func(tx *sql.Tx) error {
+		query := `UPDATE users SET role_id = (SELECT id FROM roles WHERE name = $2)
+                  WHERE role_id = $1 AND is_deleted`
+
+		_, err := tx.ExecContext(ctx, query, id, RoleRegularUser)
+		return err
+	}
+
+	deleteRole := func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "DELETE FROM roles WHERE id = $1", id)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == database.PsqlForeignKeyViolation {
+			return ErrRoleInUse
+		}
+		return err
+	}
+
+	guard, check := guardLastAdmin(ctx)
+	return m.DB.WithTransaction(ctx,
+		guard, lockRole(ctx, id, &name), checkName, notifyRoleTreeChange(ctx, id), moveDeletedUsers, deleteRole, check)
+}
+
+// AddPermissions grants the permissions with the codes to the role with the id. Permissions it already has are kept.
+// Returns ErrRoleNotFound if there is no such role, and ErrPermissionNotFound if one of the permissions does not exist.
+func (m RolePsqlRepo) AddPermissions(id int64, codes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var name string
+	return m.DB.WithTransaction(ctx, lockRole(ctx, id, &name), grantPermissions(ctx, &id, codes), notifyRoleTreeChange(ctx, id))
+}
+
+// RemovePermission revokes the permission with the code from the role with the id.
+// Returns ErrRoleNotFound if there is no such role, database.ErrRecordNotFound if the role does not
+// have the permission, and ErrLastAdmin if no active user could manage users anymore.
+func (m RolePsqlRepo) RemovePermission(id int64, code string) error {
+	query := `DELETE FROM roles_permissions
+              WHERE role_id = $1 AND permission_id = (SELECT id FROM permissions WHERE code = $2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var name string
+	guard, check := guardLastAdmin(ctx)
+	return m.DB.WithTransaction(ctx,
+		guard, lockRole(ctx, id, &name), execOne(ctx, query, id, code), check, notifyRoleTreeChange(ctx, id))
+}
+
+// AddParent makes the role with the id inherit the permissions of the role with the parentID.
+// Returns ErrRoleNotFound if either role does not exist, and ErrRoleCycle if the parent already
+// inherits from the role, or is the role itself.
+func (m RolePsqlRepo) AddParent(id, parentID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	checkCycle := func(tx *sql.Tx) error {
+		query := roleAncestorsCTE + `SELECT EXISTS (SELECT 1 FROM ancestors WHERE role_id = $1 AND ancestor_id = $2)`
+
+		var cycle bool
+		if err := tx.QueryRowContext(ctx, query, parentID, id).Scan(&cycle); err != nil {
+			return err
+		}
+		if cycle {
+			return ErrRoleCycle
+		}
+		return nil
+	}
+
+	insert := func(tx *sql.Tx) error {
+		query := `INSERT INTO roles_parents (role_id, parent_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+
+		_, err := tx.ExecContext(ctx, query, id, parentID)
+		return err
+	}
+
+	getParent := func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE id = $1)", parentID).Scan(&exists)
+		if err == nil && !exists {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+
+	var name string
+	return m.DB.WithTransaction(ctx,
+		lockRoleChanges(ctx), lockRole(ctx, id, &name), getParent, checkCycle, insert, notifyRoleTreeChange(ctx, id))
+}
+
+// RemoveParent stops the role with the id from inheriting the permissions of the role with the parentID.
+// Returns ErrRoleNotFound if there is no such role, database.ErrRecordNotFound if the role does not
+// inherit from the parent, and ErrLastAdmin if no active user could manage users anymore.
+func (m RolePsqlRepo) RemoveParent(id, parentID int64) error {
+	query := `DELETE FROM roles_parents WHERE role_id = $1 AND parent_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var name string
+	guard, check := guardLastAdmin(ctx)
+	return m.DB.WithTransaction(ctx,
+		guard, lockRole(ctx, id, &name), execOne(ctx, query, id, parentID), check, notifyRoleTreeChange(ctx, id))
+}
+
+// ListUsersForRole returns a page of the users who hold the role with the id, oldest first, and the number of them.
+// Deleted users are left out. The users' Role is left empty.
+func (m RolePsqlRepo) ListUsersForRole(id int64, pagination database.Pagination) ([]User, int, error) {
+	query := `SELECT count(*) OVER(), id, email, created_at, updated_at, email_verified_at,
+                  suspended_at, suspended_until, suspension_reason, suspended_by
+              FROM users
+              WHERE role_id = $1 AND NOT is_deleted
+              ORDER BY created_at, id
+              LIMIT $2 OFFSET $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id, pagination.Limit(), pagination.Offset())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	users := []User{}
+
+	for rows.Next() {
+		var user User
+		var suspendedAt sql.NullTime
+		var suspension Suspension
+
+		err = rows.Scan(
+			&totalRecords,
+			&user.ID,
+			&user.Email,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.EmailVerifiedAt,
+			&suspendedAt,
+			&suspension.Until,
+			&suspension.Reason,
+			&suspension.SuspendedBy,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if suspendedAt.Valid {
+			suspension.SuspendedAt = suspendedAt.Time
+			user.Suspension = &suspension
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return users, totalRecords, nil
+}
+
+// AssignRole gives the role with the id to every user with one of the ids, all at once.
+// Returns ErrRoleNotFound if there is no such role, database.ErrRecordNotFound if one of the users
+// does not exist or was deleted, and ErrLastAdmin if no active user could manage users anymore.
+func (m RolePsqlRepo) AssignRole(id int64, userIDs []uuid.UUID) error {
+	query := `UPDATE users SET role_id = $1, updated_at = CURRENT_TIMESTAMP
+              WHERE id = ANY($2::uuid[]) AND NOT is_deleted`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	ids := make([]string, len(userIDs))
+	for i, userID := range userIDs {
+		ids[i] = userID.String()
+	}
+
+	var name string
+	assign := func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, id, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows != int64(len(userIDs)) {
+			return database.ErrRecordNotFound
+		}
+		return nil
+	}
+
+	guard, check := guardLastAdmin(ctx)
+	fns := []database.TxFn{guard, lockRole(ctx, id, &name), assign, check}
+	for _, userID := range userIDs {
+		fns = append(fns, notifyUserChange(ctx, userID))
+	}
+
+	return m.DB.WithTransaction(ctx, fns...)
+}
+
+// ListPermissions returns every permission, ordered by code.
+func (m RolePsqlRepo) ListPermissions() ([]Permission, error) {
+	query := `SELECT id, code, description, created_at FROM permissions ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []Permission{}
+	for rows.Next() {
+		var permission Permission
+		if err = rows.Scan(&permission.ID, &permission.Code, &permission.Description, &permission.CreatedAt); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// InsertPermission creates the permission, setting its ID and CreatedAt.
+// Returns ErrDuplicatePermission if the code is taken.
+func (m RolePsqlRepo) InsertPermission(permission *Permission) error {
+	query := `INSERT INTO permissions (code, description)
+              VALUES ($1, $2)
+              RETURNING id, created_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, permission.Code, permission.Description).Scan(&permission.ID, &permission.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == database.PsqlUniqueViolation {
+			return ErrDuplicatePermission
+		}
+		return err
+	}
+
+	return nil
+}
+
+// lockRole returns a database.TxFn that locks the role with the id for the rest of the transaction,
+// marking it as updated and reading its name. Returns ErrRoleNotFound if there is no such role.
+func lockRole(ctx context.Context, id int64, name *string) database.TxFn {
+	return func(tx *sql.Tx) error {
+		query := `UPDATE roles SET updated_at = CURRENT_TIMESTAMP WHERE id = $1 RETURNING name`
+
+		err := tx.QueryRowContext(ctx, query, id).Scan(name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+}
+
+// grantPermissions returns a database.TxFn that grants the permissions with the codes to the role
+// with the id. Returns ErrPermissionNotFound if one of the permissions does not exist.
+func grantPermissions(ctx context.Context, roleID *int64, codes []string) database.TxFn {
+	return func(tx *sql.Tx) error {
+		var found int
+		err := tx.QueryRowContext(ctx, "SELECT count(*) FROM permissions WHERE code = ANY($1)", pq.Array(codes)).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != len(codes) {
+			return ErrPermissionNotFound
+		}
+
+		query := `INSERT INTO roles_permissions (role_id, permission_id)
+                  SELECT $1, id FROM permissions WHERE code = ANY($2)
+                  ON CONFLICT DO NOTHING`
+
+		_, err = tx.ExecContext(ctx, query, *roleID, pq.Array(codes))
+		return err
+	}
+}
+
+// lockRoleChanges returns a database.TxFn that waits for the other transactions changing roles
+// or who holds them to end, and keeps them waiting until this one ends.
+func lockRoleChanges(ctx context.Context) database.TxFn {
+	return func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", roleChangesLockID)
+		return err
+	}
+}
+
+// guardLastAdmin returns the database.TxFns to run first and after the changes of a transaction
+// changing roles or who holds them. The first serializes such transactions and the second fails
+// the transaction with ErrLastAdmin if it leaves no active user granted PermUsersManage, directly,
+// through a wildcard or through a parent role, when there was one.
+// Deployments taking roles from token claims may have no such user in the database, and are not blocked.
+func guardLastAdmin(ctx context.Context) (guard, check database.TxFn) {
+	query := roleAncestorsCTE + `SELECT EXISTS (
+                  SELECT 1 FROM users
+                  INNER JOIN ancestors ON ancestors.role_id = users.role_id
+                  INNER JOIN roles_permissions ON roles_permissions.role_id = ancestors.ancestor_id
+                  INNER JOIN permissions ON permissions.id = roles_permissions.permission_id
+                  WHERE permissions.code = ANY($1) AND NOT users.is_deleted
+                      AND (users.suspended_at IS NULL OR users.suspended_until <= CURRENT_TIMESTAMP)
+              )`
+
+	patterns := pq.Array(permissionPatterns(PermUsersManage))
+
+	var hadAdmin bool
+	guard = func(tx *sql.Tx) error {
+		if err := lockRoleChanges(ctx)(tx); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, query, patterns).Scan(&hadAdmin)
+	}
+
+	check = func(tx *sql.Tx) error {
+		var hasAdmin bool
+		if err := tx.QueryRowContext(ctx, query, patterns).Scan(&hasAdmin); err != nil {
+			return err
+		}
+		if hadAdmin && !hasAdmin {
+			return ErrLastAdmin
+		}
+		return nil
+	}
+
+	return guard, check
+}
+
+// notifyRoleTreeChange returns a database.TxFn that announces a change to the permissions of the role
+// with the id, and so of the roles inheriting from it, on NotifyChannel.
+func notifyRoleTreeChange(ctx context.Context, id int64) database.TxFn {
+	return func(tx *sql.Tx) error {
+		query := `WITH RECURSIVE descendants (id) AS (
+                      SELECT $1::bigint
+                      UNION
+                      SELECT roles_parents.role_id
+                      FROM descendants
+                      INNER JOIN roles_parents ON roles_parents.parent_id = descendants.id
+                  )
+                  SELECT roles.name FROM roles INNER JOIN descendants ON descendants.id = roles.id`
+
+		rows, err := tx.QueryContext(ctx, query, id)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var names []string
+		for rows.Next() {
+			var name string
+			if err = rows.Scan(&name); err != nil {
+				return err
+			}
+			names = append(names, name)
+		}
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, name := range names {
+			if err = notifyRoleChange(ctx, name)(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// execOne returns a database.TxFn that executes the query, and returns database.ErrRecordNotFound
+// if it affected no rows.
+func execOne(ctx context.Context, query string, args ...any) database.TxFn {
+	return func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return database.ErrRecordNotFound
+		}
+		return nil
+	}
+}
+
+// notifyUserChange returns a database.TxFn that announces a change to the user on NotifyChannel.
+func notifyUserChange(ctx context.Context, id uuid.UUID) database.TxFn {
+	return notifyChange(ctx, changeNotification{UserID: &id})
+}
+
+// notifyRoleChange returns a database.TxFn that announces a change to the role's permissions on NotifyChannel.
+func notifyRoleChange(ctx context.Context, roleName string) database.TxFn {
+	return notifyChange(ctx, changeNotification{Role: roleName})
+}
+
+func notifyChange(ctx context.Context, change changeNotification) database.TxFn {
+	return func(tx *sql.Tx) error {
+		payload, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		return database.Notify(ctx, tx, NotifyChannel, string(payload))
+	}
 }
@@ -1,23 +1,115 @@
 package users
 
 import (
-	"database/sql"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/validator"
+	"regexp"
+	"time"
 )
 
 const (
 	RoleRegularUser = "regular"
 )
 
+var (
+	// RoleNameRX matches role names: a lowercase word, which may contain digits, dashes and underscores.
+	RoleNameRX = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+	// PermissionCodeRX matches permission codes: a resource and an action, like users:manage,
+	// either of which may be the PermissionWildcard.
+	PermissionCodeRX = regexp.MustCompile(`^([a-z][a-z0-9_-]*|\*):([a-z][a-z0-9_-]*|\*)$`)
+)
+
 type Role struct {
 	Name        string
 	Permissions Permissions
+	// set is the Permissions precomputed by NewRole
+	set *PermissionSet
+}
+
+var RegularRole = NewRole(RoleRegularUser, Permissions{})
+
+// NewRole returns the role with the permissions precomputed for the checks of Allows and AllowsAny.
+// The permissions must not be changed afterwards.
+func NewRole(name string, permissions Permissions) Role {
+	return Role{Name: name, Permissions: permissions, set: NewPermissionSet(permissions...)}
+}
+
+func (r Role) permissionSet() *PermissionSet {
+	if r.set == nil {
+		return NewPermissionSet(r.Permissions...)
+	}
+	return r.set
+}
+
+// Allows reports whether the role is granted every one of the permission codes, wildcards included.
+func (r Role) Allows(codes ...string) bool {
+	return r.permissionSet().Includes(codes...)
+}
+
+// AllowsAny reports whether the role is granted at least one of the permission codes, wildcards included.
+func (r Role) AllowsAny(codes ...string) bool {
+	return r.permissionSet().IncludesAny(codes...)
+}
+
+// RoleDetails is a role as administrators manage it, along with the number of users holding it.
+type RoleDetails struct {
+	ID          int64  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Permissions are the permissions granted to the role itself
+	Permissions Permissions `json:"permissions"`
+	// Parents are the names of the roles the role inherits the permissions of
+	Parents []string `json:"parents"`
+	// EffectivePermissions are the permissions of the role and of every role it inherits from
+	EffectivePermissions Permissions `json:"effectivePermissions"`
+	UserCount            int         `json:"userCount"`
+	CreatedAt            time.Time   `json:"createdAt"`
+	UpdatedAt            time.Time   `json:"updatedAt"`
+}
+
+// IsProtectedRole reports whether the role with the name is one the application relies on by name,
+// which may therefore be neither renamed nor deleted.
+func IsProtectedRole(name string) bool {
+	return name == RoleRegularUser
+}
+
+// Permission is a permission that may be granted to roles.
+type Permission struct {
+	ID          int64     `json:"id"`
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func ValidateRoleName(v *validator.Validator, name string) {
+	v.Check(name != "", "name", "must be provided")
+	v.Check(len(name) <= 50, "name", "must not be more than 50 bytes long")
+	v.Check(name == "" || validator.Matches(name, RoleNameRX), "name",
+		"must be a lowercase word, which may contain digits, dashes and underscores")
+}
+
+func ValidatePermissionCodes(v *validator.Validator, key string, codes []string) {
+	v.Check(len(codes) <= 100, key, "must not contain more than 100 permissions")
+	v.Check(validator.Unique(codes), key, "must not contain duplicate values")
+	for _, code := range codes {
+		v.Check(validator.Matches(code, PermissionCodeRX), key, "must only contain codes like resource:action, resource:* or *:action")
+	}
+}
+
+func ValidateRoleDetails(v *validator.Validator, role *RoleDetails) {
+	ValidateRoleName(v, role.Name)
+	v.Check(len(role.Description) <= 500, "description", "must not be more than 500 bytes long")
+	ValidatePermissionCodes(v, "permissions", role.Permissions)
 }
 
-var RegularRole = Role{
-	Name:        RoleRegularUser,
-	Permissions: Permissions{},
+func ValidatePermission(v *validator.Validator, permission *Permission) {
+	v.Check(permission.Code != "", "code", "must be provided")
+	v.Check(len(permission.Code) <= 100, "code", "must not be more than 100 bytes long")
+	v.Check(permission.Code == "" || validator.Matches(permission.Code, PermissionCodeRX), "code",
+		"must be a resource and an action, either of which may be *, like resource:action")
+	v.Check(len(permission.Description) <= 500, "description", "must not be more than 500 bytes long")
 }
 
 type RolePsqlRepo struct {
-	DB *sql.DB
+	DB *database.DB
 }
@@ -0,0 +1,590 @@
+package users
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/audit"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/validator"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxBulkAssignments is the number of users whose role may be changed in a single request.
+const maxBulkAssignments = 100
+
+type roleManager interface {
+	ListRoles() ([]RoleDetails, error)
+	GetRole(id int64) (*RoleDetails, error)
+	CreateRole(role *RoleDetails) error
+	UpdateRole(role *RoleDetails) error
+	DeleteRole(id int64) error
+	AddPermissions(id int64, codes []string) error
+	RemovePermission(id int64, code string) error
+	AddParent(id, parentID int64) error
+	RemoveParent(id, parentID int64) error
+	ListUsersForRole(id int64, pagination database.Pagination) ([]User, int, error)
+	AssignRole(id int64, userIDs []uuid.UUID) error
+	ListPermissions() ([]Permission, error)
+	CreatePermission(permission *Permission) error
+}
+
+// ListRolesHandler responds with every role, its permissions and the number of users holding it.
+func ListRolesHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		roles, err := manager.ListRoles()
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"roles": roles}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListRolesHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// CreateRoleHandler creates the role with the name, description and permissions in the body.
+func CreateRoleHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name        string   `json:"name"`
+			Description string   `json:"description"`
+			Permissions []string `json:"permissions"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		role := &RoleDetails{
+			Name:        input.Name,
+			Description: strings.TrimSpace(input.Description),
+			Permissions: Permissions{},
+		}
+		if input.Permissions != nil {
+			role.Permissions = input.Permissions
+		}
+
+		v := validator.New()
+		if ValidateRoleDetails(v, role); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if err := manager.CreateRole(role); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetResource(r, "role", strconv.FormatInt(role.ID, 10))
+		audit.SetAfter(r, role)
+
+		err := apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"role": role}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("CreateRoleHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// GetRoleHandler responds with the role with the id in the path.
+func GetRoleHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		role, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"role": role}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("GetRoleHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// UpdateRoleHandler renames the role with the id in the path, or changes its description.
+// The regular role, given to new users, cannot be renamed.
+func UpdateRoleHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Name        *string `json:"name"`
+			Description *string `json:"description"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		before, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		role := *before
+		if input.Name != nil {
+			role.Name = *input.Name
+		}
+		if input.Description != nil {
+			role.Description = strings.TrimSpace(*input.Description)
+		}
+
+		v := validator.New()
+		if ValidateRoleDetails(v, &role); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if err := manager.UpdateRole(&role); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+		audit.SetAfter(r, role)
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"role": role}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("UpdateRoleHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// DeleteRoleHandler deletes the role with the id in the path. Roles still held by users
+// and the regular role, given to new users, cannot be deleted.
+func DeleteRoleHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := manager.DeleteRole(before.ID); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"message": "role deleted"}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("DeleteRoleHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// AddRolePermissionsHandler grants the permissions in the body to the role with the id in the path.
+func AddRolePermissionsHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Permissions []string `json:"permissions"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		v.Check(len(input.Permissions) > 0, "permissions", "must be provided")
+		if ValidatePermissionCodes(v, "permissions", input.Permissions); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		before, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := manager.AddPermissions(before.ID, input.Permissions); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+
+		after, ok := getChangedRole(w, r, logger, manager, before.ID)
+		if !ok {
+			return
+		}
+		audit.SetAfter(r, after)
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"role": after}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("AddRolePermissionsHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RemoveRolePermissionHandler revokes the permission with the code in the path from the role with the id
+// in the path. The users:manage permission cannot be revoked from the last active users holding it.
+func RemoveRolePermissionHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := r.PathValue("code")
+
+		v := validator.New()
+		if ValidatePermissionCodes(v, "code", []string{code}); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		before, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := manager.RemovePermission(before.ID, code); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+
+		after, ok := getChangedRole(w, r, logger, manager, before.ID)
+		if !ok {
+			return
+		}
+		audit.SetAfter(r, after)
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"role": after}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RemoveRolePermissionHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// AddRoleParentHandler makes the role with the id in the path inherit the permissions of the role
+// with the parentId in the path. A role cannot inherit from itself, even through other roles.
+func AddRoleParentHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parentID, ok := readParentID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		before, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := manager.AddParent(before.ID, parentID); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+
+		after, ok := getChangedRole(w, r, logger, manager, before.ID)
+		if !ok {
+			return
+		}
+		audit.SetAfter(r, after)
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"role": after}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("AddRoleParentHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// RemoveRoleParentHandler stops the role with the id in the path from inheriting the permissions
+// of the role with the parentId in the path.
+func RemoveRoleParentHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parentID, ok := readParentID(w, r, logger)
+		if !ok {
+			return
+		}
+
+		before, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := manager.RemoveParent(before.ID, parentID); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+
+		after, ok := getChangedRole(w, r, logger, manager, before.ID)
+		if !ok {
+			return
+		}
+		audit.SetAfter(r, after)
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"role": after}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("RemoveRoleParentHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListRoleUsersHandler responds with a page of the users holding the role with the id in the path,
+// paginated with page and pageSize. Their role has the permissions it inherits, as RequirePermissions checks.
+func ListRoleUsersHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		qs := r.URL.Query()
+		v := validator.New()
+
+		var pagination database.Pagination
+		pagination.Page, _ = apiutils.ReadIntQuery(qs, "page", 1, v)
+		pagination.PageSize, _ = apiutils.ReadIntQuery(qs, "pageSize", database.DefaultPageSize, v)
+
+		if database.ValidatePagination(v, pagination); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		role, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+
+		users, totalRecords, err := manager.ListUsersForRole(role.ID, pagination)
+		if err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+		for i := range users {
+			users[i].Role = NewRole(role.Name, role.EffectivePermissions)
+		}
+
+		responseData := apiutils.Envelope{
+			"users":    users,
+			"metadata": database.CalculateMetadata(totalRecords, pagination),
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, responseData, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListRoleUsersHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// AssignRoleHandler gives the role with the id in the path to every user in the body, at once:
+// when one of them does not exist, none of them is changed.
+func AssignRoleHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			UserIDs []uuid.UUID `json:"userIds"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		v := validator.New()
+		v.Check(len(input.UserIDs) > 0, "userIds", "must be provided")
+		v.Check(len(input.UserIDs) <= maxBulkAssignments, "userIds", "must not contain more than 100 users")
+		v.Check(validator.Unique(input.UserIDs), "userIds", "must not contain duplicate values")
+		if !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		before, ok := getRole(w, r, logger, manager)
+		if !ok {
+			return
+		}
+		audit.SetBefore(r, before)
+
+		if err := manager.AssignRole(before.ID, input.UserIDs); err != nil {
+			if errors.Is(err, database.ErrRecordNotFound) {
+				v.AddError("userIds", "must only contain existing users")
+				apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+				return
+			}
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+		after, ok := getChangedRole(w, r, logger, manager, before.ID)
+		if !ok {
+			return
+		}
+		audit.SetAfter(r, apiutils.Envelope{"role": after, "userIds": input.UserIDs})
+
+		err := apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"role": after}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("AssignRoleHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// ListPermissionsHandler responds with every permission roles may be granted.
+func ListPermissionsHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		permissions, err := manager.ListPermissions()
+		if err != nil {
+			apiutils.ServerErrorResponse(w, r, logger, err)
+			return
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"permissions": permissions}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("ListPermissionsHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// CreatePermissionHandler creates the permission with the code and description in the body,
+// for permissions checked by code the application does not ship with.
+func CreatePermissionHandler(
+	logger *slog.Logger,
+	manager roleManager,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct {
+			Code        string `json:"code"`
+			Description string `json:"description"`
+		}
+
+		if err := apiutils.ReadJSON(w, r, &input); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		permission := &Permission{Code: input.Code, Description: strings.TrimSpace(input.Description)}
+
+		v := validator.New()
+		if ValidatePermission(v, permission); !v.Valid() {
+			apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+			return
+		}
+
+		if err := manager.CreatePermission(permission); err != nil {
+			roleErrorResponse(w, r, logger, err)
+			return
+		}
+
+		audit.SetResource(r, "permission", strconv.FormatInt(permission.ID, 10))
+		audit.SetAfter(r, permission)
+
+		err := apiutils.WriteJson(w, http.StatusCreated, apiutils.Envelope{"permission": permission}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("CreatePermissionHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
+
+// getRole returns the role with the id in the path, recording it as the resource of the request.
+// It responds with 422 if the id is invalid and 404 if there is no such role.
+func getRole(w http.ResponseWriter, r *http.Request, logger *slog.Logger, manager roleManager) (*RoleDetails, bool) {
+	v := validator.New()
+	id := int64(apiutils.ReadIdPath(r, "id", v))
+	if !v.Valid() {
+		apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+		return nil, false
+	}
+
+	audit.SetResource(r, "role", strconv.FormatInt(id, 10))
+
+	role, err := manager.GetRole(id)
+	if err != nil {
+		roleErrorResponse(w, r, logger, err)
+		return nil, false
+	}
+
+	return role, true
+}
+
+// readParentID returns the parentId in the path, responding with 422 if it is invalid
+// or the id of the role itself.
+func readParentID(w http.ResponseWriter, r *http.Request, logger *slog.Logger) (int64, bool) {
+	v := validator.New()
+	parentID := apiutils.ReadIdPath(r, "parentId", v)
+	v.Check(r.PathValue("parentId") != r.PathValue("id"), "parentId", "must be another role")
+	if !v.Valid() {
+		apiutils.FailedValidationResponse(w, r, logger, v.Errors)
+		return 0, false
+	}
+
+	return int64(parentID), true
+}
+
+// getChangedRole returns the role with the id, read again after the request changed it.
+func getChangedRole(w http.ResponseWriter, r *http.Request, logger *slog.Logger, manager roleManager, id int64) (*RoleDetails, bool) {
+	role, err := manager.GetRole(id)
+	if err != nil {
+		roleErrorResponse(w, r, logger, err)
+		return nil, false
+	}
+
+	return role, true
+}
+
+// roleErrorResponse responds with the status matching an error of a roleManager.
+func roleErrorResponse(w http.ResponseWriter, r *http.Request, logger *slog.Logger, err error) {
+	switch {
+	case errors.Is(err, ErrRoleNotFound), errors.Is(err, database.ErrRecordNotFound):
+		apiutils.NotFoundResponse(w, r, logger)
+	case errors.Is(err, ErrDuplicateRole):
+		apiutils.UniqueViolationResponse(w, r, logger, "name")
+	case errors.Is(err, ErrDuplicatePermission):
+		apiutils.UniqueViolationResponse(w, r, logger, "code")
+	case errors.Is(err, ErrPermissionNotFound):
+		apiutils.FailedValidationResponse(w, r, logger, map[string]string{"permissions": "must only contain existing permissions"})
+	case errors.Is(err, ErrRoleInUse):
+		apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "the role is held by users, give them another role first")
+	case errors.Is(err, ErrProtectedRole):
+		apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "the regular role is given to new users and cannot be renamed or deleted")
+	case errors.Is(err, ErrRoleCycle):
+		apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "the role would inherit from itself")
+	case errors.Is(err, ErrLastAdmin):
+		apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "the change would leave no active user able to manage users")
+	default:
+		apiutils.ServerErrorResponse(w, r, logger, err)
+	}
+}
@@ -0,0 +1,419 @@
+package users
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/testutils"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// memoryRoleRepo keeps roles in memory. Like the Postgres repository, it refuses to revoke
+// users:manage from the admin role, which the only admin holds.
+type memoryRoleRepo struct {
+	roles       map[int64]*RoleDetails
+	permissions []string
+	holders     map[uuid.UUID]int64
+	nextID      int64
+}
+
+func newMemoryRoleRepo() *memoryRoleRepo {
+	m := &memoryRoleRepo{
+		roles:       map[int64]*RoleDetails{},
+		permissions: []string{PermUsersManage, PermAuditRead, PermTokensRevoke},
+		holders:     map[uuid.UUID]int64{},
+	}
+	_ = m.InsertRole(&RoleDetails{Name: RoleRegularUser, Permissions: Permissions{}})
+	_ = m.InsertRole(&RoleDetails{Name: "admin", Permissions: Permissions{PermUsersManage, PermAuditRead}})
+	m.holders[uuid.New()] = 2
+	return m
+}
+
+func (m *memoryRoleRepo) ListRoles() ([]RoleDetails, error) {
+	roles := []RoleDetails{}
+	for _, id := range slices.Sorted(maps.Keys(m.roles)) {
+		role := *m.roles[id]
+		role.Permissions = slices.Clone(role.Permissions)
+		role.Parents = slices.Clone(role.Parents)
+		for _, roleID := range m.holders {
+			if roleID == id {
+				role.UserCount++
+			}
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (m *memoryRoleRepo) InsertRole(role *RoleDetails) error {
+	for _, existing := range m.roles {
+		if existing.Name == role.Name {
+			return ErrDuplicateRole
+		}
+	}
+	for _, code := range role.Permissions {
+		if !slices.Contains(m.permissions, code) {
+			return ErrPermissionNotFound
+		}
+	}
+	m.nextID++
+	role.ID = m.nextID
+	copied := *role
+	m.roles[role.ID] = &copied
+	return nil
+}
+
+func (m *memoryRoleRepo) UpdateRole(role *RoleDetails) error {
+	existing, ok := m.roles[role.ID]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	if IsProtectedRole(existing.Name) && role.Name != existing.Name {
+		return ErrProtectedRole
+	}
+	existing.Name, existing.Description = role.Name, role.Description
+	return nil
+}
+
+func (m *memoryRoleRepo) DeleteRole(id int64) error {
+	role, ok := m.roles[id]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	if IsProtectedRole(role.Name) {
+		return ErrProtectedRole
+	}
+	for _, roleID := range m.holders {
+		if roleID == id {
+			return ErrRoleInUse
+		}
+	}
+	delete(m.roles, id)
+	return nil
+}
+
+func (m *memoryRoleRepo) AddPermissions(id int64, codes []string) error {
+	role, ok := m.roles[id]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	for _, code := range codes {
+		if !slices.Contains(m.permissions, code) {
+			return ErrPermissionNotFound
+		}
+	}
+	role.Permissions = mergePermissions(role.Permissions, codes)
+	return nil
+}
+
+func (m *memoryRoleRepo) RemovePermission(id int64, code string) error {
+	role, ok := m.roles[id]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	if !slices.Contains(role.Permissions, code) {
+		return database.ErrRecordNotFound
+	}
+	if role.Name == "admin" && code == PermUsersManage {
+		return ErrLastAdmin
+	}
+	role.Permissions = slices.DeleteFunc(role.Permissions, func(c string) bool { return c == code })
+	return nil
+}
+
+func (m *memoryRoleRepo) AddParent(id, parentID int64) error {
+	role, ok := m.roles[id]
+	parent, parentOk := m.roles[parentID]
+	if !ok || !parentOk {
+		return ErrRoleNotFound
+	}
+	hierarchy := RoleHierarchy{}
+	for _, r := range m.roles {
+		hierarchy[r.Name] = r.Parents
+	}
+	if slices.Contains(hierarchy.Ancestors(parent.Name), role.Name) {
+		return ErrRoleCycle
+	}
+	role.Parents = append(role.Parents, parent.Name)
+	return nil
+}
+
+func (m *memoryRoleRepo) RemoveParent(id, parentID int64) error {
+	role, ok := m.roles[id]
+	if !ok {
+		return ErrRoleNotFound
+	}
+	parent, ok := m.roles[parentID]
+	if !ok || !slices.Contains(role.Parents, parent.Name) {
+		return database.ErrRecordNotFound
+	}
+	role.Parents = slices.DeleteFunc(role.Parents, func(name string) bool { return name == parent.Name })
+	return nil
+}
+
+func (m *memoryRoleRepo) ListUsersForRole(id int64, pagination database.Pagination) ([]User, int, error) {
+	users := []User{}
+	for userID, roleID := range m.holders {
+		if roleID == id {
+			users = append(users, User{ID: userID})
+		}
+	}
+	return users, len(users), nil
+}
+
+func (m *memoryRoleRepo) AssignRole(id int64, userIDs []uuid.UUID) error {
+	if _, ok := m.roles[id]; !ok {
+		return ErrRoleNotFound
+	}
+	for _, userID := range userIDs {
+		if _, ok := m.holders[userID]; !ok {
+			return database.ErrRecordNotFound
+		}
+	}
+	for _, userID := range userIDs {
+		m.holders[userID] = id
+	}
+	return nil
+}
+
+func (m *memoryRoleRepo) ListPermissions() ([]Permission, error) {
+	permissions := []Permission{}
+	for i, code := range m.permissions {
+		permissions = append(permissions, Permission{ID: int64(i + 1), Code: code})
+	}
+	return permissions, nil
+}
+
+func (m *memoryRoleRepo) InsertPermission(permission *Permission) error {
+	if slices.Contains(m.permissions, permission.Code) {
+		return ErrDuplicatePermission
+	}
+	m.permissions = append(m.permissions, permission.Code)
+	permission.ID = int64(len(m.permissions))
+	return nil
+}
+
+func serveRoleRequest(handler http.Handler, method, id, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/v1/admin/roles", bytes.NewBufferString(body))
+	req.SetPathValue("id", id)
+
+	rec := httptest.NewRecorder()
+	addUserHandler(&User{ID: uuid.New()}, handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func readRole(t *testing.T, rec *httptest.ResponseRecorder) RoleDetails {
+	t.Helper()
+	var env struct {
+		Role RoleDetails `json:"role"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+	return env.Role
+}
+
+func TestCreateRole(t *testing.T) {
+	service := NewRoleService(newMemoryRoleRepo())
+	handler := CreateRoleHandler(slog.New(slog.NewTextHandler(io.Discard, nil)), service)
+
+	rec := serveRoleRequest(handler, "POST", "", `{"name": "support", "description": " Support staff ", "permissions": ["audit:read"]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+	if role := readRole(t, rec); role.ID == 0 || role.Description != "Support staff" || !role.Permissions.Includes(PermAuditRead) {
+		t.Errorf("Expected the created role, got %+v", role)
+	}
+
+	rec = serveRoleRequest(handler, "POST", "", `{"name": "support"}`)
+	testutils.CheckJSONResponseError(t, rec, http.StatusConflict, "the resource you're trying to create already exists: name")
+
+	invalid := map[string]string{
+		"name":        `{"name": "Customer Support"}`,
+		"permissions": `{"name": "editor", "permissions": ["posts"]}`,
+	}
+	for field, body := range invalid {
+		rec = serveRoleRequest(handler, "POST", "", body)
+		if rec.Code != http.StatusUnprocessableEntity || !bytes.Contains(rec.Body.Bytes(), []byte(`"`+field+`"`)) {
+			t.Errorf("Expected an error on %s, got %d: %s", field, rec.Code, rec.Body)
+		}
+	}
+
+	rec = serveRoleRequest(handler, "POST", "", `{"name": "editor", "permissions": ["posts:write"]}`)
+	if rec.Code != http.StatusUnprocessableEntity || !bytes.Contains(rec.Body.Bytes(), []byte("must only contain existing permissions")) {
+		t.Errorf("Expected unknown permissions to be rejected, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestProtectedRoles(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	service := NewRoleService(newMemoryRoleRepo())
+
+	rec := serveRoleRequest(UpdateRoleHandler(logger, service), "PATCH", "1", `{"name": "basic"}`)
+	testutils.CheckJSONResponseError(t, rec, http.StatusConflict, "the regular role is given to new users and cannot be renamed or deleted")
+
+	rec = serveRoleRequest(UpdateRoleHandler(logger, service), "PATCH", "1", `{"description": "Everyone"}`)
+	if role := readRole(t, rec); rec.Code != http.StatusOK || role.Name != RoleRegularUser || role.Description != "Everyone" {
+		t.Errorf("Expected the description of the regular role to change, got %d: %+v", rec.Code, role)
+	}
+
+	rec = serveRoleRequest(DeleteRoleHandler(logger, service), "DELETE", "1", "")
+	testutils.CheckJSONResponseError(t, rec, http.StatusConflict, "the regular role is given to new users and cannot be renamed or deleted")
+
+	rec = serveRoleRequest(DeleteRoleHandler(logger, service), "DELETE", "2", "")
+	testutils.CheckJSONResponseError(t, rec, http.StatusConflict, "the role is held by users, give them another role first")
+
+	rec = serveRoleRequest(DeleteRoleHandler(logger, service), "DELETE", "3", "")
+	testutils.CheckJSONResponseError(t, rec, http.StatusNotFound, "the requested resource could not be found")
+}
+
+func TestRolePermissions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	service := NewRoleService(newMemoryRoleRepo())
+
+	rec := serveRoleRequest(AddRolePermissionsHandler(logger, service), "POST", "2", `{"permissions": ["tokens:revoke"]}`)
+	if role := readRole(t, rec); rec.Code != http.StatusOK || !role.Permissions.Includes(PermTokensRevoke, PermUsersManage) {
+		t.Errorf("Expected the permission to be added, got %d: %+v", rec.Code, role)
+	}
+
+	remove := func(code string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/v1/admin/roles/2/permissions/"+code, nil)
+		req.SetPathValue("id", "2")
+		req.SetPathValue("code", code)
+		rec := httptest.NewRecorder()
+		RemoveRolePermissionHandler(logger, service).ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec = remove(PermTokensRevoke); rec.Code != http.StatusOK || readRole(t, rec).Permissions.Includes(PermTokensRevoke) {
+		t.Errorf("Expected the permission to be removed, got %d", rec.Code)
+	}
+	testutils.CheckJSONResponseError(t, remove(PermTokensRevoke), http.StatusNotFound, "the requested resource could not be found")
+	testutils.CheckJSONResponseError(t, remove(PermUsersManage), http.StatusConflict, "the change would leave no active user able to manage users")
+}
+
+func TestRoleParents(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := newMemoryRoleRepo()
+	service := NewRoleService(repo)
+	_ = repo.InsertRole(&RoleDetails{Name: "support", Permissions: Permissions{PermTokensRevoke}})
+
+	serve := func(handler http.Handler, id, parentID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/v1/admin/roles/"+id+"/parents/"+parentID, nil)
+		req.SetPathValue("id", id)
+		req.SetPathValue("parentId", parentID)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(AddRoleParentHandler(logger, service), "2", "3")
+	role := readRole(t, rec)
+	if rec.Code != http.StatusOK || !slices.Equal(role.Parents, []string{"support"}) {
+		t.Fatalf("Expected admin to inherit from support, got %d: %+v", rec.Code, role)
+	}
+	if !role.EffectivePermissions.Includes(PermUsersManage, PermTokensRevoke) || role.Permissions.Includes(PermTokensRevoke) {
+		t.Errorf("Expected the inherited permissions to be effective only, got %+v", role)
+	}
+
+	rec = serve(AddRoleParentHandler(logger, service), "3", "2")
+	testutils.CheckJSONResponseError(t, rec, http.StatusConflict, "the role would inherit from itself")
+
+	rec = serve(AddRoleParentHandler(logger, service), "3", "3")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected a role not to be its own parent, got %d", rec.Code)
+	}
+
+	rec = serve(RemoveRoleParentHandler(logger, service), "2", "3")
+	if role = readRole(t, rec); rec.Code != http.StatusOK || len(role.Parents) != 0 || role.EffectivePermissions.Includes(PermTokensRevoke) {
+		t.Errorf("Expected admin to stop inheriting from support, got %d: %+v", rec.Code, role)
+	}
+	testutils.CheckJSONResponseError(t, serve(RemoveRoleParentHandler(logger, service), "2", "3"),
+		http.StatusNotFound, "the requested resource could not be found")
+}
+
+func TestAssignRole(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	repo := newMemoryRoleRepo()
+	service := NewRoleService(repo)
+
+	first, second := uuid.New(), uuid.New()
+	repo.holders[first], repo.holders[second] = 1, 1
+
+	body := func(ids ...uuid.UUID) string {
+		data, _ := json.Marshal(map[string][]uuid.UUID{"userIds": ids})
+		return string(data)
+	}
+
+	rec := serveRoleRequest(AssignRoleHandler(logger, service), "POST", "2", body(first, uuid.New()))
+	if rec.Code != http.StatusUnprocessableEntity || repo.holders[first] != 1 {
+		t.Errorf("Expected no user to be changed when one does not exist, got %d", rec.Code)
+	}
+
+	rec = serveRoleRequest(AssignRoleHandler(logger, service), "POST", "2", body(first, first))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected duplicate users to be rejected, got %d", rec.Code)
+	}
+
+	rec = serveRoleRequest(AssignRoleHandler(logger, service), "POST", "2", body(first, second))
+	if role := readRole(t, rec); rec.Code != http.StatusOK || role.UserCount != 3 {
+		t.Errorf("Expected both users to get the role, got %d: %+v", rec.Code, role)
+	}
+
+	_ = repo.InsertRole(&RoleDetails{Name: "support", Permissions: Permissions{PermTokensRevoke}})
+	if err := service.AddParent(2, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = serveRoleRequest(ListRoleUsersHandler(logger, service), "GET", "2", "")
+	var env struct {
+		Users    []User            `json:"users"`
+		Metadata database.Metadata `json:"metadata"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Metadata.TotalRecords != 3 || len(env.Users) != 3 || env.Users[0].Role.Name != "admin" {
+		t.Errorf("Expected the 3 admins with their role, got %+v", env)
+	}
+	if !env.Users[0].Role.Permissions.Includes(PermUsersManage, PermTokensRevoke) {
+		t.Errorf("Expected the admins with the permissions admin inherits, got %+v", env.Users[0].Role)
+	}
+}
+
+func TestRoleServiceInvalidatesCache(t *testing.T) {
+	user := testUser(RoleRegularUser)
+	getter := newCountingGetter(user)
+	cache := newTestCache(getter)
+	repo := newMemoryRoleRepo()
+	repo.holders[user.ID] = 1
+	service := NewRoleService(repo, WithRoleCache(cache))
+
+	_, _ = cache.GetById(user.ID)
+	if err := service.AssignRole(2, []uuid.UUID{user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if size := cache.Stats().Size; size != 0 {
+		t.Errorf("Expected the user whose role changed to be dropped, got %d cached", size)
+	}
+
+	_, _ = cache.GetById(user.ID)
+	if err := service.AddPermissions(2, []string{PermTokensRevoke}); err != nil {
+		t.Fatal(err)
+	}
+	if size := cache.Stats().Size; size != 0 {
+		t.Errorf("Expected users to be dropped when a role changes, got %d cached", size)
+	}
+
+	if err := service.AssignRole(3, []uuid.UUID{user.ID}); !errors.Is(err, ErrRoleNotFound) {
+		t.Errorf("Expected an error for a missing role, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package users
 import (
 	"fmt"
 	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
 )
 
 type UserInserter interface {
@@ -21,11 +22,27 @@ type UserGetter interface {
 	GetById(id uuid.UUID) (*User, error)
 }
 
+type UserEmailGetter interface {
+	GetByEmail(email string) (*User, error)
+}
+
+type UserEmailVerifier interface {
+	MarkEmailVerified(id uuid.UUID, email string) error
+}
+
+type UserSuspender interface {
+	Suspend(id uuid.UUID, suspension *Suspension) error
+	Reinstate(id uuid.UUID) error
+}
+
 type userRepository interface {
 	UserInserter
 	UserUpdater
 	UserDeleter
 	UserGetter
+	UserEmailGetter
+	UserSuspender
+	UserEmailVerifier
 }
 
 // UserService aggregates the methods a user may need to operate over the usersrepository.
@@ -38,17 +55,41 @@ type userRepository interface {
 // UserDeleter to delete an existing user from the database.
 type UserService struct {
 	userRepository userRepository
+	cache          *Cache
 }
 
-func NewUserService(userRepository userRepository) *UserService {
-	return &UserService{userRepository: userRepository}
+type UserServiceOption func(*UserService)
+
+// WithCache reads users by ID through the cache, and drops the users the service changes from it.
+// The cache must read from the same repository as the service.
+func WithCache(cache *Cache) UserServiceOption {
+	return func(u *UserService) {
+		u.cache = cache
+	}
 }
 
-// newDefaultUser creates a new user with the provided email and id.
+func NewUserService(userRepository userRepository, opts ...UserServiceOption) *UserService {
+	u := &UserService{userRepository: userRepository}
+	for _, opt := range opts {
+		opt(u)
+	}
+
+	return u
+}
+
+// invalidate drops the user from the cache, if there is one, after they changed. It is called
+// whether or not the change succeeded, since a change that failed may still have been committed.
+func (u *UserService) invalidate(userId uuid.UUID) {
+	if u.cache != nil {
+		u.cache.Invalidate(userId)
+	}
+}
+
+// NewDefaultUser creates a new user with the provided email and id.
 // Since we use Supabase for auth, and we want to control other fields in our db,
 // we do not include a username or other fields here.
 // The user is created with the RegularRole by default.
-func newDefaultUser(email string, id uuid.UUID) *User {
+func NewDefaultUser(email string, id uuid.UUID) *User {
 	return &User{
 		ID:        id,
 		Email:     email,
@@ -63,7 +104,7 @@ func newDefaultUser(email string, id uuid.UUID) *User {
 // The error wraps the underlying error returned by the Inserter.Insert method.
 func (u *UserService) InsertDefaultUser(email string, id uuid.UUID) error {
 	// Create a new default user with basic role that has limited permissions
-	user := newDefaultUser(email, id)
+	user := NewDefaultUser(email, id)
 
 	// Insert this role into the db
 	err := u.userRepository.Insert(user)
@@ -82,6 +123,7 @@ func (u *UserService) UpdateUserEmail(userId uuid.UUID, email string) error {
 	user := User{ID: userId, Email: email}
 
 	err := u.userRepository.UpdateEmail(&user)
+	u.invalidate(userId)
 	if err != nil {
 		return err
 	}
@@ -96,6 +138,7 @@ func (u *UserService) DeleteUser(userId uuid.UUID, oldEmail string) error {
 	delEmail := fmt.Sprintf("%v+%v", oldEmail, userId)
 
 	err := u.userRepository.Delete(userId, delEmail)
+	u.invalidate(userId)
 	if err != nil {
 		return fmt.Errorf("error deleting user: %w", err)
 	}
@@ -103,11 +146,268 @@ func (u *UserService) DeleteUser(userId uuid.UUID, oldEmail string) error {
 	return nil
 }
 
+// VerifyUserEmail records that the user identified by the provided UUID owns the email.
+// It returns database.ErrRecordNotFound, wrapped, when the email is no longer the user's.
+func (u *UserService) VerifyUserEmail(userId uuid.UUID, email string) error {
+	err := u.userRepository.MarkEmailVerified(userId, email)
+	u.invalidate(userId)
+	if err != nil {
+		return fmt.Errorf("error verifying user email: %w", err)
+	}
+
+	return nil
+}
+
+// SuspendUser suspends the user identified by the provided UUID until the suspension is lifted with
+// ReinstateUser, or its expiry passes. A user who is already suspended gets the new suspension instead.
+func (u *UserService) SuspendUser(userId uuid.UUID, suspension *Suspension) error {
+	err := u.userRepository.Suspend(userId, suspension)
+	u.invalidate(userId)
+	if err != nil {
+		return fmt.Errorf("error suspending user: %w", err)
+	}
+
+	return nil
+}
+
+// ReinstateUser lifts the suspension of the user identified by the provided UUID.
+func (u *UserService) ReinstateUser(userId uuid.UUID) error {
+	err := u.userRepository.Reinstate(userId)
+	u.invalidate(userId)
+	if err != nil {
+		return fmt.Errorf("error reinstating user: %w", err)
+	}
+
+	return nil
+}
+
 func (u *UserService) GetById(userId uuid.UUID) (*User, error) {
-	user, err := u.userRepository.GetById(userId)
+	var getter UserGetter = u.userRepository
+	if u.cache != nil {
+		getter = u.cache
+	}
+
+	user, err := getter.GetById(userId)
 	if err != nil {
 		return nil, fmt.Errorf("error getting user: %w", err)
 	}
 
 	return user, nil
 }
+
+// GetByEmail returns the user with the email, which is compared case-insensitively.
+func (u *UserService) GetByEmail(email string) (*User, error) {
+	user, err := u.userRepository.GetByEmail(email)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	return user, nil
+}
+
+type roleRepository interface {
+	ListRoles() ([]RoleDetails, error)
+	InsertRole(role *RoleDetails) error
+	UpdateRole(role *RoleDetails) error
+	DeleteRole(id int64) error
+	AddPermissions(id int64, codes []string) error
+	RemovePermission(id int64, code string) error
+	AddParent(id, parentID int64) error
+	RemoveParent(id, parentID int64) error
+	ListUsersForRole(id int64, pagination database.Pagination) ([]User, int, error)
+	AssignRole(id int64, userIDs []uuid.UUID) error
+	ListPermissions() ([]Permission, error)
+	InsertPermission(permission *Permission) error
+}
+
+// RoleService manages roles, their permissions and the users holding them.
+type RoleService struct {
+	roleRepository roleRepository
+	cache          *Cache
+}
+
+type RoleServiceOption func(*RoleService)
+
+// WithRoleCache drops the users whose role the service changes from the cache.
+func WithRoleCache(cache *Cache) RoleServiceOption {
+	return func(s *RoleService) {
+		s.cache = cache
+	}
+}
+
+func NewRoleService(roleRepository roleRepository, opts ...RoleServiceOption) *RoleService {
+	s := &RoleService{roleRepository: roleRepository}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// purge drops every user from the cache, if there is one, after a role changed. Roles change
+// rarely enough that finding the cached users holding the role is not worth it.
+func (s *RoleService) purge() {
+	if s.cache != nil {
+		s.cache.Purge()
+	}
+}
+
+// ListRoles returns every role, with the permissions it is granted directly and through its parents.
+func (s *RoleService) ListRoles() ([]RoleDetails, error) {
+	roles, err := s.roleRepository.ListRoles()
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles: %w", err)
+	}
+
+	hierarchy := RoleHierarchy{}
+	granted := map[string]Permissions{}
+	for _, role := range roles {
+		hierarchy[role.Name] = role.Parents
+		granted[role.Name] = role.Permissions
+	}
+	for i := range roles {
+		roles[i].EffectivePermissions = hierarchy.Permissions(roles[i].Name, granted)
+	}
+
+	return roles, nil
+}
+
+// GetRole returns the role with the id, or ErrRoleNotFound, wrapped. Resolving the permissions
+// it inherits needs the whole hierarchy, which is small enough to be read every time.
+func (s *RoleService) GetRole(id int64) (*RoleDetails, error) {
+	roles, err := s.ListRoles()
+	if err != nil {
+		return nil, fmt.Errorf("error getting role: %w", err)
+	}
+
+	for _, role := range roles {
+		if role.ID == id {
+			return &role, nil
+		}
+	}
+
+	return nil, fmt.Errorf("error getting role: %w", ErrRoleNotFound)
+}
+
+// CreateRole creates the role with its permissions.
+func (s *RoleService) CreateRole(role *RoleDetails) error {
+	if err := s.roleRepository.InsertRole(role); err != nil {
+		return fmt.Errorf("error creating role: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateRole renames the role with the role's ID and changes its description.
+func (s *RoleService) UpdateRole(role *RoleDetails) error {
+	err := s.roleRepository.UpdateRole(role)
+	s.purge()
+	if err != nil {
+		return fmt.Errorf("error updating role: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteRole deletes the role with the id, which no user may hold anymore.
+// The roles inheriting from it lose its permissions.
+func (s *RoleService) DeleteRole(id int64) error {
+	err := s.roleRepository.DeleteRole(id)
+	s.purge()
+	if err != nil {
+		return fmt.Errorf("error deleting role: %w", err)
+	}
+
+	return nil
+}
+
+// AddPermissions grants the permissions with the codes to the role with the id.
+func (s *RoleService) AddPermissions(id int64, codes []string) error {
+	err := s.roleRepository.AddPermissions(id, codes)
+	s.purge()
+	if err != nil {
+		return fmt.Errorf("error adding permissions: %w", err)
+	}
+
+	return nil
+}
+
+// RemovePermission revokes the permission with the code from the role with the id,
+// unless that would leave no active user able to manage users.
+func (s *RoleService) RemovePermission(id int64, code string) error {
+	err := s.roleRepository.RemovePermission(id, code)
+	s.purge()
+	if err != nil {
+		return fmt.Errorf("error removing permission: %w", err)
+	}
+
+	return nil
+}
+
+// AddParent makes the role with the id inherit the permissions of the role with the parentID,
+// unless the parent already inherits from the role.
+func (s *RoleService) AddParent(id, parentID int64) error {
+	err := s.roleRepository.AddParent(id, parentID)
+	s.purge()
+	if err != nil {
+		return fmt.Errorf("error adding parent role: %w", err)
+	}
+
+	return nil
+}
+
+// RemoveParent stops the role with the id from inheriting the permissions of the role with the parentID,
+// unless that would leave no active user able to manage users.
+func (s *RoleService) RemoveParent(id, parentID int64) error {
+	err := s.roleRepository.RemoveParent(id, parentID)
+	s.purge()
+	if err != nil {
+		return fmt.Errorf("error removing parent role: %w", err)
+	}
+
+	return nil
+}
+
+// ListUsersForRole returns a page of the users holding the role with the id, and the number of them.
+func (s *RoleService) ListUsersForRole(id int64, pagination database.Pagination) ([]User, int, error) {
+	users, totalRecords, err := s.roleRepository.ListUsersForRole(id, pagination)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error listing users for role: %w", err)
+	}
+
+	return users, totalRecords, nil
+}
+
+// AssignRole gives the role with the id to all the users with the ids, or to none of them,
+// unless that would leave no active user able to manage users.
+func (s *RoleService) AssignRole(id int64, userIDs []uuid.UUID) error {
+	err := s.roleRepository.AssignRole(id, userIDs)
+	if s.cache != nil {
+		for _, userID := range userIDs {
+			s.cache.Invalidate(userID)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error assigning role: %w", err)
+	}
+
+	return nil
+}
+
+func (s *RoleService) ListPermissions() ([]Permission, error) {
+	permissions, err := s.roleRepository.ListPermissions()
+	if err != nil {
+		return nil, fmt.Errorf("error listing permissions: %w", err)
+	}
+
+	return permissions, nil
+}
+
+// CreatePermission creates a permission that roles may then be granted.
+func (s *RoleService) CreatePermission(permission *Permission) error {
+	if err := s.roleRepository.InsertPermission(permission); err != nil {
+		return fmt.Errorf("error creating permission: %w", err)
+	}
+
+	return nil
+}
@@ -3,6 +3,7 @@ package users
 import (
 	"github.com/google/uuid"
 	"go-web-api-starter/internal/validator"
+	"strings"
 	"time"
 )
 
@@ -13,6 +14,58 @@ type User struct {
 	Email     string    `json:"email"`
 	Role      Role      `json:"role"`
 	IsDeleted bool      `json:"isDeleted"`
+	// Suspension is set while the user is suspended, and until a suspension with an expiry is cleared
+	Suspension *Suspension `json:"suspension,omitempty"`
+	// EmailVerifiedAt is when the user proved they own their email, and is cleared when it changes
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+}
+
+// AccountStatus is whether a user may authenticate.
+type AccountStatus string
+
+const (
+	StatusActive    AccountStatus = "active"
+	StatusSuspended AccountStatus = "suspended"
+	StatusDeleted   AccountStatus = "deleted"
+)
+
+// Suspension blocks a user from authenticating until it is lifted or, when Until is set, expires.
+type Suspension struct {
+	Reason      string        `json:"reason"`
+	SuspendedAt time.Time     `json:"suspendedAt"`
+	SuspendedBy uuid.NullUUID `json:"suspendedBy"`
+	Until       *time.Time    `json:"until,omitempty"`
+}
+
+// Active reports whether the suspension is in effect at the given time.
+func (s *Suspension) Active(now time.Time) bool {
+	return s.Until == nil || now.Before(*s.Until)
+}
+
+// Status returns the user's account status at the current time.
+func (u *User) Status() AccountStatus {
+	switch {
+	case u.IsDeleted:
+		return StatusDeleted
+	case u.Suspension != nil && u.Suspension.Active(time.Now()):
+		return StatusSuspended
+	default:
+		return StatusActive
+	}
+}
+
+// IsEmailVerified reports whether the user has verified their current email address.
+func (u *User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
+// AnonymousUser is the context user of requests that were let through without credentials
+// by an Authenticate middleware in optional mode. It has no ID and no permissions.
+var AnonymousUser = &User{}
+
+// IsAnonymous reports whether the user is the AnonymousUser.
+func (u *User) IsAnonymous() bool {
+	return u == AnonymousUser
 }
 
 // ValidateEmail checks if the provided email string is not empty and if it
@@ -31,6 +84,15 @@ func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
 }
 
+// ValidateSuspension checks the reason and expiry of a suspension.
+func ValidateSuspension(v *validator.Validator, suspension *Suspension) {
+	v.Check(strings.TrimSpace(suspension.Reason) != "", "reason", "must be provided")
+	v.MaxLength(suspension.Reason, "reason", 500)
+	if suspension.Until != nil {
+		v.Check(suspension.Until.After(time.Now()), "until", "must be in the future")
+	}
+}
+
 func ValidateUser(v *validator.Validator, user *User) {
 	ValidateEmail(v, user.Email)
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 func (v *Validator) FirstLetterUpper(s, key string) {
@@ -37,3 +38,7 @@ func (v *Validator) NotOnlySpaces(s, key string) {
 	trimmed := strings.TrimSpace(s)
 	v.Check(trimmed != "", key, "must not only contain spaces")
 }
+
+func (v *Validator) MinLength(s, key string, length int) {
+	v.Check(utf8.RuneCountInString(s) >= length, key, fmt.Sprintf("must be at least %d characters", length))
+}
@@ -0,0 +1,9 @@
+package webhooks
+
+import "errors"
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature is missing or invalid")
+	ErrInvalidTimestamp = errors.New("webhook timestamp is missing or outside the tolerance")
+	ErrInvalidSecret    = errors.New("webhook secret must be base64 encoded, optionally prefixed with whsec_")
+)
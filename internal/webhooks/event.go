@@ -0,0 +1,106 @@
+package webhooks
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// EventType is the user lifecycle change a webhook delivery announces.
+type EventType string
+
+const (
+	UserCreated EventType = "user.created"
+	UserUpdated EventType = "user.updated"
+	UserDeleted EventType = "user.deleted"
+)
+
+// Outcome is what handling an event did.
+type Outcome string
+
+const (
+	// OutcomeApplied means the event changed the user, or the user was already in the announced state.
+	OutcomeApplied Outcome = "applied"
+	// OutcomeStale means a newer event for the user was applied first, so this one was skipped.
+	OutcomeStale Outcome = "stale"
+	// OutcomeDuplicate means the delivery was already handled.
+	OutcomeDuplicate Outcome = "duplicate"
+)
+
+// Event is a webhook delivery about a user, recorded so that retries of the same delivery are ignored.
+type Event struct {
+	ID         string
+	Type       EventType
+	UserID     uuid.UUID
+	Email      string
+	OccurredAt time.Time
+	ReceivedAt time.Time
+	Outcome    Outcome
+}
+
+// authUser is the subset of an auth.users row the API keeps in sync.
+type authUser struct {
+	ID        uuid.UUID  `json:"id"`
+	Email     string     `json:"email"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+// databasePayload is the body of a Supabase database webhook on the auth.users table.
+type databasePayload struct {
+	Type      string    `json:"type"`
+	Table     string    `json:"table"`
+	Schema    string    `json:"schema"`
+	Record    *authUser `json:"record"`
+	OldRecord *authUser `json:"old_record"`
+}
+
+// event turns the payload into an Event, and reports false for changes to other tables.
+// The event occurred when the row says it was created, updated or deleted, and at the
+// delivery's timestamp when the row does not say, as for hard deletes.
+func (p databasePayload) event(id string, timestamp time.Time) (*Event, bool) {
+	if p.Schema != "auth" || p.Table != "users" {
+		return nil, false
+	}
+
+	event := &Event{ID: id, OccurredAt: timestamp}
+	var row *authUser
+	var occurredAt *time.Time
+
+	switch p.Type {
+	case "INSERT":
+		event.Type = UserCreated
+		row = p.Record
+		if row != nil {
+			occurredAt = row.CreatedAt
+		}
+	case "UPDATE":
+		event.Type = UserUpdated
+		row = p.Record
+		if row != nil {
+			occurredAt = row.UpdatedAt
+			// Supabase soft deletes users by setting deleted_at
+			if row.DeletedAt != nil {
+				event.Type = UserDeleted
+				occurredAt = row.DeletedAt
+			}
+		}
+	case "DELETE":
+		event.Type = UserDeleted
+		row = p.OldRecord
+	default:
+		return nil, false
+	}
+
+	if row == nil || row.ID == uuid.Nil {
+		return nil, false
+	}
+
+	event.UserID = row.ID
+	event.Email = row.Email
+	if occurredAt != nil {
+		event.OccurredAt = *occurredAt
+	}
+
+	return event, true
+}
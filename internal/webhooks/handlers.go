@@ -0,0 +1,72 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"go-web-api-starter/internal/apiutils"
+	"go-web-api-starter/internal/middleware"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type signatureVerifier interface {
+	Verify(header http.Header, body []byte) (string, time.Time, error)
+}
+
+type eventHandler interface {
+	Handle(event *Event) (Outcome, error)
+}
+
+// SupabaseUsersHandler receives Supabase database webhooks on the auth.users table,
+// and keeps the users table in sync with the users created, updated and deleted in Supabase.
+// Deliveries for other tables and operations are acknowledged and ignored.
+//
+// A delivery that fails is answered with an error status so that Supabase retries it.
+func SupabaseUsersHandler(
+	logger *slog.Logger,
+	verifier signatureVerifier,
+	handler eventHandler,
+) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1_048_576))
+		if err != nil {
+			apiutils.BadRequestResponse(w, r, logger, err)
+			return
+		}
+
+		id, timestamp, err := verifier.Verify(r.Header, body)
+		if err != nil {
+			apiutils.ErrorResponse(w, r, logger, http.StatusUnauthorized, "invalid webhook signature")
+			return
+		}
+
+		var payload databasePayload
+		if err = json.Unmarshal(body, &payload); err != nil {
+			apiutils.BadRequestResponse(w, r, logger, errors.New("body contains badly-formed JSON"))
+			return
+		}
+
+		outcome := Outcome("ignored")
+		if event, ok := payload.event(id, timestamp); ok {
+			outcome, err = handler.Handle(event)
+			if err != nil {
+				switch {
+				case errors.Is(err, users.ErrDuplicateEmail):
+					apiutils.ErrorResponse(w, r, logger, http.StatusConflict, "the user's email address belongs to another user")
+				default:
+					apiutils.ServerErrorResponse(w, r, logger, err)
+				}
+				return
+			}
+		}
+
+		err = apiutils.WriteJson(w, http.StatusOK, apiutils.Envelope{"outcome": outcome}, http.Header{})
+		if err != nil {
+			requestId, _ := middleware.GetRequestID(r)
+			logger.Error("SupabaseUsersHandler write failed", middleware.RequestIdLog, requestId, "error", err)
+		}
+	})
+}
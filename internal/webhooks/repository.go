@@ -0,0 +1,84 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"time"
+)
+
+type EventPsqlRepo struct {
+	DB *database.DB
+}
+
+// WithUserLock runs fn while holding a Postgres advisory lock on the user, so that the events of a user
+// are handled one at a time across every instance of the application. The lock is taken within a
+// transaction, and released when it ends after fn returns or the timeout elapses.
+func (m EventPsqlRepo) WithUserLock(userID uuid.UUID, fn func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lock := func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", userID.String())
+		return err
+	}
+	run := func(tx *sql.Tx) error {
+		return fn()
+	}
+
+	return m.DB.WithTransaction(ctx, lock, run)
+}
+
+// Exists reports whether a delivery with the ID was already recorded.
+func (m EventPsqlRepo) Exists(id string) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM webhook_events WHERE id = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&exists)
+	return exists, err
+}
+
+// LatestApplied returns when the newest event applied to the user occurred,
+// or the zero time if none was.
+func (m EventPsqlRepo) LatestApplied(userID uuid.UUID) (time.Time, error) {
+	query := `SELECT MAX(occurred_at)
+              FROM webhook_events
+              WHERE user_id = $1 AND outcome = 'applied'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var latest sql.NullTime
+	if err := m.DB.QueryRowContext(ctx, query, userID).Scan(&latest); err != nil {
+		return time.Time{}, err
+	}
+	return latest.Time, nil
+}
+
+// Insert records the event. Recording a delivery twice is not an error, the second one is ignored.
+func (m EventPsqlRepo) Insert(event *Event) error {
+	query := `INSERT INTO webhook_events (id, type, user_id, email, occurred_at, outcome)
+              VALUES ($1, $2, $3, $4, $5, $6)
+              ON CONFLICT (id) DO NOTHING
+              RETURNING received_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query,
+		event.ID,
+		event.Type,
+		event.UserID,
+		event.Email,
+		event.OccurredAt,
+		event.Outcome,
+	).Scan(&event.ReceivedAt)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
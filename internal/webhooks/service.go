@@ -0,0 +1,130 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"log/slog"
+	"time"
+)
+
+type eventRepository interface {
+	Exists(id string) (bool, error)
+	LatestApplied(userID uuid.UUID) (time.Time, error)
+	Insert(event *Event) error
+	WithUserLock(userID uuid.UUID, fn func() error) error
+}
+
+type userService interface {
+	GetById(id uuid.UUID) (*users.User, error)
+	InsertDefaultUser(email string, id uuid.UUID) error
+	UpdateUserEmail(userId uuid.UUID, email string) error
+	DeleteUser(userId uuid.UUID, oldEmail string) error
+}
+
+// Service applies user lifecycle events to the users table.
+//
+// Handling is idempotent: a delivery is recorded once it is applied, and recorded deliveries are
+// ignored. Events are ordered by when they occurred, so an event older than the newest one applied
+// to the user is skipped, and a deleted user stays deleted. The events of a user are handled one at
+// a time, even when they are delivered to several instances at once.
+type Service struct {
+	logger *slog.Logger
+	events eventRepository
+	users  userService
+}
+
+func NewService(logger *slog.Logger, events eventRepository, users userService) *Service {
+	return &Service{logger: logger, events: events, users: users}
+}
+
+// Handle applies the event and records it. Returns users.ErrDuplicateEmail, without recording
+// the event, if the user's new email belongs to another user, so that it can be delivered again.
+func (s *Service) Handle(event *Event) (Outcome, error) {
+	var outcome Outcome
+	err := s.events.WithUserLock(event.UserID, func() error {
+		var err error
+		outcome, err = s.handleLocked(event)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if outcome == OutcomeDuplicate {
+		return outcome, nil
+	}
+
+	s.logger.Info("webhook event handled",
+		"event id", event.ID,
+		"type", event.Type,
+		"user id", event.UserID,
+		"outcome", event.Outcome,
+	)
+	return event.Outcome, nil
+}
+
+// handleLocked applies and records the event, unless it was already recorded.
+// It must be called while holding the lock on the event's user.
+func (s *Service) handleLocked(event *Event) (Outcome, error) {
+	exists, err := s.events.Exists(event.ID)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return OutcomeDuplicate, nil
+	}
+
+	event.Outcome, err = s.apply(event)
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.events.Insert(event); err != nil {
+		return "", fmt.Errorf("could not record webhook event: %w", err)
+	}
+
+	return event.Outcome, nil
+}
+
+func (s *Service) apply(event *Event) (Outcome, error) {
+	latest, err := s.events.LatestApplied(event.UserID)
+	if err != nil {
+		return "", err
+	}
+	if !latest.IsZero() && !event.OccurredAt.After(latest) {
+		return OutcomeStale, nil
+	}
+
+	user, err := s.users.GetById(event.UserID)
+	if err != nil && !errors.Is(err, database.ErrRecordNotFound) {
+		return "", err
+	}
+	found := err == nil
+
+	// Deletion is final, whatever the order the events arrive in
+	if found && user.IsDeleted {
+		return OutcomeStale, nil
+	}
+
+	switch event.Type {
+	case UserCreated, UserUpdated:
+		switch {
+		// The user may have been inserted by Authenticate, or the created event may still be on its way
+		case !found:
+			err = s.users.InsertDefaultUser(event.Email, event.UserID)
+		case user.Email != event.Email:
+			err = s.users.UpdateUserEmail(event.UserID, event.Email)
+		}
+	case UserDeleted:
+		if found {
+			err = s.users.DeleteUser(event.UserID, user.Email)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return OutcomeApplied, nil
+}
@@ -0,0 +1,282 @@
+package webhooks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go-web-api-starter/internal/database"
+	"go-web-api-starter/internal/users"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockEventRepo holds a single lock for every user, and fails the reads and writes of events
+// made without holding it.
+type mockEventRepo struct {
+	mu     sync.Mutex
+	locked bool
+	events map[string]*Event
+}
+
+var errNotLocked = errors.New("event repository used without holding the user lock")
+
+func (m *mockEventRepo) WithUserLock(userID uuid.UUID, fn func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.locked = true
+	defer func() { m.locked = false }()
+	return fn()
+}
+
+func (m *mockEventRepo) Exists(id string) (bool, error) {
+	if !m.locked {
+		return false, errNotLocked
+	}
+	_, ok := m.events[id]
+	return ok, nil
+}
+
+func (m *mockEventRepo) LatestApplied(userID uuid.UUID) (time.Time, error) {
+	if !m.locked {
+		return time.Time{}, errNotLocked
+	}
+	var latest time.Time
+	for _, event := range m.events {
+		if event.UserID == userID && event.Outcome == OutcomeApplied && event.OccurredAt.After(latest) {
+			latest = event.OccurredAt
+		}
+	}
+	return latest, nil
+}
+
+func (m *mockEventRepo) Insert(event *Event) error {
+	if !m.locked {
+		return errNotLocked
+	}
+	m.events[event.ID] = event
+	return nil
+}
+
+type mockUserService struct {
+	users   map[uuid.UUID]*users.User
+	inserts int
+}
+
+func (m *mockUserService) GetById(id uuid.UUID) (*users.User, error) {
+	user, ok := m.users[id]
+	if !ok {
+		return nil, fmt.Errorf("error getting user: %w", database.ErrRecordNotFound)
+	}
+	return user, nil
+}
+
+func (m *mockUserService) InsertDefaultUser(email string, id uuid.UUID) error {
+	m.inserts++
+	m.users[id] = &users.User{ID: id, Email: email, Role: users.RegularRole}
+	return nil
+}
+
+func (m *mockUserService) UpdateUserEmail(id uuid.UUID, email string) error {
+	m.users[id].Email = email
+	return nil
+}
+
+func (m *mockUserService) DeleteUser(id uuid.UUID, oldEmail string) error {
+	m.users[id].IsDeleted = true
+	m.users[id].Email = fmt.Sprintf("%v+%v", oldEmail, id)
+	return nil
+}
+
+type testReceiver struct {
+	handler http.Handler
+	users   *mockUserService
+	events  *mockEventRepo
+}
+
+func newTestReceiver(t *testing.T) *testReceiver {
+	t.Helper()
+	verifier, err := NewVerifier(testSecret, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	userService := &mockUserService{users: map[uuid.UUID]*users.User{}}
+	events := &mockEventRepo{events: map[string]*Event{}}
+
+	return &testReceiver{
+		handler: SupabaseUsersHandler(logger, verifier, NewService(logger, events, userService)),
+		users:   userService,
+		events:  events,
+	}
+}
+
+// deliver sends a signed database webhook for a change to the user, and returns the response.
+func (tr *testReceiver) deliver(t *testing.T, id, operation string, userID uuid.UUID, email string, at time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+	row := fmt.Sprintf(`{"id": %q, "email": %q, "created_at": %q, "updated_at": %q}`,
+		userID, email, at.Format(time.RFC3339Nano), at.Format(time.RFC3339Nano))
+
+	record, oldRecord := row, "null"
+	if operation == "DELETE" {
+		record, oldRecord = "null", row
+	}
+	body := []byte(fmt.Sprintf(`{"type": %q, "table": "users", "schema": "auth", "record": %s, "old_record": %s}`,
+		operation, record, oldRecord))
+
+	req := httptest.NewRequest("POST", "/v1/webhooks/supabase/users", bytes.NewReader(body))
+	req.Header = signedHeader(t, id, time.Now(), body)
+
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected delivery %s to succeed, got %d: %s", id, rec.Code, rec.Body)
+	}
+	return rec
+}
+
+func TestUserLifecycleEvents(t *testing.T) {
+	tr := newTestReceiver(t)
+	userID := uuid.New()
+	created := time.Now().Add(-time.Hour)
+
+	tr.deliver(t, "msg_1", "INSERT", userID, "first@example.com", created)
+	if user := tr.users.users[userID]; user == nil || user.Email != "first@example.com" {
+		t.Fatal("Expected the created user to be inserted")
+	}
+
+	tr.deliver(t, "msg_2", "UPDATE", userID, "second@example.com", created.Add(time.Minute))
+	if tr.users.users[userID].Email != "second@example.com" {
+		t.Error("Expected the email change to be applied")
+	}
+
+	tr.deliver(t, "msg_3", "DELETE", userID, "second@example.com", created.Add(2*time.Minute))
+	if !tr.users.users[userID].IsDeleted {
+		t.Error("Expected the user to be deleted")
+	}
+}
+
+func TestDuplicateDeliveriesAreIgnored(t *testing.T) {
+	tr := newTestReceiver(t)
+	userID := uuid.New()
+	at := time.Now().Add(-time.Hour)
+
+	tr.deliver(t, "msg_1", "INSERT", userID, "first@example.com", at)
+	tr.deliver(t, "msg_2", "UPDATE", userID, "second@example.com", at.Add(time.Minute))
+
+	// A retry of the first delivery must not undo the email change
+	rec := tr.deliver(t, "msg_1", "INSERT", userID, "first@example.com", at)
+	if !bytes.Contains(rec.Body.Bytes(), []byte(OutcomeDuplicate)) {
+		t.Errorf("Expected the retry to be reported as a duplicate, got %s", rec.Body)
+	}
+	if tr.users.users[userID].Email != "second@example.com" {
+		t.Error("Expected the duplicate delivery to be ignored")
+	}
+}
+
+func TestOutOfOrderEvents(t *testing.T) {
+	tr := newTestReceiver(t)
+	userID := uuid.New()
+	at := time.Now().Add(-time.Hour)
+
+	// The update is delivered before the insert, and creates the user
+	tr.deliver(t, "msg_2", "UPDATE", userID, "second@example.com", at.Add(time.Minute))
+	rec := tr.deliver(t, "msg_1", "INSERT", userID, "first@example.com", at)
+
+	if !bytes.Contains(rec.Body.Bytes(), []byte(OutcomeStale)) {
+		t.Errorf("Expected the older event to be stale, got %s", rec.Body)
+	}
+	if tr.users.users[userID].Email != "second@example.com" {
+		t.Error("Expected the older event not to overwrite the newer email")
+	}
+
+	// Once deleted, a late update does not bring the user back
+	tr.deliver(t, "msg_4", "DELETE", userID, "second@example.com", at.Add(3*time.Minute))
+	tr.deliver(t, "msg_3", "UPDATE", userID, "third@example.com", at.Add(2*time.Minute))
+	if user := tr.users.users[userID]; !user.IsDeleted || user.Email == "third@example.com" {
+		t.Error("Expected the user to stay deleted")
+	}
+}
+
+func TestLazilyInsertedUserIsNotDuplicated(t *testing.T) {
+	tr := newTestReceiver(t)
+	userID := uuid.New()
+
+	// Authenticate inserted the user before the created event arrived
+	_ = tr.users.InsertDefaultUser("first@example.com", userID)
+	tr.deliver(t, "msg_1", "INSERT", userID, "first@example.com", time.Now())
+
+	if event := tr.events.events["msg_1"]; event == nil || event.Outcome != OutcomeApplied {
+		t.Error("Expected the created event to be recorded as applied")
+	}
+}
+
+func TestConcurrentDeliveriesAreHandledOnce(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	userService := &mockUserService{users: map[uuid.UUID]*users.User{}}
+	events := &mockEventRepo{events: map[string]*Event{}}
+	service := NewService(logger, events, userService)
+
+	userID := uuid.New()
+	at := time.Now().Add(-time.Hour)
+
+	// The same delivery retried by several instances at once
+	var wg sync.WaitGroup
+	outcomes := make(chan Outcome, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outcome, err := service.Handle(&Event{ID: "msg_1", Type: UserCreated, UserID: userID, Email: "first@example.com", OccurredAt: at})
+			if err != nil {
+				t.Error(err)
+			}
+			outcomes <- outcome
+		}()
+	}
+	wg.Wait()
+	close(outcomes)
+
+	applied := 0
+	for outcome := range outcomes {
+		if outcome == OutcomeApplied {
+			applied++
+		}
+	}
+	if applied != 1 || userService.inserts != 1 {
+		t.Errorf("Expected the event to be applied once, got %d applied outcomes and %d inserts", applied, userService.inserts)
+	}
+}
+
+func TestOtherTablesAreIgnored(t *testing.T) {
+	tr := newTestReceiver(t)
+	body := []byte(`{"type": "INSERT", "table": "identities", "schema": "auth", "record": {"id": "` + uuid.NewString() + `"}}`)
+
+	req := httptest.NewRequest("POST", "/v1/webhooks/supabase/users", bytes.NewReader(body))
+	req.Header = signedHeader(t, "msg_1", time.Now(), body)
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK || len(tr.users.users) != 0 || len(tr.events.events) != 0 {
+		t.Errorf("Expected the delivery to be acknowledged and ignored, got %d", rec.Code)
+	}
+}
+
+func TestUnsignedDeliveryIsRejected(t *testing.T) {
+	tr := newTestReceiver(t)
+	body := []byte(`{"type": "INSERT", "table": "users", "schema": "auth", "record": {"id": "` + uuid.NewString() + `"}}`)
+
+	rec := httptest.NewRecorder()
+	tr.handler.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/webhooks/supabase/users", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusUnauthorized || len(tr.users.users) != 0 {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of the Standard Webhooks specification, which Supabase signs its webhooks with.
+const (
+	HeaderID        = "webhook-id"
+	HeaderTimestamp = "webhook-timestamp"
+	HeaderSignature = "webhook-signature"
+)
+
+// Verifier checks webhook signatures as described by the Standard Webhooks specification:
+// the signature is an HMAC-SHA256 of "<id>.<timestamp>.<body>" with the shared secret.
+//
+// The timestamp must be within the tolerance of the current time, so a captured
+// delivery cannot be replayed later.
+type Verifier struct {
+	secret    []byte
+	tolerance time.Duration
+	now       func() time.Time
+}
+
+// NewVerifier returns a Verifier for the secret as shown in the Supabase dashboard,
+// either "v1,whsec_<base64>", "whsec_<base64>" or the bare base64 secret.
+func NewVerifier(secret string, tolerance time.Duration) (*Verifier, error) {
+	secret = strings.TrimPrefix(secret, "v1,")
+	secret = strings.TrimPrefix(secret, "whsec_")
+
+	key, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+
+	return &Verifier{secret: key, tolerance: tolerance, now: time.Now}, nil
+}
+
+// Verify checks the signature headers against the raw request body, and returns the
+// delivery's ID and timestamp. The ID is the same for every retry of a delivery.
+func (v *Verifier) Verify(header http.Header, body []byte) (string, time.Time, error) {
+	id := header.Get(HeaderID)
+	if id == "" {
+		return "", time.Time{}, ErrInvalidSignature
+	}
+
+	seconds, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return "", time.Time{}, ErrInvalidTimestamp
+	}
+	timestamp := time.Unix(seconds, 0)
+
+	now := v.now()
+	if timestamp.Before(now.Add(-v.tolerance)) || timestamp.After(now.Add(v.tolerance)) {
+		return "", time.Time{}, ErrInvalidTimestamp
+	}
+
+	expected := v.sign(id, seconds, body)
+
+	// The header holds space separated "<version>,<signature>" pairs, any of which may match
+	for _, versioned := range strings.Fields(header.Get(HeaderSignature)) {
+		version, signature, ok := strings.Cut(versioned, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(signature)
+		if err != nil {
+			continue
+		}
+		if hmac.Equal(decoded, expected) {
+			return id, timestamp, nil
+		}
+	}
+
+	return "", time.Time{}, ErrInvalidSignature
+}
+
+func (v *Verifier) sign(id string, timestamp int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(id))
+	mac.Write([]byte("."))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
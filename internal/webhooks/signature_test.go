@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testSecret = "whsec_" + base64.StdEncoding.EncodeToString([]byte("test webhook secret"))
+
+// signedHeader returns the headers Supabase would send with the body.
+func signedHeader(t *testing.T, id string, timestamp time.Time, body []byte) http.Header {
+	t.Helper()
+	verifier, err := NewVerifier(testSecret, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := verifier.sign(id, timestamp.Unix(), body)
+
+	header := http.Header{}
+	header.Set(HeaderID, id)
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	header.Set(HeaderSignature, "v1,"+base64.StdEncoding.EncodeToString(signature))
+	return header
+}
+
+func TestVerifierAcceptsValidSignature(t *testing.T) {
+	verifier, err := NewVerifier("v1,"+testSecret, 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"type":"INSERT"}`)
+	now := time.Now()
+	header := signedHeader(t, "msg_1", now, body)
+	// Signatures with other versions or of rotated secrets are skipped
+	header.Set(HeaderSignature, "v2,abc v1,bm90IGl0 "+header.Get(HeaderSignature))
+
+	id, timestamp, err := verifier.Verify(header, body)
+	if err != nil {
+		t.Fatalf("Expected the signature to be valid, got %v", err)
+	}
+	if id != "msg_1" || timestamp.Unix() != now.Unix() {
+		t.Errorf("Expected the delivery id and timestamp, got %s and %v", id, timestamp)
+	}
+}
+
+func TestVerifierRejectsInvalidDeliveries(t *testing.T) {
+	verifier, _ := NewVerifier(testSecret, 5*time.Minute)
+	body := []byte(`{"type":"INSERT"}`)
+
+	testCases := []struct {
+		name     string
+		header   func() http.Header
+		body     []byte
+		expected error
+	}{
+		{"tampered body", func() http.Header { return signedHeader(t, "msg_1", time.Now(), body) }, []byte(`{"type":"DELETE"}`), ErrInvalidSignature},
+		{"other id", func() http.Header {
+			h := signedHeader(t, "msg_1", time.Now(), body)
+			h.Set(HeaderID, "msg_2")
+			return h
+		}, body, ErrInvalidSignature},
+		{"missing signature", func() http.Header {
+			h := signedHeader(t, "msg_1", time.Now(), body)
+			h.Del(HeaderSignature)
+			return h
+		}, body, ErrInvalidSignature},
+		{"too old", func() http.Header { return signedHeader(t, "msg_1", time.Now().Add(-10*time.Minute), body) }, body, ErrInvalidTimestamp},
+		{"in the future", func() http.Header { return signedHeader(t, "msg_1", time.Now().Add(10*time.Minute), body) }, body, ErrInvalidTimestamp},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, _, err := verifier.Verify(tc.header(), tc.body); !errors.Is(err, tc.expected) {
+				t.Errorf("Expected %v, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestNewVerifierRejectsInvalidSecret(t *testing.T) {
+	if _, err := NewVerifier("whsec_not base64!", time.Minute); !errors.Is(err, ErrInvalidSecret) {
+		t.Errorf("Expected ErrInvalidSecret, got %v", err)
+	}
+}